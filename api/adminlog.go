@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// maxAdminBody is the largest request body kept in an operation entry;
+// larger ones are recorded without it.
+const maxAdminBody = 64 << 10
+
+// AdminOperation is one admin mutation. Entries are hash chained: Hash is
+// the SHA-256 of PrevHash and the entry's JSON without Hash, and PrevHash of
+// a day's first entry is the last Hash of the day before, so editing or
+// dropping an entry breaks every later hash. Method, Path and Body are the
+// request as received, enough to replay it.
+type AdminOperation struct {
+	Seq       int64           `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Actor     string          `json:"actor"`
+	Role      string          `json:"role,omitempty"`
+	Operation string          `json:"operation"`
+	Method    string          `json:"method,omitempty"`
+	Path      string          `json:"path,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	PrevHash  string          `json:"prevHash"`
+	Hash      string          `json:"hash"`
+}
+
+// AdminLogAnchor is the chaincode's record of a day's final hash.
+type AdminLogAnchor struct {
+	Day        string `json:"day"`
+	Replica    string `json:"replica"`
+	Hash       string `json:"hash"`
+	Entries    int    `json:"entries"`
+	AnchoredAt int64  `json:"anchoredAt"`
+	AnchoredBy string `json:"anchoredBy"`
+	TxID       string `json:"txId"`
+}
+
+// adminLogStore appends operations to one JSON-lines file per UTC day in
+// ADMIN_LOG_DIR. Files are only ever appended to; <day>.anchor holds the
+// day's anchor once it is on the ledger.
+type adminLogStore struct {
+	dir     string
+	replica string
+	anchor  bool
+	mu      sync.Mutex
+	seq     int64
+	last    string
+}
+
+var adminLog *adminLogStore
+
+// loadAdminLog reads ADMIN_LOG_DIR, ADMIN_LOG_REPLICA (default POD_NAME or
+// the host name) and ADMIN_LOG_ANCHOR, and picks up the chain where the
+// newest day file ends.
+func loadAdminLog() {
+	dir := os.Getenv("ADMIN_LOG_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-api-admin-log")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("admin log: %v", err)
+	}
+	replica := os.Getenv("ADMIN_LOG_REPLICA")
+	if replica == "" {
+		replica = os.Getenv("POD_NAME")
+	}
+	if replica == "" {
+		var err error
+		if replica, err = os.Hostname(); err != nil {
+			log.Fatalf("admin log: %v", err)
+		}
+	}
+	if len(replica) > 128 {
+		log.Fatalf("ADMIN_LOG_REPLICA: at most 128 bytes")
+	}
+	st := &adminLogStore{dir: dir, replica: replica, anchor: os.Getenv("ADMIN_LOG_ANCHOR") == "true"}
+	days, err := st.days()
+	if err != nil {
+		log.Fatalf("admin log: %v", err)
+	}
+	if len(days) > 0 {
+		ops, err := st.read(days[len(days)-1])
+		if err != nil {
+			log.Fatalf("admin log: %v", err)
+		}
+		if len(ops) > 0 {
+			st.seq, st.last = ops[len(ops)-1].Seq, ops[len(ops)-1].Hash
+		}
+	}
+	adminLog = st
+}
+
+func (st *adminLogStore) path(day string) string {
+	return filepath.Join(st.dir, day+".jsonl")
+}
+
+// days lists the days with a log file, oldest first.
+func (st *adminLogStore) days() ([]string, error) {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if day, ok := strings.CutSuffix(e.Name(), ".jsonl"); ok {
+			out = append(out, day)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (st *adminLogStore) read(day string) ([]AdminOperation, error) {
+	f, err := os.Open(st.path(day))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []AdminOperation
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 2*maxAdminBody)
+	for sc.Scan() {
+		var op AdminOperation
+		if err := json.Unmarshal(sc.Bytes(), &op); err != nil {
+			return nil, err
+		}
+		out = append(out, op)
+	}
+	return out, sc.Err()
+}
+
+func hashOperation(op AdminOperation) string {
+	op.Hash = ""
+	b, _ := json.Marshal(op)
+	sum := sha256.Sum256(append([]byte(op.PrevHash), b...))
+	return hex.EncodeToString(sum[:])
+}
+
+// append chains op to the log and writes it with a single write to the
+// day's file, opened for appending only.
+func (st *adminLogStore) append(op AdminOperation) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	op.Time = time.Now().UTC()
+	op.Seq = st.seq + 1
+	op.PrevHash = st.last
+	op.Hash = hashOperation(op)
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(st.path(op.Time.Format("2006-01-02")), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	st.seq, st.last = op.Seq, op.Hash
+	return nil
+}
+
+// recordSystemOperation logs a change the API made on its own, such as an
+// identity reload.
+func recordSystemOperation(operation string, err error) {
+	if adminLog == nil {
+		return
+	}
+	op := AdminOperation{Actor: "system", Operation: operation}
+	if err != nil {
+		op.Error = err.Error()
+	}
+	if err := adminLog.append(op); err != nil {
+		log.Printf("admin log: %v", err)
+	}
+}
+
+// adminAudit records every admin request other than reads once it has been
+// answered, whatever the outcome.
+func adminAudit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == "GET" || c.Request.Method == "HEAD" || adminLog == nil {
+			c.Next()
+			return
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			if body, err = io.ReadAll(io.LimitReader(c.Request.Body, maxAdminBody+1)); err != nil {
+				internalError(c, err)
+				return
+			}
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		}
+		c.Next()
+		p := principal(c)
+		op := AdminOperation{Actor: p.Name, Role: p.Role, Operation: c.Request.Method + " " + c.FullPath(), Method: c.Request.Method, Path: c.Request.URL.RequestURI(), Status: c.Writer.Status()}
+		if rc := currentRequestContext(c); rc != nil {
+			op.RequestID = rc.RequestID
+		}
+		if len(body) <= maxAdminBody && json.Valid(body) {
+			op.Body = body
+		}
+		if err := adminLog.append(op); err != nil {
+			log.Printf("admin log: %v", err)
+		}
+	}
+}
+
+// adminOperationsHandler returns ?day's operations (default today, UTC),
+// whether their chain verifies, and the day's anchor once it is written.
+func adminOperationsHandler(c *gin.Context) {
+	day := c.DefaultQuery("day", time.Now().UTC().Format("2006-01-02"))
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		apiError(c, 400, ErrInvalidDate, gin.H{"param": "day"})
+		return
+	}
+	ops, err := adminLog.read(day)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if ops == nil {
+		ops = []AdminOperation{}
+	}
+	verified := true
+	for i, op := range ops {
+		if hashOperation(op) != op.Hash || i > 0 && op.PrevHash != ops[i-1].Hash {
+			verified = false
+		}
+	}
+	out := gin.H{"day": day, "replica": adminLog.replica, "operations": ops, "verified": verified}
+	if len(ops) > 0 {
+		out["hash"] = ops[len(ops)-1].Hash
+	}
+	if b, err := os.ReadFile(filepath.Join(adminLog.dir, day+".anchor")); err == nil {
+		var a AdminLogAnchor
+		if json.Unmarshal(b, &a) == nil {
+			out["anchor"] = a
+		}
+	}
+	c.JSON(200, out)
+}
+
+// runAdminLogAnchors anchors the final hash of every finished day that is
+// not yet anchored, at start and then hourly, until ctx is cancelled.
+func runAdminLogAnchors(ctx context.Context) {
+	if adminLog == nil || !adminLog.anchor {
+		return
+	}
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		if err := adminLog.anchorDays(); err != nil {
+			log.Printf("admin log anchor: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (st *adminLogStore) anchorDays() error {
+	days, err := st.days()
+	if err != nil {
+		return err
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	for _, day := range days {
+		marker := filepath.Join(st.dir, day+".anchor")
+		if day >= today {
+			continue
+		}
+		if _, err := os.Stat(marker); err == nil {
+			continue
+		}
+		ops, err := st.read(day)
+		if err != nil {
+			return err
+		}
+		if len(ops) == 0 {
+			continue
+		}
+		hash := ops[len(ops)-1].Hash
+		res, _, submitErr := submit("AnchorAdminLog", client.WithArguments(day, st.replica, hash, strconv.Itoa(len(ops))))
+		if submitErr != nil {
+			// A retry after a lost response finds the day anchored; take the
+			// ledger's anchor if it is this hash.
+			var a AdminLogAnchor
+			if res, err = contract.EvaluateTransaction("GetAdminLogAnchor", day, st.replica); err != nil || json.Unmarshal(res, &a) != nil {
+				return fmt.Errorf("%s: %w", day, submitErr)
+			}
+			if a.Hash != hash {
+				return fmt.Errorf("%s: ledger anchors %s, the log ends in %s", day, a.Hash, hash)
+			}
+		}
+		if err := os.WriteFile(marker, res, 0o600); err != nil {
+			return err
+		}
+		log.Printf("admin log %s anchored", day)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAnalyticsMonths is how many months of aggregates are kept per account.
+const maxAnalyticsMonths = 24
+
+// MonthlyAggregate sums an account's postings in one calendar month (UTC).
+// Credits and Debits are the totals of positive and negative TRANSAMOUNTs;
+// Debits is reported as a positive number.
+type MonthlyAggregate struct {
+	Month   string      `json:"month" xml:"month"`
+	Credits int64       `json:"credits" xml:"credits"`
+	Debits  int64       `json:"debits" xml:"debits"`
+	Count   int         `json:"count" xml:"count"`
+	ByType  xmlMap[int] `json:"countByType" xml:"countByType"`
+}
+
+// indexedPosting is one posting found in a block: an entry the chaincode
+// added to an account's recent transactions ring in that transaction. The
+// ring, unlike the account record, has every posting of a multi-posting
+// transaction such as a transfer with a fee.
+type indexedPosting struct {
+	MSISDN string
+	TxSummary
+}
+
+// recentPostings returns the entries of a written recent transactions ring
+// that belong to transaction txID.
+func recentPostings(key string, value []byte, txID string) ([]*indexedPosting, error) {
+	parts := strings.Split(key, "\x00")
+	if len(parts) != 4 || parts[1] != "recent" || value == nil {
+		return nil, nil
+	}
+	var ring []TxSummary
+	if err := json.Unmarshal(value, &ring); err != nil {
+		return nil, err
+	}
+	var out []*indexedPosting
+	for i := len(ring) - 1; i >= 0; i-- {
+		if ring[i].TxID == txID && ring[i].TRANSAMOUNT != 0 {
+			out = append(out, &indexedPosting{MSISDN: parts[2], TxSummary: ring[i]})
+		}
+	}
+	return out, nil
+}
+
+// aggregate adds p to its account's month. The caller holds changeIndex if
+// s is its snapshot.
+func (s *indexSnapshot) aggregate(p *indexedPosting) {
+	months := s.Analytics[p.MSISDN]
+	if months == nil {
+		months = map[string]*MonthlyAggregate{}
+		s.Analytics[p.MSISDN] = months
+	}
+	month := time.Unix(p.Timestamp, 0).UTC().Format("2006-01")
+	m := months[month]
+	if m == nil {
+		m = &MonthlyAggregate{Month: month, ByType: map[string]int{}}
+		months[month] = m
+		if len(months) > maxAnalyticsMonths {
+			oldest := month
+			for k := range months {
+				if k < oldest {
+					oldest = k
+				}
+			}
+			delete(months, oldest)
+		}
+	}
+	if p.TRANSAMOUNT > 0 {
+		m.Credits += p.TRANSAMOUNT
+	} else {
+		m.Debits -= p.TRANSAMOUNT
+	}
+	m.Count++
+	m.ByType[p.TRANSTYPE]++
+}
+
+// analyticsHandler returns the account's aggregates for the last ?months=
+// (default 6, at most 24) calendar months, oldest first, including months
+// without postings. They are maintained by the change index as blocks
+// arrive, so no history is scanned.
+func analyticsHandler(c *gin.Context) {
+	if !changeIndex.enabled {
+		apiError(c, 503, ErrChangeIndexDisabled, nil)
+		return
+	}
+	n, err := strconv.Atoi(c.DefaultQuery("months", "6"))
+	if err != nil || n < 1 || n > maxAnalyticsMonths {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "months", "min": 1, "max": maxAnalyticsMonths})
+		return
+	}
+	msisdn := c.Param("msisdn")
+	now := time.Now().UTC()
+	first := time.Date(now.Year(), now.Month()-time.Month(n-1), 1, 0, 0, 0, 0, time.UTC)
+	out := make([]MonthlyAggregate, 0, n)
+	changeIndex.RLock()
+	for i := 0; i < n; i++ {
+		month := first.AddDate(0, i, 0).Format("2006-01")
+		if m := changeIndex.Analytics[msisdn][month]; m != nil {
+			agg := *m
+			agg.ByType = make(map[string]int, len(m.ByType))
+			for k, v := range m.ByType {
+				agg.ByType[k] = v
+			}
+			out = append(out, agg)
+		} else {
+			out = append(out, MonthlyAggregate{Month: month, ByType: map[string]int{}})
+		}
+	}
+	through := int64(changeIndex.Next) - 1
+	changeIndex.RUnlock()
+	respond(c, 200, gin.H{"MSISDN": msisdn, "months": out, "indexedThrough": through})
+}
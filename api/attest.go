@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	signedResponseHeader = "X-Signed-Response"
+	attestationKey       = "attestation"
+)
+
+type EndorsementProof struct {
+	MSPID     string `json:"mspId"`
+	Endorser  []byte `json:"endorser"`
+	Signature []byte `json:"signature"`
+}
+
+// Attestation is what a client needs to check a read without trusting the
+// API: the signed proposal it answers, the proposal response payload, and
+// each endorser's identity and signature over that payload followed by the
+// endorser bytes. Payload is the chaincode result inside the response
+// payload; data in the response is decoded from it but re-encoded by the
+// API, so verifying clients should use Payload. Byte fields are base64.
+type Attestation struct {
+	TxID                    string             `json:"txId"`
+	Channel                 string             `json:"channel"`
+	Chaincode               string             `json:"chaincode"`
+	Function                string             `json:"function"`
+	SignedProposal          []byte             `json:"signedProposal"`
+	ProposalResponsePayload []byte             `json:"proposalResponsePayload"`
+	Endorsements            []EndorsementProof `json:"endorsements"`
+	Payload                 []byte             `json:"payload"`
+}
+
+func signedRequested(c *gin.Context) bool {
+	return c.GetHeader(signedResponseHeader) == "true"
+}
+
+// evaluate runs a read on the nearest read region. In signed mode it is
+// endorsed through the main gateway instead, never submitted, and the
+// endorsements are kept for signedResponses to attach.
+func evaluate(c *gin.Context, fn string, opts ...client.ProposalOption) ([]byte, error) {
+	opts = append(endorsementOptions(fn), opts...)
+	if !signedRequested(c) {
+		return evaluateNearest(c.Request.Context(), fn, opts...)
+	}
+	proposal, err := contract.NewProposal(fn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := proposal.EndorseWithContext(c.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+	att, err := attestation(fn, proposal, tx)
+	if err != nil {
+		return nil, err
+	}
+	c.Set(attestationKey, att)
+	return tx.Result(), nil
+}
+
+func attestation(fn string, proposal *client.Proposal, tx *client.Transaction) (*Attestation, error) {
+	pb, err := proposal.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var proposed gateway.ProposedTransaction
+	if err := proto.Unmarshal(pb, &proposed); err != nil {
+		return nil, err
+	}
+	signed, err := proto.Marshal(proposed.GetProposal())
+	if err != nil {
+		return nil, err
+	}
+	tb, err := tx.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var prepared gateway.PreparedTransaction
+	if err := proto.Unmarshal(tb, &prepared); err != nil {
+		return nil, err
+	}
+	var payload common.Payload
+	if err := proto.Unmarshal(prepared.GetEnvelope().GetPayload(), &payload); err != nil {
+		return nil, err
+	}
+	var ptx peer.Transaction
+	if err := proto.Unmarshal(payload.GetData(), &ptx); err != nil {
+		return nil, err
+	}
+	if len(ptx.GetActions()) != 1 {
+		return nil, errors.New("endorsed transaction has no single action")
+	}
+	var action peer.ChaincodeActionPayload
+	if err := proto.Unmarshal(ptx.GetActions()[0].GetPayload(), &action); err != nil {
+		return nil, err
+	}
+	att := &Attestation{
+		TxID:                    tx.TransactionID(),
+		Channel:                 network.Name(),
+		Chaincode:               contract.ChaincodeName(),
+		Function:                fn,
+		SignedProposal:          signed,
+		ProposalResponsePayload: action.GetAction().GetProposalResponsePayload(),
+		Endorsements:            []EndorsementProof{},
+		Payload:                 tx.Result(),
+	}
+	for _, e := range action.GetAction().GetEndorsements() {
+		var id msp.SerializedIdentity
+		if err := proto.Unmarshal(e.GetEndorser(), &id); err != nil {
+			return nil, err
+		}
+		att.Endorsements = append(att.Endorsements, EndorsementProof{MSPID: id.GetMspid(), Endorser: e.GetEndorser(), Signature: e.GetSignature()})
+	}
+	return att, nil
+}
+
+// bufferedWriter holds a JSON response back so it can be wrapped. Any other
+// content type, such as an NDJSON or CSV export, has nothing to wrap it in,
+// so from its first write it goes straight through instead of being held in
+// memory.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	decided     bool
+	passthrough bool
+}
+
+// buffering decides, on the first write, whether the response is held.
+func (w *bufferedWriter) buffering() bool {
+	if !w.decided {
+		w.decided = true
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			w.passthrough = true
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+	return !w.passthrough
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.status = code
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	if !w.buffering() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if !w.buffering() {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	if !w.buffering() {
+		return w.ResponseWriter.WriteString(s)
+	}
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Flush() {
+	if !w.buffering() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *bufferedWriter) Status() int {
+	if w.passthrough {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if w.passthrough {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	if w.passthrough {
+		return w.ResponseWriter.Written()
+	}
+	return w.body.Len() > 0
+}
+
+// signedResponses answers requests sent with X-Signed-Response: true as
+// {"data": <usual body>, "attestation": {...}} when the handler's read was
+// endorsed. Errors and responses without an attestation are passed through,
+// so a client asking for one must check that it is there.
+func signedResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Streams are never wrapped; other non-JSON bodies are passed
+		// through by bufferedWriter.
+		if !signedRequested(c) || c.Request.URL.Path == "/events" || strings.HasPrefix(c.Request.URL.Path, "/ws/") || strings.HasSuffix(c.Request.URL.Path, ".ndjson") {
+			c.Next()
+			return
+		}
+		w := &bufferedWriter{ResponseWriter: c.Writer, status: 200}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.passthrough {
+			return
+		}
+		att, ok := c.Get(attestationKey)
+		if ok && w.status == 200 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			c.JSON(200, gin.H{"data": json.RawMessage(w.body.Bytes()), "attestation": att})
+			return
+		}
+		c.Writer.WriteHeader(w.status)
+		c.Writer.Write(w.body.Bytes())
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleDealer   = "dealer"
+	RoleViewer   = "viewer"
+
+	principalKey = "principal"
+)
+
+type Principal struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	DealerID string `json:"dealerId,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+}
+
+type apiKey struct {
+	Key string `json:"key"`
+	Principal
+}
+
+// FunctionPolicy lists chaincode functions a role may call through the
+// pass-through endpoints. "*" matches every function; deny wins over allow.
+type FunctionPolicy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+type authConfig struct {
+	Keys      []apiKey                  `json:"keys"`
+	Functions map[string]FunctionPolicy `json:"functions"`
+}
+
+var auth = struct {
+	keys      map[[32]byte]Principal
+	functions map[string]FunctionPolicy
+}{keys: map[[32]byte]Principal{}, functions: map[string]FunctionPolicy{}}
+
+// loadAuth reads API keys and per-role function policies from the JSON file
+// named by AUTH_CONFIG. Without it every authenticated route answers 401.
+func loadAuth() {
+	p := os.Getenv("AUTH_CONFIG")
+	if p == "" {
+		log.Print("AUTH_CONFIG not set, authenticated routes are disabled")
+		return
+	}
+	var cfg authConfig
+	if err := json.Unmarshal(readFile(p), &cfg); err != nil {
+		log.Fatalf("parse %s: %v", p, err)
+	}
+	for _, k := range cfg.Keys {
+		if k.Key == "" || k.Role == "" {
+			log.Fatalf("%s: every key needs a key and a role", p)
+		}
+		if k.Role == RoleDealer && k.DealerID == "" {
+			log.Fatalf("%s: dealer keys need a dealerId", p)
+		}
+		auth.keys[sha256.Sum256([]byte(k.Key))] = k.Principal
+	}
+	if cfg.Functions != nil {
+		auth.functions = cfg.Functions
+	}
+}
+
+func presentedKey(c *gin.Context) string {
+	if k := c.GetHeader("X-API-Key"); k != "" {
+		return k
+	}
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return ""
+}
+
+func principalForKey(k string) (Principal, bool) {
+	p, ok := auth.keys[sha256.Sum256([]byte(k))]
+	return p, ok && k != ""
+}
+
+func authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := principalForKey(presentedKey(c))
+		if !ok {
+			apiError(c, 401, ErrUnauthorized, nil)
+			return
+		}
+		c.Set(principalKey, p)
+		if !tenantRateLimit(c, tenantOf(c)) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// identify sets the principal when a key is presented but, unlike
+// authenticate, lets anonymous requests through.
+func identify() gin.HandlerFunc {
+	authn := authenticate()
+	return func(c *gin.Context) {
+		if presentedKey(c) == "" {
+			c.Next()
+			return
+		}
+		authn(c)
+	}
+}
+
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := principal(c)
+		for _, r := range roles {
+			if p.Role == r {
+				c.Next()
+				return
+			}
+		}
+		apiError(c, 403, ErrForbidden, nil)
+	}
+}
+
+func principal(c *gin.Context) Principal {
+	v, _ := c.Get(principalKey)
+	p, _ := v.(Principal)
+	return p
+}
+
+// functionAllowed reports whether p may call fn: its role's policy and its
+// tenant's overlay, if any, must both allow it.
+func functionAllowed(c *gin.Context, p Principal, fn string) bool {
+	pol, ok := auth.functions[p.Role]
+	return ok && policyAllows(pol, fn) && tenantOf(c).functionAllowed(fn)
+}
+
+func policyAllows(pol FunctionPolicy, fn string) bool {
+	match := func(list []string) bool {
+		for _, f := range list {
+			if f == "*" || f == fn {
+				return true
+			}
+		}
+		return false
+	}
+	return match(pol.Allow) && !match(pol.Deny)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+type BlockTx struct {
+	TxID           string `json:"txId"`
+	ValidationCode string `json:"validationCode"`
+	Valid          bool   `json:"valid"`
+}
+
+// BlockMessage is one websocket frame of GET /ws/blocks. Raw carries the
+// marshalled common.Block in full mode so consumers can decode read/write sets.
+type BlockMessage struct {
+	Number       uint64    `json:"number"`
+	Type         string    `json:"type"`
+	Transactions []BlockTx `json:"transactions"`
+	Raw          []byte    `json:"raw,omitempty"`
+}
+
+var (
+	upgrader = websocket.Upgrader{CheckOrigin: allowedOrigin}
+
+	consumerName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	consumers    = struct {
+		sync.Mutex
+		active map[string]bool
+	}{active: map[string]bool{}}
+)
+
+// allowedOrigin lets a browser open the websocket only from an origin listed
+// in WS_ALLOWED_ORIGINS ("https://ops.example.com,https://..."). Requests
+// without an Origin header do not come from a browser page and pass.
+func allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range strings.Split(os.Getenv("WS_ALLOWED_ORIGINS"), ",") {
+		if strings.TrimSpace(o) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func checkpointDir() string {
+	if d := os.Getenv("BLOCK_CHECKPOINT_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join(os.TempDir(), "fabric-api-checkpoints")
+}
+
+// claimConsumer makes sure only one connection at a time advances a named
+// checkpoint.
+func claimConsumer(name string) bool {
+	consumers.Lock()
+	defer consumers.Unlock()
+	if consumers.active[name] {
+		return false
+	}
+	consumers.active[name] = true
+	return true
+}
+
+func releaseConsumer(name string) {
+	consumers.Lock()
+	defer consumers.Unlock()
+	delete(consumers.active, name)
+}
+
+func fullBlockMessage(b *common.Block) (*BlockMessage, error) {
+	raw, err := proto.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	msg := &BlockMessage{Number: b.GetHeader().GetNumber(), Type: "full", Transactions: []BlockTx{}, Raw: raw}
+	var filter []byte
+	if md := b.GetMetadata().GetMetadata(); len(md) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		filter = md[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+	for i, data := range b.GetData().GetData() {
+		var env common.Envelope
+		if err := proto.Unmarshal(data, &env); err != nil {
+			return nil, err
+		}
+		var payload common.Payload
+		if err := proto.Unmarshal(env.GetPayload(), &payload); err != nil {
+			return nil, err
+		}
+		var ch common.ChannelHeader
+		if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), &ch); err != nil {
+			return nil, err
+		}
+		code := peer.TxValidationCode_VALID
+		if i < len(filter) {
+			code = peer.TxValidationCode(filter[i])
+		}
+		msg.Transactions = append(msg.Transactions, BlockTx{TxID: ch.GetTxId(), ValidationCode: code.String(), Valid: code == peer.TxValidationCode_VALID})
+	}
+	return msg, nil
+}
+
+func filteredBlockMessage(b *peer.FilteredBlock) *BlockMessage {
+	msg := &BlockMessage{Number: b.GetNumber(), Type: "filtered", Transactions: []BlockTx{}}
+	for _, tx := range b.GetFilteredTransactions() {
+		code := tx.GetTxValidationCode()
+		msg.Transactions = append(msg.Transactions, BlockTx{TxID: tx.GetTxid(), ValidationCode: code.String(), Valid: code == peer.TxValidationCode_VALID})
+	}
+	return msg
+}
+
+// blocksHandler streams committed blocks over a websocket in commit order.
+//
+// Query parameters: type=full|filtered (default filtered), startBlock=N, and
+// consumer=name. A named consumer resumes after the last block it was sent,
+// tracked in a checkpoint file that only advances once a frame is written, so
+// delivery is at least once. Full blocks carry every transaction's arguments,
+// so only admin keys get them.
+func blocksHandler(c *gin.Context) {
+	kind := c.DefaultQuery("type", "filtered")
+	if kind != "full" && kind != "filtered" {
+		apiError(c, 400, ErrInvalidBlockType, nil)
+		return
+	}
+	if kind == "full" && principal(c).Role != RoleAdmin {
+		apiError(c, 403, ErrForbidden, nil)
+		return
+	}
+	var opts []client.BlockEventsOption
+	if v := c.Query("startBlock"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			apiError(c, 400, ErrInvalidBlockNumber, gin.H{"param": "startBlock"})
+			return
+		}
+		opts = append(opts, client.WithStartBlock(n))
+	}
+	var cp *client.FileCheckpointer
+	if name := c.Query("consumer"); name != "" {
+		if !consumerName.MatchString(name) {
+			apiError(c, 400, ErrInvalidConsumer, nil)
+			return
+		}
+		if !claimConsumer(name) {
+			apiError(c, 409, ErrConsumerConnected, gin.H{"consumer": name})
+			return
+		}
+		defer releaseConsumer(name)
+		if err := os.MkdirAll(checkpointDir(), 0o700); err != nil {
+			internalError(c, err)
+			return
+		}
+		var err error
+		if cp, err = client.NewFileCheckpointer(filepath.Join(checkpointDir(), name+".json")); err != nil {
+			internalError(c, err)
+			return
+		}
+		defer cp.Close()
+		opts = append(opts, client.WithCheckpoint(cp))
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	// Reading is only needed to notice the client going away.
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	next, err := blockStream(ctx, kind, opts)
+	if err != nil {
+		conn.WriteJSON(localizedFabricError(c, err))
+		return
+	}
+	for {
+		msg, err := next()
+		if err != nil {
+			conn.WriteJSON(errorBody(c, ErrInternal, gin.H{"detail": err.Error()}))
+			return
+		}
+		if msg == nil {
+			return
+		}
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+		if cp != nil {
+			if err := cp.CheckpointBlock(msg.Number); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// blockStream hides the difference between full and filtered block events;
+// next returns nil once the stream ends.
+func blockStream(ctx context.Context, kind string, opts []client.BlockEventsOption) (func() (*BlockMessage, error), error) {
+	if kind == "full" {
+		blocks, err := network.BlockEvents(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return func() (*BlockMessage, error) {
+			b, ok := <-blocks
+			if !ok {
+				return nil, nil
+			}
+			return fullBlockMessage(b)
+		}, nil
+	}
+	blocks, err := network.FilteredBlockEvents(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return func() (*BlockMessage, error) {
+		b, ok := <-blocks
+		if !ok {
+			return nil, nil
+		}
+		return filteredBlockMessage(b), nil
+	}, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+
+	fabricErrorKey = "fabricError"
+)
+
+type breaker struct {
+	state    string
+	failures int // consecutive
+	openedAt time.Time
+	probing  bool
+	opened   int // times tripped
+}
+
+// breakers trips per route after failureThreshold consecutive gateway calls
+// that failed for lack of a peer (unavailable or timed out), answers 503
+// without calling Fabric for cooldown, then lets a single probe through.
+var breakers = struct {
+	sync.Mutex
+	failureThreshold int
+	cooldown         time.Duration
+	routes           map[string]*breaker
+}{failureThreshold: 5, cooldown: 30 * time.Second, routes: map[string]*breaker{}}
+
+func loadBreakers() {
+	if v := os.Getenv("BREAKER_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatal("BREAKER_FAILURES must be a positive number")
+		}
+		breakers.failureThreshold = n
+	}
+	if v := os.Getenv("BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("BREAKER_COOLDOWN: %v", err)
+		}
+		breakers.cooldown = d
+	}
+}
+
+// allow reports whether a request may go through and whether it is the
+// half-open probe.
+func (b *breaker) allow(now time.Time) bool {
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < breakers.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *breaker) record(failed bool, now time.Time) {
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= breakers.failureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = now
+		b.probing = false
+		b.opened++
+	}
+}
+
+// peerFailure reports whether the handler hit a gateway error that says the
+// peer is unreachable, as recorded by fabricError. Chaincode rejections do not
+// count: the peer answered.
+func peerFailure(c *gin.Context) bool {
+	v, ok := c.Get(fabricErrorKey)
+	if !ok {
+		return false
+	}
+	fe := v.(FabricError)
+	return fe.Category == CategoryUnavailable || fe.Category == CategoryTimeout
+}
+
+func circuitBreaker() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.FullPath() == "" || c.FullPath() == "/health" || c.FullPath() == "/metrics" || c.FullPath() == "/readyz" {
+			c.Next()
+			return
+		}
+		route := c.Request.Method + " " + c.FullPath()
+		breakers.Lock()
+		b, ok := breakers.routes[route]
+		if !ok {
+			b = &breaker{state: BreakerClosed}
+			breakers.routes[route] = b
+		}
+		allowed := b.allow(time.Now())
+		breakers.Unlock()
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(breakers.cooldown.Seconds())))
+			apiError(c, 503, ErrCircuitOpen, gin.H{"category": CategoryUnavailable, "retryable": true})
+			return
+		}
+		// Deferred so a panicking handler does not leave the probe taken
+		// and the breaker half open for good. A panic says nothing about the
+		// peer: the next request probes again.
+		defer func() {
+			breakers.Lock()
+			defer breakers.Unlock()
+			if r := recover(); r != nil {
+				b.probing = false
+				panic(r)
+			}
+			b.record(peerFailure(c), time.Now())
+		}()
+		c.Next()
+	}
+}
+
+type BreakerState struct {
+	Route    string `json:"route"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	Opened   int    `json:"opened"`
+}
+
+func breakerStates() []BreakerState {
+	breakers.Lock()
+	defer breakers.Unlock()
+	out := []BreakerState{}
+	for route, b := range breakers.routes {
+		out = append(out, BreakerState{Route: route, State: b.state, Failures: b.failures, Opened: b.opened})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out
+}
+
+// readyzHandler reports not ready while any route's breaker is open or the
+// chaincode does not answer Ping, so a load balancer stops sending traffic
+// to a replica that cannot reach its peer or chaincode.
+func readyzHandler(c *gin.Context) {
+	states := breakerStates()
+	var open []string
+	for _, s := range states {
+		if s.State == BreakerOpen {
+			open = append(open, s.Route)
+		}
+	}
+	if len(open) > 0 {
+		c.JSON(503, gin.H{"status": "unavailable", "openBreakers": open, "breakers": states})
+		return
+	}
+	ping, err := cachedPing()
+	if err != nil {
+		c.JSON(503, gin.H{"status": "unavailable", "chaincode": gin.H{"error": classify(err).Error}, "breakers": states})
+		return
+	}
+	c.JSON(200, gin.H{"status": "ready", "chaincode": ping, "breakers": states})
+}
+
+// metricsHandler serves breaker state and query cache counters in the
+// Prometheus text format.
+func metricsHandler(c *gin.Context) {
+	var b strings.Builder
+	states := breakerStates()
+	b.WriteString("# HELP fabric_api_breaker_state Circuit breaker state per route (0 closed, 1 half open, 2 open).\n")
+	b.WriteString("# TYPE fabric_api_breaker_state gauge\n")
+	for _, s := range states {
+		v := 0
+		switch s.State {
+		case BreakerHalfOpen:
+			v = 1
+		case BreakerOpen:
+			v = 2
+		}
+		fmt.Fprintf(&b, "fabric_api_breaker_state{route=%q} %d\n", s.Route, v)
+	}
+	b.WriteString("# HELP fabric_api_breaker_opened_total Times the breaker for a route has opened.\n")
+	b.WriteString("# TYPE fabric_api_breaker_opened_total counter\n")
+	for _, s := range states {
+		fmt.Fprintf(&b, "fabric_api_breaker_opened_total{route=%q} %d\n", s.Route, s.Opened)
+	}
+	hits, misses, pages := queryCacheStats()
+	b.WriteString("# HELP fabric_api_query_cache_requests_total Asset page requests by cache result.\n")
+	b.WriteString("# TYPE fabric_api_query_cache_requests_total counter\n")
+	fmt.Fprintf(&b, "fabric_api_query_cache_requests_total{result=\"hit\"} %d\n", hits)
+	fmt.Fprintf(&b, "fabric_api_query_cache_requests_total{result=\"miss\"} %d\n", misses)
+	b.WriteString("# HELP fabric_api_query_cache_pages Asset pages currently cached.\n")
+	b.WriteString("# TYPE fabric_api_query_cache_pages gauge\n")
+	fmt.Fprintf(&b, "fabric_api_query_cache_pages %d\n", pages)
+	writeRegionMetrics(&b)
+	writeSlowQueryMetrics(&b)
+	writePoolMetrics(&b)
+	writeSubmitQueueMetrics(&b)
+	writePublicMetrics(&b)
+	writeSLIMetrics(&b)
+	writeReadStrategyMetrics(&b)
+	writeRetentionMetrics(&b)
+	writeTenantMetrics(&b)
+	writeFraudMetrics(&b)
+	writeSettlementMetrics(&b)
+	writeStatusCacheMetrics(&b)
+	writeSubmissionMetrics(&b)
+	writePolicyMetrics(&b)
+	c.Data(200, "text/plain; version=0.0.4", []byte(b.String()))
+}
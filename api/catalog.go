@@ -0,0 +1,283 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes returned in the "code" field of every error response. They are
+// stable: clients key their own copy on them, never on the message.
+const (
+	ErrUnauthorized          = "UNAUTHORIZED"
+	ErrForbidden             = "FORBIDDEN"
+	ErrFunctionNotAllowed    = "FUNCTION_NOT_ALLOWED"
+	ErrSubmitOnly            = "SUBMIT_ONLY"
+	ErrInvalidBody           = "INVALID_BODY"
+	ErrOutOfRange            = "OUT_OF_RANGE"
+	ErrInvalidBlockNumber    = "INVALID_BLOCK_NUMBER"
+	ErrInvalidTimestamp      = "INVALID_TIMESTAMP"
+	ErrSinceRequired         = "SINCE_REQUIRED"
+	ErrInvalidBlockType      = "INVALID_BLOCK_TYPE"
+	ErrInvalidConsumer       = "INVALID_CONSUMER"
+	ErrConsumerConnected     = "CONSUMER_CONNECTED"
+	ErrInvalidFormat         = "INVALID_FORMAT"
+	ErrInvalidEncryptionKey  = "INVALID_ENCRYPTION_KEY"
+	ErrOperationIDTooLong    = "OPERATION_ID_TOO_LONG"
+	ErrNotFound              = "NOT_FOUND"
+	ErrChangeIndexDisabled   = "CHANGE_INDEX_DISABLED"
+	ErrMaintenance           = "MAINTENANCE"
+	ErrOverloaded            = "OVERLOADED"
+	ErrCircuitOpen           = "CIRCUIT_OPEN"
+	ErrSubmitQueueFull       = "SUBMIT_QUEUE_FULL"
+	ErrRateLimited           = "RATE_LIMITED"
+	ErrInvalidEndorsingOrgs  = "INVALID_ENDORSING_ORGANIZATIONS"
+	ErrParamRequired         = "PARAM_REQUIRED"
+	ErrConfirmTokenRequired  = "CONFIRM_TOKEN_REQUIRED"
+	ErrInvalidReadStrategy   = "INVALID_READ_STRATEGY"
+	ErrInvalidTenant         = "INVALID_TENANT"
+	ErrFeatureDisabled       = "FEATURE_DISABLED"
+	ErrFraudBlocked          = "FRAUD_BLOCKED"
+	ErrFraudCheckUnavailable = "FRAUD_CHECK_UNAVAILABLE"
+	ErrSettlementDayOpen     = "SETTLEMENT_DAY_OPEN"
+	ErrInvalidExpand         = "INVALID_EXPAND"
+	ErrInvalidTLSRoots       = "INVALID_TLS_ROOTS"
+	ErrInvalidFault          = "INVALID_FAULT"
+	ErrSandboxUnsupported    = "SANDBOX_UNSUPPORTED"
+	ErrAccountNotActive      = "ACCOUNT_NOT_ACTIVE"
+	ErrPolicyDenied          = "POLICY_DENIED"
+	ErrInvalidPolicy         = "INVALID_POLICY"
+	ErrIdempotencyKeyReused  = "IDEMPOTENCY_KEY_REUSED"
+	ErrSubmissionInProgress  = "SUBMISSION_IN_PROGRESS"
+	ErrRunbookRunning        = "RUNBOOK_RUNNING"
+	ErrOutboxDisabled        = "OUTBOX_DISABLED"
+	ErrInvalidTransfer       = "INVALID_TRANSFER"
+	ErrInvalidOperationID    = "INVALID_OPERATION_ID"
+	ErrOperationCompensated  = "OPERATION_COMPENSATED"
+	ErrOperationFailed       = "OPERATION_FAILED"
+	ErrInvalidAmount         = "INVALID_AMOUNT"
+	ErrInvalidCertificate    = "INVALID_CERTIFICATE"
+	ErrProposalExpired       = "PROPOSAL_EXPIRED"
+	ErrProposalState         = "PROPOSAL_STATE"
+	ErrInvalidCursor         = "INVALID_CURSOR"
+	ErrInvalidRole           = "INVALID_ROLE"
+	ErrPINMismatch           = "MPIN_MISMATCH"
+	ErrPINLocked             = "MPIN_LOCKED"
+	ErrInvalidDate           = "INVALID_DATE"
+	ErrInvalidOrigin         = "INVALID_ORIGIN"
+	ErrInternal              = "INTERNAL"
+
+	// Gateway failures get FABRIC_ plus their upper-cased category.
+	ErrFabricRejected     = "FABRIC_REJECTED"
+	ErrFabricEndorsement  = "FABRIC_ENDORSEMENT"
+	ErrFabricOrdering     = "FABRIC_ORDERING"
+	ErrFabricCommitStatus = "FABRIC_COMMIT_STATUS"
+	ErrFabricConflict     = "FABRIC_CONFLICT"
+	ErrFabricInvalid      = "FABRIC_INVALID"
+	ErrFabricUnavailable  = "FABRIC_UNAVAILABLE"
+	ErrFabricTimeout      = "FABRIC_TIMEOUT"
+	ErrFabricUnknown      = "FABRIC_UNKNOWN"
+)
+
+// defaultMessages is the English catalog. {name} placeholders are filled from
+// the response's params, so translations may reorder them.
+var defaultMessages = map[string]string{
+	ErrUnauthorized:          "unauthorized",
+	ErrForbidden:             "forbidden",
+	ErrFunctionNotAllowed:    "function {function} not allowed",
+	ErrSubmitOnly:            "function {function} must be submitted, not evaluated",
+	ErrInvalidBody:           "invalid request body",
+	ErrOutOfRange:            "{param} must be between {min} and {max}",
+	ErrInvalidBlockNumber:    "{param} must be a block number",
+	ErrInvalidTimestamp:      "{param} must be unix seconds or RFC3339",
+	ErrSinceRequired:         "sinceBlock or sinceTimestamp required",
+	ErrInvalidBlockType:      "type must be full or filtered",
+	ErrInvalidConsumer:       "consumer must be 1-64 letters, digits, '.', '_' or '-'",
+	ErrConsumerConnected:     "consumer {consumer} already connected",
+	ErrInvalidFormat:         "unsupported format",
+	ErrInvalidEncryptionKey:  "{header} must be a base64 AES-128, AES-192 or AES-256 key",
+	ErrOperationIDTooLong:    "{header} must be at most {max} characters",
+	ErrNotFound:              "not found",
+	ErrChangeIndexDisabled:   "change index disabled",
+	ErrMaintenance:           "maintenance mode: writes are frozen",
+	ErrOverloaded:            "peer overloaded, request shed",
+	ErrCircuitOpen:           "circuit open: peer unavailable",
+	ErrSubmitQueueFull:       "too many {class} transactions waiting; retry later",
+	ErrRateLimited:           "too many requests; retry later",
+	ErrInvalidEndorsingOrgs:  "{header} must list at least one MSP ID",
+	ErrParamRequired:         "{param} required",
+	ErrConfirmTokenRequired:  "confirmToken from the dry run required",
+	ErrInvalidReadStrategy:   "invalid read strategy: {reason}",
+	ErrInvalidTenant:         "invalid tenant overlay: {reason}",
+	ErrFeatureDisabled:       "{feature} is disabled for this tenant",
+	ErrFraudBlocked:          "transaction declined by fraud screening (decision {decisionId})",
+	ErrFraudCheckUnavailable: "fraud screening unavailable; retry later",
+	ErrSettlementDayOpen:     "{date} has not ended yet (UTC)",
+	ErrInvalidExpand:         "{param} may only list {allowed}",
+	ErrInvalidTLSRoots:       "TLS roots not reloaded: {detail}",
+	ErrInvalidFault:          "invalid fault: {detail}",
+	ErrSandboxUnsupported:    "{function} is not emulated by the sandbox; run against a Fabric network",
+	ErrAccountNotActive:      "{msisdn} is {status}",
+	ErrPolicyDenied:          "denied by policy: {reason}",
+	ErrInvalidPolicy:         "invalid policy: {reason}",
+	ErrIdempotencyKeyReused:  "Idempotency-Key {key} was used for a different request",
+	ErrSubmissionInProgress:  "transaction {txId} for Idempotency-Key {key} is still being submitted",
+	ErrRunbookRunning:        "{action} is already running as job {id}",
+	ErrOutboxDisabled:        "outbox disabled",
+	ErrInvalidTransfer:       "invalid transfer",
+	ErrInvalidOperationID:    "{header} must be 1-128 letters, digits, '.', '_' or '-'",
+	ErrOperationCompensated:  "operation failed and was rolled back",
+	ErrOperationFailed:       "operation failed and could not be rolled back",
+	ErrInvalidAmount:         "{param} must be a positive whole number",
+	ErrInvalidCertificate:    "certificate must be a PEM X.509 certificate",
+	ErrProposalExpired:       "proposal {txId} expired at {expiresAt}",
+	ErrProposalState:         "proposal {txId} is already {state}",
+	ErrInvalidCursor:         "{param} is not a cursor returned by this API",
+	ErrInvalidRole:           "{param} must be one of {roles}",
+	ErrPINMismatch:           "wrong MPIN ({failures} failed in a row)",
+	ErrPINLocked:             "MPIN locked until {lockedUntil}",
+	ErrInvalidDate:           "{param} must be a date as YYYY-MM-DD",
+	ErrInvalidOrigin:         "{header} is not valid",
+	ErrInternal:              "internal error",
+	ErrFabricRejected:        "rejected by chaincode",
+	ErrFabricEndorsement:     "endorsement failed",
+	ErrFabricOrdering:        "ordering failed",
+	ErrFabricCommitStatus:    "commit status unknown; the transaction may still commit",
+	ErrFabricConflict:        "read conflict; retry the request",
+	ErrFabricInvalid:         "transaction invalidated",
+	ErrFabricUnavailable:     "peer unavailable",
+	ErrFabricTimeout:         "peer timed out",
+	ErrFabricUnknown:         "ledger request failed",
+}
+
+//go:embed i18n/*.json
+var bundledMessages embed.FS
+
+// bundles maps a language tag, lower-cased, to its messages. Codes missing
+// from a bundle fall back to English.
+var bundles = map[string]map[string]string{}
+
+// loadCatalog reads the bundles shipped in i18n/ and then any <lang>.json in
+// ERROR_BUNDLES_DIR, which replace shipped ones for the same language.
+func loadCatalog() {
+	entries, err := bundledMessages.ReadDir("i18n")
+	if err != nil {
+		log.Fatalf("read i18n: %v", err)
+	}
+	for _, e := range entries {
+		b, err := bundledMessages.ReadFile("i18n/" + e.Name())
+		if err != nil {
+			log.Fatalf("read i18n/%s: %v", e.Name(), err)
+		}
+		addBundle(e.Name(), b)
+	}
+	dir := os.Getenv("ERROR_BUNDLES_DIR")
+	if dir == "" {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		log.Fatalf("ERROR_BUNDLES_DIR: %v", err)
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			log.Fatalf("read %s: %v", f, err)
+		}
+		addBundle(filepath.Base(f), b)
+	}
+}
+
+func addBundle(name string, b []byte) {
+	msgs := map[string]string{}
+	if err := json.Unmarshal(b, &msgs); err != nil {
+		log.Fatalf("parse error bundle %s: %v", name, err)
+	}
+	for code := range msgs {
+		if _, ok := defaultMessages[code]; !ok {
+			log.Printf("error bundle %s: unknown code %s", name, code)
+		}
+	}
+	bundles[strings.ToLower(strings.TrimSuffix(name, ".json"))] = msgs
+}
+
+// languages lists the Accept-Language tags in preference order, each followed
+// by its base language, e.g. "fr-CA;q=0.8, es" gives es, fr-ca, fr.
+func languages(header string) []string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if lang != "" && lang != "*" && q > 0 {
+			tags = append(tags, tag{strings.ToLower(lang), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	var out []string
+	for _, t := range tags {
+		out = append(out, t.lang)
+		if base, _, ok := strings.Cut(t.lang, "-"); ok {
+			out = append(out, base)
+		}
+	}
+	return out
+}
+
+// localize renders code in the caller's preferred language.
+func localize(c *gin.Context, code string, params gin.H) string {
+	msg := defaultMessages[code]
+	for _, lang := range languages(c.GetHeader("Accept-Language")) {
+		if m, ok := bundles[lang][code]; ok {
+			msg = m
+			break
+		}
+	}
+	for k, v := range params {
+		msg = strings.ReplaceAll(msg, "{"+k+"}", fmt.Sprint(v))
+	}
+	return msg
+}
+
+// errorBody is the response for code: the localized message, the code and
+// params, which carry the message arguments and any other fields.
+func errorBody(c *gin.Context, code string, params gin.H) gin.H {
+	body := gin.H{}
+	for k, v := range params {
+		body[k] = v
+	}
+	body["error"] = localize(c, code, params)
+	body["code"] = code
+	return body
+}
+
+// apiError aborts the request with status and the catalog entry for code.
+func apiError(c *gin.Context, status int, code string, params gin.H) {
+	c.AbortWithStatusJSON(status, errorBody(c, code, params))
+}
+
+// internalError answers 500 with err's text as detail.
+func internalError(c *gin.Context, err error) {
+	apiError(c, 500, ErrInternal, gin.H{"detail": err.Error()})
+}
+
+// bodyError answers 400 for a request body that failed to bind.
+func bodyError(c *gin.Context, err error) {
+	apiError(c, 400, ErrInvalidBody, gin.H{"detail": err.Error()})
+}
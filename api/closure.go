@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type Closure struct {
+	MSISDN           string `json:"MSISDN" xml:"MSISDN"`
+	PreviousStatus   string `json:"previousStatus" xml:"previousStatus"`
+	SettlementMSISDN string `json:"settlementMsisdn,omitempty" xml:"settlementMsisdn,omitempty"`
+	SettledAmount    int64  `json:"settledAmount" xml:"settledAmount"`
+	ClosedAt         int64  `json:"closedAt" xml:"closedAt"`
+	ClosedBy         string `json:"closedBy" xml:"closedBy"`
+	TxID             string `json:"txId" xml:"txId"`
+	Receipt          string `json:"receipt" xml:"receipt"`
+}
+
+type closeRequest struct {
+	SettlementMSISDN string `json:"settlementMsisdn"`
+}
+
+// closeAccountHandler closes :msisdn, moving any balance to the body's
+// settlement account in the same transaction. The body may be omitted for a
+// zero balance.
+func closeAccountHandler(c *gin.Context) {
+	var req closeRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		bodyError(c, err)
+		return
+	}
+	opts, ok := proposalOptions(c, c.Param("msisdn"), req.SettlementMSISDN)
+	if !ok {
+		return
+	}
+	res, _, err := submit("CloseAccount", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var cl Closure
+	if err := json.Unmarshal(res, &cl); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, cl)
+}
+
+func closureHandler(c *gin.Context) {
+	res, err := evaluate(c, "GetClosure", client.WithArguments(c.Param("msisdn")))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var cl Closure
+	if err := json.Unmarshal(res, &cl); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, cl)
+}
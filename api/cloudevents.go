@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const (
+	formatPlain             = "plain"
+	formatCloudEvents       = "cloudevents"
+	formatCloudEventsBinary = "cloudevents-binary"
+
+	cloudEventsContentType = "application/cloudevents+json"
+	cloudEventTypePrefix   = "org.hyperledger.fabric."
+)
+
+// CloudEvent is a CloudEvents 1.0 envelope in the structured JSON encoding.
+// A transaction carries at most one chaincode event, so the transaction ID
+// is a unique id within the source, which names channel and chaincode.
+// The fabric* attributes are extensions.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	FabricBlock     uint64          `json:"fabricblock"`
+	FabricFunction  string          `json:"fabricfunction,omitempty"`
+}
+
+var outboxFormat = formatPlain
+
+// loadCloudEvents reads OUTBOX_FORMAT, the envelope of outbox webhook
+// deliveries: plain (default), cloudevents for the structured mode or
+// cloudevents-binary for the binary one.
+func loadCloudEvents() {
+	switch v := os.Getenv("OUTBOX_FORMAT"); v {
+	case "", formatPlain:
+	case formatCloudEvents, formatCloudEventsBinary:
+		outboxFormat = v
+	default:
+		log.Fatalf("OUTBOX_FORMAT must be %s, %s or %s", formatPlain, formatCloudEvents, formatCloudEventsBinary)
+	}
+}
+
+// cloudEventSource is CLOUDEVENTS_SOURCE or, by default,
+// /channels/<channel>/chaincodes/<chaincode>.
+func cloudEventSource() string {
+	if v := os.Getenv("CLOUDEVENTS_SOURCE"); v != "" {
+		return v
+	}
+	return "/channels/" + network.Name() + "/chaincodes/" + contract.ChaincodeName()
+}
+
+// newCloudEvent wraps a committed transaction. Its type is
+// org.hyperledger.fabric.chaincode.<event name>, or for a transaction
+// without an event org.hyperledger.fabric.transaction.<function>. The
+// chaincode payload is the data when it is JSON.
+func newCloudEvent(txID, function, eventName string, block uint64, payload []byte) CloudEvent {
+	ev := CloudEvent{SpecVersion: "1.0", ID: txID, Source: cloudEventSource(), FabricBlock: block, FabricFunction: function}
+	if eventName != "" {
+		ev.Type = cloudEventTypePrefix + "chaincode." + eventName
+	} else {
+		ev.Type = cloudEventTypePrefix + "transaction." + function
+	}
+	if json.Valid(payload) {
+		ev.DataContentType = "application/json"
+		ev.Data = payload
+	}
+	return ev
+}
+
+// setBinaryHeaders puts ev's attributes in ce- headers for the binary
+// content mode; the body is then ev.Data alone.
+func (ev CloudEvent) setBinaryHeaders(h http.Header) {
+	h.Set("ce-specversion", ev.SpecVersion)
+	h.Set("ce-id", ev.ID)
+	h.Set("ce-source", ev.Source)
+	h.Set("ce-type", ev.Type)
+	h.Set("ce-fabricblock", strconv.FormatUint(ev.FabricBlock, 10))
+	if ev.FabricFunction != "" {
+		h.Set("ce-fabricfunction", ev.FabricFunction)
+	}
+	if ev.DataContentType != "" {
+		h.Set("Content-Type", ev.DataContentType)
+	}
+}
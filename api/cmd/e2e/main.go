@@ -0,0 +1,791 @@
+// Command e2e runs black-box checks against the API on a real Fabric network.
+// By default it brings the test network up with netup, starts the API from
+// this directory and tears both down afterwards:
+//
+//	go run ./cmd/e2e -samples ~/fabric-samples
+//
+// With -api it only runs the checks against an API that is already up, as
+// the e2e compose profile does:
+//
+//	go run ./cmd/e2e -api http://localhost:8080 -admin-key change-me-admin -operator-key change-me-operator
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"fabric-api/pkg/apiclient"
+)
+
+type config struct {
+	samples     string
+	api         string
+	adminKey    string
+	operatorKey string
+	keep        bool
+	run         string
+}
+
+type check struct {
+	name string
+	fn   func(ctx context.Context, s *suite) error
+}
+
+// suite holds the clients and the accounts the checks share. Checks run in
+// order and later ones build on the accounts earlier ones created.
+type suite struct {
+	base     string
+	public   *apiclient.Client
+	admin    *apiclient.Client
+	operator *apiclient.Client
+	adminKey string
+	prefix   string
+	events   *apiclient.Subscription
+}
+
+func (s *suite) msisdn(n int) string {
+	return fmt.Sprintf("%s%02d", s.prefix, n)
+}
+
+func main() {
+	home, _ := os.UserHomeDir()
+	var cfg config
+	flag.StringVar(&cfg.samples, "samples", filepath.Join(home, "fabric-samples"), "path to a fabric-samples checkout, passed to netup")
+	flag.StringVar(&cfg.api, "api", "", "base URL of a running API; skips the network and API start-up")
+	flag.StringVar(&cfg.adminKey, "admin-key", "e2e-admin", "admin API key")
+	flag.StringVar(&cfg.operatorKey, "operator-key", "e2e-operator", "operator API key")
+	flag.BoolVar(&cfg.keep, "keep", false, "leave the network up afterwards")
+	flag.StringVar(&cfg.run, "run", "", "only run checks whose name contains this")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	base := cfg.api
+	if base == "" {
+		stop, err := startStack(ctx, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer stop()
+		base = "http://" + apiAddr
+	}
+	if err := waitReady(ctx, base, 2*time.Minute); err != nil {
+		log.Fatal(err)
+	}
+	s := &suite{
+		base:     base,
+		public:   apiclient.New(base),
+		admin:    apiclient.New(base, apiclient.WithAPIKey(cfg.adminKey)),
+		operator: apiclient.New(base, apiclient.WithAPIKey(cfg.operatorKey)),
+		adminKey: cfg.adminKey,
+		// MSISDNs unique per run, so the suite can run again on the same ledger.
+		prefix: "9" + strconv.FormatInt(time.Now().Unix()%1e7, 10),
+	}
+	failed := 0
+	for _, c := range checks {
+		if cfg.run != "" && !strings.Contains(c.name, cfg.run) {
+			continue
+		}
+		start := time.Now()
+		cctx, ccancel := context.WithTimeout(ctx, 2*time.Minute)
+		err := c.fn(cctx, s)
+		ccancel()
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL %-28s %v\n", c.name, err)
+			continue
+		}
+		fmt.Printf("ok   %-28s %s\n", c.name, time.Since(start).Round(time.Millisecond))
+	}
+	if failed > 0 {
+		fmt.Printf("%d check(s) failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
+
+const (
+	apiAddr  = "127.0.0.1:18080"
+	rejected = "FABRIC_REJECTED"
+)
+
+// startStack brings the network up with netup, writes an auth config with the
+// suite's keys and starts the API with go run. stop kills the API and, unless
+// -keep, tears the network down.
+func startStack(ctx context.Context, cfg config) (stop func(), err error) {
+	dir, err := os.MkdirTemp("", "fabric-api-e2e")
+	if err != nil {
+		return nil, err
+	}
+	envFile := filepath.Join(dir, "api.env")
+	netup := exec.CommandContext(ctx, "go", "run", "./cmd/netup", "-samples", cfg.samples, "-out", envFile)
+	netup.Stdout, netup.Stderr = os.Stdout, os.Stderr
+	if err := netup.Run(); err != nil {
+		return nil, fmt.Errorf("netup: %w", err)
+	}
+	down := func() {
+		if cfg.keep {
+			log.Printf("network left up; go run ./cmd/netup -down tears it down")
+			return
+		}
+		cmd := exec.Command("go", "run", "./cmd/netup", "-samples", cfg.samples, "-down")
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("netup -down: %v", err)
+		}
+		os.RemoveAll(dir)
+	}
+	env, err := readEnv(envFile)
+	if err != nil {
+		down()
+		return nil, err
+	}
+	authFile := filepath.Join(dir, "auth.json")
+	authJSON := fmt.Sprintf(`{"keys": [{"key": %q, "name": "e2e-admin", "role": "admin"}, {"key": %q, "name": "e2e-operator", "role": "operator"}],
+"functions": {"admin": {"allow": ["*"]}, "operator": {"allow": ["*"], "deny": ["DeleteAsset"]}}}`, cfg.adminKey, cfg.operatorKey)
+	if err := os.WriteFile(authFile, []byte(authJSON), 0o600); err != nil {
+		down()
+		return nil, err
+	}
+	env = append(env,
+		"API_ADDR="+apiAddr,
+		"AUTH_CONFIG="+authFile,
+		"LEADER_ELECTION=none",
+		"DISCOVERY=false",
+		"IDENTITY_WATCH=false",
+		"INDEX_FILE="+filepath.Join(dir, "index.json"),
+		"BLOCK_CHECKPOINT_DIR="+filepath.Join(dir, "checkpoints"),
+		"SAGA_DIR="+filepath.Join(dir, "sagas"),
+		"OFFLINE_DIR="+filepath.Join(dir, "offline"),
+	)
+	logFile, err := os.Create(filepath.Join(dir, "api.log"))
+	if err != nil {
+		down()
+		return nil, err
+	}
+	api := exec.Command("go", "run", ".")
+	api.Env = append(os.Environ(), env...)
+	api.Stdout, api.Stderr = logFile, logFile
+	if err := api.Start(); err != nil {
+		down()
+		return nil, err
+	}
+	log.Printf("API starting on %s, logging to %s", apiAddr, logFile.Name())
+	return func() {
+		// go run forwards the interrupt to the built binary.
+		api.Process.Signal(os.Interrupt)
+		api.Wait()
+		logFile.Close()
+		down()
+	}, nil
+}
+
+func readEnv(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			out = append(out, line)
+		}
+	}
+	return out, sc.Err()
+}
+
+func waitReady(ctx context.Context, base string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := http.Get(base + "/readyz")
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == 200 {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("API at %s not ready after %s", base, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// expectError checks that err is an API error with the given code. Chaincode
+// rejections, not-found included, come back as FABRIC_REJECTED.
+func expectError(err error, code string) error {
+	var apiErr *apiclient.Error
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("want a %s error, got %v", code, err)
+	}
+	if apiErr.ErrorCode != code {
+		return fmt.Errorf("want %s, got %v", code, err)
+	}
+	return nil
+}
+
+func balanceOf(ctx context.Context, s *suite, msisdn string) (int64, error) {
+	a, err := s.public.GetAsset(ctx, msisdn)
+	if err != nil {
+		return 0, err
+	}
+	return a.BALANCE, nil
+}
+
+// get fetches path and returns the body of a 200 response.
+func get(ctx context.Context, s *suite, path, key string) (string, error) {
+	return getAs(ctx, s, path, key, "")
+}
+
+// getAs is get with an Accept header.
+func getAs(ctx context.Context, s *suite, path, key, accept string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("GET %s: %d %s", path, res.StatusCode, b)
+	}
+	return string(b), nil
+}
+
+var checks = []check{
+	{"health", func(ctx context.Context, s *suite) error {
+		return s.public.Health(ctx)
+	}},
+	{"metrics", func(ctx context.Context, s *suite) error {
+		body, err := get(ctx, s, "/metrics", "")
+		if err == nil && !strings.Contains(body, "fabric_api_") {
+			err = errors.New("no fabric_api_ metrics")
+		}
+		return err
+	}},
+	{"events subscribe", func(ctx context.Context, s *suite) error {
+		// Kept open for "events delivered"; it must outlive this check's ctx.
+		sub, err := s.operator.Subscribe(context.Background())
+		s.events = sub
+		return err
+	}},
+	{"create assets", func(ctx context.Context, s *suite) error {
+		for i, bal := range []int64{1000, 500, 0} {
+			a := apiclient.Account{DEALERID: "E2E", MSISDN: s.msisdn(i + 1), MPIN: "1234", BALANCE: bal, STATUS: "ACTIVE"}
+			if err := s.operator.CreateAsset(ctx, a); err != nil {
+				return err
+			}
+		}
+		return expectError(s.operator.CreateAsset(ctx, apiclient.Account{DEALERID: "E2E", MSISDN: s.msisdn(1), STATUS: "ACTIVE"}), rejected)
+	}},
+	{"read asset", func(ctx context.Context, s *suite) error {
+		a, err := s.public.GetAsset(ctx, s.msisdn(1))
+		if err != nil {
+			return err
+		}
+		if a.BALANCE != 1000 || a.DEALERID != "E2E" || a.CreatedAt == 0 {
+			return fmt.Errorf("unexpected account %+v", a)
+		}
+		_, err = s.public.GetAsset(ctx, s.msisdn(99))
+		return expectError(err, rejected)
+	}},
+	{"public status", func(ctx context.Context, s *suite) error {
+		st, err := s.public.PublicStatus(ctx, s.msisdn(1))
+		if err != nil {
+			return err
+		}
+		if !st.Exists || st.STATUS != "ACTIVE" {
+			return fmt.Errorf("public status %+v, want an existing ACTIVE account", st)
+		}
+		if st, err = s.public.PublicStatus(ctx, s.msisdn(99)); err != nil {
+			return err
+		}
+		if st.Exists {
+			return errors.New("public status reports a missing account as existing")
+		}
+		return nil
+	}},
+	{"content negotiation", func(ctx context.Context, s *suite) error {
+		body, err := getAs(ctx, s, "/assets/"+s.msisdn(1), "", "application/xml")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(body, "<Account>") || !strings.Contains(body, "<MSISDN>"+s.msisdn(1)+"</MSISDN>") {
+			return fmt.Errorf("not an XML account: %.200s", body)
+		}
+		body, err = getAs(ctx, s, "/assets/"+s.msisdn(1), "", "application/msgpack")
+		if err == nil && (len(body) == 0 || body[0] == '{') {
+			err = errors.New("not a MessagePack body")
+		}
+		return err
+	}},
+	{"dealer export", func(ctx context.Context, s *suite) error {
+		found := false
+		err := s.admin.EachDealerAsset(ctx, "E2E", func(a apiclient.Account) error {
+			if a.DEALERID != "E2E" {
+				return fmt.Errorf("account %s of dealer %q in E2E export", a.MSISDN, a.DEALERID)
+			}
+			found = found || a.MSISDN == s.msisdn(1)
+			return nil
+		})
+		if err == nil && !found {
+			err = fmt.Errorf("%s missing from export", s.msisdn(1))
+		}
+		return err
+	}},
+	{"dealer quota", func(ctx context.Context, s *suite) error {
+		q, err := s.operator.DealerQuota(ctx, "E2E")
+		if err != nil {
+			return err
+		}
+		if q.DEALERID != "E2E" || q.Used < 1 {
+			return fmt.Errorf("quota %+v, want E2E with its accounts counted", q)
+		}
+		return nil
+	}},
+	{"admin operation log", func(ctx context.Context, s *suite) error {
+		// The quota change needs an admin identity on chain; the log records
+		// the attempt whether or not the chaincode accepts it.
+		s.admin.SetDealerQuota(ctx, "E2E-AUDIT", 0)
+		l, err := s.admin.AdminOperations(ctx, "")
+		if err != nil {
+			return err
+		}
+		if !l.Verified {
+			return errors.New("admin log hash chain does not verify")
+		}
+		for _, op := range l.Operations {
+			if op.Path == "/admin/dealers/E2E-AUDIT/quota" && op.Operation == "PUT /admin/dealers/:dealerId/quota" {
+				return nil
+			}
+		}
+		return errors.New("quota change missing from the admin log")
+	}},
+	{"merge guard", func(ctx context.Context, s *suite) error {
+		// Merging needs an admin identity on chain; the API's own checks
+		// and the lineage read do not.
+		if _, err := s.admin.MergeAccounts(ctx, s.msisdn(1), s.msisdn(2), ""); expectError(err, "CONFIRM_TOKEN_REQUIRED") != nil {
+			return fmt.Errorf("merge without a token: %v", err)
+		}
+		l, err := s.public.MergeLineage(ctx, s.msisdn(1))
+		if err != nil {
+			return err
+		}
+		if len(l.Merges) != 0 {
+			return fmt.Errorf("%s has %d merges, want none", s.msisdn(1), len(l.Merges))
+		}
+		return nil
+	}},
+	{"update asset", func(ctx context.Context, s *suite) error {
+		a := apiclient.Account{DEALERID: "E2E", MSISDN: s.msisdn(1), BALANCE: 1200, STATUS: "ACTIVE", TRANSAMOUNT: 200, TRANSTYPE: "CREDIT", REMARKS: "e2e top-up"}
+		if err := s.operator.UpdateAsset(ctx, a); err != nil {
+			return err
+		}
+		bal, err := balanceOf(ctx, s, s.msisdn(1))
+		if err == nil && bal != 1200 {
+			err = fmt.Errorf("balance %d, want 1200", bal)
+		}
+		return err
+	}},
+	{"request origin", func(ctx context.Context, s *suite) error {
+		c := apiclient.New(s.base, apiclient.WithOrigin("e2e", "APP"))
+		a, err := c.GetAsset(ctx, s.msisdn(2))
+		if err != nil {
+			return err
+		}
+		a.TRANSAMOUNT, a.TRANSTYPE = 0, ""
+		id := fmt.Sprintf("e2e-%d", time.Now().UnixNano())
+		if err := c.UpdateAsset(apiclient.WithRequestID(ctx, id), *a); err != nil {
+			return err
+		}
+		h, err := c.History(ctx, s.msisdn(2))
+		if err != nil {
+			return err
+		}
+		var o *apiclient.Origin
+		for _, rec := range h {
+			if rec.Origin != nil && rec.Origin.RequestID == id {
+				o = rec.Origin
+			}
+		}
+		if o == nil || o.AppID != "e2e" || o.Channel != "APP" || o.Fingerprint == "" {
+			return fmt.Errorf("history origin %+v, want request %s from e2e over APP", o, id)
+		}
+		_, err = apiclient.New(s.base, apiclient.WithOrigin("e2e", "FAX")).GetAsset(ctx, s.msisdn(2))
+		return expectError(err, "INVALID_ORIGIN")
+	}},
+	{"events delivered", func(ctx context.Context, s *suite) error {
+		for {
+			select {
+			case ev, ok := <-s.events.Events:
+				if !ok {
+					return fmt.Errorf("stream ended: %v", s.events.Err())
+				}
+				if ev.EventName == "AssetUpdated" && strings.Contains(string(ev.Payload), s.msisdn(1)) {
+					return nil
+				}
+			case <-ctx.Done():
+				return errors.New("no AssetUpdated event")
+			}
+		}
+	}},
+	{"list assets", func(ctx context.Context, s *suite) error {
+		all, err := s.public.ListAssets(ctx)
+		if err != nil {
+			return err
+		}
+		for _, a := range all {
+			if a.MSISDN == s.msisdn(1) {
+				return nil
+			}
+		}
+		return errors.New("created account not listed")
+	}},
+	{"pagination", func(ctx context.Context, s *suite) error {
+		all, err := s.public.ListAssets(ctx)
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		err = s.public.EachAsset(ctx, 2, func(a apiclient.Account) error {
+			if seen[a.MSISDN] {
+				return fmt.Errorf("%s returned twice", a.MSISDN)
+			}
+			seen[a.MSISDN] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(seen) < len(all) {
+			return fmt.Errorf("pages returned %d accounts, list %d", len(seen), len(all))
+		}
+		page, err := s.public.ListAssetsPage(ctx, 1, "")
+		if err != nil {
+			return err
+		}
+		if len(page.Records) != 1 || page.Bookmark == "" {
+			return fmt.Errorf("first page of 1: %d records, bookmark %q", len(page.Records), page.Bookmark)
+		}
+		if m := page.Meta; m.ReturnedCount != 1 || m.PageSize != 1 || m.NextCursor != page.Bookmark || m.FetchedAtBlockHeight == 0 {
+			return fmt.Errorf("first page of 1: meta %+v", m)
+		}
+		return nil
+	}},
+	{"delta sync", func(ctx context.Context, s *suite) error {
+		cursor, found := "", false
+		for {
+			page, err := s.public.Sync(ctx, cursor, 50)
+			if err != nil {
+				return err
+			}
+			for _, a := range page.Upserts {
+				found = found || a.MSISDN == s.msisdn(1)
+			}
+			cursor = page.NextCursor
+			if !page.HasMore {
+				break
+			}
+		}
+		if !found {
+			return errors.New("updated account not in sync")
+		}
+		return nil
+	}},
+	{"history", func(ctx context.Context, s *suite) error {
+		h, err := s.public.History(ctx, s.msisdn(1))
+		if err == nil && len(h) < 2 {
+			err = fmt.Errorf("%d history entries, want 2", len(h))
+		}
+		if err != nil {
+			return err
+		}
+		_, err = s.public.ExportHistory(ctx, s.msisdn(1), "csv")
+		return err
+	}},
+	{"history pages", func(ctx context.Context, s *suite) error {
+		h, err := s.public.History(ctx, s.msisdn(1))
+		if err != nil {
+			return err
+		}
+		var paged []apiclient.History
+		after := ""
+		for {
+			page, err := s.public.HistoryPage(ctx, s.msisdn(1), 1, after)
+			if err != nil {
+				return err
+			}
+			paged = append(paged, page.Records...)
+			if page.Next == "" {
+				break
+			}
+			after = page.Next
+		}
+		if len(paged) != len(h) {
+			return fmt.Errorf("%d entries in pages of 1, %d in the full history", len(paged), len(h))
+		}
+		for i := range h {
+			if paged[i].TxID != h[i].TxID {
+				return fmt.Errorf("page entry %d is %s, want %s", i, paged[i].TxID, h[i].TxID)
+			}
+		}
+		return nil
+	}},
+	{"transfer with fee", func(ctx context.Context, s *suite) error {
+		q, err := s.operator.TransferFunds(ctx, s.msisdn(1), s.msisdn(2), 100, "e2e transfer")
+		if err != nil {
+			return err
+		}
+		bal, err := balanceOf(ctx, s, s.msisdn(2))
+		if err == nil && bal != 600 {
+			err = fmt.Errorf("receiver balance %d, want 600", bal)
+		}
+		if err == nil && q.Total != q.Amount+q.Fee {
+			err = fmt.Errorf("quote %+v does not add up", q)
+		}
+		if err != nil {
+			return err
+		}
+		r, err := s.operator.GetReceipt(ctx, q.Receipt)
+		if err == nil && r.TxID != q.TxID {
+			err = fmt.Errorf("receipt %s names %s, want %s", q.Receipt, r.TxID, q.TxID)
+		}
+		return err
+	}},
+	{"debit and quote", func(ctx context.Context, s *suite) error {
+		if _, err := s.public.QuoteFee(ctx, "debit", 50); err != nil {
+			return err
+		}
+		if _, err := s.operator.Debit(ctx, s.msisdn(2), 50, "e2e debit"); err != nil {
+			return err
+		}
+		_, err := s.operator.Debit(ctx, s.msisdn(3), 50, "overdraw")
+		return expectError(err, rejected)
+	}},
+	{"recent and statement", func(ctx context.Context, s *suite) error {
+		recent, err := s.public.RecentTransactions(ctx, s.msisdn(2), 10)
+		if err == nil && len(recent) == 0 {
+			err = errors.New("no recent transactions")
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = s.public.MiniStatement(ctx, s.msisdn(2), 5); err != nil {
+			return err
+		}
+		// From yesterday, in case the run crossed midnight UTC.
+		from := time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+		days, err := s.public.DailySummaries(ctx, s.msisdn(2), from, "")
+		if err == nil && len(days) == 0 {
+			err = errors.New("no daily summary")
+		}
+		return err
+	}},
+	{"saga transfer", func(ctx context.Context, s *suite) error {
+		saga, err := s.operator.Transfer(ctx, apiclient.TransferRequest{From: s.msisdn(2), To: s.msisdn(3), Amount: 10, Remarks: "e2e saga"})
+		if err != nil {
+			return err
+		}
+		for saga.State == "running" || saga.State == "compensating" {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("saga %s still %s", saga.ID, saga.State)
+			case <-time.After(time.Second):
+			}
+			if saga, err = s.public.Operation(ctx, saga.ID); err != nil {
+				return err
+			}
+		}
+		if saga.State != "completed" {
+			return fmt.Errorf("saga %s %s: %s", saga.ID, saga.State, saga.Error)
+		}
+		return nil
+	}},
+	{"sub-accounts", func(ctx context.Context, s *suite) error {
+		sub := apiclient.Account{MSISDN: s.msisdn(4), MPIN: "1234", BALANCE: 25, STATUS: "ACTIVE"}
+		if err := s.public.CreateSubAccount(ctx, s.msisdn(1), sub); err != nil {
+			return err
+		}
+		r, err := s.public.SubAccounts(ctx, s.msisdn(1))
+		if err == nil && (len(r.SubAccounts) != 1 || r.SubAccountsBalance != 25) {
+			err = fmt.Errorf("unexpected rollup %+v", r)
+		}
+		return err
+	}},
+	{"signed read", func(ctx context.Context, s *suite) error {
+		a, att, err := s.public.GetAssetSigned(ctx, s.msisdn(1))
+		if err != nil {
+			return err
+		}
+		if att == nil || len(att.Endorsements) == 0 || a.MSISDN != s.msisdn(1) {
+			return errors.New("read returned no endorsements")
+		}
+		return nil
+	}},
+	{"passthrough", func(ctx context.Context, s *suite) error {
+		if _, err := s.public.Query(ctx, "ReadAsset", []string{s.msisdn(1)}, nil); expectError(err, "UNAUTHORIZED") != nil {
+			return fmt.Errorf("unauthenticated query: %v", err)
+		}
+		if _, err := s.operator.Query(ctx, "ReadAsset", []string{s.msisdn(1)}, nil); err != nil {
+			return err
+		}
+		_, err := s.operator.Invoke(ctx, "DeleteAsset", []string{s.msisdn(3)}, nil)
+		return expectError(err, "FUNCTION_NOT_ALLOWED")
+	}},
+	{"admin routes", func(ctx context.Context, s *suite) error {
+		if _, err := s.admin.Dashboard(ctx, 5); err != nil {
+			return err
+		}
+		for _, p := range []string{"/admin/maintenance", "/admin/regions", "/admin/chaincode", "/admin/fees", "/admin/slow-queries"} {
+			if _, err := get(ctx, s, p, s.adminKey); err != nil {
+				return err
+			}
+		}
+		_, err := get(ctx, s, "/admin/maintenance", "")
+		if err == nil {
+			return errors.New("admin route answered without a key")
+		}
+		return nil
+	}},
+	{"openapi roles", func(ctx context.Context, s *suite) error {
+		full, err := get(ctx, s, "/openapi.json", "")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(full, `"/admin/fees"`) || !strings.Contains(full, `"x-required-roles"`) {
+			return errors.New("document misses admin routes or roles")
+		}
+		viewer, err := get(ctx, s, "/openapi.json?role=viewer", "")
+		if err != nil {
+			return err
+		}
+		if strings.Contains(viewer, `"/admin/`) || !strings.Contains(viewer, `"/assets/{msisdn}"`) {
+			return errors.New("viewer document lists the wrong routes")
+		}
+		_, err = get(ctx, s, "/docs/try-it", "")
+		return err
+	}},
+	{"block stream", func(ctx context.Context, s *suite) error {
+		return websocketHandshake(ctx, s.base+"/ws/blocks", s.adminKey)
+	}},
+	{"mpin policy", func(ctx context.Context, s *suite) error {
+		m := s.msisdn(3)
+		if _, err := s.operator.VerifyMPIN(ctx, m, "0000"); expectError(err, "MPIN_MISMATCH") != nil {
+			return fmt.Errorf("wrong PIN: %v", err)
+		}
+		if _, err := s.operator.ChangeMPIN(ctx, m, "1234", "5678"); err != nil {
+			return err
+		}
+		if _, err := s.operator.ChangeMPIN(ctx, m, "5678", "1234"); expectError(err, rejected) != nil {
+			return fmt.Errorf("reusing a PIN: %v", err)
+		}
+		var err error
+		for i := 0; i < 3; i++ {
+			_, err = s.operator.VerifyMPIN(ctx, m, "0000")
+		}
+		if expectError(err, "MPIN_LOCKED") != nil {
+			return fmt.Errorf("third wrong PIN: %v", err)
+		}
+		// Unlocking needs an admin identity on chain, which the API may not have.
+		if _, err := s.operator.VerifyMPIN(ctx, m, "5678"); expectError(err, "MPIN_LOCKED") != nil {
+			return fmt.Errorf("right PIN while locked: %v", err)
+		}
+		return nil
+	}},
+	{"close account", func(ctx context.Context, s *suite) error {
+		if _, err := s.operator.CloseAccount(ctx, s.msisdn(1), s.msisdn(2)); expectError(err, rejected) != nil {
+			return fmt.Errorf("closing with an open sub-account: %v", err)
+		}
+		if _, err := s.operator.CloseAccount(ctx, s.msisdn(4), s.msisdn(1)); err != nil {
+			return err
+		}
+		cl, err := s.operator.CloseAccount(ctx, s.msisdn(1), s.msisdn(2))
+		if err != nil {
+			return err
+		}
+		if got, err := s.public.GetClosure(ctx, s.msisdn(1)); err != nil || got.SettledAmount != cl.SettledAmount {
+			return fmt.Errorf("closure record %+v: %v", got, err)
+		}
+		_, err = s.operator.Debit(ctx, s.msisdn(1), 1, "after closure")
+		return expectError(err, rejected)
+	}},
+	{"delete asset", func(ctx context.Context, s *suite) error {
+		if err := s.operator.DeleteAsset(ctx, s.msisdn(3)); err != nil {
+			return err
+		}
+		_, err := s.public.GetAsset(ctx, s.msisdn(3))
+		return expectError(err, rejected)
+	}},
+	{"deleted assets", func(ctx context.Context, s *suite) error {
+		page, err := s.admin.DeletedAssets(ctx, s.msisdn(3), 100, "")
+		if err != nil {
+			return err
+		}
+		for _, t := range page.Records {
+			if t.Account != nil && t.Account.MPIN != "" {
+				return fmt.Errorf("tombstone %s keeps the MPIN", t.TxID)
+			}
+		}
+		if len(page.Records) == 0 {
+			return fmt.Errorf("no tombstone for %s", s.msisdn(3))
+		}
+		_, err = s.public.DeletedAssets(ctx, "", 10, "")
+		return expectError(err, "UNAUTHORIZED")
+	}},
+}
+
+// websocketHandshake checks that url upgrades to a WebSocket, without reading
+// any frames.
+func websocketHandshake(ctx context.Context, rawURL, key string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nX-API-Key: %s\r\n\r\n", u.RequestURI(), u.Host, key)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("status %d, want 101", res.StatusCode)
+	}
+	return nil
+}
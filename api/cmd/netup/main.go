@@ -0,0 +1,122 @@
+// Command netup brings up a local Fabric test network for this project using
+// fabric-samples/test-network, deploys the asset-management chaincode and
+// writes the environment file the API reads.
+//
+//	go run ./cmd/netup -samples ~/fabric-samples -out .env
+//	go run ./cmd/netup -down
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type config struct {
+	samples   string
+	channel   string
+	ccName    string
+	ccPath    string
+	out       string
+	down      bool
+	skipUp    bool
+	ccVersion string
+	ccSeq     string
+}
+
+func main() {
+	home, _ := os.UserHomeDir()
+	var cfg config
+	flag.StringVar(&cfg.samples, "samples", filepath.Join(home, "fabric-samples"), "path to a fabric-samples checkout with bin/ installed")
+	flag.StringVar(&cfg.channel, "channel", "mychannel", "channel to create")
+	flag.StringVar(&cfg.ccName, "cc-name", "asset-management", "chaincode name")
+	flag.StringVar(&cfg.ccPath, "cc-path", "../chaincode/asset-management", "chaincode source directory")
+	flag.StringVar(&cfg.ccVersion, "cc-version", "1.0", "chaincode version")
+	flag.StringVar(&cfg.ccSeq, "cc-sequence", "1", "chaincode definition sequence")
+	flag.StringVar(&cfg.out, "out", ".env", "env file to write for the API")
+	flag.BoolVar(&cfg.down, "down", false, "tear the network down and exit")
+	flag.BoolVar(&cfg.skipUp, "deploy-only", false, "only (re)deploy the chaincode and rewrite the env file")
+	flag.Parse()
+
+	net := filepath.Join(cfg.samples, "test-network")
+	if err := preflight(cfg.samples); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.down {
+		must(run(net, "./network.sh", "down"))
+		return
+	}
+	if !cfg.skipUp {
+		// -ca issues the crypto material through Fabric CA instead of cryptogen.
+		must(run(net, "./network.sh", "up", "createChannel", "-c", cfg.channel, "-ca"))
+	}
+	ccPath, err := filepath.Abs(cfg.ccPath)
+	must(err)
+	must(run(net, "./network.sh", "deployCC", "-c", cfg.channel, "-ccn", cfg.ccName, "-ccp", ccPath, "-ccl", "go", "-ccv", cfg.ccVersion, "-ccs", cfg.ccSeq))
+	env, err := apiEnv(net, cfg)
+	must(err)
+	must(os.WriteFile(cfg.out, []byte(env), 0o600))
+	log.Printf("network ready, API configuration written to %s", cfg.out)
+}
+
+func must(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func preflight(samples string) error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return errors.New("docker not found in PATH")
+	}
+	for _, p := range []string{"test-network/network.sh", "bin/peer", "bin/fabric-ca-client"} {
+		if _, err := os.Stat(filepath.Join(samples, p)); err != nil {
+			return fmt.Errorf("%s missing; install fabric-samples and binaries first (curl -sSLO https://raw.githubusercontent.com/hyperledger/fabric/main/scripts/install-fabric.sh)", p)
+		}
+	}
+	return nil
+}
+
+func run(dir, name string, args ...string) error {
+	log.Printf("%s %s", name, strings.Join(args, " "))
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "PATH="+filepath.Join(dir, "..", "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return cmd.Run()
+}
+
+// apiEnv renders the variables connect() expects for the Org1 admin.
+func apiEnv(net string, cfg config) (string, error) {
+	org := filepath.Join(net, "organizations", "peerOrganizations", "org1.example.com")
+	user := filepath.Join(org, "users", "Admin@org1.example.com", "msp")
+	keys, err := filepath.Glob(filepath.Join(user, "keystore", "*"))
+	if err != nil || len(keys) == 0 {
+		return "", fmt.Errorf("no private key in %s", filepath.Join(user, "keystore"))
+	}
+	certs, err := filepath.Glob(filepath.Join(user, "signcerts", "*.pem"))
+	if err != nil || len(certs) == 0 {
+		return "", fmt.Errorf("no certificate in %s", filepath.Join(user, "signcerts"))
+	}
+	vars := [][2]string{
+		{"PEER_ENDPOINT", "localhost:7051"},
+		{"GATEWAY_PEER", "peer0.org1.example.com"},
+		{"MSP_ID", "Org1MSP"},
+		{"CHANNEL_NAME", cfg.channel},
+		{"CHAINCODE_NAME", cfg.ccName},
+		{"TLS_CERT_PATH", filepath.Join(org, "peers", "peer0.org1.example.com", "tls", "ca.crt")},
+		{"CERT_PATH", certs[0]},
+		{"KEY_PATH", keys[0]},
+	}
+	var b strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&b, "%s=%s\n", v[0], v[1])
+	}
+	return b.String(), nil
+}
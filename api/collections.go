@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const endorsingOrgsHeader = "X-Endorsing-Organizations"
+
+// Collection is the part of a Fabric collections config entry the API uses.
+// Policy is the collection's member policy, e.g.
+// "OR('Org1MSP.member', 'Org2MSP.member')".
+type Collection struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+}
+
+// policyPrincipal matches the 'MSPID.role' principals of a signature policy.
+var policyPrincipal = regexp.MustCompile(`'([^'.]+)\.[a-z]+'`)
+
+// endorsers maps a chaincode function to the organisations allowed to
+// endorse it: the members of the private data collection it touches.
+var endorsers = map[string][]string{}
+
+// loadCollections reads COLLECTIONS_CONFIG, the collections config file the
+// chaincode was deployed with, and COLLECTION_FUNCTIONS, which names the
+// collection each private function touches, e.g.
+// "SetKYC=kycCollection,GetKYC=kycCollection".
+func loadCollections() {
+	path := os.Getenv("COLLECTIONS_CONFIG")
+	mapping := os.Getenv("COLLECTION_FUNCTIONS")
+	if path == "" {
+		if mapping != "" {
+			log.Fatalf("COLLECTION_FUNCTIONS needs COLLECTIONS_CONFIG")
+		}
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("COLLECTIONS_CONFIG: %v", err)
+	}
+	var config []Collection
+	if err := json.Unmarshal(b, &config); err != nil {
+		log.Fatalf("COLLECTIONS_CONFIG: %v", err)
+	}
+	members := map[string][]string{}
+	for _, col := range config {
+		orgs := collectionMembers(col.Policy)
+		if len(orgs) == 0 {
+			log.Fatalf("COLLECTIONS_CONFIG: collection %s: no organisations in policy %q", col.Name, col.Policy)
+		}
+		members[col.Name] = orgs
+	}
+	for _, kv := range strings.Split(mapping, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		fn, name, ok := strings.Cut(kv, "=")
+		orgs, known := members[strings.TrimSpace(name)]
+		if !ok || !known {
+			log.Fatalf("COLLECTION_FUNCTIONS: bad entry %q", kv)
+		}
+		endorsers[strings.TrimSpace(fn)] = orgs
+	}
+}
+
+// collectionMembers returns the MSP IDs named in a member policy, sorted.
+// Any member may hold the data, whatever the policy's AND and OutOf
+// structure, so all of them are safe endorsers.
+func collectionMembers(policy string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range policyPrincipal.FindAllStringSubmatch(policy, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			out = append(out, m[1])
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// endorsementOptions restricts fn's proposal to the members of its
+// collection, so its transient data never reaches a peer outside it. It
+// goes before the request's own options, whose override then wins.
+func endorsementOptions(fn string) []client.ProposalOption {
+	if orgs, ok := endorsers[fn]; ok {
+		return []client.ProposalOption{client.WithEndorsingOrganizations(orgs...)}
+	}
+	return nil
+}
+
+// endorsingOverride reads X-Endorsing-Organizations, a comma-separated list
+// of MSP IDs that replaces the organisations a proposal goes to. Only admin
+// keys may send it: nothing checks the list against the collection.
+func endorsingOverride(c *gin.Context) ([]string, bool) {
+	h := c.GetHeader(endorsingOrgsHeader)
+	if h == "" {
+		return nil, true
+	}
+	if principal(c).Role != RoleAdmin {
+		apiError(c, 403, ErrForbidden, nil)
+		return nil, false
+	}
+	var orgs []string
+	for _, o := range strings.Split(h, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			orgs = append(orgs, o)
+		}
+	}
+	if len(orgs) == 0 {
+		apiError(c, 400, ErrInvalidEndorsingOrgs, gin.H{"header": endorsingOrgsHeader})
+		return nil, false
+	}
+	return orgs, true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiVersion is the version of the response shapes. It goes up when a
+// field is removed or changes type; adding fields does not change it.
+const apiVersion = 1
+
+var apiPrefix = "/api/v" + strconv.Itoa(apiVersion)
+
+// DeprecatedField is a response field kept for compatibility until the
+// next API version; Replacement is what to read instead.
+type DeprecatedField struct {
+	Field       string `json:"field"`
+	Replacement string `json:"replacement"`
+}
+
+type deprecation struct {
+	fields        []DeprecatedField
+	since, sunset time.Time
+}
+
+var (
+	metaSince  = time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	metaSunset = time.Date(2027, 4, 16, 0, 0, 0, 0, time.UTC)
+)
+
+// deprecations lists retired response fields by route. The meta block that
+// list endpoints gained replaces their own paging fields.
+var deprecations = map[string]deprecation{
+	"GET /assets": {
+		fields: []DeprecatedField{{"bookmark", "meta.nextCursor"}, {"fetchedCount", "meta.hasMore"}},
+		since:  metaSince, sunset: metaSunset,
+	},
+	"GET /assets/:msisdn/history": {
+		fields: []DeprecatedField{{"next", "meta.nextCursor"}},
+		since:  metaSince, sunset: metaSunset,
+	},
+	"GET /assets/deleted": {
+		fields: []DeprecatedField{{"bookmark", "meta.nextCursor"}, {"fetchedCount", "meta.hasMore"}},
+		since:  metaSince, sunset: metaSunset,
+	},
+	"GET /sync": {
+		fields: []DeprecatedField{{"nextCursor", "meta.nextCursor"}, {"hasMore", "meta.hasMore"}},
+		since:  metaSince, sunset: metaSunset,
+	},
+}
+
+// routeVersion is the version of a route's response shape: "METHOD /path"
+// or a bare path. Routes under apiV2Prefix have their own; the rest are at
+// apiVersion.
+func routeVersion(route string) int {
+	if _, path, ok := strings.Cut(route, " "); ok {
+		route = path
+	}
+	if strings.HasPrefix(route, apiV2Prefix+"/") {
+		return 2
+	}
+	return apiVersion
+}
+
+// successors names the version 2 route that replaces a version 1 one, sent
+// as a successor-version Link so clients can find it.
+var successors = map[string]string{
+	"GET /assets/:msisdn/history": apiV2Prefix + "/assets/:msisdn/history",
+}
+
+// versionedPaths serves every route under /api/v<apiVersion> as well as at
+// its bare path.
+func versionedPaths(h http.Handler) http.Handler {
+	stripped := http.StripPrefix(apiPrefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+			stripped.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// deprecationHeaders names the API version on every response, links
+// version 1 routes to their successors and, on routes with retired fields,
+// announces them: Deprecation (RFC 9745) and Sunset (RFC 8594) give the
+// dates, X-Deprecated-Fields the fields and what replaces them. The fields
+// may be absent from some variants of the route, such as unpaged lists.
+func deprecationHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("X-API-Version", strconv.Itoa(routeVersion(c.FullPath())))
+		if next, ok := successors[c.Request.Method+" "+c.FullPath()]; ok {
+			for _, p := range c.Params {
+				next = strings.Replace(next, ":"+p.Key, url.PathEscape(p.Value), 1)
+			}
+			c.Header("Link", "<"+next+">; rel=\"successor-version\"")
+		}
+		if d, ok := deprecations[c.Request.Method+" "+c.FullPath()]; ok {
+			c.Header("Deprecation", "@"+strconv.FormatInt(d.since.Unix(), 10))
+			c.Header("Sunset", d.sunset.Format(http.TimeFormat))
+			names := make([]string, len(d.fields))
+			for i, f := range d.fields {
+				names[i] = f.Field + " (use " + f.Replacement + ")"
+			}
+			c.Header("X-Deprecated-Fields", strings.Join(names, ", "))
+		}
+		c.Next()
+	}
+}
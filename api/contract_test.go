@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateContract = flag.Bool("update", false, "rewrite the fixtures in contract/ unless a change needs a new API version")
+
+// contractDir holds one golden fixture per entry of responseShapes,
+// relative to the api directory.
+const contractDir = "contract"
+
+// responseShapes are the typed success responses the contract covers, by
+// route as in routeDocs; "?pageSize" marks the paged variant of a list and
+// "?expand" the account with its expansions.
+// Routes answering ad hoc objects, streams or non-JSON bodies are not
+// covered.
+var responseShapes = map[string]any{
+	"GET /public/assets/:msisdn/status":           PublicStatus{},
+	"GET /assets":                                 ListResponse[Account]{},
+	"GET /assets?pageSize":                        AssetPage{},
+	"GET /sync":                                   SyncPage{},
+	"GET /assets/:msisdn":                         Account{},
+	"GET /assets/:msisdn?expand":                  ExpandedAccount{},
+	"GET /assets/:msisdn/history":                 ListResponse[History]{},
+	"GET /assets/:msisdn/history?pageSize":        HistoryPage{},
+	"GET /api/v2/assets/:msisdn/history":          ListResponse[HistoryV2]{},
+	"GET /assets/:msisdn/recent-transactions":     ListResponse[TxSummary]{},
+	"GET /assets/:msisdn/ministatement":           MiniStatement{},
+	"GET /assets/:msisdn/daily-summaries":         ListResponse[DailySummary]{},
+	"POST /operations/transfers":                  Saga{},
+	"GET /operations/:id":                         Saga{},
+	"GET /submissions/:key":                       SubmissionStatus{},
+	"POST /assets/:msisdn/transfer":               FeeQuote{},
+	"POST /assets/:msisdn/debit":                  FeeQuote{},
+	"GET /assets/:msisdn/holds":                   Holds{},
+	"POST /assets/:msisdn/holds":                  Hold{},
+	"POST /assets/:msisdn/holds/:ref/release":     Hold{},
+	"POST /assets/:msisdn/holds/:ref/capture":     FeeQuote{},
+	"POST /assets/:msisdn/close":                  Closure{},
+	"GET /assets/:msisdn/closure":                 Closure{},
+	"GET /assets/:msisdn/merges":                  MergeLineage{},
+	"POST /assets/:msisdn/mpin":                   PINResult{},
+	"POST /assets/:msisdn/mpin/verify":            PINResult{},
+	"GET /assets/:msisdn/mpin":                    PINResult{},
+	"GET /receipts/:receipt":                      Receipt{},
+	"GET /transactions/:txId/origin":              Origin{},
+	"GET /fees/quote":                             FeeQuote{},
+	"GET /assets/:msisdn/balance-proof":           BalanceProof{},
+	"GET /assets/:msisdn/subaccounts":             Rollup{},
+	"GET /assets/deleted":                         TombstonePage{},
+	"GET /dealers/:dealerId/quota":                DealerQuota{},
+	"GET /dashboard":                              Dashboard{},
+	"GET /admin/maintenance":                      MaintenanceState{},
+	"POST /admin/maintenance":                     MaintenanceState{},
+	"GET /admin/read-strategies":                  readStrategiesState{},
+	"PUT /admin/read-strategies":                  readStrategiesState{},
+	"GET /admin/tenants/:tenant":                  TenantConfig{},
+	"PUT /admin/tenants/:tenant":                  TenantConfig{},
+	"GET /admin/state-validation":                 StateReport{},
+	"GET /admin/chaincode":                        ChaincodeInfo{},
+	"GET /admin/topology":                         Topology{},
+	"GET /admin/tls-roots":                        TLSRoots{},
+	"POST /admin/tls-roots/reload":                TLSRoots{},
+	"GET /admin/policies":                         PolicySet{},
+	"POST /admin/policies/reload":                 PolicySet{},
+	"POST /admin/policies/evaluate":               PolicyDecision{},
+	"GET /admin/runbooks/jobs":                    RunbookJobs{},
+	"GET /admin/runbooks/jobs/:id":                RunbookJob{},
+	"DELETE /admin/runbooks/jobs/:id":             RunbookJob{},
+	"POST /admin/runbooks/:action":                RunbookJob{},
+	"GET /admin/regions":                          []RegionState{},
+	"GET /admin/dealers/:dealerId/deletion":       DealerDeletionPlan{},
+	"POST /admin/dealers/:dealerId/deletion":      DealerDeletionResult{},
+	"GET /admin/assets/:msisdn/merge":             MergePlan{},
+	"POST /admin/assets/:msisdn/merge":            MergeLineage{},
+	"PUT /admin/dealers/:dealerId/quota":          DealerQuota{},
+	"POST /admin/dealers/:dealerId/quota/recount": DealerQuota{},
+	"GET /admin/fees":                             FeeSchedule{},
+	"PUT /admin/fees":                             FeeSchedule{},
+	"GET /admin/fx":                               FXConfig{},
+	"PUT /admin/fx":                               FXConfig{},
+	"POST /admin/assets/:msisdn/mpin/unlock":      PINResult{},
+	"GET /admin/mpin-policy":                      PINPolicy{},
+	"PUT /admin/mpin-policy":                      PINPolicy{},
+}
+
+// contractFixture is a golden response shape. A shape is a JSON type name
+// ("string", "integer", "number", "boolean" or "any"), an object of field
+// shapes, an object {"*": shape} for maps, or a one-element array of the
+// element shape.
+type contractFixture struct {
+	APIVersion int    `json:"apiVersion"`
+	Route      string `json:"route"`
+	Shape      any    `json:"shape"`
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// shapeOf describes the JSON encoding/json produces for t.
+func shapeOf(t reflect.Type, seen map[reflect.Type]bool) any {
+	switch t {
+	case timeType:
+		return "string"
+	case rawType:
+		return "any"
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return shapeOf(t.Elem(), seen)
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return []any{shapeOf(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]any{"*": shapeOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return "any"
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		out := map[string]any{}
+		addFields(t, out, seen)
+		return out
+	}
+	return "any"
+}
+
+func addFields(t reflect.Type, out map[string]any, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(ft, out, seen)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out[name] = shapeOf(f.Type, seen)
+	}
+}
+
+// compareShapes lists what changed from old to cur under path: breaking
+// removals and type changes, and additions.
+func compareShapes(path string, old, cur any, breaking, added *[]string) {
+	switch o := old.(type) {
+	case map[string]any:
+		c, ok := cur.(map[string]any)
+		if !ok {
+			*breaking = append(*breaking, fmt.Sprintf("%s: was an object, now %s", path, shapeName(cur)))
+			return
+		}
+		for _, k := range sortedKeys(o) {
+			if _, ok := c[k]; !ok {
+				*breaking = append(*breaking, fmt.Sprintf("%s.%s: removed", path, k))
+				continue
+			}
+			compareShapes(path+"."+k, o[k], c[k], breaking, added)
+		}
+		for _, k := range sortedKeys(c) {
+			if _, ok := o[k]; !ok {
+				*added = append(*added, path+"."+k)
+			}
+		}
+	case []any:
+		c, ok := cur.([]any)
+		if !ok || len(o) != 1 || len(c) != 1 {
+			*breaking = append(*breaking, fmt.Sprintf("%s: was an array, now %s", path, shapeName(cur)))
+			return
+		}
+		compareShapes(path+"[]", o[0], c[0], breaking, added)
+	default:
+		if old != cur {
+			*breaking = append(*breaking, fmt.Sprintf("%s: was %s, now %s", path, shapeName(old), shapeName(cur)))
+		}
+	}
+}
+
+func shapeName(s any) string {
+	switch s.(type) {
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	}
+	return fmt.Sprint(s)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var fixtureUnsafe = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func fixturePath(route string) string {
+	return filepath.Join(contractDir, strings.Trim(fixtureUnsafe.ReplaceAllString(route, "_"), "_")+".json")
+}
+
+// currentShape is route's shape as the code encodes it today, normalised
+// through JSON so it compares with a fixture read from disk.
+func currentShape(route string) any {
+	var s any
+	b, _ := json.Marshal(shapeOf(reflect.TypeOf(responseShapes[route]), map[reflect.Type]bool{}))
+	json.Unmarshal(b, &s)
+	return s
+}
+
+// TestContract checks the response shapes against the fixtures in
+// contractDir, or with -update rewrites them. Removing a field or changing
+// its type fails unless apiVersion is above the fixture's; so does a
+// fixture whose route is gone, a missing fixture, and a deprecation naming
+// a field the route does not have. New fields only ask for the fixtures to
+// be updated.
+func TestContract(t *testing.T) {
+	routes := make([]string, 0, len(responseShapes))
+	for r := range responseShapes {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	var writes []contractFixture
+	for _, route := range routes {
+		cur := currentShape(route)
+		b, err := os.ReadFile(fixturePath(route))
+		if os.IsNotExist(err) {
+			if !*updateContract {
+				t.Errorf("%s: no fixture at %s", route, fixturePath(route))
+			}
+			writes = append(writes, contractFixture{routeVersion(route), route, cur})
+			continue
+		}
+		var fx contractFixture
+		if err == nil {
+			err = json.Unmarshal(b, &fx)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", route, err)
+			continue
+		}
+		var breaking, added []string
+		compareShapes("$", fx.Shape, cur, &breaking, &added)
+		version := routeVersion(route)
+		switch {
+		case len(breaking) > 0 && fx.APIVersion >= version:
+			t.Errorf("%s: breaking changes without a new apiVersion (fixture is v%d):\n  %s", route, fx.APIVersion, strings.Join(breaking, "\n  "))
+			continue
+		case len(breaking) > 0:
+			t.Logf("%s: breaking changes for v%d:\n  %s", route, version, strings.Join(breaking, "\n  "))
+		case len(added) > 0 && !*updateContract:
+			t.Logf("%s: new fields %s; run go test -run TestContract -update", route, strings.Join(added, ", "))
+		}
+		if len(breaking) > 0 || len(added) > 0 || fx.APIVersion != version {
+			writes = append(writes, contractFixture{version, route, cur})
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(contractDir, "*.json"))
+	for _, f := range files {
+		var fx contractFixture
+		if b, err := os.ReadFile(f); err != nil || json.Unmarshal(b, &fx) != nil {
+			t.Errorf("%s: unreadable fixture", f)
+			continue
+		}
+		if _, live := responseShapes[fx.Route]; live || fixturePath(fx.Route) != f {
+			continue
+		}
+		if fx.APIVersion >= routeVersion(fx.Route) {
+			t.Errorf("%s: route removed without a new apiVersion", fx.Route)
+		} else if *updateContract {
+			os.Remove(f)
+		}
+	}
+	for route, d := range deprecations {
+		for _, f := range d.fields {
+			found := false
+			for _, variant := range []string{route, route + "?pageSize"} {
+				if _, covered := responseShapes[variant]; !covered {
+					continue
+				}
+				if s, ok := currentShape(variant).(map[string]any); ok {
+					_, has := s[f.Field]
+					found = found || has
+				}
+			}
+			if !found {
+				t.Errorf("%s: deprecated field %s is not in its response", route, f.Field)
+			}
+		}
+	}
+	if t.Failed() || !*updateContract {
+		return
+	}
+	if err := os.MkdirAll(contractDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, fx := range writes {
+		b, _ := json.MarshalIndent(fx, "", "  ")
+		if err := os.WriteFile(fixturePath(fx.Route), append(b, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("wrote %s", fixturePath(fx.Route))
+	}
+}
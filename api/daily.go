@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxDailyDays = 366
+
+// DailySummary totals an account's postings on one UTC day, kept on chain.
+type DailySummary struct {
+	MSISDN         string        `json:"MSISDN" xml:"MSISDN"`
+	Date           string        `json:"date" xml:"date"`
+	Count          int           `json:"count" xml:"count"`
+	Credits        int64         `json:"credits" xml:"credits"`
+	Debits         int64         `json:"debits" xml:"debits"`
+	ByType         xmlMap[int64] `json:"byType" xml:"byType"`
+	OpeningBalance int64         `json:"openingBalance" xml:"openingBalance"`
+	ClosingBalance int64         `json:"closingBalance" xml:"closingBalance"`
+}
+
+// dailySummariesHandler returns :msisdn's daily summaries from ?from= to ?to=
+// (YYYY-MM-DD, both default today), read from the summary keys rather than
+// the history.
+func dailySummariesHandler(c *gin.Context) {
+	today := time.Now().UTC().Format(time.DateOnly)
+	var days [2]time.Time
+	for i, param := range []string{"from", "to"} {
+		d, err := time.Parse(time.DateOnly, c.DefaultQuery(param, today))
+		if err != nil {
+			apiError(c, 400, ErrInvalidDate, gin.H{"param": param})
+			return
+		}
+		days[i] = d
+	}
+	if n := int(days[1].Sub(days[0]).Hours()/24) + 1; n < 1 || n > maxDailyDays {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "days", "min": 1, "max": maxDailyDays})
+		return
+	}
+	opts, ok := proposalOptions(c, c.Param("msisdn"), days[0].Format("20060102"), days[1].Format("20060102"))
+	if !ok {
+		return
+	}
+	height := chainHeight(c)
+	res, err := evaluate(c, "GetDailySummaries", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	out := []DailySummary{}
+	if err := json.Unmarshal(res, &out); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, ListResponse[DailySummary]{Records: out, Meta: ListMeta{ReturnedCount: len(out), FetchedAtBlockHeight: height}})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type DealerBalance struct {
+	DEALERID string `json:"DEALERID"`
+	Accounts int    `json:"accounts"`
+	BALANCE  int64  `json:"BALANCE"`
+}
+
+type Dashboard struct {
+	TotalAccounts int             `json:"totalAccounts"`
+	TotalBalance  int64           `json:"totalBalance"`
+	ByStatus      map[string]int  `json:"byStatus"`
+	TopDealers    []DealerBalance `json:"topDealers"`
+	Volume24h     int64           `json:"volume24h"`
+	Count24h      int             `json:"transactions24h"`
+	AsOf          int64           `json:"asOf"`
+}
+
+func dashboardHandler(c *gin.Context) {
+	top, err := strconv.Atoi(c.DefaultQuery("topDealers", "5"))
+	if err != nil || top < 0 || top > 100 {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "topDealers", "min": 0, "max": 100})
+		return
+	}
+	res, err := evaluate(c, "GetDashboard", client.WithArguments(strconv.Itoa(top)))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var d Dashboard
+	if err := json.Unmarshal(res, &d); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, d)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// dealerAssetsHandler answers GET /assets for a dealer key with only that
+// dealer's accounts, paged when pageSize is given. The chaincode enforces the
+// same scope for identities carrying a dealerId attribute.
+func dealerAssetsHandler(c *gin.Context, dealerID string) {
+	if c.Query("pageSize") == "" {
+		if fromIndex(c, "accounts") {
+			indexedAssetsHandler(c, dealerID)
+			return
+		}
+		opts, ok := proposalOptions(c, dealerID)
+		if !ok {
+			return
+		}
+		height := chainHeight(c)
+		res, err := evaluate(c, "GetAssetsByDealer", opts...)
+		if err != nil {
+			fabricError(c, err)
+			return
+		}
+		out := []Account{}
+		if len(res) > 0 {
+			if err := json.Unmarshal(res, &out); err != nil {
+				internalError(c, err)
+				return
+			}
+		}
+		respond(c, 200, ListResponse[Account]{Records: out, Meta: ListMeta{ReturnedCount: len(out), FetchedAtBlockHeight: height}})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.Query("pageSize"))
+	if err != nil || pageSize < 1 || pageSize > 1000 {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "pageSize", "min": 1, "max": 1000})
+		return
+	}
+	opts, ok := proposalOptions(c, dealerID, strconv.Itoa(pageSize), c.Query("bookmark"))
+	if !ok {
+		return
+	}
+	cachedAssetsPage(c, pageKey{function: "GetAssetsPageByDealer", dealer: dealerID, bookmark: c.Query("bookmark"), pageSize: pageSize}, opts)
+}
+
+// dealerScopeName is the transient field naming the dealer a proposal is
+// made for; the chaincode holds such proposals to that dealer's accounts, as
+// it does certificates with a dealerId attribute.
+const dealerScopeName = "dealerId"
+
+// addDealerScope puts p's dealer into a proposal's transient data, replacing
+// whatever a caller sent under that name.
+func addDealerScope(transient map[string][]byte, p Principal) {
+	if p.Role == RoleDealer {
+		transient[dealerScopeName] = []byte(p.DealerID)
+		return
+	}
+	delete(transient, dealerScopeName)
+}
+
+// ownAccountOnly guards the /assets/:msisdn routes: a dealer key only gets
+// through for its own dealer's accounts and gets a 404 for the rest, as for
+// an unknown MSISDN. Other callers are not looked up.
+func ownAccountOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal(c).Role != RoleDealer {
+			c.Next()
+			return
+		}
+		t := map[string][]byte{}
+		addDealerScope(t, principal(c))
+		res, err := evaluate(c, "ReadAsset", client.WithArguments(c.Param("msisdn")), client.WithTransient(t))
+		if notFound(err) {
+			apiError(c, 404, ErrNotFound, nil)
+			return
+		}
+		if err != nil {
+			fabricError(c, err)
+			c.Abort()
+			return
+		}
+		var a Account
+		if err := json.Unmarshal(res, &a); err != nil {
+			internalError(c, err)
+			return
+		}
+		if !ownAccount(c, a) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// ownDealerOnly filters changes to the accounts of a dealer key's dealer.
+func ownDealerOnly(c *gin.Context, ch *Change) bool {
+	p := principal(c)
+	return p.Role != RoleDealer || ch.DEALERID == p.DealerID
+}
+
+// ownAccount rejects a dealer key reading another dealer's account with the
+// same 404 an unknown MSISDN gets.
+func ownAccount(c *gin.Context, a Account) bool {
+	if p := principal(c); p.Role == RoleDealer && p.DealerID != a.DEALERID {
+		apiError(c, 404, ErrNotFound, nil)
+		return false
+	}
+	return true
+}
+
+// ownDealerAccount rejects a dealer key creating an account for another
+// dealer, or moving one of its accounts to another dealer.
+func ownDealerAccount(c *gin.Context, a Account) bool {
+	if p := principal(c); p.Role == RoleDealer && p.DealerID != a.DEALERID {
+		apiError(c, 403, ErrForbidden, nil)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type DeletionCandidate struct {
+	MSISDN  string `json:"MSISDN"`
+	BALANCE int64  `json:"BALANCE"`
+	STATUS  string `json:"STATUS"`
+	PARENT  string `json:"PARENT,omitempty"`
+}
+
+type DealerDeletionPlan struct {
+	DEALERID     string              `json:"DEALERID"`
+	Accounts     []DeletionCandidate `json:"accounts"`
+	TotalBalance int64               `json:"totalBalance"`
+	BatchSize    int                 `json:"batchSize"`
+	ConfirmToken string              `json:"confirmToken"`
+}
+
+type DealerDeletion struct {
+	DEALERID     string   `json:"DEALERID"`
+	Deleted      []string `json:"deleted"`
+	Remaining    int      `json:"remaining"`
+	ConfirmToken string   `json:"confirmToken,omitempty"`
+}
+
+// DealerDeletionResult adds up the batches of one request. Failure is set
+// when a batch failed after earlier ones had committed.
+type DealerDeletionResult struct {
+	DEALERID  string       `json:"DEALERID"`
+	Deleted   []string     `json:"deleted"`
+	Remaining int          `json:"remaining"`
+	Batches   int          `json:"batches"`
+	Failure   *FabricError `json:"failure,omitempty"`
+}
+
+type dealerDeletionRequest struct {
+	ConfirmToken string `json:"confirmToken"`
+}
+
+// dealerDeletionPlanHandler is the mandatory dry run: it lists what deleting
+// the dealer would remove and the confirmToken the delete needs.
+func dealerDeletionPlanHandler(c *gin.Context) {
+	res, err := contract.Evaluate("PlanDealerDeletion", client.WithArguments(c.Param("dealerId")))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var plan DealerDeletionPlan
+	if err := json.Unmarshal(res, &plan); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, plan)
+}
+
+// deleteDealerAssetsHandler submits DeleteAssetsByDealer batch by batch until
+// the dealer has no accounts left, passing each batch the token the previous
+// one returned. If the accounts change in between, the chaincode rejects the
+// token and the caller has to run the dry run again.
+func deleteDealerAssetsHandler(c *gin.Context) {
+	var req dealerDeletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.ConfirmToken == "" {
+		apiError(c, 400, ErrConfirmTokenRequired, nil)
+		return
+	}
+	out := DealerDeletionResult{DEALERID: c.Param("dealerId"), Deleted: []string{}}
+	token := req.ConfirmToken
+	for token != "" {
+		opts, ok := proposalOptions(c, out.DEALERID, token)
+		if !ok {
+			return
+		}
+		res, _, err := submit("DeleteAssetsByDealer", opts...)
+		if err != nil {
+			if out.Batches == 0 {
+				fabricError(c, err)
+				return
+			}
+			fe := localizedFabricError(c, err)
+			c.Set(fabricErrorKey, fe)
+			out.Failure = &fe
+			c.JSON(500, out)
+			return
+		}
+		var batch DealerDeletion
+		if err := json.Unmarshal(res, &batch); err != nil {
+			internalError(c, err)
+			return
+		}
+		out.Batches++
+		out.Deleted = append(out.Deleted, batch.Deleted...)
+		out.Remaining = batch.Remaining
+		token = batch.ConfirmToken
+	}
+	c.JSON(200, out)
+}
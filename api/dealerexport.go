@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dealerExportHandler streams :dealerId's accounts as newline-delimited JSON,
+// one account per line, paging through the chaincode's dealer index and
+// flushing each page as it arrives so memory stays flat however many
+// accounts the dealer has. Once the first line is out the status is
+// committed, so a later failure ends the stream with an {"error": ...} line
+// instead.
+func dealerExportHandler(c *gin.Context) {
+	dealerID := c.Param("dealerId")
+	if p := principal(c); p.Role == RoleDealer && p.DealerID != dealerID {
+		apiError(c, 403, ErrForbidden, nil)
+		return
+	}
+	pageSize := 1000
+	if v := c.Query("pageSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			apiError(c, 400, ErrOutOfRange, gin.H{"param": "pageSize", "min": 1, "max": 1000})
+			return
+		}
+		pageSize = n
+	}
+	ctx := c.Request.Context()
+	enc := json.NewEncoder(c.Writer)
+	started := false
+	bookmark := ""
+	for {
+		opts, ok := proposalOptions(c, dealerID, strconv.Itoa(pageSize), bookmark)
+		if !ok {
+			return
+		}
+		res, err := evaluateNearest(ctx, "GetAssetsPageByDealerIndex", opts...)
+		if err != nil {
+			if !started {
+				fabricError(c, err)
+				return
+			}
+			enc.Encode(gin.H{"error": localizedFabricError(c, err)})
+			return
+		}
+		var page AssetPage
+		if err := json.Unmarshal(res, &page); err != nil {
+			if !started {
+				internalError(c, err)
+				return
+			}
+			enc.Encode(gin.H{"error": errorBody(c, ErrInternal, gin.H{"detail": err.Error()})})
+			return
+		}
+		if !started {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(dealerID+"-assets.ndjson"))
+			c.Status(200)
+			started = true
+		}
+		for _, a := range page.Records {
+			if err := enc.Encode(a); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+		if page.Bookmark == "" || int(page.Fetched) < pageSize || ctx.Err() != nil {
+			return
+		}
+		bookmark = page.Bookmark
+	}
+}
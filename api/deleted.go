@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tombstone is the chaincode's record of a deleted account: its last state,
+// without the MPIN, and who removed it when.
+type Tombstone struct {
+	MSISDN    string   `json:"MSISDN" xml:"MSISDN"`
+	DEALERID  string   `json:"DEALERID" xml:"DEALERID"`
+	Account   *Account `json:"account" xml:"account"`
+	DeletedAt int64    `json:"deletedAt" xml:"deletedAt"`
+	DeletedBy string   `json:"deletedBy" xml:"deletedBy"`
+	TxID      string   `json:"txId" xml:"txId"`
+	Function  string   `json:"function" xml:"function"`
+	Origin    *Origin  `json:"origin,omitempty" xml:"origin,omitempty"`
+	// MergedInto is the account this one was merged into, if it was.
+	MergedInto string `json:"mergedInto,omitempty" xml:"mergedInto,omitempty"`
+}
+
+type TombstonePage struct {
+	Records  []Tombstone `json:"records" xml:"records>tombstone"`
+	Bookmark string      `json:"bookmark" xml:"bookmark"`
+	Fetched  int32       `json:"fetchedCount" xml:"fetchedCount"`
+	Meta     ListMeta    `json:"meta" xml:"meta"`
+}
+
+// deletedAssetsHandler pages through deleted accounts for compliance
+// review, optionally only ?msisdn='s removals.
+func deletedAssetsHandler(c *gin.Context) {
+	pageSize := 100
+	if v := c.Query("pageSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			apiError(c, 400, ErrOutOfRange, gin.H{"param": "pageSize", "min": 1, "max": 1000})
+			return
+		}
+		pageSize = n
+	}
+	opts, ok := proposalOptions(c, c.Query("msisdn"), strconv.Itoa(pageSize), c.Query("bookmark"))
+	if !ok {
+		return
+	}
+	height := chainHeight(c)
+	res, err := evaluate(c, "GetDeletedAssets", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var page TombstonePage
+	if err := json.Unmarshal(res, &page); err != nil {
+		internalError(c, err)
+		return
+	}
+	page.Meta = ListMeta{ReturnedCount: len(page.Records), PageSize: pageSize, NextCursor: page.Bookmark, HasMore: pageHasMore(page.Bookmark, page.Fetched, pageSize), FetchedAtBlockHeight: height}
+	respond(c, 200, page)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/discovery"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gossip"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/protobuf/proto"
+)
+
+const gatewayScheme = "fabric"
+
+type DiscoveredPeer struct {
+	MSPID        string   `json:"mspId"`
+	Endpoint     string   `json:"endpoint"`
+	LedgerHeight uint64   `json:"ledgerHeight"`
+	Chaincodes   []string `json:"chaincodes"`
+	Local        bool     `json:"local"`
+}
+
+type Topology struct {
+	Channel     string              `json:"channel"`
+	Bootstrap   string              `json:"bootstrap"`
+	Gateways    []string            `json:"gateways"`
+	Peers       []DiscoveredPeer    `json:"peers"`
+	Orderers    map[string][]string `json:"orderers"`
+	RefreshedAt time.Time           `json:"refreshedAt"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// topology is the last discovery result. gatewayTargets feeds the gateway
+// connection's resolver; it is nil unless DISCOVERY=true.
+var (
+	topology = struct {
+		sync.RWMutex
+		Topology
+	}{}
+	gatewayTargets *manual.Resolver
+	// peerConn is peerPool, as the interface the gateway and discovery
+	// clients take.
+	peerConn grpc.ClientConnInterface
+	peerPool *connPool
+)
+
+// gatewayDialTarget returns what connect dials. With DISCOVERY=true that is
+// a resolver starting at PEER_ENDPOINT that runDiscovery later points at
+// every live peer of our own org, balanced round robin, so evaluations stay
+// in the local org and a single peer going away is not an outage.
+func gatewayDialTarget(peerEndpoint, serverName string) (string, []grpc.DialOption) {
+	topology.Bootstrap = peerEndpoint
+	topology.Gateways = []string{peerEndpoint}
+	topology.Peers = []DiscoveredPeer{}
+	topology.Orderers = map[string][]string{}
+	if os.Getenv("DISCOVERY") != "true" {
+		return peerEndpoint, []grpc.DialOption{grpc.WithTransportCredentials(ids.transportCredentials(serverName))}
+	}
+	gatewayTargets = manual.NewBuilderWithScheme(gatewayScheme)
+	gatewayTargets.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: peerEndpoint, ServerName: serverName}}})
+	return gatewayScheme + ":///gateway", []grpc.DialOption{
+		// The server name comes from each address.
+		grpc.WithTransportCredentials(ids.transportCredentials("")),
+		grpc.WithResolvers(gatewayTargets),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"round_robin":{}}]}`),
+	}
+}
+
+// runDiscovery asks the discovery service for the channel's peers and
+// orderers every DISCOVERY_INTERVAL (default 1m) until ctx is cancelled.
+func runDiscovery(ctx context.Context) {
+	if gatewayTargets == nil {
+		return
+	}
+	interval := time.Minute
+	if v := os.Getenv("DISCOVERY_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("DISCOVERY_INTERVAL: %v", err)
+		}
+		interval = d
+	}
+	for {
+		refreshTopology(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func refreshTopology(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	peers, orderers, err := discover(ctx)
+	topology.Lock()
+	defer topology.Unlock()
+	if err != nil {
+		// Keep the last known topology and targets; the error is reported.
+		log.Printf("discovery: %v", err)
+		topology.Error = err.Error()
+		return
+	}
+	var addrs []resolver.Address
+	var gateways []string
+	for _, p := range peers {
+		if !p.Local {
+			continue
+		}
+		host, _, err := net.SplitHostPort(p.Endpoint)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, resolver.Address{Addr: p.Endpoint, ServerName: host})
+		gateways = append(gateways, p.Endpoint)
+	}
+	if len(addrs) > 0 {
+		if err := gatewayTargets.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+			log.Printf("discovery: update gateway targets: %v", err)
+		} else {
+			topology.Gateways = gateways
+		}
+	}
+	topology.Peers = peers
+	topology.Orderers = orderers
+	topology.RefreshedAt = time.Now().UTC()
+	topology.Error = ""
+}
+
+// discover sends one signed request with a membership and a config query for
+// the channel.
+func discover(ctx context.Context) ([]DiscoveredPeer, map[string][]string, error) {
+	id := ids.snapshot()
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: ids.MspID(), IdBytes: id.certPEM})
+	if err != nil {
+		return nil, nil, err
+	}
+	channel := network.Name()
+	payload, err := proto.Marshal(&discovery.Request{
+		Authentication: &discovery.AuthInfo{ClientIdentity: creator},
+		Queries: []*discovery.Query{
+			{Channel: channel, Query: &discovery.Query_PeerQuery{PeerQuery: &discovery.PeerMembershipQuery{}}},
+			{Channel: channel, Query: &discovery.Query_ConfigQuery{ConfigQuery: &discovery.ConfigQuery{}}},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := id.sign(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := discovery.NewDiscoveryClient(peerConn).Discover(ctx, &discovery.SignedRequest{Payload: payload, Signature: sig})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(res.GetResults()) != 2 {
+		return nil, nil, fmt.Errorf("expected 2 results, got %d", len(res.GetResults()))
+	}
+	for _, r := range res.GetResults() {
+		if e := r.GetError(); e != nil {
+			return nil, nil, fmt.Errorf("discovery: %s", e.GetContent())
+		}
+	}
+
+	var peers []DiscoveredPeer
+	for mspID, group := range res.GetResults()[0].GetMembers().GetPeersByOrg() {
+		for _, p := range group.GetPeers() {
+			var alive gossip.GossipMessage
+			if err := proto.Unmarshal(p.GetMembershipInfo().GetPayload(), &alive); err != nil {
+				return nil, nil, err
+			}
+			dp := DiscoveredPeer{MSPID: mspID, Endpoint: alive.GetAliveMsg().GetMembership().GetEndpoint(), Chaincodes: []string{}, Local: mspID == ids.MspID()}
+			var state gossip.GossipMessage
+			if err := proto.Unmarshal(p.GetStateInfo().GetPayload(), &state); err != nil {
+				return nil, nil, err
+			}
+			props := state.GetStateInfo().GetProperties()
+			if props.GetLeftChannel() {
+				continue
+			}
+			dp.LedgerHeight = props.GetLedgerHeight()
+			for _, cc := range props.GetChaincodes() {
+				dp.Chaincodes = append(dp.Chaincodes, cc.GetName()+":"+cc.GetVersion())
+			}
+			peers = append(peers, dp)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].MSPID != peers[j].MSPID {
+			return peers[i].MSPID < peers[j].MSPID
+		}
+		return peers[i].Endpoint < peers[j].Endpoint
+	})
+
+	orderers := map[string][]string{}
+	for mspID, eps := range res.GetResults()[1].GetConfigResult().GetOrderers() {
+		for _, ep := range eps.GetEndpoint() {
+			orderers[mspID] = append(orderers[mspID], net.JoinHostPort(ep.GetHost(), strconv.Itoa(int(ep.GetPort()))))
+		}
+	}
+	return peers, orderers, nil
+}
+
+// topologyHandler reports the last discovered peers and orderers and the
+// peers the gateway connection is currently balanced across.
+func topologyHandler(c *gin.Context) {
+	topology.RLock()
+	defer topology.RUnlock()
+	t := topology.Topology
+	t.Channel = network.Name()
+	c.JSON(200, t)
+}
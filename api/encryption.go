@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/base64"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	encryptionKeyHeader = "X-Encryption-Key"
+	operationIDHeader   = "X-Operation-ID"
+)
+
+// proposalOptions forwards a base64 AES key from the X-Encryption-Key header
+// as transient data, so the chaincode can encrypt MPIN and REMARKS on write
+// and decrypt them on read, and likewise X-Operation-ID, which the chaincode
+// uses to reject repeated writes, the request context and a dealer key's
+// scope. It answers 400 itself for a malformed key.
+func proposalOptions(c *gin.Context, args ...string) ([]client.ProposalOption, bool) {
+	opts := []client.ProposalOption{client.WithArguments(args...)}
+	transient := map[string][]byte{}
+	if h := c.GetHeader(encryptionKeyHeader); h != "" {
+		key, err := base64.StdEncoding.DecodeString(h)
+		if err != nil || len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			apiError(c, 400, ErrInvalidEncryptionKey, gin.H{"header": encryptionKeyHeader})
+			return nil, false
+		}
+		transient["encryptionKey"] = key
+	}
+	if id := c.GetHeader(operationIDHeader); id != "" {
+		if len(id) > 128 {
+			apiError(c, 400, ErrOperationIDTooLong, gin.H{"header": operationIDHeader, "max": 128})
+			return nil, false
+		}
+		transient["operationId"] = []byte(id)
+	}
+	addRequestContext(transient, currentRequestContext(c))
+	addDealerScope(transient, principal(c))
+	orgs, ok := endorsingOverride(c)
+	if !ok {
+		return nil, false
+	}
+	if orgs != nil {
+		opts = append(opts, client.WithEndorsingOrganizations(orgs...))
+	}
+	if len(transient) == 0 {
+		return opts, true
+	}
+	return append(opts, client.WithTransient(transient)), true
+}
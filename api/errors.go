@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	CategoryRejected      = "rejected"
+	CategoryEndorsement   = "endorsement"
+	CategoryOrdering      = "ordering"
+	CategoryCommitStatus  = "commit_status"
+	CategoryConflict      = "conflict"
+	CategoryInvalid       = "invalid"
+	CategoryUnavailable   = "unavailable"
+	CategoryTimeout       = "timeout"
+	CategoryUnknown       = "unknown"
+	chaincodeResponseText = "chaincode response"
+)
+
+type ErrorDetail struct {
+	Address string `json:"address"`
+	MspID   string `json:"mspId"`
+	Message string `json:"message"`
+}
+
+type FabricError struct {
+	Error     string        `json:"error"`
+	Category  string        `json:"category"`
+	Retryable bool          `json:"retryable"`
+	Code      string        `json:"grpcCode,omitempty"`
+	TxID      string        `json:"txId,omitempty"`
+	Details   []ErrorDetail `json:"details,omitempty"`
+	ErrorCode string        `json:"code,omitempty"`
+	Detail    string        `json:"detail,omitempty"`
+}
+
+// classify inspects a gateway error and decides whether the same request can
+// safely be sent again. Chaincode rejections and invalidated transactions other
+// than read conflicts are final; transport problems are not.
+func classify(err error) FabricError {
+	fe := FabricError{Error: err.Error(), Category: CategoryUnknown}
+
+	var commitErr *client.CommitError
+	if errors.As(err, &commitErr) {
+		fe.TxID = commitErr.TransactionID
+		fe.Code = commitErr.Code.String()
+		switch commitErr.Code {
+		case peer.TxValidationCode_MVCC_READ_CONFLICT, peer.TxValidationCode_PHANTOM_READ_CONFLICT:
+			fe.Category = CategoryConflict
+			fe.Retryable = true
+		default:
+			fe.Category = CategoryInvalid
+		}
+		return fe
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return fe
+	}
+	fe.Code = st.Code().String()
+	for _, d := range st.Details() {
+		if ed, ok := d.(*gateway.ErrorDetail); ok {
+			fe.Details = append(fe.Details, ErrorDetail{Address: ed.GetAddress(), MspID: ed.GetMspId(), Message: ed.GetMessage()})
+		}
+	}
+
+	var endorseErr *client.EndorseError
+	var submitErr *client.SubmitError
+	var statusErr *client.CommitStatusError
+	switch {
+	case errors.As(err, &endorseErr):
+		fe.TxID = endorseErr.TransactionID
+		fe.Category = CategoryEndorsement
+	case errors.As(err, &submitErr):
+		fe.TxID = submitErr.TransactionID
+		fe.Category = CategoryOrdering
+		if st.Code() == codes.DeadlineExceeded {
+			// The orderer may have taken the envelope before the deadline;
+			// as with a commit status failure, resubmitting risks a double
+			// write.
+			return fe
+		}
+	case errors.As(err, &statusErr):
+		// The transaction may still commit; resubmitting risks a double write.
+		fe.TxID = statusErr.TransactionID
+		fe.Category = CategoryCommitStatus
+		return fe
+	}
+
+	if fe.rejectedByChaincode() {
+		fe.Category = CategoryRejected
+		return fe
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.ResourceExhausted:
+		fe.Category = CategoryUnavailable
+		fe.Retryable = true
+	case codes.DeadlineExceeded:
+		fe.Category = CategoryTimeout
+		fe.Retryable = true
+	case codes.Aborted:
+		fe.Retryable = true
+	}
+	return fe
+}
+
+// notFound reports whether the chaincode rejected a call with "not found",
+// which is also its answer to a dealer-scoped caller for another dealer's
+// account.
+func notFound(err error) bool {
+	fe := classify(err)
+	return fe.rejectedByChaincode() && strings.Contains(fe.Error, "not found")
+}
+
+func (fe FabricError) rejectedByChaincode() bool {
+	for _, d := range fe.Details {
+		if strings.Contains(d.Message, chaincodeResponseText) {
+			return true
+		}
+	}
+	return strings.Contains(fe.Error, chaincodeResponseText)
+}
+
+// localizedFabricError is classify with the catalog code and message for the
+// category; the gateway's own text moves to detail.
+func localizedFabricError(c *gin.Context, err error) FabricError {
+	return localizeFabricError(c, classify(err))
+}
+
+// localizeFabricError localizes an error classified earlier, such as one
+// stored with a submission.
+func localizeFabricError(c *gin.Context, fe FabricError) FabricError {
+	fe.ErrorCode = "FABRIC_" + strings.ToUpper(fe.Category)
+	fe.Detail = fe.Error
+	fe.Error = localize(c, fe.ErrorCode, nil)
+	return fe
+}
+
+func fabricError(c *gin.Context, err error) {
+	if submitQueueFull(c, err) || unsupportedInSandbox(c, err) {
+		return
+	}
+	fe := localizedFabricError(c, err)
+	c.Set(fabricErrorKey, fe)
+	c.JSON(500, fe)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type eventHandler func(ev *client.ChaincodeEvent)
+
+var eventHandlers = []eventHandler{logEvent, confirmOutboxEvent}
+
+func logEvent(ev *client.ChaincodeEvent) {
+	log.Printf("event %s block=%d tx=%s", ev.EventName, ev.BlockNumber, ev.TransactionID)
+}
+
+// eventCheckpointPath is EVENT_CHECKPOINT_FILE, by default a file under the
+// system temp dir.
+func eventCheckpointPath() string {
+	if p := os.Getenv("EVENT_CHECKPOINT_FILE"); p != "" {
+		return p
+	}
+	return filepath.Join(os.TempDir(), "fabric-api-events.checkpoint")
+}
+
+// processEvents delivers chaincode events to every registered handler until
+// ctx is cancelled. The last delivered event is checkpointed to
+// eventCheckpointPath, and every stream, after a break or on a new leader,
+// resumes right after it; with the file on a volume the replicas share, a
+// new leader neither replays nor skips what the old one saw.
+func processEvents(ctx context.Context) {
+	cp, err := client.NewFileCheckpointer(eventCheckpointPath())
+	if err != nil {
+		log.Printf("event checkpoint %s: %v; resuming from memory only", eventCheckpointPath(), err)
+		mem := new(client.InMemoryCheckpointer)
+		processEventsFrom(ctx, mem, func(ev *client.ChaincodeEvent) error {
+			mem.CheckpointChaincodeEvent(ev)
+			return nil
+		})
+		return
+	}
+	defer cp.Close()
+	processEventsFrom(ctx, cp, cp.CheckpointChaincodeEvent)
+}
+
+func processEventsFrom(ctx context.Context, cp client.Checkpoint, save func(*client.ChaincodeEvent) error) {
+	for ctx.Err() == nil {
+		events, err := network.ChaincodeEvents(ctx, contract.ChaincodeName(), client.WithCheckpoint(cp))
+		if err != nil {
+			log.Printf("chaincode events: %v", err)
+		} else {
+			for ev := range events {
+				for _, h := range eventHandlers {
+					h(ev)
+				}
+				if err := save(ev); err != nil {
+					log.Printf("event checkpoint: %v", err)
+				}
+				recordEventBlock(ev.BlockNumber)
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+type StreamedEvent struct {
+	BlockNumber   uint64          `json:"blockNumber"`
+	TransactionID string          `json:"txId"`
+	EventName     string          `json:"eventName"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+// eventVisible reports whether the caller may see ev. Dealer keys only get
+// events about their own dealer: by the payload's DEALERID or, for events
+// that only name accounts, when a dealer-scoped ReadAssets finds one of them.
+func eventVisible(c *gin.Context, ev *client.ChaincodeEvent) bool {
+	p := principal(c)
+	if p.Role != RoleDealer {
+		return true
+	}
+	var e cacheEvent
+	if err := json.Unmarshal(ev.Payload, &e); err != nil {
+		return false
+	}
+	if e.DEALERID != "" {
+		return e.DEALERID == p.DealerID
+	}
+	if e.MSISDN != "" {
+		e.MSISDNs = append(e.MSISDNs, e.MSISDN)
+	}
+	if len(e.MSISDNs) == 0 {
+		return false
+	}
+	list, err := json.Marshal(e.MSISDNs)
+	if err != nil {
+		return false
+	}
+	t := map[string][]byte{}
+	addDealerScope(t, p)
+	res, err := evaluate(c, "ReadAssets", client.WithArguments(string(list)), client.WithTransient(t))
+	if err != nil {
+		log.Printf("event %s for dealer %s: %v", ev.TransactionID, p.DealerID, err)
+		return false
+	}
+	var found []Account
+	if err := json.Unmarshal(res, &found); err != nil {
+		return false
+	}
+	for _, a := range found {
+		if a.DEALERID == p.DealerID {
+			return true
+		}
+	}
+	return false
+}
+
+// eventsHandler streams chaincode events to the caller as server-sent events,
+// as CloudEvents with ?format=cloudevents. Each subscriber gets its own
+// gateway stream, so it works on every replica regardless of which one holds
+// the processing lease. It needs an API key, and dealer keys only get their
+// own dealer's events.
+func eventsHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", formatPlain)
+	if format != formatPlain && format != formatCloudEvents {
+		apiError(c, 400, ErrInvalidFormat, nil)
+		return
+	}
+	cloudEvents := format == formatCloudEvents
+	var opts []client.ChaincodeEventsOption
+	if v := c.Query("startBlock"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			apiError(c, 400, ErrInvalidBlockNumber, gin.H{"param": "startBlock"})
+			return
+		}
+		opts = append(opts, client.WithStartBlock(n))
+	}
+	events, err := network.ChaincodeEvents(c.Request.Context(), contract.ChaincodeName(), opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	c.Stream(func(w io.Writer) bool {
+		ev, ok := <-events
+		if !ok {
+			return false
+		}
+		if !eventVisible(c, ev) {
+			return true
+		}
+		if cloudEvents {
+			c.SSEvent(ev.EventName, newCloudEvent(ev.TransactionID, "", ev.EventName, ev.BlockNumber, ev.Payload))
+			return true
+		}
+		out := StreamedEvent{BlockNumber: ev.BlockNumber, TransactionID: ev.TransactionID, EventName: ev.EventName}
+		if json.Valid(ev.Payload) {
+			out.Payload = ev.Payload
+		}
+		c.SSEvent(ev.EventName, out)
+		return true
+	})
+}
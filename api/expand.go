@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// Expansions GET /assets/:msisdn can embed, with the default and maximum
+// number of entries each returns.
+var expansionLimits = map[string]struct{ def, max int }{
+	"history": {def: 10, max: 100},
+	"audit":   {def: 10, max: 50},
+}
+
+// maxAuditLookups bounds the qscc lookups one audit expansion runs at once.
+const maxAuditLookups = 8
+
+// AuditRecord is who wrote one version of an account and how the peer
+// validated it.
+type AuditRecord struct {
+	TxID           string  `json:"txId" xml:"txId"`
+	Timestamp      int64   `json:"timestamp" xml:"timestamp"`
+	IsDelete       bool    `json:"isDelete" xml:"isDelete"`
+	SubmitterMSP   string  `json:"submitterMsp" xml:"submitterMsp"`
+	ValidationCode string  `json:"validationCode" xml:"validationCode"`
+	Valid          bool    `json:"valid" xml:"valid"`
+	Origin         *Origin `json:"origin,omitempty" xml:"origin,omitempty"`
+}
+
+// ExpansionError is an expansion that failed; the account and the other
+// expansions are still returned.
+type ExpansionError struct {
+	Expansion string `json:"expansion" xml:"expansion"`
+	Code      string `json:"code" xml:"code"`
+	Error     string `json:"error" xml:"error"`
+	Retryable bool   `json:"retryable" xml:"retryable"`
+}
+
+// ExpandedAccount is GET /assets/:msisdn with ?expand: the account with the
+// latest history entries and audit records embedded, newest first.
+type ExpandedAccount struct {
+	XMLName xml.Name `json:"-" xml:"Account"`
+	Account
+	History         *ListResponse[History]     `json:"history,omitempty" xml:"history,omitempty"`
+	Audit           *ListResponse[AuditRecord] `json:"audit,omitempty" xml:"audit,omitempty"`
+	ExpansionErrors []ExpansionError           `json:"expansionErrors,omitempty" xml:"expansionErrors>expansionError,omitempty"`
+}
+
+// expansions is a request's ?expand, started alongside the account read.
+// The goroutines never touch the gin context, which is recycled once the
+// handler returns.
+type expansions struct {
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	out    ExpandedAccount
+	errs   map[string]error
+	blocks bool
+}
+
+// startExpansions parses ?expand=history,audit and the per-expansion limits
+// ?historyLimit= and ?auditLimit=, and starts the chaincode evaluations. It
+// returns nil without ?expand, and answers 400 itself on bad parameters.
+func startExpansions(c *gin.Context, msisdn string) (*expansions, bool) {
+	v := c.Query("expand")
+	if v == "" {
+		return nil, true
+	}
+	limits := map[string]int{}
+	for _, name := range strings.Split(v, ",") {
+		l, ok := expansionLimits[name]
+		if !ok {
+			apiError(c, 400, ErrInvalidExpand, gin.H{"param": "expand", "allowed": "history, audit"})
+			return nil, false
+		}
+		n := l.def
+		param := name + "Limit"
+		if q := c.Query(param); q != "" {
+			var err error
+			if n, err = strconv.Atoi(q); err != nil || n < 1 || n > l.max {
+				apiError(c, 400, ErrOutOfRange, gin.H{"param": param, "min": 1, "max": l.max})
+				return nil, false
+			}
+		}
+		limits[name] = n
+	}
+	e := &expansions{errs: map[string]error{}, blocks: c.Query("blocks") != "false"}
+	ctx := c.Request.Context()
+	for name, n := range limits {
+		opts, ok := proposalOptions(c, msisdn, strconv.Itoa(n), "")
+		if !ok {
+			return nil, false
+		}
+		e.wg.Add(1)
+		go func(name string) {
+			defer e.wg.Done()
+			var err error
+			switch name {
+			case "history":
+				err = e.history(ctx, n, opts)
+			case "audit":
+				err = e.audit(ctx, n, opts)
+			}
+			if err != nil {
+				e.mu.Lock()
+				e.errs[name] = err
+				e.mu.Unlock()
+			}
+		}(name)
+	}
+	return e, true
+}
+
+func historyWindow(ctx context.Context, opts []client.ProposalOption) (*HistoryPage, error) {
+	res, err := evaluateNearest(ctx, "GetAssetHistoryPage", append(endorsementOptions("GetAssetHistoryPage"), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	var page HistoryPage
+	return &page, json.Unmarshal(res, &page)
+}
+
+// history embeds the latest n history entries, with their blocks unless
+// ?blocks=false.
+func (e *expansions) history(ctx context.Context, n int, opts []client.ProposalOption) error {
+	page, err := historyWindow(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if e.blocks {
+		for i := range page.Records {
+			if page.Records[i].BlockNumber, err = blockOfTx(page.Records[i].TxID); err != nil {
+				return err
+			}
+		}
+	}
+	out := &ListResponse[History]{Records: page.Records, Meta: ListMeta{ReturnedCount: len(page.Records), PageSize: n, NextCursor: page.Next, HasMore: page.Next != ""}}
+	e.mu.Lock()
+	e.out.History = out
+	e.mu.Unlock()
+	return nil
+}
+
+// audit embeds who submitted the latest n versions and how they validated,
+// looked up in qscc a few at a time.
+func (e *expansions) audit(ctx context.Context, n int, opts []client.ProposalOption) error {
+	page, err := historyWindow(ctx, opts)
+	if err != nil {
+		return err
+	}
+	records := make([]AuditRecord, len(page.Records))
+	errs := make([]error, len(page.Records))
+	sem := make(chan struct{}, maxAuditLookups)
+	var wg sync.WaitGroup
+	for i, h := range page.Records {
+		records[i] = AuditRecord{TxID: h.TxID, Timestamp: h.Timestamp, IsDelete: h.IsDelete, Origin: h.Origin}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			info, err := transactionInfo(records[i].TxID)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			records[i].SubmitterMSP, records[i].ValidationCode, records[i].Valid = info.SubmitterMSP, info.ValidationCode, info.Valid
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	out := &ListResponse[AuditRecord]{Records: records, Meta: ListMeta{ReturnedCount: len(records), PageSize: n, NextCursor: page.Next, HasMore: page.Next != ""}}
+	e.mu.Lock()
+	e.out.Audit = out
+	e.mu.Unlock()
+	return nil
+}
+
+// wait returns a with the expansions embedded once they have all finished.
+func (e *expansions) wait(c *gin.Context, a Account) *ExpandedAccount {
+	e.wg.Wait()
+	e.out.Account = a
+	for _, name := range []string{"history", "audit"} {
+		if err, ok := e.errs[name]; ok {
+			fe := localizedFabricError(c, err)
+			e.out.ExpansionErrors = append(e.out.ExpansionErrors, ExpansionError{Expansion: name, Code: fe.ErrorCode, Error: fe.Error, Retryable: fe.Retryable})
+		}
+	}
+	return &e.out
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HistoryExportRecord struct {
+	TxID           string   `json:"txId"`
+	Timestamp      string   `json:"timestamp"`
+	IsDelete       bool     `json:"isDelete"`
+	Value          *Account `json:"value,omitempty"`
+	ValidationCode string   `json:"validationCode"`
+	SubmitterMSP   string   `json:"submitterMsp"`
+	Origin         *Origin  `json:"origin,omitempty"`
+}
+
+var exportCSVHeader = []string{"txId", "timestamp", "isDelete", "validationCode", "submitterMsp", "value", "originChannel", "originApp", "originRequestId", "originFingerprint"}
+
+func (r *HistoryExportRecord) csvRow() ([]string, error) {
+	value := ""
+	if r.Value != nil {
+		b, err := json.Marshal(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		value = string(b)
+	}
+	row := []string{r.TxID, r.Timestamp, strconv.FormatBool(r.IsDelete), r.ValidationCode, r.SubmitterMSP, value, "", "", "", ""}
+	if o := r.Origin; o != nil {
+		copy(row[6:], []string{o.Channel, o.AppID, o.RequestID, o.Fingerprint})
+	}
+	return row, nil
+}
+
+// historyExportHandler writes an account's history as CSV or JSON Lines with
+// nanosecond RFC3339 timestamps, and the validation status and submitting MSP
+// of every transaction taken from qscc.
+func historyExportHandler(c *gin.Context) {
+	format := c.DefaultQuery("format", "jsonl")
+	if format != "csv" && format != "jsonl" {
+		apiError(c, 400, ErrInvalidFormat, nil)
+		return
+	}
+	msisdn := c.Param("msisdn")
+	opts, ok := proposalOptions(c, msisdn)
+	if !ok {
+		return
+	}
+	res, err := contract.Evaluate("GetAssetHistory", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var h []History
+	if len(res) > 0 {
+		if err := json.Unmarshal(res, &h); err != nil {
+			internalError(c, err)
+			return
+		}
+	}
+	records := make([]*HistoryExportRecord, 0, len(h))
+	for _, rec := range h {
+		info, err := transactionInfo(rec.TxID)
+		if err != nil {
+			fabricError(c, err)
+			return
+		}
+		records = append(records, &HistoryExportRecord{
+			TxID:           rec.TxID,
+			Timestamp:      time.Unix(0, rec.TimestampNanos).UTC().Format(time.RFC3339Nano),
+			IsDelete:       rec.IsDelete,
+			Value:          rec.Value,
+			ValidationCode: info.ValidationCode,
+			SubmitterMSP:   info.SubmitterMSP,
+			Origin:         rec.Origin,
+		})
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(msisdn+"-history."+format))
+	if format == "jsonl" {
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return
+			}
+		}
+		return
+	}
+	c.Header("Content-Type", "text/csv")
+	w := csv.NewWriter(c.Writer)
+	w.Write(exportCSVHeader)
+	for _, r := range records {
+		row, err := r.csvRow()
+		if err != nil {
+			return
+		}
+		w.Write(row)
+	}
+	w.Flush()
+}
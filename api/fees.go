@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type FeeTier struct {
+	UpTo        int64 `json:"upTo"`
+	Fee         int64 `json:"fee"`
+	BasisPoints int64 `json:"basisPoints"`
+}
+
+type FeeRule struct {
+	Type        string    `json:"type"`
+	Fee         int64     `json:"fee,omitempty"`
+	BasisPoints int64     `json:"basisPoints,omitempty"`
+	Min         int64     `json:"min,omitempty"`
+	Max         int64     `json:"max,omitempty"`
+	Tiers       []FeeTier `json:"tiers,omitempty"`
+}
+
+type FeeSchedule struct {
+	FeeAccount string              `json:"feeAccount"`
+	Rules      map[string]*FeeRule `json:"rules"`
+}
+
+type FeeQuote struct {
+	Operation  string `json:"operation" xml:"operation"`
+	Amount     int64  `json:"amount" xml:"amount"`
+	Fee        int64  `json:"fee" xml:"fee"`
+	Total      int64  `json:"total" xml:"total"`
+	FeeAccount string `json:"feeAccount,omitempty" xml:"feeAccount,omitempty"`
+	Receipt    string `json:"receipt,omitempty" xml:"receipt,omitempty"`
+	TxID       string `json:"txId,omitempty" xml:"txId,omitempty"`
+	// FX is set when a transfer converted between currencies.
+	FX *FXConversion `json:"fx,omitempty" xml:"fx,omitempty"`
+}
+
+// feeQuoteHandler previews the fee for ?amount= on ?operation= (transfer,
+// the default, or debit) under the schedule currently on chain.
+func feeQuoteHandler(c *gin.Context) {
+	amount, err := strconv.ParseInt(c.Query("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		apiError(c, 400, ErrInvalidAmount, gin.H{"param": "amount"})
+		return
+	}
+	res, err := evaluate(c, "QuoteFee", client.WithArguments(c.DefaultQuery("operation", "transfer"), strconv.FormatInt(amount, 10)))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var q FeeQuote
+	if err := json.Unmarshal(res, &q); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, q)
+}
+
+func getFeeScheduleHandler(c *gin.Context) {
+	res, err := contract.Evaluate("GetFeeSchedule")
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var fs FeeSchedule
+	if err := json.Unmarshal(res, &fs); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, fs)
+}
+
+// setFeeScheduleHandler replaces the on-chain schedule; the chaincode
+// validates the rules and the fee account.
+func setFeeScheduleHandler(c *gin.Context) {
+	var fs FeeSchedule
+	if err := c.ShouldBindJSON(&fs); err != nil {
+		bodyError(c, err)
+		return
+	}
+	b, err := json.Marshal(fs)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if _, _, err := submit("SetFeeSchedule", client.WithArguments(string(b))); err != nil {
+		fabricError(c, err)
+		return
+	}
+	c.JSON(200, fs)
+}
+
+type postingRequest struct {
+	To      string `json:"to"`
+	Amount  int64  `json:"amount"`
+	Remarks string `json:"remarks"`
+}
+
+// transferFundsHandler moves amount from :msisdn to the body's account in a
+// single transaction, charging the transfer fee to :msisdn.
+func transferFundsHandler(c *gin.Context) {
+	var req postingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.To == "" || req.To == c.Param("msisdn") {
+		apiError(c, 400, ErrInvalidTransfer, gin.H{"detail": "to must be another account"})
+		return
+	}
+	if req.Amount <= 0 {
+		apiError(c, 400, ErrInvalidAmount, gin.H{"param": "amount"})
+		return
+	}
+	submitPosting(c, "Transfer", c.Param("msisdn"), req.To, strconv.FormatInt(req.Amount, 10), req.Remarks)
+}
+
+// debitHandler takes amount and the debit fee from :msisdn.
+func debitHandler(c *gin.Context) {
+	var req postingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.Amount <= 0 {
+		apiError(c, 400, ErrInvalidAmount, gin.H{"param": "amount"})
+		return
+	}
+	submitPosting(c, "Debit", c.Param("msisdn"), strconv.FormatInt(req.Amount, 10), req.Remarks)
+}
+
+// submitPosting answers with the fee that was charged.
+func submitPosting(c *gin.Context, fn string, args ...string) {
+	if !checkAccountStatus(c, fn, args) || !screenMonetary(c, fn, args) {
+		return
+	}
+	opts, ok := proposalOptions(c, args...)
+	if !ok {
+		return
+	}
+	res, st, err := submit(fn, opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var q FeeQuote
+	if err := json.Unmarshal(res, &q); err != nil {
+		internalError(c, err)
+		return
+	}
+	q.TxID = st.TransactionID
+	c.JSON(200, q)
+}
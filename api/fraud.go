@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// fraudFlaggedHeader carries the decision ID of a transaction that went
+// ahead although its score reached FRAUD_FLAG_SCORE.
+const fraudFlaggedHeader = "X-Fraud-Flagged"
+
+// Actions a fraud decision ends in.
+const (
+	fraudAllow = "allow"
+	fraudFlag  = "flag"
+	fraudBlock = "block"
+)
+
+// MonetaryOperation is what a pre-submit hook is told about a transaction
+// that moves money.
+type MonetaryOperation struct {
+	Function    string          `json:"function"`
+	From        string          `json:"from,omitempty"`
+	To          string          `json:"to,omitempty"`
+	Amount      int64           `json:"amount"`
+	TransType   string          `json:"transType,omitempty"`
+	Remarks     string          `json:"remarks,omitempty"`
+	OperationID string          `json:"operationId,omitempty"`
+	Actor       string          `json:"actor,omitempty"`
+	Role        string          `json:"role,omitempty"`
+	DealerID    string          `json:"dealerId,omitempty"`
+	Tenant      string          `json:"tenant,omitempty"`
+	Request     *RequestContext `json:"request,omitempty"`
+}
+
+// FraudScore is a hook's verdict: the higher Score, the likelier fraud.
+type FraudScore struct {
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// PreSubmitHook is consulted before a monetary transaction is submitted.
+// An error means the hook could not score it; FRAUD_FAIL_MODE decides what
+// happens then.
+type PreSubmitHook interface {
+	Name() string
+	Score(ctx context.Context, op *MonetaryOperation) (FraudScore, error)
+}
+
+// FraudDecision is what the audit trail records about one screening.
+type FraudDecision struct {
+	ID        string             `json:"decisionId"`
+	Action    string             `json:"action"`
+	Hook      string             `json:"hook,omitempty"`
+	Score     *float64           `json:"score,omitempty"`
+	Reason    string             `json:"reason,omitempty"`
+	FailMode  string             `json:"failMode,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Operation *MonetaryOperation `json:"operation"`
+}
+
+var fraud = struct {
+	sync.Mutex
+	hooks     []PreSubmitHook
+	blockAt   float64
+	flagAt    float64
+	failMode  map[string]string
+	auditAll  bool
+	decisions map[string]uint64
+	errors    uint64
+}{blockAt: 0.9, flagAt: 0.7, failMode: map[string]string{"*": "open"}, decisions: map[string]uint64{}}
+
+// loadFraudScoring installs the scorer FRAUD_SCORING_URL names: an http(s)
+// URL that is POSTed the operation as JSON, or grpc(s)://host:port/
+// package.Service/Method, called with the same JSON as its message. Either
+// answers {"score": 0.0-1.0, "reason": "..."}. Unset, nothing is screened.
+func loadFraudScoring() {
+	raw := os.Getenv("FRAUD_SCORING_URL")
+	if raw == "" {
+		return
+	}
+	timeout := 2 * time.Second
+	if v := os.Getenv("FRAUD_SCORING_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("FRAUD_SCORING_TIMEOUT must be a positive duration")
+		}
+		timeout = d
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		log.Fatalf("FRAUD_SCORING_URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		fraud.hooks = append(fraud.hooks, &httpFraudScorer{url: raw, token: os.Getenv("FRAUD_SCORING_TOKEN"), http: &http.Client{Timeout: timeout}})
+	case "grpc", "grpcs":
+		h, err := newGRPCFraudScorer(u, timeout)
+		if err != nil {
+			log.Fatalf("FRAUD_SCORING_URL: %v", err)
+		}
+		fraud.hooks = append(fraud.hooks, h)
+	default:
+		log.Fatalf("FRAUD_SCORING_URL: scheme must be http, https, grpc or grpcs")
+	}
+	for env, p := range map[string]*float64{"FRAUD_BLOCK_SCORE": &fraud.blockAt, "FRAUD_FLAG_SCORE": &fraud.flagAt} {
+		if v := os.Getenv(env); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				log.Fatalf("%s: %v", env, err)
+			}
+			*p = f
+		}
+	}
+	if fraud.flagAt > fraud.blockAt {
+		log.Fatalf("FRAUD_FLAG_SCORE must not exceed FRAUD_BLOCK_SCORE")
+	}
+	if v := os.Getenv("FRAUD_FAIL_MODE"); v != "" {
+		m, err := parseFailModes(v)
+		if err != nil {
+			log.Fatalf("FRAUD_FAIL_MODE: %v", err)
+		}
+		fraud.failMode = m
+	}
+	fraud.auditAll = os.Getenv("FRAUD_AUDIT_ALL") == "true"
+}
+
+// parseFailModes reads "closed" or a list such as "Transfer=closed,*=open".
+// Functions not listed, without "*", fail open.
+func parseFailModes(v string) (map[string]string, error) {
+	m := map[string]string{"*": "open"}
+	for _, part := range strings.Split(v, ",") {
+		fn, mode, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			fn, mode = "*", fn
+		}
+		if mode != "open" && mode != "closed" {
+			return nil, fmt.Errorf("bad entry %q: mode must be open or closed", part)
+		}
+		m[fn] = mode
+	}
+	return m, nil
+}
+
+func failModeFor(fn string) string {
+	if m, ok := fraud.failMode[fn]; ok {
+		return m
+	}
+	return fraud.failMode["*"]
+}
+
+// monetaryOperation describes a call to fn with args, or returns nil when fn
+// does not move money or its amount is not a number, which the chaincode
+// rejects anyway.
+func monetaryOperation(c *gin.Context, fn string, args []string) *MonetaryOperation {
+	op := &MonetaryOperation{Function: fn}
+	var amount string
+	switch {
+	case fn == "Transfer" && len(args) == 4:
+		op.From, op.To, amount, op.Remarks = args[0], args[1], args[2], args[3]
+	case fn == "Debit" && len(args) == 3:
+		op.From, amount, op.Remarks = args[0], args[1], args[2]
+	case fn == "PostEntry" && len(args) == 4:
+		op.From, op.TransType, amount, op.Remarks = args[0], args[1], args[2], args[3]
+	case fn == "HoldAmount" && len(args) == 4:
+		op.From, amount, op.Remarks = args[0], args[1], "hold "+args[2]
+	default:
+		return nil
+	}
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return nil
+	}
+	op.Amount = n
+	p := principal(c)
+	op.Actor, op.Role, op.DealerID, op.Tenant = p.Name, p.Role, p.DealerID, p.Tenant
+	op.Request = currentRequestContext(c)
+	return op
+}
+
+// screenMonetary runs the pre-submit hooks on a call to fn with args. It
+// answers 403 FRAUD_BLOCKED, or 503 FRAUD_CHECK_UNAVAILABLE when a hook
+// fails closed, and returns false; a flagged transaction goes ahead with
+// X-Fraud-Flagged set.
+func screenMonetary(c *gin.Context, fn string, args []string) bool {
+	if op := monetaryOperation(c, fn, args); op != nil {
+		return screenOperation(c, op)
+	}
+	return true
+}
+
+func screenOperation(c *gin.Context, op *MonetaryOperation) bool {
+	if len(fraud.hooks) == 0 {
+		return true
+	}
+	d := &FraudDecision{ID: newDecisionID(), Action: fraudAllow, Operation: op}
+	for _, h := range fraud.hooks {
+		s, err := h.Score(c.Request.Context(), op)
+		if err != nil {
+			d.Hook, d.Error, d.FailMode = h.Name(), err.Error(), failModeFor(op.Function)
+			if d.FailMode == "closed" {
+				d.Action = fraudBlock
+			}
+			break
+		}
+		if d.Score == nil || s.Score > *d.Score {
+			d.Hook, d.Score, d.Reason = h.Name(), &s.Score, s.Reason
+		}
+	}
+	if d.Error == "" {
+		switch {
+		case *d.Score >= fraud.blockAt:
+			d.Action = fraudBlock
+		case *d.Score >= fraud.flagAt:
+			d.Action = fraudFlag
+		}
+	}
+	recordFraudDecision(c, d)
+	switch {
+	case d.Action == fraudBlock && d.Error != "":
+		apiError(c, 503, ErrFraudCheckUnavailable, gin.H{"retryable": true, "decisionId": d.ID})
+		return false
+	case d.Action == fraudBlock:
+		apiError(c, 403, ErrFraudBlocked, gin.H{"decisionId": d.ID})
+		return false
+	case d.Action == fraudFlag:
+		c.Header(fraudFlaggedHeader, d.ID)
+	}
+	return true
+}
+
+func newDecisionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// recordFraudDecision counts d and writes it to the admin log. Allowed
+// transactions that were scored without error are only written with
+// FRAUD_AUDIT_ALL=true.
+func recordFraudDecision(c *gin.Context, d *FraudDecision) {
+	fraud.Lock()
+	fraud.decisions[d.Action]++
+	if d.Error != "" {
+		fraud.errors++
+	}
+	fraud.Unlock()
+	if d.Error != "" {
+		log.Printf("fraud scoring %s (%s): %v; failing %s", d.Operation.Function, d.ID, d.Error, d.FailMode)
+	}
+	if adminLog == nil || d.Action == fraudAllow && d.Error == "" && !fraud.auditAll {
+		return
+	}
+	body, err := json.Marshal(d)
+	if err != nil {
+		log.Printf("admin log: %v", err)
+		return
+	}
+	op := AdminOperation{Actor: "fraud-scoring", Operation: "fraud-" + d.Action, Method: c.Request.Method, Path: c.Request.URL.RequestURI(), Body: body, Error: d.Error}
+	if d.Operation.Request != nil {
+		op.RequestID = d.Operation.Request.RequestID
+	}
+	if err := adminLog.append(op); err != nil {
+		log.Printf("admin log: %v", err)
+	}
+}
+
+type httpFraudScorer struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func (h *httpFraudScorer) Name() string { return h.url }
+
+func (h *httpFraudScorer) Score(ctx context.Context, op *MonetaryOperation) (FraudScore, error) {
+	var s FraudScore
+	b, err := json.Marshal(op)
+	if err != nil {
+		return s, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(b))
+	if err != nil {
+		return s, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	res, err := h.http.Do(req)
+	if err != nil {
+		return s, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return s, fmt.Errorf("scoring service answered %s", res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&s); err != nil {
+		return s, fmt.Errorf("scoring service: %w", err)
+	}
+	return s, nil
+}
+
+type grpcFraudScorer struct {
+	name    string
+	method  string
+	timeout time.Duration
+	conn    *grpc.ClientConn
+}
+
+func newGRPCFraudScorer(u *url.URL, timeout time.Duration) (*grpcFraudScorer, error) {
+	if u.Host == "" || strings.Count(u.Path, "/") != 2 {
+		return nil, errors.New("want grpc://host:port/package.Service/Method")
+	}
+	creds := insecure.NewCredentials()
+	if u.Scheme == "grpcs" {
+		creds = credentials.NewTLS(nil)
+	}
+	conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcFraudScorer{name: u.String(), method: u.Path, timeout: timeout, conn: conn}, nil
+}
+
+func (g *grpcFraudScorer) Name() string { return g.name }
+
+func (g *grpcFraudScorer) Score(ctx context.Context, op *MonetaryOperation) (FraudScore, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	var s FraudScore
+	err := g.conn.Invoke(ctx, g.method, op, &s, grpc.ForceCodec(jsonCodec{}))
+	return s, err
+}
+
+// jsonCodec sends gRPC messages as JSON, so a scoring service needs no
+// generated stubs on our side.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func writeFraudMetrics(b *strings.Builder) {
+	if len(fraud.hooks) == 0 {
+		return
+	}
+	fraud.Lock()
+	defer fraud.Unlock()
+	b.WriteString("# HELP fabric_api_fraud_decisions_total Monetary transactions screened, by action.\n")
+	b.WriteString("# TYPE fabric_api_fraud_decisions_total counter\n")
+	for _, a := range []string{fraudAllow, fraudFlag, fraudBlock} {
+		fmt.Fprintf(b, "fabric_api_fraud_decisions_total{action=%q} %d\n", a, fraud.decisions[a])
+	}
+	b.WriteString("# HELP fabric_api_fraud_check_errors_total Screenings a scoring hook could not answer.\n")
+	b.WriteString("# TYPE fabric_api_fraud_check_errors_total counter\n")
+	fmt.Fprintf(b, "fabric_api_fraud_check_errors_total %d\n", fraud.errors)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// FXConfig is the chaincode's cross-currency configuration: the currency of
+// accounts without a CURRENCY and the chaincode transfers between
+// currencies take their rate from.
+type FXConfig struct {
+	BaseCurrency   string `json:"baseCurrency"`
+	RatesChaincode string `json:"ratesChaincode,omitempty"`
+	RatesFunction  string `json:"ratesFunction,omitempty"`
+}
+
+// FXConversion is the rate a transfer between currencies used, as recorded
+// on its receipt.
+type FXConversion struct {
+	From      string `json:"from" xml:"from"`
+	To        string `json:"to" xml:"to"`
+	Rate      string `json:"rate" xml:"rate"`
+	Source    string `json:"source" xml:"source"`
+	Chaincode string `json:"chaincode" xml:"chaincode"`
+	Amount    int64  `json:"amount" xml:"amount"`
+	Converted int64  `json:"converted" xml:"converted"`
+}
+
+func getFXConfigHandler(c *gin.Context) {
+	res, err := contract.Evaluate("GetFXConfig")
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var cfg FXConfig
+	if err := json.Unmarshal(res, &cfg); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, cfg)
+}
+
+// setFXConfigHandler replaces the on-chain FX config; the chaincode checks
+// the currency codes and keeps the base currency from changing.
+func setFXConfigHandler(c *gin.Context) {
+	var cfg FXConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		bodyError(c, err)
+		return
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if _, _, err := submit("SetFXConfig", client.WithArguments(string(b))); err != nil {
+		fabricError(c, err)
+		return
+	}
+	c.JSON(200, cfg)
+}
+
+type setCurrencyRequest struct {
+	Currency string `json:"currency"`
+}
+
+// setAccountCurrencyHandler sets the currency :msisdn holds; the account
+// must be empty.
+func setAccountCurrencyHandler(c *gin.Context) {
+	var req setCurrencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.Currency == "" {
+		apiError(c, 400, ErrInvalidBody, gin.H{"detail": "currency is required"})
+		return
+	}
+	if _, _, err := submit("SetAccountCurrency", client.WithArguments(c.Param("msisdn"), req.Currency)); err != nil {
+		fabricError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"MSISDN": c.Param("msisdn"), "currency": req.Currency})
+}
@@ -3,7 +3,14 @@ module fabric-api
 go 1.22
 
 require (
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gin-gonic/gin v1.10.0
+	github.com/google/cel-go v0.20.1
+	github.com/gorilla/websocket v1.5.1
 	github.com/hyperledger/fabric-gateway v1.3.0
+	github.com/hyperledger/fabric-protos-go-apiv2 v0.3.3
+	github.com/pkg/sftp v1.13.6
+	golang.org/x/crypto v0.22.0
 	google.golang.org/grpc v1.63.2
+	google.golang.org/protobuf v1.34.1
 )
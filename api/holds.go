@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Hold struct {
+	MSISDN    string `json:"MSISDN" xml:"MSISDN"`
+	Ref       string `json:"ref" xml:"ref"`
+	Amount    int64  `json:"amount" xml:"amount"`
+	CreatedAt int64  `json:"createdAt" xml:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt" xml:"expiresAt"`
+	CreatedBy string `json:"createdBy" xml:"createdBy"`
+	TxID      string `json:"txId" xml:"txId"`
+}
+
+// Holds is an account's active holds and the balance they leave available.
+type Holds struct {
+	MSISDN    string `json:"MSISDN" xml:"MSISDN"`
+	Balance   int64  `json:"balance" xml:"balance"`
+	Held      int64  `json:"held" xml:"held"`
+	Available int64  `json:"available" xml:"available"`
+	Holds     []Hold `json:"holds" xml:"holds>hold"`
+}
+
+// holdRequest places a hold. The expiry is either expiresAt, in Unix
+// seconds or RFC3339, or expiresIn, a duration such as "15m" from now.
+type holdRequest struct {
+	Ref       string `json:"ref"`
+	Amount    int64  `json:"amount"`
+	ExpiresAt string `json:"expiresAt"`
+	ExpiresIn string `json:"expiresIn"`
+}
+
+type captureRequest struct {
+	Amount  int64  `json:"amount"`
+	Remarks string `json:"remarks"`
+}
+
+func holdsHandler(c *gin.Context) {
+	opts, ok := proposalOptions(c, c.Param("msisdn"))
+	if !ok {
+		return
+	}
+	res, err := evaluate(c, "GetHolds", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var h Holds
+	if err := json.Unmarshal(res, &h); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, h)
+}
+
+// placeHoldHandler reserves amount of :msisdn's available balance under the
+// caller's ref until it expires. Holds are screened like debits; their
+// capture is not, having been authorised already.
+func placeHoldHandler(c *gin.Context) {
+	var req holdRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.Ref == "" {
+		apiError(c, 400, ErrParamRequired, gin.H{"param": "ref"})
+		return
+	}
+	if req.Amount <= 0 {
+		apiError(c, 400, ErrInvalidAmount, gin.H{"param": "amount"})
+		return
+	}
+	var expiry int64
+	switch {
+	case req.ExpiresAt != "":
+		var err error
+		if expiry, err = strconv.ParseInt(req.ExpiresAt, 10, 64); err != nil {
+			t, perr := time.Parse(time.RFC3339, req.ExpiresAt)
+			if perr != nil {
+				apiError(c, 400, ErrInvalidTimestamp, gin.H{"param": "expiresAt"})
+				return
+			}
+			expiry = t.Unix()
+		}
+	case req.ExpiresIn != "":
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > 720*time.Hour {
+			apiError(c, 400, ErrOutOfRange, gin.H{"param": "expiresIn", "min": "1s", "max": "720h"})
+			return
+		}
+		expiry = time.Now().Add(d).Unix()
+	default:
+		apiError(c, 400, ErrParamRequired, gin.H{"param": "expiresAt"})
+		return
+	}
+	args := []string{c.Param("msisdn"), strconv.FormatInt(req.Amount, 10), req.Ref, strconv.FormatInt(expiry, 10)}
+	if !checkAccountStatus(c, "HoldAmount", args) || !screenMonetary(c, "HoldAmount", args) {
+		return
+	}
+	opts, ok := proposalOptions(c, args...)
+	if !ok {
+		return
+	}
+	res, _, err := submit("HoldAmount", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var h Hold
+	if err := json.Unmarshal(res, &h); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(201, h)
+}
+
+// releaseHoldHandler gives the funds held under :ref back to :msisdn.
+func releaseHoldHandler(c *gin.Context) {
+	opts, ok := proposalOptions(c, c.Param("msisdn"), c.Param("ref"))
+	if !ok {
+		return
+	}
+	res, _, err := submit("ReleaseHold", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var h Hold
+	if err := json.Unmarshal(res, &h); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, h)
+}
+
+// captureHoldHandler debits the held amount, or the body's smaller amount,
+// from :msisdn with the debit fee and releases the rest of the hold. The
+// body may be omitted to capture the whole hold.
+func captureHoldHandler(c *gin.Context) {
+	var req captureRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		bodyError(c, err)
+		return
+	}
+	if req.Amount < 0 {
+		apiError(c, 400, ErrInvalidAmount, gin.H{"param": "amount"})
+		return
+	}
+	amount := ""
+	if req.Amount > 0 {
+		amount = strconv.FormatInt(req.Amount, 10)
+	}
+	submitPosting(c, "CaptureHold", c.Param("msisdn"), c.Param("ref"), amount, req.Remarks)
+}
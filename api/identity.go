@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
+)
+
+type material struct {
+	certPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+// credentialStore is the gateway identity and TLS trust, read from
+// CERT_PATH, KEY_PATH and TLS_CERT_PATH. It implements identity.Identity so
+// a reload swaps what new proposals are created and signed with without
+// reconnecting.
+// The TLS roots are kept apart, so a bad key does not hold back a new CA.
+type credentialStore struct {
+	mspID                           string
+	certPath, keyPath, tlsRootsPath string
+	current, previous               atomic.Pointer[material]
+	roots                           atomic.Pointer[tlsRoots]
+}
+
+var _ identity.Identity = (*credentialStore)(nil)
+
+func newCredentialStore(mspID, certPath, keyPath, tlsRootsPath string) (*credentialStore, error) {
+	s := &credentialStore{mspID: mspID, certPath: certPath, keyPath: keyPath, tlsRootsPath: tlsRootsPath}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	_, err := s.reloadRoots()
+	return s, err
+}
+
+// load reads the certificate and key and only swaps them in if they match,
+// so a rotation caught half-written keeps the old pair.
+func (s *credentialStore) load() error {
+	certPEM, err := os.ReadFile(s.certPath)
+	if err != nil {
+		return err
+	}
+	cert, err := identity.CertificateFromPEM(certPEM)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(s.keyPath)
+	if err != nil {
+		return err
+	}
+	key, err := privateKeyFromPEM(keyPEM)
+	if err != nil {
+		return err
+	}
+	if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || !pub.Equal(&key.PublicKey) {
+		return errors.New("certificate does not match private key")
+	}
+	s.previous.Store(s.current.Load())
+	s.current.Store(&material{certPEM: certPEM, key: key})
+	return nil
+}
+
+func (s *credentialStore) MspID() string { return s.mspID }
+
+func (s *credentialStore) Credentials() []byte { return s.current.Load().certPEM }
+
+// snapshot is the current certificate and key, for requests built outside
+// the gateway client that must name and sign with the same pair.
+func (s *credentialStore) snapshot() *material { return s.current.Load() }
+
+// connectOptions make the gateway pass sign the proposal or transaction
+// payload itself rather than its digest, so sign can pick the key of the
+// certificate the payload names as its creator.
+func (s *credentialStore) connectOptions() []client.ConnectOption {
+	return []client.ConnectOption{client.WithSign(s.sign), client.WithHash(func(message []byte) []byte { return message })}
+}
+
+// sign signs a proposal or transaction payload with the key matching its
+// creator, so one created just before a reload is still signed with the
+// key it was created for. Only the pair before the current one is kept.
+func (s *credentialStore) sign(message []byte) ([]byte, error) {
+	var payload common.Payload
+	if err := proto.Unmarshal(message, &payload); err != nil {
+		return nil, err
+	}
+	var sh common.SignatureHeader
+	if err := proto.Unmarshal(payload.GetHeader().GetSignatureHeader(), &sh); err != nil {
+		return nil, err
+	}
+	var creator msp.SerializedIdentity
+	if err := proto.Unmarshal(sh.GetCreator(), &creator); err != nil {
+		return nil, err
+	}
+	for _, m := range []*material{s.current.Load(), s.previous.Load()} {
+		if m != nil && bytes.Equal(m.certPEM, creator.GetIdBytes()) {
+			return m.sign(message)
+		}
+	}
+	return nil, errors.New("the certificate this transaction was created with has been rotated out; retry")
+}
+
+// sign signs the SHA-256 digest of message. S is kept in the lower half of
+// the curve order, as peers and orderers require.
+func (m *material) sign(message []byte) ([]byte, error) {
+	type ecdsaSig struct{ R, S *big.Int }
+	digest := sha256.Sum256(message)
+	r, sig, err := ecdsa.Sign(rand.Reader, m.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	n := m.key.Curve.Params().N
+	if sig.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		sig.Sub(n, sig)
+	}
+	return asn1.Marshal(ecdsaSig{r, sig})
+}
+
+// transportCredentials verifies the peer against the current TLS roots on
+// every handshake, so reconnects pick up a rotated CA while established
+// connections stay up. With an empty serverName the name comes from the
+// address dialled.
+func (s *credentialStore) transportCredentials(serverName string) credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true, // verified in VerifyConnection
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("peer sent no certificate")
+			}
+			inter := x509.NewCertPool()
+			for _, c := range cs.PeerCertificates[1:] {
+				inter.AddCert(c)
+			}
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: s.roots.Load().pool, Intermediates: inter, DNSName: cs.ServerName})
+			return err
+		},
+	})
+}
+
+// watch reloads the credentials when anything changes in the directories
+// holding them, and in the directories TLS_CERT_PATH lists. Kubernetes
+// updates a mounted secret by swapping a symlink in that directory, so
+// events are matched by directory rather than file name.
+func (s *credentialStore) watch(ctx context.Context) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("identity watch disabled: %v", err)
+		return
+	}
+	defer w.Close()
+	dirs := map[string]bool{}
+	for _, p := range []string{s.certPath, s.keyPath} {
+		dirs[filepath.Dir(p)] = true
+	}
+	for _, p := range strings.Split(s.tlsRootsPath, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			dirs[p] = true
+		} else {
+			dirs[filepath.Dir(p)] = true
+		}
+	}
+	for d := range dirs {
+		if err := w.Add(d); err != nil {
+			log.Printf("identity watch disabled: %v", err)
+			return
+		}
+	}
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			// Secrets change several files at once; wait for them to settle.
+			reload = time.After(500 * time.Millisecond)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("identity watch: %v", err)
+		case <-reload:
+			reload = nil
+			s.reloadRootsLogged()
+			if err := s.load(); err != nil {
+				log.Printf("identity reload failed, keeping previous credentials: %v", err)
+				recordSystemOperation("identity reload", err)
+				continue
+			}
+			log.Print("identity reloaded")
+			recordSystemOperation("identity reload", nil)
+		}
+	}
+}
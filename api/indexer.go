@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// Change is the latest committed write to an account.
+type Change struct {
+	MSISDN      string `json:"MSISDN" xml:"MSISDN"`
+	BlockNumber uint64 `json:"blockNumber" xml:"blockNumber"`
+	TxID        string `json:"txId" xml:"txId"`
+	Timestamp   int64  `json:"timestamp" xml:"timestamp"`
+	Deleted     bool   `json:"deleted" xml:"deleted"`
+	// DEALERID is the account's dealer as written or, for a delete, as last
+	// indexed.
+	DEALERID string `json:"DEALERID,omitempty" xml:"DEALERID,omitempty"`
+
+	account *Account
+}
+
+// indexSnapshot also carries the analytics aggregates, each account's
+// latest state and the postings for settlement files, so they are saved
+// with the block they are current to.
+type indexSnapshot struct {
+	Next        uint64                                   `json:"next"`
+	Changes     map[string]*Change                       `json:"changes"`
+	Analytics   map[string]map[string]*MonthlyAggregate  `json:"analytics"`
+	Accounts    map[string]*Account                      `json:"accounts"`
+	Settlements map[string]map[string][]*SettlementEntry `json:"settlements"`
+}
+
+func emptySnapshot() indexSnapshot {
+	return indexSnapshot{Changes: map[string]*Change{}, Analytics: map[string]map[string]*MonthlyAggregate{}, Accounts: map[string]*Account{}, Settlements: map[string]map[string][]*SettlementEntry{}}
+}
+
+// changeIndex is built by every replica from the block stream, so it also
+// sees writes that emit no chaincode event, such as status cascades.
+var changeIndex = struct {
+	sync.RWMutex
+	enabled bool
+	indexSnapshot
+}{indexSnapshot: emptySnapshot()}
+
+// loadChangeIndex restores the snapshot in INDEX_FILE, if any, so a restart
+// resumes from the last indexed block instead of replaying the channel.
+func loadChangeIndex() {
+	if os.Getenv("CHANGE_INDEX") == "false" {
+		return
+	}
+	changeIndex.enabled = true
+	p := os.Getenv("INDEX_FILE")
+	if p == "" {
+		return
+	}
+	b, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("read %s: %v", p, err)
+	}
+	if err := json.Unmarshal(b, &changeIndex.indexSnapshot); err != nil {
+		log.Fatalf("parse %s: %v", p, err)
+	}
+	if changeIndex.Analytics == nil {
+		changeIndex.Analytics = map[string]map[string]*MonthlyAggregate{}
+	}
+	if changeIndex.Settlements == nil {
+		changeIndex.Settlements = map[string]map[string][]*SettlementEntry{}
+	}
+	if changeIndex.Accounts == nil {
+		// Snapshots from before account states were kept cannot serve
+		// reads, so the channel is indexed again from the start.
+		log.Printf("%s has no account states; reindexing from block 0", p)
+		changeIndex.indexSnapshot = emptySnapshot()
+	}
+}
+
+func saveChangeIndex() {
+	p := os.Getenv("INDEX_FILE")
+	if p == "" {
+		return
+	}
+	changeIndex.RLock()
+	b, err := json.Marshal(changeIndex.indexSnapshot)
+	changeIndex.RUnlock()
+	if err != nil {
+		log.Printf("index snapshot: %v", err)
+		return
+	}
+	if err := os.WriteFile(p+".tmp", b, 0o600); err != nil {
+		log.Printf("index snapshot: %v", err)
+		return
+	}
+	if err := os.Rename(p+".tmp", p); err != nil {
+		log.Printf("index snapshot: %v", err)
+	}
+}
+
+// runIndexer follows committed blocks until ctx is cancelled, reconnecting
+// after the last indexed block if the stream breaks.
+func runIndexer(ctx context.Context) {
+	if !changeIndex.enabled {
+		return
+	}
+	defer saveChangeIndex()
+	saved := time.Now()
+	for ctx.Err() == nil {
+		changeIndex.RLock()
+		next := changeIndex.Next
+		changeIndex.RUnlock()
+		blocks, err := network.BlockEvents(ctx, client.WithStartBlock(next))
+		if err != nil {
+			log.Printf("index blocks: %v", err)
+		} else {
+			for b := range blocks {
+				indexBlock(b)
+				if time.Since(saved) > 10*time.Second {
+					saveChangeIndex()
+					saved = time.Now()
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// indexBlock skips blocks the index already has, which the stream still
+// delivers after a re-sync has caught up past it.
+func indexBlock(b *common.Block) {
+	txs := blockWrites(b)
+	changeIndex.Lock()
+	defer changeIndex.Unlock()
+	if b.GetHeader().GetNumber() < changeIndex.Next {
+		return
+	}
+	changeIndex.apply(b.GetHeader().GetNumber(), txs)
+}
+
+type txWrites struct {
+	changes  []*Change
+	postings []*indexedPosting
+}
+
+// blockWrites decodes the account writes and postings of b's valid
+// transactions.
+func blockWrites(b *common.Block) []txWrites {
+	num := b.GetHeader().GetNumber()
+	var filter []byte
+	if md := b.GetMetadata().GetMetadata(); len(md) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		filter = md[common.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	}
+	var txs []txWrites
+	for i, data := range b.GetData().GetData() {
+		if i < len(filter) && peer.TxValidationCode(filter[i]) != peer.TxValidationCode_VALID {
+			continue
+		}
+		c, p, err := accountWrites(data, num)
+		if err != nil {
+			log.Printf("index block %d tx %d: %v", num, i, err)
+			continue
+		}
+		txs = append(txs, txWrites{c, p})
+	}
+	return txs
+}
+
+// apply adds block num's writes to s. The caller holds changeIndex if s is
+// its snapshot.
+func (s *indexSnapshot) apply(num uint64, txs []txWrites) {
+	for _, tx := range txs {
+		for _, c := range tx.changes {
+			if c.Deleted {
+				if a := s.Accounts[c.MSISDN]; a != nil {
+					c.DEALERID = a.DEALERID
+				}
+				delete(s.Analytics, c.MSISDN)
+				delete(s.Accounts, c.MSISDN)
+			} else {
+				s.Accounts[c.MSISDN] = c.account
+			}
+			s.Changes[c.MSISDN] = c
+		}
+		for _, p := range tx.postings {
+			s.aggregate(p)
+			s.fileSettlement(p, num)
+		}
+	}
+	s.Next = num + 1
+}
+
+// accountWrites decodes an endorser transaction down to its write set and
+// returns the account keys it wrote in this chaincode's namespace, with the
+// accounts as written, and the postings it added to recent transactions.
+// Other composite keys (indexes, config) are skipped.
+func accountWrites(data []byte, block uint64) ([]*Change, []*indexedPosting, error) {
+	var env common.Envelope
+	if err := proto.Unmarshal(data, &env); err != nil {
+		return nil, nil, err
+	}
+	var payload common.Payload
+	if err := proto.Unmarshal(env.GetPayload(), &payload); err != nil {
+		return nil, nil, err
+	}
+	var ch common.ChannelHeader
+	if err := proto.Unmarshal(payload.GetHeader().GetChannelHeader(), &ch); err != nil {
+		return nil, nil, err
+	}
+	if common.HeaderType(ch.GetType()) != common.HeaderType_ENDORSER_TRANSACTION {
+		return nil, nil, nil
+	}
+	var tx peer.Transaction
+	if err := proto.Unmarshal(payload.GetData(), &tx); err != nil {
+		return nil, nil, err
+	}
+	var out []*Change
+	var postings []*indexedPosting
+	for _, action := range tx.GetActions() {
+		var ccPayload peer.ChaincodeActionPayload
+		if err := proto.Unmarshal(action.GetPayload(), &ccPayload); err != nil {
+			return nil, nil, err
+		}
+		var prp peer.ProposalResponsePayload
+		if err := proto.Unmarshal(ccPayload.GetAction().GetProposalResponsePayload(), &prp); err != nil {
+			return nil, nil, err
+		}
+		var cca peer.ChaincodeAction
+		if err := proto.Unmarshal(prp.GetExtension(), &cca); err != nil {
+			return nil, nil, err
+		}
+		var rws rwset.TxReadWriteSet
+		if err := proto.Unmarshal(cca.GetResults(), &rws); err != nil {
+			return nil, nil, err
+		}
+		for _, ns := range rws.GetNsRwset() {
+			if ns.GetNamespace() != contract.ChaincodeName() {
+				continue
+			}
+			var kv kvrwset.KVRWSet
+			if err := proto.Unmarshal(ns.GetRwset(), &kv); err != nil {
+				return nil, nil, err
+			}
+			for _, w := range kv.GetWrites() {
+				if strings.HasPrefix(w.GetKey(), "\x00") {
+					p, err := recentPostings(w.GetKey(), w.GetValue(), ch.GetTxId())
+					if err != nil {
+						return nil, nil, err
+					}
+					postings = append(postings, p...)
+					continue
+				}
+				c := &Change{MSISDN: w.GetKey(), BlockNumber: block, TxID: ch.GetTxId(), Timestamp: ch.GetTimestamp().GetSeconds(), Deleted: w.GetIsDelete()}
+				if !c.Deleted {
+					c.account = &Account{}
+					if err := decodeAccount(w.GetValue(), c.account); err != nil {
+						return nil, nil, err
+					}
+					c.DEALERID = c.account.DEALERID
+				}
+				out = append(out, c)
+			}
+		}
+	}
+	return out, postings, nil
+}
+
+// assetChangesHandler lists accounts whose latest write is after sinceBlock
+// or sinceTimestamp (unix seconds or RFC3339), oldest first. indexedThrough
+// is the last block the index has seen; use it as the next sinceBlock.
+func assetChangesHandler(c *gin.Context) {
+	if !changeIndex.enabled {
+		apiError(c, 503, ErrChangeIndexDisabled, nil)
+		return
+	}
+	var after func(*Change) bool
+	switch {
+	case c.Query("sinceBlock") != "":
+		n, err := strconv.ParseUint(c.Query("sinceBlock"), 10, 64)
+		if err != nil {
+			apiError(c, 400, ErrInvalidBlockNumber, gin.H{"param": "sinceBlock"})
+			return
+		}
+		after = func(ch *Change) bool { return ch.BlockNumber > n }
+	case c.Query("sinceTimestamp") != "":
+		v := c.Query("sinceTimestamp")
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			t, perr := time.Parse(time.RFC3339, v)
+			if perr != nil {
+				apiError(c, 400, ErrInvalidTimestamp, gin.H{"param": "sinceTimestamp"})
+				return
+			}
+			ts = t.Unix()
+		}
+		after = func(ch *Change) bool { return ch.Timestamp > ts }
+	default:
+		apiError(c, 400, ErrSinceRequired, nil)
+		return
+	}
+	changeIndex.RLock()
+	out := []*Change{}
+	for _, ch := range changeIndex.Changes {
+		if after(ch) && ownDealerOnly(c, ch) {
+			out = append(out, ch)
+		}
+	}
+	through := int64(changeIndex.Next) - 1
+	changeIndex.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].BlockNumber != out[j].BlockNumber {
+			return out[i].BlockNumber < out[j].BlockNumber
+		}
+		return out[i].MSISDN < out[j].MSISDN
+	})
+	respond(c, 200, gin.H{"changes": out, "indexedThrough": through, "meta": ListMeta{ReturnedCount: len(out), FetchedAtBlockHeight: uint64(through + 1)}})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	microTimeLayout   = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// elector runs fn while this replica holds leadership and cancels its context
+// as soon as leadership is lost, so only one replica processes events.
+type elector interface {
+	Run(ctx context.Context, fn func(ctx context.Context))
+}
+
+type soloElector struct{}
+
+func (soloElector) Run(ctx context.Context, fn func(ctx context.Context)) { fn(ctx) }
+
+// leaderWork is everything only the leader runs: event processing, resuming
+// unfinished sagas and submissions and, when configured, outbox delivery, height marking and
+// settlement file pushes.
+func leaderWork(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runSagas(ctx)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runOfflinePrune(ctx)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runHeightMarker(ctx)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runSubmissions(ctx)
+	}()
+	if outbox != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runOutbox(ctx)
+		}()
+	}
+	if settlementPush != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runSettlementPush(ctx)
+		}()
+	}
+	processEvents(ctx)
+	wg.Wait()
+}
+
+func newElector() elector {
+	switch os.Getenv("LEADER_ELECTION") {
+	case "", "none":
+		return soloElector{}
+	case "k8s":
+		e, err := newLeaseElector()
+		if err != nil {
+			log.Fatalf("leader election: %v", err)
+		}
+		return e
+	default:
+		log.Fatalf("unknown LEADER_ELECTION %q", os.Getenv("LEADER_ELECTION"))
+	}
+	return nil
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+type leaseMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type lease struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Metadata   leaseMeta `json:"metadata"`
+	Spec       leaseSpec `json:"spec"`
+}
+
+// leaseElector implements leader election on a coordination.k8s.io/v1 Lease
+// using the pod's service account, relying on resourceVersion for
+// compare-and-swap between replicas.
+type leaseElector struct {
+	http      *http.Client
+	url       string
+	token     string
+	name      string
+	namespace string
+	identity  string
+	duration  time.Duration
+}
+
+func newLeaseElector() (*leaseElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account ca.crt")
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		b, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		if identity, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	name := os.Getenv("LEASE_NAME")
+	if name == "" {
+		name = "fabric-api-events"
+	}
+	duration := 10 * time.Second
+	if v := os.Getenv("LEASE_DURATION"); v != "" {
+		if duration, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("LEASE_DURATION: %w", err)
+		}
+	}
+	return &leaseElector{
+		http:      &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+		url:       fmt.Sprintf("https://%s:%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", host, port, namespace),
+		token:     strings.TrimSpace(string(token)),
+		name:      name,
+		namespace: namespace,
+		identity:  identity,
+		duration:  duration,
+	}, nil
+}
+
+func (e *leaseElector) Run(ctx context.Context, fn func(ctx context.Context)) {
+	retry := time.NewTicker(e.duration / 3)
+	defer retry.Stop()
+	var cancel context.CancelFunc
+	var done chan struct{}
+	var renewed time.Time
+	for {
+		ok, err := e.tryAcquireOrRenew(ctx)
+		if err != nil {
+			log.Printf("leader election: %v", err)
+		}
+		if ok {
+			renewed = time.Now()
+		}
+		leading := ok || cancel != nil && time.Since(renewed) < e.duration*2/3
+		switch {
+		case leading && cancel == nil:
+			log.Printf("leader election: %s acquired lease %s", e.identity, e.name)
+			var lctx context.Context
+			lctx, cancel = context.WithCancel(ctx)
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				fn(lctx)
+			}()
+		case !leading && cancel != nil:
+			log.Printf("leader election: %s lost lease %s", e.identity, e.name)
+			cancel()
+			<-done
+			cancel = nil
+		}
+		select {
+		case <-ctx.Done():
+			if cancel != nil {
+				cancel()
+				<-done
+				e.release()
+			}
+			return
+		case <-retry.C:
+		}
+	}
+}
+
+func (e *leaseElector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+	now := time.Now()
+	cur, err := e.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if cur == nil {
+		l := &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease", Metadata: leaseMeta{Name: e.name, Namespace: e.namespace}}
+		e.stamp(l, now, true)
+		return e.write(ctx, http.MethodPost, e.url, l)
+	}
+	mine := cur.Spec.HolderIdentity == e.identity
+	if !mine && !e.expired(cur, now) {
+		return false, nil
+	}
+	e.stamp(cur, now, !mine)
+	return e.write(ctx, http.MethodPut, e.url+"/"+e.name, cur)
+}
+
+func (e *leaseElector) stamp(l *lease, now time.Time, acquire bool) {
+	ts := now.UTC().Format(microTimeLayout)
+	if acquire {
+		if l.Spec.HolderIdentity != "" {
+			l.Spec.LeaseTransitions++
+		}
+		l.Spec.AcquireTime = ts
+	}
+	l.Spec.HolderIdentity = e.identity
+	l.Spec.LeaseDurationSeconds = int(e.duration / time.Second)
+	l.Spec.RenewTime = ts
+}
+
+func (e *leaseElector) expired(l *lease, now time.Time) bool {
+	if l.Spec.HolderIdentity == "" {
+		return true
+	}
+	renewed, err := time.Parse(microTimeLayout, l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// release gives the lease up on shutdown so another replica can take over
+// without waiting for it to expire.
+func (e *leaseElector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cur, err := e.get(ctx)
+	if err != nil || cur == nil || cur.Spec.HolderIdentity != e.identity {
+		return
+	}
+	cur.Spec.HolderIdentity = ""
+	if _, err := e.write(ctx, http.MethodPut, e.url+"/"+e.name, cur); err != nil {
+		log.Printf("leader election: release: %v", err)
+	}
+}
+
+func (e *leaseElector) get(ctx context.Context) (*lease, error) {
+	res, err := e.do(ctx, http.MethodGet, e.url+"/"+e.name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("get lease: %s: %s", res.Status, b)
+	}
+	var l lease
+	if err := json.NewDecoder(res.Body).Decode(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// write returns false without error when another replica updated the lease
+// first.
+func (e *leaseElector) write(ctx context.Context, method, url string, l *lease) (bool, error) {
+	body, err := json.Marshal(l)
+	if err != nil {
+		return false, err
+	}
+	res, err := e.do(ctx, method, url, body)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	}
+	b, _ := io.ReadAll(res.Body)
+	return false, fmt.Errorf("%s lease: %s: %s", strings.ToLower(method), res.Status, b)
+}
+
+func (e *leaseElector) do(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+e.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return e.http.Do(req)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer/lifecycle"
+	"google.golang.org/protobuf/proto"
+)
+
+type InstalledPackage struct {
+	PackageID string `json:"packageId"`
+	Label     string `json:"label"`
+	Version   string `json:"version"`
+}
+
+type ChaincodeInfo struct {
+	Name              string             `json:"name"`
+	Channel           string             `json:"channel"`
+	Version           string             `json:"version"`
+	Sequence          int64              `json:"sequence"`
+	EndorsementPolicy string             `json:"endorsementPolicy"`
+	EndorsementPlugin string             `json:"endorsementPlugin"`
+	ValidationPlugin  string             `json:"validationPlugin"`
+	InitRequired      bool               `json:"initRequired"`
+	Approvals         map[string]bool    `json:"approvals"`
+	Installed         []InstalledPackage `json:"installed"`
+	InstalledError    string             `json:"installedError,omitempty"`
+}
+
+func lifecycleQuery(fn string, args, out proto.Message) error {
+	arg, err := proto.Marshal(args)
+	if err != nil {
+		return err
+	}
+	res, err := network.GetContract("_lifecycle").Evaluate(fn, client.WithBytesArguments(arg))
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(res, out)
+}
+
+// chaincodeHandler reports the committed definition of the chaincode the API
+// is configured for, as _lifecycle on the gateway peer sees it, plus the
+// packages installed on that peer for it. Listing installed packages needs a
+// peer admin identity; without one only installedError is set.
+func chaincodeHandler(c *gin.Context) {
+	name := contract.ChaincodeName()
+	var def lifecycle.QueryChaincodeDefinitionResult
+	if err := lifecycleQuery("QueryChaincodeDefinition", &lifecycle.QueryChaincodeDefinitionArgs{Name: name}, &def); err != nil {
+		fabricError(c, err)
+		return
+	}
+	info := ChaincodeInfo{
+		Name:              name,
+		Channel:           network.Name(),
+		Version:           def.GetVersion(),
+		Sequence:          def.GetSequence(),
+		EndorsementPlugin: def.GetEndorsementPlugin(),
+		ValidationPlugin:  def.GetValidationPlugin(),
+		InitRequired:      def.GetInitRequired(),
+		Approvals:         def.GetApprovals(),
+		Installed:         []InstalledPackage{},
+	}
+	var policy peer.ApplicationPolicy
+	if err := proto.Unmarshal(def.GetValidationParameter(), &policy); err != nil {
+		info.EndorsementPolicy = "unreadable: " + err.Error()
+	} else if ref := policy.GetChannelConfigPolicyReference(); ref != "" {
+		info.EndorsementPolicy = ref
+	} else {
+		env := policy.GetSignaturePolicy()
+		info.EndorsementPolicy = signaturePolicyString(env.GetRule(), env.GetIdentities())
+	}
+
+	var installed lifecycle.QueryInstalledChaincodesResult
+	if err := lifecycleQuery("QueryInstalledChaincodes", &lifecycle.QueryInstalledChaincodesArgs{}, &installed); err != nil {
+		info.InstalledError = classify(err).Error
+	}
+	for _, cc := range installed.GetInstalledChaincodes() {
+		for _, ref := range cc.GetReferences()[network.Name()].GetChaincodes() {
+			if ref.GetName() == name {
+				info.Installed = append(info.Installed, InstalledPackage{PackageID: cc.GetPackageId(), Label: cc.GetLabel(), Version: ref.GetVersion()})
+			}
+		}
+	}
+	c.JSON(200, info)
+}
+
+// signaturePolicyString renders a signature policy in the syntax peer CLI
+// accepts, e.g. OutOf(2, 'Org1MSP.member', 'Org2MSP.member').
+func signaturePolicyString(p *common.SignaturePolicy, ids []*msp.MSPPrincipal) string {
+	if p == nil {
+		return ""
+	}
+	if n := p.GetNOutOf(); n != nil {
+		rules := make([]string, 0, len(n.GetRules()))
+		for _, r := range n.GetRules() {
+			rules = append(rules, signaturePolicyString(r, ids))
+		}
+		return fmt.Sprintf("OutOf(%d, %s)", n.GetN(), strings.Join(rules, ", "))
+	}
+	i := int(p.GetSignedBy())
+	if i < 0 || i >= len(ids) {
+		return fmt.Sprintf("SignedBy(%d)", i)
+	}
+	var role msp.MSPRole
+	if ids[i].GetPrincipalClassification() != msp.MSPPrincipal_ROLE || proto.Unmarshal(ids[i].GetPrincipal(), &role) != nil {
+		return fmt.Sprintf("SignedBy(%d)", i)
+	}
+	return fmt.Sprintf("'%s.%s'", role.GetMspIdentifier(), strings.ToLower(role.GetRole().String()))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// ListMeta describes the page a list endpoint returned. PageSize is zero
+// for lists that are not paged. FetchedAtBlockHeight is the channel height
+// read just before the query, so the records reflect at least every block
+// below it; it is omitted when the height could not be read. A client
+// paging through a list can compare it across pages to tell whether the
+// ledger moved underneath it.
+type ListMeta struct {
+	ReturnedCount        int    `json:"returnedCount" xml:"returnedCount"`
+	PageSize             int    `json:"pageSize,omitempty" xml:"pageSize,omitempty"`
+	NextCursor           string `json:"nextCursor,omitempty" xml:"nextCursor,omitempty"`
+	HasMore              bool   `json:"hasMore" xml:"hasMore"`
+	FetchedAtBlockHeight uint64 `json:"fetchedAtBlockHeight,omitempty" xml:"fetchedAtBlockHeight,omitempty"`
+}
+
+// ListResponse is the body of a list endpoint that has no page type of its
+// own.
+type ListResponse[T any] struct {
+	Records []T      `json:"records" xml:"records>record"`
+	Meta    ListMeta `json:"meta" xml:"meta"`
+}
+
+// chainHeight reads the channel height from qscc for a list's meta. It is
+// called before the list's own evaluate and answers 0 if qscc fails, which
+// is not worth failing the list over.
+func chainHeight(c *gin.Context) uint64 {
+	res, err := qscc.EvaluateWithContext(c.Request.Context(), "GetChainInfo", client.WithArguments(network.Name()))
+	if err != nil {
+		return 0
+	}
+	var info common.BlockchainInfo
+	if err := proto.Unmarshal(res, &info); err != nil {
+		return 0
+	}
+	return info.GetHeight()
+}
+
+// pageHasMore reports whether a bookmark-paged query may have more records.
+// Fabric returns a bookmark even from the last page, so a page short of
+// pageSize is taken as the end.
+func pageHasMore(bookmark string, fetched int32, pageSize int) bool {
+	return bookmark != "" && int(fetched) >= pageSize
+}
@@ -1,47 +1,128 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/x509"
-	"encoding/asn1"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"flag"
 	"log"
-	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hyperledger/fabric-gateway/pkg/client"
-	"github.com/hyperledger/fabric-gateway/pkg/identity"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 )
 
+// Account is an account as the API returns it. The MPIN is never returned;
+// it is only written, through AccountInput.
 type Account struct {
-	DEALERID    string `json:"DEALERID"`
-	MSISDN      string `json:"MSISDN"`
-	MPIN        string `json:"MPIN"`
-	BALANCE     int64  `json:"BALANCE"`
-	STATUS      string `json:"STATUS"`
-	TRANSAMOUNT int64  `json:"TRANSAMOUNT"`
-	TRANSTYPE   string `json:"TRANSTYPE"`
-	REMARKS     string `json:"REMARKS"`
+	DEALERID    string `json:"DEALERID" xml:"DEALERID"`
+	MSISDN      string `json:"MSISDN" xml:"MSISDN"`
+	MPIN        string `json:"-" xml:"-"`
+	BALANCE     int64  `json:"BALANCE" xml:"BALANCE"`
+	STATUS      string `json:"STATUS" xml:"STATUS"`
+	TRANSAMOUNT int64  `json:"TRANSAMOUNT" xml:"TRANSAMOUNT"`
+	TRANSTYPE   string `json:"TRANSTYPE" xml:"TRANSTYPE"`
+	REMARKS     string `json:"REMARKS" xml:"REMARKS"`
+	PARENT      string `json:"PARENT,omitempty" xml:"PARENT,omitempty"`
+	// CURRENCY is empty for accounts in the base currency of the chaincode's
+	// FX config.
+	CURRENCY string `json:"CURRENCY,omitempty" xml:"CURRENCY,omitempty"`
+	// CreatedAt and LastModified are the Unix seconds of the transactions
+	// that created and last wrote the account, taken from the ledger. Older
+	// accounts may have no CreatedAt. Both are ignored on writes.
+	CreatedAt    int64 `json:"createdAt,omitempty" xml:"createdAt,omitempty"`
+	LastModified int64 `json:"lastModified,omitempty" xml:"lastModified,omitempty"`
+}
+
+// AccountInput is the body of POST /assets, PUT /assets/:msisdn and POST
+// /assets/:msisdn/subaccounts: an account with its MPIN. PUT ignores the
+// MPIN; only POST /assets/:msisdn/mpin changes it.
+type AccountInput struct {
+	Account
+	MPIN string `json:"MPIN"`
+}
+
+// account is in as an Account, MPIN included.
+func (in AccountInput) account() Account {
+	a := in.Account
+	a.MPIN = in.MPIN
+	return a
+}
+
+// accountPayload is the argument of the chaincode's CreateAssetJSON and
+// UpdateAssetJSON: the fields of a that are written, as typed JSON.
+func accountPayload(a Account) string {
+	b, _ := json.Marshal(struct {
+		DEALERID    string `json:"DEALERID"`
+		MSISDN      string `json:"MSISDN"`
+		MPIN        string `json:"MPIN"`
+		BALANCE     int64  `json:"BALANCE"`
+		STATUS      string `json:"STATUS"`
+		TRANSAMOUNT int64  `json:"TRANSAMOUNT"`
+		TRANSTYPE   string `json:"TRANSTYPE"`
+		REMARKS     string `json:"REMARKS"`
+	}{a.DEALERID, a.MSISDN, a.MPIN, a.BALANCE, a.STATUS, a.TRANSAMOUNT, a.TRANSTYPE, a.REMARKS})
+	return string(b)
 }
 
 type History struct {
-	TxID      string   `json:"txId"`
-	Value     *Account `json:"value,omitempty"`
-	IsDelete  bool     `json:"isDelete"`
-	Timestamp int64    `json:"timestamp"`
+	TxID           string   `json:"txId" xml:"txId"`
+	Value          *Account `json:"value,omitempty" xml:"value,omitempty"`
+	IsDelete       bool     `json:"isDelete" xml:"isDelete"`
+	Timestamp      int64    `json:"timestamp" xml:"timestamp"`
+	TimestampNanos int64    `json:"timestampNanos" xml:"timestampNanos"`
+	ValueHash      string   `json:"valueHash,omitempty" xml:"valueHash,omitempty"`
+	BlockNumber    uint64   `json:"blockNumber,omitempty" xml:"blockNumber,omitempty"`
+	Origin         *Origin  `json:"origin,omitempty" xml:"origin,omitempty"`
+}
+
+// HistoryPage is a window of an account's history; Next is empty after the
+// last.
+type HistoryPage struct {
+	Records []History `json:"records" xml:"records>history"`
+	Next    string    `json:"next" xml:"next"`
+	Meta    ListMeta  `json:"meta" xml:"meta"`
+}
+
+type AssetPage struct {
+	Records  []Account `json:"records" xml:"records>account"`
+	Bookmark string    `json:"bookmark" xml:"bookmark"`
+	Fetched  int32     `json:"fetchedCount" xml:"fetchedCount"`
+	Meta     ListMeta  `json:"meta" xml:"meta"`
+}
+
+type Rollup struct {
+	MSISDN             string    `json:"MSISDN" xml:"MSISDN"`
+	BALANCE            int64     `json:"BALANCE" xml:"BALANCE"`
+	SubAccounts        []Account `json:"subAccounts" xml:"subAccounts>account"`
+	SubAccountsBalance int64     `json:"subAccountsBalance" xml:"subAccountsBalance"`
+	TotalBalance       int64     `json:"totalBalance" xml:"totalBalance"`
+}
+
+type TxSummary struct {
+	TxID        string `json:"txId" xml:"txId"`
+	TRANSTYPE   string `json:"TRANSTYPE" xml:"TRANSTYPE"`
+	TRANSAMOUNT int64  `json:"TRANSAMOUNT" xml:"TRANSAMOUNT"`
+	BALANCE     int64  `json:"BALANCE" xml:"BALANCE"`
+	REMARKS     string `json:"REMARKS" xml:"REMARKS"`
+	Timestamp   int64  `json:"timestamp" xml:"timestamp"`
+	Receipt     string `json:"receipt,omitempty" xml:"receipt,omitempty"`
 }
 
 var gw *client.Gateway
+var network *client.Network
 var contract *client.Contract
+var qscc *client.Contract
+var ids *credentialStore
 
 func mustEnv(k string) string {
 	v := os.Getenv(k)
@@ -91,146 +172,500 @@ func connect() {
 	certPath := mustEnv("CERT_PATH")
 	keyPath := mustEnv("KEY_PATH")
 
-	creds, err := credentials.NewClientTLSFromFile(tlsCertPath, gatewayPeer)
+	var err error
+	ids, err = newCredentialStore(mspID, certPath, keyPath, tlsCertPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	conn, err := grpc.Dial(peerEndpoint, grpc.WithTransportCredentials(creds))
+	target, dialOpts := gatewayDialTarget(peerEndpoint, gatewayPeer)
+	peerPool, err = dialPool(target, poolSize(), append(dialOpts, grpc.WithChainUnaryInterceptor(peerLatencyInterceptor, slowQueryInterceptor))...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	peerConn = peerPool
 
-	cert, err := identity.CertificateFromPEM(readFile(certPath))
+	gw, err = client.Connect(ids, append(ids.connectOptions(), client.WithClientConnection(peerConn), client.WithEvaluateTimeout(10*time.Second), client.WithEndorseTimeout(10*time.Second), client.WithSubmitTimeout(10*time.Second), client.WithCommitStatusTimeout(10*time.Second))...)
 	if err != nil {
 		log.Fatal(err)
 	}
-	id, err := identity.NewX509Identity(mspID, cert)
-	if err != nil {
-		log.Fatal(err)
+	network = gw.GetNetwork(channel)
+	contract = network.GetContract(ccName)
+	qscc = network.GetContract("qscc")
+}
+
+func assetsPageHandler(c *gin.Context) {
+	pageSize, err := strconv.Atoi(c.Query("pageSize"))
+	if err != nil || pageSize < 1 || pageSize > 1000 {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "pageSize", "min": 1, "max": 1000})
+		return
+	}
+	opts, ok := proposalOptions(c, strconv.Itoa(pageSize), c.Query("bookmark"))
+	if !ok {
+		return
 	}
-	priv, err := privateKeyFromPEM(readFile(keyPath))
+	cachedAssetsPage(c, pageKey{function: "GetAssetsPage", bookmark: c.Query("bookmark"), pageSize: pageSize}, opts)
+}
+
+// historyPageHandler serves ?pageSize history entries following ?after, a
+// TxID from the previous page's next.
+func historyPageHandler(c *gin.Context) {
+	pageSize, err := strconv.Atoi(c.Query("pageSize"))
+	if err != nil || pageSize < 1 || pageSize > 1000 {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "pageSize", "min": 1, "max": 1000})
+		return
+	}
+	opts, ok := proposalOptions(c, c.Param("msisdn"), strconv.Itoa(pageSize), c.Query("after"))
+	if !ok {
+		return
+	}
+	height := chainHeight(c)
+	res, err := evaluate(c, "GetAssetHistoryPage", opts...)
 	if err != nil {
-		log.Fatal(err)
+		fabricError(c, err)
+		return
 	}
-	type ecdsaSig struct{ R, S *big.Int }
-	sign := func(digest []byte) ([]byte, error) {
-		r, s, err := ecdsa.Sign(rand.Reader, priv, digest)
-		if err != nil {
-			return nil, err
-		}
-		return asn1.Marshal(ecdsaSig{r, s})
+	var page HistoryPage
+	if err := json.Unmarshal(res, &page); err != nil {
+		internalError(c, err)
+		return
+	}
+	if !addBlockNumbers(c, page.Records) {
+		return
 	}
+	page.Meta = ListMeta{ReturnedCount: len(page.Records), PageSize: pageSize, NextCursor: page.Next, HasMore: page.Next != "", FetchedAtBlockHeight: height}
+	respond(c, 200, page)
+}
 
-	gw, err = client.Connect(id, client.WithSign(sign), client.WithClientConnection(conn), client.WithEvaluateTimeout(10*time.Second), client.WithEndorseTimeout(10*time.Second), client.WithSubmitTimeout(10*time.Second), client.WithCommitStatusTimeout(10*time.Second))
-	if err != nil {
-		log.Fatal(err)
+// addBlockNumbers fills in each entry's block unless ?blocks=false,
+// answering the error itself when a lookup fails.
+func addBlockNumbers(c *gin.Context, h []History) bool {
+	if c.Query("blocks") == "false" {
+		return true
 	}
-	network := gw.GetNetwork(channel)
-	contract = network.GetContract(ccName)
+	for i := range h {
+		n, err := blockOfTx(h[i].TxID)
+		if err != nil {
+			fabricError(c, err)
+			return false
+		}
+		h[i].BlockNumber = n
+	}
+	return true
 }
 
 func main() {
-	connect()
+	check := flag.Bool("check", false, "validate the configuration, credentials and peer connection, print a report and exit")
+	sandbox := flag.Bool("sandbox", false, "serve from an in-memory ledger emulator instead of a Fabric network")
+	flag.Parse()
+	loadRedaction()
+	var report *preflightReport
+	if *check {
+		report = newPreflightReport(os.Stdout)
+	} else if os.Getenv("PREFLIGHT") != "false" && !*sandbox {
+		report = newPreflightReport(log.Writer())
+	}
+	if *sandbox && *check {
+		log.Fatal("--check does not apply to --sandbox")
+	}
+	if report != nil && !preflightLocal(report) {
+		if *check {
+			os.Exit(1)
+		}
+		log.Fatal("preflight failed")
+	}
+	if *sandbox {
+		connectSandbox()
+	} else {
+		connect()
+	}
 	defer gw.Close()
+	if report != nil {
+		preflightGateway(report)
+		if report.failed && !*check {
+			log.Fatal("preflight failed")
+		}
+	}
+	loadAuth()
+	loadDocs()
+	loadOutbox()
+	loadCatalog()
+	loadShedding()
+	loadChangeIndex()
+	loadBreakers()
+	loadQueryCache()
+	loadSagas()
+	loadOffline()
+	loadSubmissions()
+	loadRunbooks()
+	loadRegions()
+	loadSlowQueries()
+	loadOrigins()
+	loadAdminLog()
+	loadSubmitQueue()
+	loadHeightMarker()
+	loadPublic()
+	loadCloudEvents()
+	loadCollections()
+	loadReadStrategies()
+	loadRetention()
+	loadTenants()
+	loadFraudScoring()
+	loadSettlementPush()
+	loadStatusCache()
+	loadPolicies()
+	if *check {
+		report.ok("config", "all settings parsed")
+		if report.failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	elected := make(chan struct{})
+	go func() {
+		defer close(elected)
+		newElector().Run(ctx, leaderWork)
+	}()
+	indexed := make(chan struct{})
+	go func() {
+		defer close(indexed)
+		runIndexer(ctx)
+	}()
+	go runQueryCache(ctx)
+	go runStatusCache(ctx)
+	go runDiscovery(ctx)
+	go peerPool.watch(ctx)
+	go runRegionProbes(ctx)
+	go runLedgerHeight(ctx)
+	go runAdminLogAnchors(ctx)
+	go runRetention(ctx)
+	go runTenantReload(ctx)
+	go runTLSRootsReload(ctx)
+	go runPolicyReload(ctx)
+	if os.Getenv("IDENTITY_WATCH") == "true" {
+		go ids.watch(ctx)
+	}
 
 	r := gin.Default()
+	r.Use(sloRecorder())
+	r.Use(requestContext())
+	r.Use(deprecationHeaders())
+	r.Use(endpointTimeouts())
+	r.Use(maintenanceGuard())
+	r.Use(loadShedder())
+	r.Use(circuitBreaker())
+	r.Use(signedResponses())
+	r.Use(policyGuard())
 	r.GET("/health", func(c *gin.Context) { c.JSON(200, gin.H{"status": "ok"}) })
+	r.GET("/readyz", readyzHandler)
+	r.GET("/metrics", metricsHandler)
+	r.GET("/openapi.json", openAPIHandler(r))
+	r.GET("/docs/try-it", tryItHandler)
 
-	r.GET("/assets", func(c *gin.Context) {
-		res, err := contract.EvaluateTransaction("GetAllAssets")
+	// The public group is unauthenticated and shares no middleware with the
+	// privileged routes beyond the global chain above.
+	pub := r.Group("/public", publicCORS(), publicRateLimit())
+	pub.GET("/assets/:msisdn/status", publicStatusHandler)
+	pub.OPTIONS("/assets/:msisdn/status")
+
+	r.GET("/assets", identify(), func(c *gin.Context) {
+		if p := principal(c); p.Role == RoleDealer {
+			dealerAssetsHandler(c, p.DealerID)
+			return
+		}
+		if c.Query("pageSize") != "" {
+			assetsPageHandler(c)
+			return
+		}
+		if fromIndex(c, "accounts") {
+			indexedAssetsHandler(c, "")
+			return
+		}
+		opts, ok := proposalOptions(c)
+		if !ok {
+			return
+		}
+		height := chainHeight(c)
+		res, err := evaluate(c, "GetAllAssets", opts...)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			fabricError(c, err)
 			return
 		}
-		var out []Account
+		out := []Account{}
 		if len(res) > 0 {
 			if err := json.Unmarshal(res, &out); err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				internalError(c, err)
 				return
 			}
 		}
-		c.JSON(200, out)
+		respond(c, 200, ListResponse[Account]{Records: out, Meta: ListMeta{ReturnedCount: len(out), FetchedAtBlockHeight: height}})
 	})
 
-	r.GET("/assets/:msisdn", func(c *gin.Context) {
+	r.GET("/assets/changes", identify(), assetChangesHandler)
+	r.GET("/sync", identify(), syncHandler)
+
+	// Every route under an account goes through ownAccountOnly, so a dealer
+	// key only reaches its own dealer's accounts.
+	acct := r.Group("/assets/:msisdn", identify(), ownAccountOnly())
+	acct.GET("", func(c *gin.Context) {
 		msisdn := c.Param("msisdn")
-		res, err := contract.EvaluateTransaction("ReadAsset", msisdn)
+		exp, ok := startExpansions(c, msisdn)
+		if !ok {
+			return
+		}
+		if fromIndex(c, "account") {
+			a, found := indexedAccount(msisdn)
+			if !found {
+				apiError(c, 404, ErrNotFound, nil)
+				return
+			}
+			if exp != nil {
+				respond(c, 200, exp.wait(c, a))
+				return
+			}
+			respond(c, 200, a)
+			return
+		}
+		opts, ok := proposalOptions(c, msisdn)
+		if !ok {
+			return
+		}
+		res, err := evaluate(c, "ReadAsset", opts...)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			fabricError(c, err)
 			return
 		}
 		var a Account
 		if err := json.Unmarshal(res, &a); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			internalError(c, err)
+			return
+		}
+		if exp != nil {
+			respond(c, 200, exp.wait(c, a))
 			return
 		}
-		c.JSON(200, a)
+		respond(c, 200, a)
 	})
 
-	r.GET("/assets/:msisdn/history", func(c *gin.Context) {
+	acct.GET("/history", func(c *gin.Context) {
+		if c.Query("pageSize") != "" {
+			historyPageHandler(c)
+			return
+		}
 		msisdn := c.Param("msisdn")
-		res, err := contract.EvaluateTransaction("GetAssetHistory", msisdn)
+		opts, ok := proposalOptions(c, msisdn)
+		if !ok {
+			return
+		}
+		height := chainHeight(c)
+		res, err := evaluate(c, "GetAssetHistory", opts...)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			fabricError(c, err)
 			return
 		}
-		var h []History
+		h := []History{}
 		if len(res) > 0 {
 			if err := json.Unmarshal(res, &h); err != nil {
-				c.JSON(500, gin.H{"error": err.Error()})
+				internalError(c, err)
 				return
 			}
 		}
-		c.JSON(200, h)
+		if !addBlockNumbers(c, h) {
+			return
+		}
+		respond(c, 200, ListResponse[History]{Records: h, Meta: ListMeta{ReturnedCount: len(h), FetchedAtBlockHeight: height}})
 	})
 
-	r.POST("/assets", func(c *gin.Context) {
-		var a Account
-		if err := c.BindJSON(&a); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+	acct.GET("/recent-transactions", func(c *gin.Context) {
+		msisdn := c.Param("msisdn")
+		n, err := strconv.Atoi(c.DefaultQuery("n", "10"))
+		if err != nil || n < 1 || n > 50 {
+			apiError(c, 400, ErrOutOfRange, gin.H{"param": "n", "min": 1, "max": 50})
+			return
+		}
+		opts, ok := proposalOptions(c, msisdn, strconv.Itoa(n))
+		if !ok {
 			return
 		}
-		_, err := contract.SubmitTransaction("CreateAsset", a.DEALERID, a.MSISDN, a.MPIN, strconv.FormatInt(a.BALANCE, 10), a.STATUS, strconv.FormatInt(a.TRANSAMOUNT, 10), a.TRANSTYPE, a.REMARKS)
+		height := chainHeight(c)
+		res, err := evaluate(c, "GetRecentTransactions", opts...)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			fabricError(c, err)
+			return
+		}
+		out := []TxSummary{}
+		if len(res) > 0 {
+			if err := json.Unmarshal(res, &out); err != nil {
+				internalError(c, err)
+				return
+			}
+		}
+		respond(c, 200, ListResponse[TxSummary]{Records: out, Meta: ListMeta{ReturnedCount: len(out), PageSize: n, FetchedAtBlockHeight: height}})
+	})
+
+	acct.GET("/ministatement", miniStatementHandler)
+	acct.GET("/analytics", analyticsHandler)
+	acct.GET("/daily-summaries", dailySummariesHandler)
+	acct.GET("/holds", holdsHandler)
+	acct.GET("/closure", closureHandler)
+	acct.GET("/merges", mergeLineageHandler)
+	acct.GET("/mpin", pinStatusHandler)
+	acct.GET("/history/export", historyExportHandler)
+	acct.GET("/balance-proof", balanceProofHandler)
+	acct.GET("/subaccounts", subAccountsHandler)
+	acct.POST("/subaccounts", createSubAccountHandler)
+	r.GET(apiV2Prefix+"/assets/:msisdn/history", identify(), ownAccountOnly(), historyV2Handler)
+	r.GET("/operations/:id", operationHandler)
+	r.GET("/transactions/:txId/origin", originHandler)
+	r.GET("/fees/quote", feeQuoteHandler)
+
+	// Writes need a key; a dealer key only writes its own dealer's accounts.
+	authed := r.Group("/", authenticate())
+	authed.POST("/assets", requireRole(RoleAdmin, RoleOperator, RoleDealer), func(c *gin.Context) {
+		var in AccountInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			bodyError(c, err)
+			return
+		}
+		a := in.account()
+		if !ownDealerAccount(c, a) {
+			return
+		}
+		opts, ok := proposalOptions(c, accountPayload(a))
+		if !ok {
+			return
+		}
+		_, _, err := submit("CreateAssetJSON", opts...)
+		if err != nil {
+			fabricError(c, err)
 			return
 		}
 		c.JSON(201, gin.H{"message": "created", "msisdn": a.MSISDN})
 	})
 
-	r.PUT("/assets/:msisdn", func(c *gin.Context) {
+	authed.PUT("/assets/:msisdn", requireRole(RoleAdmin, RoleOperator, RoleDealer), ownAccountOnly(), func(c *gin.Context) {
 		msisdn := c.Param("msisdn")
-		var a Account
-		if err := c.BindJSON(&a); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+		var in AccountInput
+		if err := c.ShouldBindJSON(&in); err != nil {
+			bodyError(c, err)
 			return
 		}
-		if a.MSISDN == "" {
-			a.MSISDN = msisdn
+		a := in.Account
+		// The path names the account, the one ownAccountOnly checked.
+		a.MSISDN = msisdn
+		if !ownDealerAccount(c, a) {
+			return
 		}
-		_, err := contract.SubmitTransaction("UpdateAsset", a.DEALERID, a.MSISDN, a.MPIN, strconv.FormatInt(a.BALANCE, 10), a.STATUS, strconv.FormatInt(a.TRANSAMOUNT, 10), a.TRANSTYPE, a.REMARKS)
+		opts, ok := proposalOptions(c, accountPayload(a))
+		if !ok {
+			return
+		}
+		_, _, err := submit("UpdateAssetJSON", opts...)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			fabricError(c, err)
 			return
 		}
 		c.JSON(200, gin.H{"message": "updated", "msisdn": a.MSISDN})
 	})
 
-	r.DELETE("/assets/:msisdn", func(c *gin.Context) {
+	authed.DELETE("/assets/:msisdn", requireRole(RoleAdmin, RoleOperator, RoleDealer), ownAccountOnly(), func(c *gin.Context) {
 		msisdn := c.Param("msisdn")
-		_, err := contract.SubmitTransaction("DeleteAsset", msisdn)
+		opts, ok := proposalOptions(c, msisdn)
+		if !ok {
+			return
+		}
+		_, _, err := submit("DeleteAsset", opts...)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+			fabricError(c, err)
 			return
 		}
 		c.JSON(200, gin.H{"message": "deleted", "msisdn": msisdn})
 	})
+	authed.POST("/invoke", requireFeature(featurePassthrough), invokeHandler)
+	authed.POST("/query", requireFeature(featurePassthrough), queryHandler)
+	authed.GET("/submissions/:key", requireFeature(featurePassthrough), submissionHandler)
+	authed.POST("/offline/proposals", requireFeature(featureOffline), prepareOfflineHandler)
+	authed.POST("/offline/proposals/:txId/endorsement", requireFeature(featureOffline), endorseOfflineHandler)
+	authed.POST("/offline/proposals/:txId/submission", requireFeature(featureOffline), submitOfflineHandler)
+	authed.GET("/events", eventsHandler)
+	authed.GET("/ws/blocks", blocksHandler)
+	authed.GET("/receipts/:receipt", receiptHandler)
+	authed.GET("/dashboard", requireRole(RoleAdmin, RoleOperator), dashboardHandler)
+	authed.GET("/assets/deleted", requireRole(RoleAdmin, RoleOperator), deletedAssetsHandler)
+	authed.GET("/dealers/:dealerId/assets.ndjson", requireRole(RoleAdmin, RoleOperator, RoleDealer), dealerExportHandler)
+	authed.GET("/dealers/:dealerId/quota", requireRole(RoleAdmin, RoleOperator, RoleDealer), dealerQuotaHandler)
+	authed.GET("/settlements/:date/:dealerId", requireRole(RoleAdmin, RoleOperator, RoleDealer), settlementHandler)
+	authed.POST("/operations/transfers", requireRole(RoleAdmin, RoleOperator), transferHandler)
+	authed.POST("/assets/:msisdn/transfer", requireRole(RoleAdmin, RoleOperator), transferFundsHandler)
+	authed.POST("/assets/:msisdn/debit", requireRole(RoleAdmin, RoleOperator), debitHandler)
+	authed.POST("/assets/:msisdn/holds", requireRole(RoleAdmin, RoleOperator), placeHoldHandler)
+	authed.POST("/assets/:msisdn/holds/:ref/release", requireRole(RoleAdmin, RoleOperator), releaseHoldHandler)
+	authed.POST("/assets/:msisdn/holds/:ref/capture", requireRole(RoleAdmin, RoleOperator), captureHoldHandler)
+	authed.POST("/assets/:msisdn/close", requireRole(RoleAdmin, RoleOperator), closeAccountHandler)
+	authed.POST("/assets/:msisdn/mpin", requireRole(RoleAdmin, RoleOperator, RoleDealer), ownAccountOnly(), changePINHandler)
+	authed.POST("/assets/:msisdn/mpin/verify", requireRole(RoleAdmin, RoleOperator, RoleDealer), ownAccountOnly(), verifyPINHandler)
+
+	admin := r.Group("/admin", authenticate(), requireRole(RoleAdmin), adminAudit())
+	admin.GET("/maintenance", getMaintenanceHandler)
+	admin.GET("/read-strategies", getReadStrategiesHandler)
+	admin.PUT("/read-strategies", setReadStrategiesHandler)
+	admin.GET("/retention", retentionReportHandler)
+	admin.GET("/tenants", listTenantsHandler)
+	admin.GET("/tenants/:tenant", getTenantHandler)
+	admin.PUT("/tenants/:tenant", setTenantHandler)
+	admin.DELETE("/tenants/:tenant", deleteTenantHandler)
+	admin.POST("/maintenance", setMaintenanceHandler)
+	admin.GET("/state-validation", stateValidationHandler)
+	admin.GET("/chaincode", chaincodeHandler)
+	admin.GET("/topology", topologyHandler)
+	admin.GET("/tls-roots", tlsRootsHandler)
+	admin.POST("/tls-roots/reload", reloadTLSRootsHandler)
+	admin.GET("/regions", regionsHandler)
+	admin.GET("/slow-queries", slowQueriesHandler)
+	admin.GET("/dealers/:dealerId/deletion", dealerDeletionPlanHandler)
+	admin.POST("/dealers/:dealerId/deletion", deleteDealerAssetsHandler)
+	admin.GET("/assets/:msisdn/merge", mergePlanHandler)
+	admin.POST("/assets/:msisdn/merge", mergeAccountsHandler)
+	admin.PUT("/dealers/:dealerId/quota", setDealerQuotaHandler)
+	admin.POST("/dealers/:dealerId/quota/recount", recountDealerHandler)
+	admin.GET("/fees", getFeeScheduleHandler)
+	admin.PUT("/fees", setFeeScheduleHandler)
+	admin.GET("/fx", getFXConfigHandler)
+	admin.PUT("/fx", setFXConfigHandler)
+	admin.PUT("/assets/:msisdn/currency", setAccountCurrencyHandler)
+	admin.POST("/assets/:msisdn/mpin/unlock", unlockPINHandler)
+	admin.GET("/mpin-policy", getPINPolicyHandler)
+	admin.PUT("/mpin-policy", setPINPolicyHandler)
+	admin.GET("/operations", adminOperationsHandler)
+	admin.GET("/policies", listPoliciesHandler)
+	admin.POST("/policies/reload", reloadPoliciesHandler)
+	admin.POST("/policies/evaluate", evaluatePolicyHandler)
+	admin.GET("/runbooks/jobs", listRunbookJobsHandler)
+	admin.GET("/runbooks/jobs/:id", getRunbookJobHandler)
+	admin.DELETE("/runbooks/jobs/:id", cancelRunbookJobHandler)
+	admin.POST("/runbooks/:action", startRunbookHandler)
+	if *sandbox && faultRoutes != nil {
+		faultRoutes(admin)
+	}
 
 	addr := os.Getenv("API_ADDR")
 	if addr == "" {
 		addr = ":8080"
 	}
-	if err := r.Run(addr); err != nil {
+	srv := &http.Server{Addr: addr, Handler: versionedPaths(r)}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(sctx); err != nil {
+			log.Print(err)
+		}
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+	<-elected
+	<-indexed
 }
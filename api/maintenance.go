@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MaintenanceState struct {
+	Enabled    bool       `json:"enabled"`
+	RetryAfter int        `json:"retryAfter"`
+	Reason     string     `json:"reason,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	OnChain    bool       `json:"onChain"`
+}
+
+var maintenance = struct {
+	sync.RWMutex
+	state MaintenanceState
+}{}
+
+func maintenanceState() MaintenanceState {
+	maintenance.RLock()
+	defer maintenance.RUnlock()
+	return maintenance.state
+}
+
+// maintenanceGuard rejects writes with 503 while maintenance mode is on.
+// Reads, /query and the admin routes keep working so operators can verify
+// and lift the freeze.
+func maintenanceGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		st := maintenanceState()
+		if !st.Enabled || c.Request.Method == "GET" || c.Request.Method == "HEAD" || c.Request.Method == "OPTIONS" ||
+			c.Request.URL.Path == "/query" || strings.HasPrefix(c.Request.URL.Path, "/admin/") {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(st.RetryAfter))
+		apiError(c, 503, ErrMaintenance, gin.H{"reason": st.Reason, "retryable": true})
+	}
+}
+
+type maintenanceRequest struct {
+	Enabled    bool   `json:"enabled"`
+	RetryAfter int    `json:"retryAfter"`
+	Reason     string `json:"reason"`
+	OnChain    bool   `json:"onChain"`
+}
+
+func getMaintenanceHandler(c *gin.Context) {
+	c.JSON(200, maintenanceState())
+}
+
+// setMaintenanceHandler toggles the API flag and, with onChain, the chaincode
+// flag checked in BeforeTransaction, which also stops writes sent by other
+// clients or API replicas.
+func setMaintenanceHandler(c *gin.Context) {
+	var req maintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.RetryAfter <= 0 {
+		req.RetryAfter = 300
+	}
+	if req.OnChain {
+		if _, err := contract.SubmitTransaction("SetMaintenance", strconv.FormatBool(req.Enabled)); err != nil {
+			fabricError(c, err)
+			return
+		}
+	}
+	st := MaintenanceState{Enabled: req.Enabled, OnChain: req.OnChain && req.Enabled}
+	if req.Enabled {
+		st.RetryAfter = req.RetryAfter
+		st.Reason = req.Reason
+		now := time.Now().UTC()
+		st.Since = &now
+	}
+	maintenance.Lock()
+	maintenance.state = st
+	maintenance.Unlock()
+	c.JSON(200, st)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type MergeRecord struct {
+	Primary   string `json:"primary" xml:"primary"`
+	Secondary string `json:"secondary" xml:"secondary"`
+	DEALERID  string `json:"DEALERID" xml:"DEALERID"`
+	Balance   int64  `json:"balance" xml:"balance"`
+	MergedAt  int64  `json:"mergedAt" xml:"mergedAt"`
+	MergedBy  string `json:"mergedBy" xml:"mergedBy"`
+	TxID      string `json:"txId" xml:"txId"`
+}
+
+// MergeLineage lists every account merged into MSISDN, oldest first.
+type MergeLineage struct {
+	MSISDN string        `json:"MSISDN" xml:"MSISDN"`
+	Merges []MergeRecord `json:"merges" xml:"merges>merge"`
+}
+
+type MergePlan struct {
+	Primary         DeletionCandidate `json:"primary"`
+	Secondary       DeletionCandidate `json:"secondary"`
+	ResultBalance   int64             `json:"resultBalance"`
+	InheritedMerges int               `json:"inheritedMerges"`
+	ConfirmToken    string            `json:"confirmToken"`
+}
+
+type mergeRequest struct {
+	Secondary    string `json:"secondary" binding:"required"`
+	ConfirmToken string `json:"confirmToken"`
+}
+
+// mergePlanHandler is the mandatory dry run: it shows what merging
+// ?secondary into :msisdn would do and the confirmToken the merge needs.
+func mergePlanHandler(c *gin.Context) {
+	secondary := c.Query("secondary")
+	if secondary == "" {
+		apiError(c, 400, ErrParamRequired, gin.H{"param": "secondary"})
+		return
+	}
+	res, err := contract.Evaluate("PlanMerge", client.WithArguments(c.Param("msisdn"), secondary))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var plan MergePlan
+	if err := json.Unmarshal(res, &plan); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, plan)
+}
+
+// mergeAccountsHandler merges the body's secondary into :msisdn. The token
+// comes from the dry run; if either account changed since, the chaincode
+// rejects it and the caller has to run the dry run again.
+func mergeAccountsHandler(c *gin.Context) {
+	var req mergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.ConfirmToken == "" {
+		apiError(c, 400, ErrConfirmTokenRequired, nil)
+		return
+	}
+	opts, ok := proposalOptions(c, c.Param("msisdn"), req.Secondary, req.ConfirmToken)
+	if !ok {
+		return
+	}
+	res, _, err := submit("MergeAccounts", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var l MergeLineage
+	if err := json.Unmarshal(res, &l); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, l)
+}
+
+func mergeLineageHandler(c *gin.Context) {
+	res, err := evaluate(c, "GetMergeLineage", client.WithArguments(c.Param("msisdn")))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var l MergeLineage
+	if err := json.Unmarshal(res, &l); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, l)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StatementLine struct {
+	Date    string `json:"date" xml:"date"`
+	Type    string `json:"type" xml:"type"`
+	Amount  int64  `json:"amount" xml:"amount"`
+	Balance int64  `json:"balanceAfter" xml:"balanceAfter"`
+	Line    string `json:"line" xml:"line"`
+}
+
+type MiniStatement struct {
+	MSISDN  string          `json:"MSISDN" xml:"MSISDN"`
+	Balance int64           `json:"balance" xml:"balance"`
+	Lines   []StatementLine `json:"lines" xml:"lines>line"`
+}
+
+// miniStatementHandler returns the last count (default 5, at most 10)
+// balance-affecting transactions. format=text answers the pre-rendered lines
+// under a balance line, ready for a USSD gateway or SMS.
+func miniStatementHandler(c *gin.Context) {
+	count, err := strconv.Atoi(c.DefaultQuery("count", "5"))
+	if err != nil || count < 1 || count > 10 {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "count", "min": 1, "max": 10})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "text" {
+		apiError(c, 400, ErrInvalidFormat, nil)
+		return
+	}
+	opts, ok := proposalOptions(c, c.Param("msisdn"), strconv.Itoa(count))
+	if !ok {
+		return
+	}
+	res, err := evaluate(c, "GetMiniStatement", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var st MiniStatement
+	if err := json.Unmarshal(res, &st); err != nil {
+		internalError(c, err)
+		return
+	}
+	if format == "json" {
+		respond(c, 200, st)
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s BAL %d\n", st.MSISDN, st.Balance)
+	for _, l := range st.Lines {
+		b.WriteString(l.Line + "\n")
+	}
+	c.String(200, b.String())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type PINPolicy struct {
+	History     int   `json:"history"`
+	MaxFailures int   `json:"maxFailures"`
+	LockSeconds int64 `json:"lockSeconds"`
+}
+
+type PINResult struct {
+	MSISDN      string `json:"MSISDN" xml:"MSISDN"`
+	Verified    bool   `json:"verified" xml:"verified"`
+	Changed     bool   `json:"changed,omitempty" xml:"changed,omitempty"`
+	Failures    int    `json:"failures" xml:"failures"`
+	Locked      bool   `json:"locked" xml:"locked"`
+	LockedUntil int64  `json:"lockedUntil,omitempty" xml:"lockedUntil,omitempty"`
+}
+
+type changePINRequest struct {
+	OldPinHash string `json:"oldPinHash"`
+	NewPinHash string `json:"newPinHash"`
+}
+
+type verifyPINRequest struct {
+	PinHash string `json:"pinHash"`
+}
+
+// pinAnswer maps a PIN check to the response: 423 while locked, 403 for a
+// wrong PIN, the result otherwise. The failure is on chain either way.
+func pinAnswer(c *gin.Context, res []byte) {
+	var r PINResult
+	if err := json.Unmarshal(res, &r); err != nil {
+		internalError(c, err)
+		return
+	}
+	switch {
+	case r.Locked:
+		apiError(c, 423, ErrPINLocked, gin.H{"msisdn": r.MSISDN, "failures": r.Failures, "lockedUntil": time.Unix(r.LockedUntil, 0).UTC().Format(time.RFC3339)})
+	case !r.Verified:
+		apiError(c, 403, ErrPINMismatch, gin.H{"msisdn": r.MSISDN, "failures": r.Failures})
+	default:
+		c.JSON(200, r)
+	}
+}
+
+// changePINHandler replaces :msisdn's MPIN under the on-chain PIN policy.
+func changePINHandler(c *gin.Context) {
+	var req changePINRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.OldPinHash == "" || req.NewPinHash == "" {
+		apiError(c, 400, ErrInvalidBody, gin.H{"detail": "oldPinHash and newPinHash are required"})
+		return
+	}
+	opts, ok := proposalOptions(c, c.Param("msisdn"), req.OldPinHash, req.NewPinHash)
+	if !ok {
+		return
+	}
+	res, _, err := submit("ChangeMPIN", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	pinAnswer(c, res)
+}
+
+// verifyPINHandler checks a PIN. It is a submit, not a read, because a wrong
+// PIN has to be counted on chain.
+func verifyPINHandler(c *gin.Context) {
+	var req verifyPINRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.PinHash == "" {
+		apiError(c, 400, ErrInvalidBody, gin.H{"detail": "pinHash is required"})
+		return
+	}
+	opts, ok := proposalOptions(c, c.Param("msisdn"), req.PinHash)
+	if !ok {
+		return
+	}
+	res, _, err := submit("VerifyMPIN", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	pinAnswer(c, res)
+}
+
+func pinStatusHandler(c *gin.Context) {
+	res, err := evaluate(c, "GetPINStatus", client.WithArguments(c.Param("msisdn")))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var r PINResult
+	if err := json.Unmarshal(res, &r); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, r)
+}
+
+func unlockPINHandler(c *gin.Context) {
+	res, _, err := submit("UnlockMPIN", client.WithArguments(c.Param("msisdn")))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var r PINResult
+	if err := json.Unmarshal(res, &r); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, r)
+}
+
+func getPINPolicyHandler(c *gin.Context) {
+	res, err := contract.Evaluate("GetPINPolicy")
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var p PINPolicy
+	if err := json.Unmarshal(res, &p); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, p)
+}
+
+// setPINPolicyHandler replaces the on-chain PIN policy; the chaincode
+// validates it.
+func setPINPolicyHandler(c *gin.Context) {
+	var p PINPolicy
+	if err := c.ShouldBindJSON(&p); err != nil {
+		bodyError(c, err)
+		return
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if _, _, err := submit("SetPINPolicy", client.WithArguments(string(b))); err != nil {
+		fabricError(c, err)
+		return
+	}
+	c.JSON(200, p)
+}
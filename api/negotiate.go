@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+const (
+	mimeMsgPack  = "application/msgpack"
+	mimeMsgPack2 = "application/x-msgpack"
+)
+
+// respond writes obj in the format the Accept header prefers among JSON,
+// XML and MessagePack, and JSON when it names none of them. MessagePack uses
+// the json field names. Signed responses are always JSON, since the
+// attestation wraps the JSON body.
+func respond(c *gin.Context, code int, obj any) {
+	if signedRequested(c) {
+		c.JSON(code, obj)
+		return
+	}
+	switch c.NegotiateFormat(gin.MIMEJSON, gin.MIMEXML, gin.MIMEXML2, mimeMsgPack, mimeMsgPack2) {
+	case gin.MIMEXML, gin.MIMEXML2:
+		if v := reflect.ValueOf(obj); v.Kind() == reflect.Slice {
+			obj = xmlList{Items: obj}
+		}
+		c.XML(code, obj)
+	case mimeMsgPack, mimeMsgPack2:
+		c.Render(code, render.MsgPack{Data: obj})
+	default:
+		c.JSON(code, obj)
+	}
+}
+
+// xmlList gives a list response the single root element XML needs.
+type xmlList struct {
+	XMLName xml.Name `xml:"list"`
+	Items   any      `xml:"item"`
+}
+
+// xmlMap is a map that encoding/xml can write, as <entry key="...">
+// elements in key order. In JSON it is a plain object.
+type xmlMap[V any] map[string]V
+
+func (m xmlMap[V]) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry := xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: k}}}
+		if err := e.EncodeElement(m[k], entry); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+)
+
+const (
+	OfflinePrepared  = "prepared"
+	OfflineEndorsed  = "endorsed"
+	OfflineSubmitted = "submitted"
+)
+
+// OfflineProposal tracks a transaction whose signatures are made by the
+// client's own key, outside the API. The proposal and transaction bytes are
+// kept here so a signature can only ever be applied to what was prepared.
+type OfflineProposal struct {
+	TxID        string    `json:"txId"`
+	MSPID       string    `json:"mspId"`
+	Certificate string    `json:"certificate"`
+	Function    string    `json:"function"`
+	State       string    `json:"state"`
+	Proposal    []byte    `json:"proposal"`
+	Transaction []byte    `json:"transaction,omitempty"`
+	BlockNumber uint64    `json:"blockNumber,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// offlineStore keeps one file per proposal in OFFLINE_DIR, plus a
+// <txId>.submitted marker created exclusively by the one submission that
+// wins. Several replicas need a shared volume.
+type offlineStore struct {
+	dir string
+	ttl time.Duration
+}
+
+var offline *offlineStore
+
+var txIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// loadOffline reads OFFLINE_DIR and OFFLINE_PROPOSAL_TTL (default 5m), the
+// window in which a prepared proposal must be endorsed and submitted.
+func loadOffline() {
+	dir := os.Getenv("OFFLINE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-api-offline")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("offline: %v", err)
+	}
+	ttl := 5 * time.Minute
+	if v := os.Getenv("OFFLINE_PROPOSAL_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("OFFLINE_PROPOSAL_TTL: must be a positive duration")
+		}
+		ttl = d
+	}
+	offline = &offlineStore{dir: dir, ttl: ttl}
+}
+
+func (st *offlineStore) path(txID string) string {
+	return filepath.Join(st.dir, txID+".json")
+}
+
+func (st *offlineStore) put(p *OfflineProposal) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	tmp := st.path(p.TxID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path(p.TxID))
+}
+
+func (st *offlineStore) get(txID string) (*OfflineProposal, error) {
+	b, err := os.ReadFile(st.path(txID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p OfflineProposal
+	return &p, json.Unmarshal(b, &p)
+}
+
+// claim reports whether this caller is the first to submit txID. The marker
+// is created with O_EXCL, so exactly one replica wins even on a shared volume.
+func (st *offlineStore) claim(txID string) (bool, error) {
+	f, err := os.OpenFile(filepath.Join(st.dir, txID+".submitted"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, f.Close()
+}
+
+// prune drops proposals, and their markers, once they have expired: an
+// unknown proposal is refused just like an expired one.
+func (st *offlineStore) prune() {
+	files, err := os.ReadDir(st.dir)
+	if err != nil {
+		log.Printf("offline: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, f := range files {
+		txID, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		p, err := st.get(txID)
+		if err != nil || p == nil || now.Before(p.ExpiresAt) {
+			continue
+		}
+		os.Remove(st.path(txID))
+		os.Remove(filepath.Join(st.dir, txID+".submitted"))
+	}
+}
+
+// runOfflinePrune prunes expired proposals every minute until ctx is
+// cancelled. It runs on the leader.
+func runOfflinePrune(ctx context.Context) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			offline.prune()
+		}
+	}
+}
+
+// load fetches :txId and checks it is in state and still inside its window.
+func (st *offlineStore) load(c *gin.Context, state string) (*OfflineProposal, bool) {
+	txID := c.Param("txId")
+	if !txIDPattern.MatchString(txID) {
+		apiError(c, 404, ErrNotFound, gin.H{})
+		return nil, false
+	}
+	p, err := st.get(txID)
+	if err != nil {
+		internalError(c, err)
+		return nil, false
+	}
+	if p == nil {
+		apiError(c, 404, ErrNotFound, gin.H{})
+		return nil, false
+	}
+	if !time.Now().Before(p.ExpiresAt) {
+		apiError(c, 410, ErrProposalExpired, gin.H{"txId": p.TxID, "expiresAt": p.ExpiresAt.Format(time.RFC3339)})
+		return nil, false
+	}
+	if p.State != state {
+		apiError(c, 409, ErrProposalState, gin.H{"txId": p.TxID, "state": p.State})
+		return nil, false
+	}
+	return p, true
+}
+
+// signedBy reports whether sig is a signature over the SHA-256 digest of
+// message by the key of the certificate p was prepared for, as peers and
+// orderers check it: ECDSA signatures must be low-S. gw hands out messages
+// rather than digests (see connectOptions), so the digest is taken here.
+func (p *OfflineProposal) signedBy(message, sig []byte) bool {
+	cert, err := identity.CertificateFromPEM([]byte(p.Certificate))
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(message)
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) > 0 || rs.S == nil {
+			return false
+		}
+		if rs.S.Cmp(new(big.Int).Rsh(pub.Curve.Params().N, 1)) > 0 {
+			return false
+		}
+		return ecdsa.VerifyASN1(pub, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, digest[:], sig)
+	}
+	return false
+}
+
+type offlinePrepareRequest struct {
+	PassthroughRequest
+	MSPID       string `json:"mspId" binding:"required"`
+	Certificate string `json:"certificate" binding:"required"`
+}
+
+type offlineSignRequest struct {
+	Signature []byte `json:"signature" binding:"required"`
+}
+
+// prepareOfflineHandler builds an unsigned proposal for the body's identity
+// and returns its bytes and the digest the client must sign.
+func prepareOfflineHandler(c *gin.Context) {
+	var req offlinePrepareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if !functionAllowed(c, principal(c), req.Function) {
+		apiError(c, 403, ErrFunctionNotAllowed, gin.H{"function": req.Function})
+		return
+	}
+	if !checkAccountStatus(c, req.Function, req.Args) || !screenMonetary(c, req.Function, req.Args) {
+		return
+	}
+	cert, err := identity.CertificateFromPEM([]byte(req.Certificate))
+	if err != nil {
+		apiError(c, 400, ErrInvalidCertificate, gin.H{})
+		return
+	}
+	id, err := identity.NewX509Identity(req.MSPID, cert)
+	if err != nil {
+		apiError(c, 400, ErrInvalidCertificate, gin.H{})
+		return
+	}
+	// No signer: the client signs the digest itself.
+	cgw, err := client.Connect(id, client.WithClientConnection(peerConn))
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	defer cgw.Close()
+	proposal, err := cgw.GetNetwork(network.Name()).GetContract(contract.ChaincodeName()).NewProposal(req.Function, append(endorsementOptions(req.Function), req.options(c)...)...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	b, err := proposal.Bytes()
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	now := time.Now().UTC()
+	p := &OfflineProposal{TxID: proposal.TransactionID(), MSPID: req.MSPID, Certificate: req.Certificate, Function: req.Function, State: OfflinePrepared, Proposal: b, CreatedAt: now, ExpiresAt: now.Add(offline.ttl)}
+	if err := offline.put(p); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(201, gin.H{"txId": p.TxID, "proposal": b, "digest": proposal.Digest(), "expiresAt": p.ExpiresAt})
+}
+
+// endorseOfflineHandler endorses the stored proposal with the client's
+// signature over its digest and returns the transaction to sign next.
+func endorseOfflineHandler(c *gin.Context) {
+	var req offlineSignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	p, ok := offline.load(c, OfflinePrepared)
+	if !ok {
+		return
+	}
+	proposal, err := gw.NewSignedProposal(p.Proposal, req.Signature)
+	if err != nil || !p.signedBy(proposal.Digest(), req.Signature) {
+		apiError(c, 400, ErrInvalidBody, gin.H{})
+		return
+	}
+	tx, err := proposal.Endorse()
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	b, err := tx.Bytes()
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	p.State, p.Transaction = OfflineEndorsed, b
+	if err := offline.put(p); err != nil {
+		internalError(c, err)
+		return
+	}
+	// gw's Digest is the payload itself; clients sign its SHA-256.
+	digest := sha256.Sum256(tx.Digest())
+	c.JSON(200, gin.H{"txId": p.TxID, "transaction": b, "digest": digest[:], "result": resultJSON(tx.Result()), "expiresAt": p.ExpiresAt})
+}
+
+// submitOfflineHandler submits the endorsed transaction with the client's
+// signature. The signature is checked first; then only the first request for
+// a proposal gets further, and a replayed or concurrent one is refused even
+// when the first one failed.
+func submitOfflineHandler(c *gin.Context) {
+	var req offlineSignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	p, ok := offline.load(c, OfflineEndorsed)
+	if !ok {
+		return
+	}
+	// A bad signature must not use up the one submission.
+	tx, err := gw.NewSignedTransaction(p.Transaction, req.Signature)
+	if err != nil || !p.signedBy(tx.Digest(), req.Signature) {
+		apiError(c, 400, ErrInvalidBody, gin.H{})
+		return
+	}
+	won, err := offline.claim(p.TxID)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if !won {
+		apiError(c, 409, ErrProposalState, gin.H{"txId": p.TxID, "state": OfflineSubmitted})
+		return
+	}
+	p.State = OfflineSubmitted
+	if err := offline.put(p); err != nil {
+		internalError(c, err)
+		return
+	}
+	st, err := commitTransaction(tx)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	if !st.Successful {
+		fabricError(c, &client.CommitError{TransactionID: st.TransactionID, Code: st.Code})
+		return
+	}
+	p.BlockNumber = st.BlockNumber
+	if err := offline.put(p); err != nil {
+		log.Printf("offline: %v", err)
+	}
+	c.JSON(200, gin.H{"txId": st.TransactionID, "blockNumber": st.BlockNumber, "result": resultJSON(tx.Result())})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	keyOptional = "optional"
+	keyRequired = "required"
+)
+
+// routeDoc describes a route for the OpenAPI document. key says whether an
+// API key is needed; roles, if any, are the roles requireRole lets through.
+type routeDoc struct {
+	summary string
+	key     string
+	roles   []string
+}
+
+// routeDocs must follow the routes in main.go. Routes missing here are still
+// listed, without a summary, and /admin routes always require the admin role.
+var routeDocs = map[string]routeDoc{
+	"GET /health":                                 {summary: "Liveness"},
+	"GET /readyz":                                 {summary: "Readiness"},
+	"GET /public/assets/:msisdn/status":           {summary: "Whether an account exists and its STATUS, without an API key"},
+	"GET /metrics":                                {summary: "Prometheus metrics"},
+	"GET /openapi.json":                           {summary: "This document"},
+	"GET /docs/try-it":                            {summary: "Interactive documentation"},
+	"GET /assets":                                 {summary: "List accounts; dealer keys only see their own", key: keyOptional},
+	"GET /assets/changes":                         {summary: "Changed accounts since a block", key: keyOptional},
+	"GET /sync":                                   {summary: "Delta sync", key: keyOptional},
+	"GET /events":                                 {summary: "Chaincode event stream; dealer keys only their own dealer's events", key: keyRequired},
+	"GET /ws/blocks":                              {summary: "Block stream over WebSocket; type=full for admin keys only", key: keyRequired},
+	"GET /assets/:msisdn":                         {summary: "Read an account; dealer keys only their own. ?expand=history,audit embeds both", key: keyOptional},
+	"GET /assets/:msisdn/history":                 {summary: "Account history", key: keyOptional},
+	"GET /assets/:msisdn/recent-transactions":     {summary: "Recent transactions", key: keyOptional},
+	"GET /assets/:msisdn/ministatement":           {summary: "Mini statement", key: keyOptional},
+	"GET /assets/:msisdn/analytics":               {summary: "Spending analytics", key: keyOptional},
+	"GET /assets/:msisdn/daily-summaries":         {summary: "Daily transaction totals", key: keyOptional},
+	"POST /operations/transfers":                  {summary: "Start a transfer saga", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"GET /api/v2/assets/:msisdn/history":          {summary: "Account history, version 2: nanosecond timestamps, block, submitter and delete reason on every entry", key: keyOptional},
+	"GET /operations/:id":                         {summary: "Operation status"},
+	"POST /assets/:msisdn/transfer":               {summary: "Transfer funds", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"POST /assets/:msisdn/debit":                  {summary: "Debit an account", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"GET /assets/:msisdn/holds":                   {summary: "Active holds and available balance", key: keyOptional},
+	"POST /assets/:msisdn/holds":                  {summary: "Hold funds until captured or released", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"POST /assets/:msisdn/holds/:ref/release":     {summary: "Release a hold", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"POST /assets/:msisdn/holds/:ref/capture":     {summary: "Capture a hold", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"POST /assets/:msisdn/close":                  {summary: "Close an account", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"GET /assets/:msisdn/closure":                 {summary: "How an account was closed", key: keyOptional},
+	"GET /assets/:msisdn/merges":                  {summary: "Accounts merged into an account", key: keyOptional},
+	"POST /assets/:msisdn/mpin":                   {summary: "Change the MPIN", key: keyRequired, roles: []string{RoleAdmin, RoleOperator, RoleDealer}},
+	"POST /assets/:msisdn/mpin/verify":            {summary: "Verify an MPIN", key: keyRequired, roles: []string{RoleAdmin, RoleOperator, RoleDealer}},
+	"GET /assets/:msisdn/mpin":                    {summary: "MPIN failures and lock", key: keyOptional},
+	"GET /receipts/:receipt":                      {summary: "Look up a receipt; dealer keys only their own accounts' receipts", key: keyRequired},
+	"GET /transactions/:txId/origin":              {summary: "Request context of a transaction"},
+	"GET /fees/quote":                             {summary: "Fee quote"},
+	"GET /assets/:msisdn/history/export":          {summary: "Export history as CSV", key: keyOptional},
+	"GET /assets/:msisdn/balance-proof":           {summary: "Balance proof", key: keyOptional},
+	"GET /assets/:msisdn/subaccounts":             {summary: "List sub-accounts", key: keyOptional},
+	"POST /assets/:msisdn/subaccounts":            {summary: "Create a sub-account", key: keyOptional},
+	"POST /assets":                                {summary: "Create an account; dealer keys only for their own dealer", key: keyRequired, roles: []string{RoleAdmin, RoleOperator, RoleDealer}},
+	"PUT /assets/:msisdn":                         {summary: "Update an account; dealer keys only their own", key: keyRequired, roles: []string{RoleAdmin, RoleOperator, RoleDealer}},
+	"DELETE /assets/:msisdn":                      {summary: "Delete an account; dealer keys only their own", key: keyRequired, roles: []string{RoleAdmin, RoleOperator, RoleDealer}},
+	"POST /invoke":                                {summary: "Submit any chaincode function the role's policy allows", key: keyRequired},
+	"GET /submissions/:key":                       {summary: "Status of an /invoke made with an Idempotency-Key", key: keyRequired},
+	"POST /query":                                 {summary: "Evaluate any chaincode function the role's policy allows", key: keyRequired},
+	"POST /offline/proposals":                     {summary: "Prepare a proposal for offline signing", key: keyRequired},
+	"POST /offline/proposals/:txId/endorsement":   {summary: "Endorse a signed offline proposal", key: keyRequired},
+	"POST /offline/proposals/:txId/submission":    {summary: "Submit a signed offline transaction", key: keyRequired},
+	"GET /assets/deleted":                         {summary: "Deleted accounts", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"GET /dealers/:dealerId/quota":                {summary: "Dealer account quota and usage", key: keyRequired, roles: []string{RoleAdmin, RoleOperator, RoleDealer}},
+	"GET /dealers/:dealerId/assets.ndjson":        {summary: "Stream a dealer's accounts as NDJSON", key: keyRequired, roles: []string{RoleAdmin, RoleOperator, RoleDealer}},
+	"GET /settlements/:date/:dealerId":            {summary: "A dealer's settlement file for a day (fixed format or camt XML)", key: keyRequired, roles: []string{RoleAdmin, RoleOperator, RoleDealer}},
+	"GET /dashboard":                              {summary: "Operations dashboard", key: keyRequired, roles: []string{RoleAdmin, RoleOperator}},
+	"GET /admin/maintenance":                      {summary: "Maintenance mode"},
+	"POST /admin/maintenance":                     {summary: "Set maintenance mode"},
+	"GET /admin/read-strategies":                  {summary: "Read strategy per endpoint and change index lag"},
+	"PUT /admin/read-strategies":                  {summary: "Set read strategies on this replica"},
+	"GET /admin/tenants":                          {summary: "Tenant configuration overlays"},
+	"GET /admin/tenants/:tenant":                  {summary: "A tenant's configuration overlay"},
+	"PUT /admin/tenants/:tenant":                  {summary: "Create or replace a tenant's overlay"},
+	"DELETE /admin/tenants/:tenant":               {summary: "Delete a tenant's overlay"},
+	"GET /admin/retention":                        {summary: "Retention dry run: what the janitor would purge now"},
+	"GET /admin/state-validation":                 {summary: "Validate world state"},
+	"GET /admin/chaincode":                        {summary: "Chaincode definition"},
+	"GET /admin/topology":                         {summary: "Discovered network topology"},
+	"GET /admin/tls-roots":                        {summary: "CAs trusted for peer TLS"},
+	"POST /admin/tls-roots/reload":                {summary: "Reload TLS_CERT_PATH now"},
+	"GET /admin/regions":                          {summary: "Read regions"},
+	"GET /admin/slow-queries":                     {summary: "Slow gateway calls"},
+	"GET /admin/dealers/:dealerId/deletion":       {summary: "Plan a dealer's account deletion"},
+	"POST /admin/dealers/:dealerId/deletion":      {summary: "Delete a dealer's accounts"},
+	"GET /admin/assets/:msisdn/merge":             {summary: "Plan merging ?secondary into an account"},
+	"POST /admin/assets/:msisdn/merge":            {summary: "Merge an account into another"},
+	"PUT /admin/dealers/:dealerId/quota":          {summary: "Set a dealer's account quota"},
+	"POST /admin/dealers/:dealerId/quota/recount": {summary: "Recount a dealer's accounts"},
+	"GET /admin/fees":                             {summary: "Fee schedule"},
+	"GET /admin/fx":                               {summary: "FX configuration"},
+	"PUT /admin/fx":                               {summary: "Set the FX configuration"},
+	"PUT /admin/assets/:msisdn/currency":          {summary: "Set the currency an empty account holds"},
+	"PUT /admin/fees":                             {summary: "Set the fee schedule"},
+	"POST /admin/assets/:msisdn/mpin/unlock":      {summary: "Unlock an MPIN"},
+	"GET /admin/mpin-policy":                      {summary: "MPIN policy"},
+	"PUT /admin/mpin-policy":                      {summary: "Set the MPIN policy"},
+	"GET /admin/operations":                       {summary: "Admin operation log"},
+	"GET /admin/policies":                         {summary: "Authorization policy bundles in force"},
+	"POST /admin/policies/reload":                 {summary: "Reload POLICY_PATH now"},
+	"POST /admin/policies/evaluate":               {summary: "Dry-run a request against the policies"},
+	"GET /admin/runbooks/jobs":                    {summary: "Runbook jobs, newest first"},
+	"GET /admin/runbooks/jobs/:id":                {summary: "A runbook job and its progress"},
+	"DELETE /admin/runbooks/jobs/:id":             {summary: "Cancel a runbook job"},
+	"POST /admin/runbooks/:action":                {summary: "Start index-resync, dealer-index-rebuild or outbox-redrive, as a dry run unless dryRun is false"},
+	"GET /admin/faults":                           {summary: "Injected sandbox faults"},
+	"POST /admin/faults":                          {summary: "Inject a sandbox fault"},
+	"DELETE /admin/faults":                        {summary: "Remove all sandbox faults"},
+	"DELETE /admin/faults/:id":                    {summary: "Remove a sandbox fault"},
+}
+
+var allRoles = []string{RoleViewer, RoleDealer, RoleOperator, RoleAdmin}
+
+// knownRole answers 400 INVALID_ROLE unless role is a known role.
+func knownRole(c *gin.Context, role string) bool {
+	for _, r := range allRoles {
+		if r == role {
+			return true
+		}
+	}
+	apiError(c, 400, ErrInvalidRole, gin.H{"param": "role", "roles": strings.Join(allRoles, ", ")})
+	return false
+}
+
+func docFor(method, path string) routeDoc {
+	d := routeDocs[method+" "+path]
+	if strings.HasPrefix(path, "/admin/") {
+		d.key, d.roles = keyRequired, []string{RoleAdmin}
+	}
+	return d
+}
+
+// allows reports whether role may call the route. Pass-through routes are
+// further limited by the role's function policy.
+func (d routeDoc) allows(role string) bool {
+	if d.key != keyRequired || len(d.roles) == 0 {
+		return true
+	}
+	for _, r := range d.roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+var tryIt struct {
+	key string
+}
+
+// loadDocs reads TRY_IT_API_KEY, the key /docs/try-it fills in for callers.
+// It should be a viewer key, since anyone who opens the page can read it.
+func loadDocs() {
+	tryIt.key = os.Getenv("TRY_IT_API_KEY")
+	if tryIt.key == "" {
+		return
+	}
+	p, ok := principalForKey(tryIt.key)
+	if !ok {
+		log.Fatalf("TRY_IT_API_KEY: not a key in AUTH_CONFIG")
+	}
+	if p.Role != RoleViewer {
+		log.Printf("TRY_IT_API_KEY has role %s, which is published on /docs/try-it", p.Role)
+	}
+}
+
+// openAPIHandler generates an OpenAPI 3 document from the registered routes.
+// Each operation names its security and x-required-roles. With ?role= only
+// the operations that role may call are listed.
+func openAPIHandler(r *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.Query("role")
+		if role != "" && !knownRole(c, role) {
+			return
+		}
+		paths := gin.H{}
+		for _, rt := range r.Routes() {
+			d := docFor(rt.Method, rt.Path)
+			if role != "" && !d.allows(role) {
+				continue
+			}
+			op := gin.H{"summary": d.summary, "responses": gin.H{"default": gin.H{"$ref": "#/components/responses/Error"}}}
+			var params []gin.H
+			var segs []string
+			for _, s := range strings.Split(rt.Path, "/") {
+				if strings.HasPrefix(s, ":") {
+					params = append(params, gin.H{"name": s[1:], "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+					s = "{" + s[1:] + "}"
+				}
+				segs = append(segs, s)
+			}
+			if params != nil {
+				op["parameters"] = params
+			}
+			switch d.key {
+			case keyRequired:
+				op["security"] = []gin.H{{"apiKey": []string{}}, {"bearer": []string{}}}
+				roles := d.roles
+				if roles == nil {
+					roles = []string{}
+				}
+				op["x-required-roles"] = roles
+			case keyOptional:
+				op["security"] = []gin.H{{}, {"apiKey": []string{}}, {"bearer": []string{}}}
+			default:
+				op["security"] = []gin.H{}
+			}
+			if d, ok := deprecations[rt.Method+" "+rt.Path]; ok {
+				op["x-deprecated-fields"] = d.fields
+			}
+			if role != "" && (rt.Path == "/invoke" || rt.Path == "/query") {
+				op["x-allowed-functions"] = auth.functions[role]
+			}
+			path := strings.Join(segs, "/")
+			ops, _ := paths[path].(gin.H)
+			if ops == nil {
+				ops = gin.H{}
+				paths[path] = ops
+			}
+			ops[strings.ToLower(rt.Method)] = op
+		}
+		c.JSON(200, gin.H{
+			"openapi": "3.0.3",
+			"info":    gin.H{"title": "Fabric API", "version": strconv.Itoa(apiVersion)},
+			"paths":   paths,
+			"components": gin.H{
+				"securitySchemes": gin.H{
+					"apiKey": gin.H{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+					"bearer": gin.H{"type": "http", "scheme": "bearer"},
+				},
+				"responses": gin.H{
+					"Error": gin.H{"description": "Error", "content": gin.H{"application/json": gin.H{"schema": gin.H{"type": "object", "properties": gin.H{
+						"code":    gin.H{"type": "string"},
+						"message": gin.H{"type": "string"},
+						"params":  gin.H{"type": "object"},
+					}}}}},
+				},
+			},
+		})
+	}
+}
+
+var tryItPage = template.Must(template.New("try-it").Parse(`<!doctype html>
+<html>
+<head>
+<title>Fabric API ({{.Role}})</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<p>Operations callable with the {{.Role}} role. Other roles: {{range .Roles}}<a href="?role={{.}}">{{.}}</a> {{end}}</p>
+<div id="ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+const ui = SwaggerUIBundle({url: {{.Spec}}, dom_id: "#ui", persistAuthorization: true});
+{{if .Key}}ui.preauthorizeApiKey("apiKey", {{.Key}});{{end}}
+</script>
+</body>
+</html>
+`))
+
+// tryItHandler serves Swagger UI over the document for ?role= (default
+// viewer), signed in with TRY_IT_API_KEY when it is set.
+func tryItHandler(c *gin.Context) {
+	role := c.DefaultQuery("role", RoleViewer)
+	if !knownRole(c, role) {
+		return
+	}
+	key := ""
+	if p, ok := principalForKey(tryIt.key); ok && p.Role == role {
+		key = tryIt.key
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(200)
+	tryItPage.Execute(c.Writer, gin.H{"Role": role, "Roles": allRoles, "Spec": "/openapi.json?role=" + role, "Key": key})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	requestIDHeader     = "X-Request-ID"
+	clientAppHeader     = "X-Client-App"
+	originChannelHeader = "X-Origin-Channel"
+	requestContextKey   = "requestContext"
+)
+
+// RequestContext is what the chaincode learns about the request behind a
+// proposal. It is sent as transient data, so none of it is in the blocks;
+// the chaincode keeps RequestID, AppID and Channel with a fingerprint of the
+// whole, client address and user agent included.
+type RequestContext struct {
+	RequestID string `json:"requestId" xml:"requestId"`
+	AppID     string `json:"appId,omitempty" xml:"appId,omitempty"`
+	Channel   string `json:"channel,omitempty" xml:"channel,omitempty"`
+	ClientIP  string `json:"clientIp,omitempty" xml:"clientIp,omitempty"`
+	UserAgent string `json:"userAgent,omitempty" xml:"userAgent,omitempty"`
+}
+
+// Origin is the chaincode's record of a transaction's request context.
+type Origin struct {
+	RequestID   string `json:"requestId" xml:"requestId"`
+	AppID       string `json:"appId,omitempty" xml:"appId,omitempty"`
+	Channel     string `json:"channel,omitempty" xml:"channel,omitempty"`
+	Fingerprint string `json:"fingerprint" xml:"fingerprint"`
+}
+
+var originChannels = map[string]bool{"USSD": true, "APP": true, "WEB": true}
+
+func loadOrigins() {
+	v := os.Getenv("ORIGIN_CHANNELS")
+	if v == "" {
+		return
+	}
+	originChannels = map[string]bool{}
+	for _, ch := range strings.Split(v, ",") {
+		ch = strings.ToUpper(strings.TrimSpace(ch))
+		if ch == "" || len(ch) > 32 {
+			log.Fatalf("ORIGIN_CHANNELS: bad channel %q", ch)
+		}
+		originChannels[ch] = true
+	}
+}
+
+// requestContext reads X-Request-ID, X-Client-App and X-Origin-Channel,
+// generating a request ID when none is given, and echoes the request ID on
+// the response.
+func requestContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc := &RequestContext{RequestID: c.GetHeader(requestIDHeader), AppID: c.GetHeader(clientAppHeader), Channel: strings.ToUpper(c.GetHeader(originChannelHeader)), ClientIP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+		if rc.RequestID == "" {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				internalError(c, err)
+				return
+			}
+			rc.RequestID = hex.EncodeToString(b)
+		}
+		c.Header(requestIDHeader, rc.RequestID)
+		switch {
+		case !sagaID.MatchString(rc.RequestID):
+			apiError(c, 400, ErrInvalidOrigin, gin.H{"header": requestIDHeader})
+			return
+		case len(rc.AppID) > 64:
+			apiError(c, 400, ErrInvalidOrigin, gin.H{"header": clientAppHeader})
+			return
+		case rc.Channel != "" && !originChannels[rc.Channel]:
+			allowed := make([]string, 0, len(originChannels))
+			for ch := range originChannels {
+				allowed = append(allowed, ch)
+			}
+			apiError(c, 400, ErrInvalidOrigin, gin.H{"header": originChannelHeader, "allowed": allowed})
+			return
+		}
+		if len(rc.UserAgent) > 256 {
+			rc.UserAgent = rc.UserAgent[:256]
+		}
+		c.Set(requestContextKey, rc)
+		c.Next()
+	}
+}
+
+func currentRequestContext(c *gin.Context) *RequestContext {
+	v, _ := c.Get(requestContextKey)
+	rc, _ := v.(*RequestContext)
+	return rc
+}
+
+// addRequestContext puts rc into a proposal's transient data, where the
+// chaincode looks for it.
+func addRequestContext(transient map[string][]byte, rc *RequestContext) {
+	if rc == nil {
+		return
+	}
+	if b, err := json.Marshal(rc); err == nil {
+		transient["requestContext"] = b
+	}
+}
+
+// originHandler returns the request context recorded for :txId.
+func originHandler(c *gin.Context) {
+	res, err := evaluate(c, "GetOrigin", client.WithArguments(c.Param("txId")))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var o Origin
+	if err := json.Unmarshal(res, &o); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, o)
+}
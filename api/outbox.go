@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// OutboxEntry is a notification promised for a transaction before it was
+// sent to the orderer. It is delivered only once the transaction is seen
+// committed, and removed once the webhook accepts it.
+type OutboxEntry struct {
+	TxID        string          `json:"txId"`
+	Function    string          `json:"function"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Confirmed   bool            `json:"confirmed"`
+	BlockNumber uint64          `json:"blockNumber,omitempty"`
+	EventName   string          `json:"eventName,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+}
+
+// outboxStore keeps one file per transaction in dir. Every replica writes to
+// it and the leader delivers from it, so with several replicas dir must be a
+// shared volume.
+type outboxStore struct {
+	dir     string
+	webhook string
+	timeout time.Duration
+	http    *http.Client
+	mu      sync.Mutex
+}
+
+// outbox is nil unless OUTBOX_WEBHOOK_URL is set; its methods are no-ops then.
+var outbox *outboxStore
+
+const maxOutboxBackoff = 5 * time.Minute
+
+func loadOutbox() {
+	url := os.Getenv("OUTBOX_WEBHOOK_URL")
+	if url == "" {
+		return
+	}
+	dir := os.Getenv("OUTBOX_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-api-outbox")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("outbox: %v", err)
+	}
+	timeout := 10 * time.Minute
+	if v := os.Getenv("OUTBOX_CONFIRM_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("OUTBOX_CONFIRM_TIMEOUT: %v", err)
+		}
+		timeout = d
+	}
+	outbox = &outboxStore{dir: dir, webhook: url, timeout: timeout, http: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (o *outboxStore) path(txID string) string {
+	return filepath.Join(o.dir, txID+".json")
+}
+
+// put writes e through a rename so a crash never leaves half an entry.
+func (o *outboxStore) put(e *OutboxEntry) error {
+	if o == nil {
+		return nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp := o.path(e.TxID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path(e.TxID))
+}
+
+func (o *outboxStore) get(txID string) (*OutboxEntry, error) {
+	b, err := os.ReadFile(o.path(txID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e OutboxEntry
+	return &e, json.Unmarshal(b, &e)
+}
+
+func (o *outboxStore) drop(txID string) {
+	if o == nil {
+		return
+	}
+	if err := os.Remove(o.path(txID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("outbox: %v", err)
+	}
+}
+
+// list returns every entry, committed ones in block order first.
+func (o *outboxStore) list() ([]*OutboxEntry, error) {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*OutboxEntry
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		e, err := o.get(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Confirmed != out[j].Confirmed {
+			return out[i].Confirmed
+		}
+		if out[i].BlockNumber != out[j].BlockNumber {
+			return out[i].BlockNumber < out[j].BlockNumber
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+// confirm marks the entry for txID, if there is one, as committed.
+func (o *outboxStore) confirm(txID string, block uint64, eventName string, payload []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, err := o.get(txID)
+	if err != nil || e == nil || e.Confirmed {
+		return err
+	}
+	e.Confirmed = true
+	e.BlockNumber = block
+	e.EventName = eventName
+	if json.Valid(payload) {
+		e.Payload = payload
+	}
+	return o.put(e)
+}
+
+func confirmOutboxEvent(ev *client.ChaincodeEvent) {
+	if outbox == nil {
+		return
+	}
+	if err := outbox.confirm(ev.TransactionID, ev.BlockNumber, ev.EventName, ev.Payload); err != nil {
+		log.Printf("outbox confirm %s: %v", ev.TransactionID, err)
+	}
+}
+
+// submit endorses fn, submits it and waits for the commit. With an outbox
+// configured the notification is recorded under the transaction ID before
+// the transaction can reach the orderer, and dropped again only when it is
+// certain not to commit.
+func submit(fn string, opts ...client.ProposalOption) ([]byte, *client.Status, error) {
+	proposal, err := contract.NewProposal(fn, append(endorsementOptions(fn), opts...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := submits.acquire(fn); err != nil {
+		return nil, nil, err
+	}
+	defer submits.release()
+	txID := proposal.TransactionID()
+	if err := outbox.put(&OutboxEntry{TxID: txID, Function: fn, CreatedAt: time.Now()}); err != nil {
+		return nil, nil, err
+	}
+	tx, err := proposal.Endorse()
+	if err != nil {
+		outbox.drop(txID)
+		if failedCall(err) {
+			recordWrite(false)
+		}
+		return nil, nil, err
+	}
+	// From here the transaction may commit even if we see an error, so the
+	// entry stays until it is confirmed or found missing.
+	st, err := commitTransaction(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !st.Successful {
+		outbox.drop(txID)
+		return nil, st, &client.CommitError{TransactionID: st.TransactionID, Code: st.Code}
+	}
+	return tx.Result(), st, nil
+}
+
+// commitTransaction submits an endorsed transaction and waits for its commit
+// status, recording the write and its commit latency for the SLIs.
+func commitTransaction(tx *client.Transaction) (*client.Status, error) {
+	start := time.Now()
+	commit, err := tx.Submit()
+	if err != nil {
+		recordWrite(false)
+		return nil, err
+	}
+	st, err := commit.Status()
+	if err != nil {
+		recordWrite(false)
+		return nil, err
+	}
+	recordCommit(time.Since(start))
+	recordWrite(st.Successful)
+	return st, nil
+}
+
+// runOutbox delivers confirmed entries until ctx is cancelled. It runs on the
+// leader next to processEvents, which confirms entries from commit events.
+func runOutbox(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		entries, err := outbox.list()
+		if err != nil {
+			log.Printf("outbox: %v", err)
+			continue
+		}
+		for _, e := range entries {
+			if ctx.Err() != nil {
+				return
+			}
+			if e.Confirmed {
+				outbox.deliverDue(ctx, e)
+			} else if time.Since(e.CreatedAt) > outbox.timeout {
+				outbox.resolve(e)
+			}
+		}
+	}
+}
+
+// resolve settles an entry whose commit event never arrived, for example
+// because it committed while no replica held the lease, by asking the ledger.
+func (o *outboxStore) resolve(e *OutboxEntry) {
+	info, err := transactionInfo(e.TxID)
+	if err != nil {
+		if classify(err).Retryable {
+			return
+		}
+		log.Printf("outbox: dropping %s, not on the ledger: %v", e.TxID, err)
+		o.drop(e.TxID)
+		return
+	}
+	if !info.Valid {
+		o.drop(e.TxID)
+		return
+	}
+	block, err := blockOfTx(e.TxID)
+	if err != nil {
+		return
+	}
+	if err := o.confirm(e.TxID, block, "", nil); err != nil {
+		log.Printf("outbox confirm %s: %v", e.TxID, err)
+	}
+}
+
+func (o *outboxStore) deliverDue(ctx context.Context, e *OutboxEntry) {
+	if time.Now().Before(e.NextAttempt) {
+		return
+	}
+	err := o.deliver(ctx, e)
+	if err == nil {
+		o.drop(e.TxID)
+		return
+	}
+	e.Attempts++
+	backoff := time.Second << min(e.Attempts, 9)
+	if backoff > maxOutboxBackoff {
+		backoff = maxOutboxBackoff
+	}
+	e.NextAttempt = time.Now().Add(backoff)
+	log.Printf("outbox deliver %s (attempt %d): %v", e.TxID, e.Attempts, err)
+	if err := o.put(e); err != nil {
+		log.Printf("outbox: %v", err)
+	}
+}
+
+// deliver posts e to the webhook in OUTBOX_FORMAT. The transaction ID is
+// sent as the Idempotency-Key, and is the CloudEvents id, so a receiver that
+// dedupes on it sees each commit exactly once even if we crash between
+// delivering and dropping the entry.
+func (o *outboxStore) deliver(ctx context.Context, e *OutboxEntry) error {
+	ev := newCloudEvent(e.TxID, e.Function, e.EventName, e.BlockNumber, e.Payload)
+	contentType := "application/json"
+	var b []byte
+	var err error
+	switch outboxFormat {
+	case formatCloudEvents:
+		b, err = json.Marshal(ev)
+		contentType = cloudEventsContentType
+	case formatCloudEventsBinary:
+		b = ev.Data
+	default:
+		b, err = json.Marshal(gin.H{"txId": e.TxID, "function": e.Function, "blockNumber": e.BlockNumber, "eventName": e.EventName, "payload": e.Payload})
+	}
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.webhook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if outboxFormat == formatCloudEventsBinary {
+		ev.setBinaryHeaders(req.Header)
+	}
+	req.Header.Set("Idempotency-Key", e.TxID)
+	res, err := o.http.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
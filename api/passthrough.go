@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type PassthroughRequest struct {
+	Function  string            `json:"function" binding:"required"`
+	Args      []string          `json:"args"`
+	Transient map[string]string `json:"transient"`
+
+	endorsingOrgs []string
+}
+
+// options builds the proposal from the request. The request context and
+// dealer scope are the API's own, whatever transient the caller sent under
+// those names.
+func (r *PassthroughRequest) options(c *gin.Context) []client.ProposalOption {
+	opts := []client.ProposalOption{client.WithArguments(r.Args...)}
+	t := make(map[string][]byte, len(r.Transient)+1)
+	for k, v := range r.Transient {
+		t[k] = []byte(v)
+	}
+	addRequestContext(t, currentRequestContext(c))
+	addDealerScope(t, principal(c))
+	if len(t) > 0 {
+		opts = append(opts, client.WithTransient(t))
+	}
+	if r.endorsingOrgs != nil {
+		opts = append(opts, client.WithEndorsingOrganizations(r.endorsingOrgs...))
+	}
+	return opts
+}
+
+// resultJSON returns chaincode output as embedded JSON when it is JSON and as
+// a string otherwise.
+func resultJSON(res []byte) any {
+	if len(res) == 0 {
+		return nil
+	}
+	if json.Valid(res) {
+		return json.RawMessage(res)
+	}
+	return string(res)
+}
+
+func bindPassthrough(c *gin.Context) (*PassthroughRequest, bool) {
+	var req PassthroughRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return nil, false
+	}
+	if !functionAllowed(c, principal(c), req.Function) {
+		apiError(c, 403, ErrFunctionNotAllowed, gin.H{"function": req.Function})
+		return nil, false
+	}
+	orgs, ok := endorsingOverride(c)
+	if !ok {
+		return nil, false
+	}
+	req.endorsingOrgs = orgs
+	return &req, true
+}
+
+func invokeHandler(c *gin.Context) {
+	req, ok := bindPassthrough(c)
+	if !ok {
+		return
+	}
+	if key := c.GetHeader(idempotencyKeyHeader); key != "" {
+		idempotentInvoke(c, req, key)
+		return
+	}
+	if !checkAccountStatus(c, req.Function, req.Args) || !screenMonetary(c, req.Function, req.Args) {
+		return
+	}
+	res, st, err := submit(req.Function, req.options(c)...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"txId": st.TransactionID, "blockNumber": st.BlockNumber, "result": resultJSON(res)})
+}
+
+// submitOnly are functions /query refuses whatever the role's policy says:
+// evaluated, a wrong PIN would not be counted and could be guessed again
+// without limit.
+var submitOnly = map[string]bool{"VerifyMPIN": true, "ChangeMPIN": true}
+
+func queryHandler(c *gin.Context) {
+	req, ok := bindPassthrough(c)
+	if !ok {
+		return
+	}
+	if submitOnly[req.Function] {
+		apiError(c, 400, ErrSubmitOnly, gin.H{"function": req.Function})
+		return
+	}
+	res, err := evaluate(c, req.Function, req.options(c)...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"result": resultJSON(res)})
+}
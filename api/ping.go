@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// pingTTL is how long /readyz reuses a Ping answer, so probes from every
+// load balancer and kubelet cost the peer one evaluate at most this often.
+const pingTTL = 5 * time.Second
+
+// Ping is the chaincode's health answer. Height is the last height marked on
+// the ledger, so a lower bound; LedgerHeight is the height qscc last
+// reported to this replica.
+type Ping struct {
+	Version      string `json:"version"`
+	Height       uint64 `json:"height"`
+	MarkedAt     int64  `json:"markedAt,omitempty"`
+	ServerTime   int64  `json:"serverTime"`
+	LedgerHeight uint64 `json:"ledgerHeight,omitempty"`
+	LatencyMs    int64  `json:"latencyMs"`
+}
+
+var lastPing = struct {
+	sync.Mutex
+	at   time.Time
+	ping *Ping
+	err  error
+}{}
+
+// pingChaincode evaluates Ping, which proves the identity, channel,
+// chaincode and its container together.
+func pingChaincode(ctx context.Context) (*Ping, error) {
+	start := time.Now()
+	res, err := contract.EvaluateWithContext(ctx, "Ping")
+	if err != nil {
+		return nil, err
+	}
+	var p Ping
+	if err := json.Unmarshal(res, &p); err != nil {
+		return nil, err
+	}
+	p.LatencyMs = time.Since(start).Milliseconds()
+	sli.Lock()
+	p.LedgerHeight = sli.height
+	sli.Unlock()
+	return &p, nil
+}
+
+// cachedPing returns the last Ping answer or error while it is younger than
+// pingTTL and pings again otherwise.
+func cachedPing() (*Ping, error) {
+	lastPing.Lock()
+	defer lastPing.Unlock()
+	if time.Since(lastPing.at) < pingTTL {
+		return lastPing.ping, lastPing.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	lastPing.ping, lastPing.err = pingChaincode(ctx)
+	lastPing.at = time.Now()
+	return lastPing.ping, lastPing.err
+}
+
+var heightMarkerInterval time.Duration
+
+// loadHeightMarker reads HEIGHT_MARKER_INTERVAL, how often the leader
+// submits MarkHeight with the ledger height so Ping's height stays close to
+// the channel's. Unset, the marker is left alone; MarkHeight needs an admin
+// identity.
+func loadHeightMarker() {
+	v := os.Getenv("HEIGHT_MARKER_INTERVAL")
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Minute {
+		log.Fatalf("HEIGHT_MARKER_INTERVAL: must be a duration of at least 1m")
+	}
+	heightMarkerInterval = d
+}
+
+// runHeightMarker marks the height a minute after it starts, once qscc has
+// answered, and then every heightMarkerInterval. It runs on the leader.
+func runHeightMarker(ctx context.Context) {
+	if heightMarkerInterval == 0 {
+		return
+	}
+	t := time.NewTimer(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		sli.Lock()
+		height := sli.height
+		sli.Unlock()
+		if height > 0 {
+			if _, _, err := submit("MarkHeight", client.WithArguments(strconv.FormatUint(height, 10))); err != nil {
+				log.Printf("height marker: %v", err)
+			}
+		}
+		t.Reset(heightMarkerInterval)
+	}
+}
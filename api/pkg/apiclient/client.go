@@ -0,0 +1,867 @@
+// Package apiclient is a typed Go client for the fabric-api REST service.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	baseURL    string
+	http       *http.Client
+	apiKey     string
+	encKey     string
+	maxRetries int
+	backoff    time.Duration
+	appID      string
+	channel    string
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(h *http.Client) Option { return func(c *Client) { c.http = h } }
+
+func WithAPIKey(key string) Option { return func(c *Client) { c.apiKey = key } }
+
+// WithEncryptionKey sends key with every request so the chaincode encrypts
+// MPIN and REMARKS on write and decrypts them on read.
+func WithEncryptionKey(key []byte) Option {
+	return func(c *Client) { c.encKey = base64.StdEncoding.EncodeToString(key) }
+}
+
+// WithRetry sets how many times a failed request is retried and the initial
+// delay, which doubles after every attempt.
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// WithOrigin names the client application and the channel (USSD, APP,
+// WEB, or as the API's ORIGIN_CHANNELS allows) sent with every request and
+// recorded on chain with each change.
+func WithOrigin(appID, channel string) Option {
+	return func(c *Client) {
+		c.appID = appID
+		c.channel = channel
+	}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID sends id as X-Request-ID on requests made with ctx instead
+// of letting the API generate one.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+type operationIDKey struct{}
+
+// WithOperationID tags writes made with ctx with a client-generated ID; the
+// chaincode rejects a second write with the same ID within 24 hours, so a
+// double tap or a retried request is applied once.
+func WithOperationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, operationIDKey{}, id)
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey sends key as the Idempotency-Key of Invoke and
+// InvokeAsync calls made with ctx. The API then builds the transaction once
+// and answers every retry, on any replica, with that same transaction, so
+// such calls are retried like reads.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+type asyncKey struct{}
+
+type signedKey struct{}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		http:       &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+const maxBackoff = 5 * time.Second
+
+// do sends the request, retrying transport failures and gateway errors only
+// when repeating the call cannot apply a write twice: idempotent requests, or
+// any request the API flagged as retryable.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	idempotent := method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete
+	if key, _ := ctx.Value(idempotencyKey{}).(string); key != "" {
+		idempotent = true
+	}
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.once(ctx, method, path, body, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(err, idempotent) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxBackoff {
+			delay = maxBackoff
+		}
+	}
+}
+
+func retryable(err error, idempotent bool) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Retryable || idempotent && apiErr.ErrorCode == "SUBMISSION_IN_PROGRESS" {
+			return true
+		}
+		return idempotent && apiErr.Category == "" && (apiErr.StatusCode == 502 || apiErr.StatusCode == 503 || apiErr.StatusCode == 504)
+	}
+	return idempotent && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.encKey != "" {
+		req.Header.Set("X-Encryption-Key", c.encKey)
+	}
+	if id, _ := ctx.Value(operationIDKey{}).(string); id != "" {
+		req.Header.Set("X-Operation-ID", id)
+	}
+	if key, _ := ctx.Value(idempotencyKey{}).(string); key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if ctx.Value(asyncKey{}) != nil {
+		req.Header.Set("Prefer", "respond-async")
+	}
+	if c.appID != "" {
+		req.Header.Set("X-Client-App", c.appID)
+	}
+	if c.channel != "" {
+		req.Header.Set("X-Origin-Channel", c.channel)
+	}
+	if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	if ctx.Value(signedKey{}) != nil {
+		req.Header.Set("X-Signed-Response", "true")
+	}
+	return req, nil
+}
+
+func (c *Client) once(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return decodeError(res)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func decodeError(res *http.Response) error {
+	e := &Error{StatusCode: res.StatusCode}
+	b, _ := io.ReadAll(res.Body)
+	if json.Unmarshal(b, e) != nil || e.Message == "" {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	return e
+}
+
+func (c *Client) Health(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/health", nil, nil)
+}
+
+func (c *Client) ListAssets(ctx context.Context) ([]Account, error) {
+	var out list[Account]
+	return out.Records, c.do(ctx, http.MethodGet, "/assets", nil, &out)
+}
+
+func (c *Client) ListAssetsPage(ctx context.Context, pageSize int, bookmark string) (*AssetPage, error) {
+	q := url.Values{"pageSize": {strconv.Itoa(pageSize)}}
+	if bookmark != "" {
+		q.Set("bookmark", bookmark)
+	}
+	var out AssetPage
+	if err := c.do(ctx, http.MethodGet, "/assets?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// EachAsset walks every account page by page, stopping at the first error
+// returned by fn.
+func (c *Client) EachAsset(ctx context.Context, pageSize int, fn func(Account) error) error {
+	bookmark := ""
+	for {
+		page, err := c.ListAssetsPage(ctx, pageSize, bookmark)
+		if err != nil {
+			return err
+		}
+		for _, a := range page.Records {
+			if err := fn(a); err != nil {
+				return err
+			}
+		}
+		if len(page.Records) < pageSize || page.Bookmark == "" || page.Bookmark == bookmark {
+			return nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// ChangesSince lists accounts written after block; pass the returned
+// IndexedThrough as block on the next call for incremental sync.
+func (c *Client) ChangesSince(ctx context.Context, block uint64) (*Changes, error) {
+	var out Changes
+	if err := c.do(ctx, http.MethodGet, "/assets/changes?sinceBlock="+strconv.FormatUint(block, 10), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) GetAsset(ctx context.Context, msisdn string) (*Account, error) {
+	var out Account
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAssetExpanded reads the account with up to historyLimit history
+// entries and auditLimit audit records embedded, fetched by the API in
+// parallel; a limit of 0 leaves that expansion out.
+func (c *Client) GetAssetExpanded(ctx context.Context, msisdn string, historyLimit, auditLimit int) (*ExpandedAccount, error) {
+	q := url.Values{}
+	var expand []string
+	if historyLimit > 0 {
+		expand = append(expand, "history")
+		q.Set("historyLimit", strconv.Itoa(historyLimit))
+	}
+	if auditLimit > 0 {
+		expand = append(expand, "audit")
+		q.Set("auditLimit", strconv.Itoa(auditLimit))
+	}
+	q.Set("expand", strings.Join(expand, ","))
+	var out ExpandedAccount
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAssetSigned reads the account through an endorsement and returns the
+// peers' signatures with it. The account is decoded from Attestation.Payload,
+// the bytes the peers signed, not from the API's own rendering; checking the
+// signatures and endorser certificates is up to the caller.
+func (c *Client) GetAssetSigned(ctx context.Context, msisdn string) (*Account, *Attestation, error) {
+	var out struct {
+		Attestation *Attestation `json:"attestation"`
+	}
+	if err := c.do(context.WithValue(ctx, signedKey{}, true), http.MethodGet, "/assets/"+url.PathEscape(msisdn), nil, &out); err != nil {
+		return nil, nil, err
+	}
+	if out.Attestation == nil {
+		return nil, nil, errors.New("response carries no attestation")
+	}
+	var a Account
+	if err := json.Unmarshal(out.Attestation.Payload, &a); err != nil {
+		return nil, nil, err
+	}
+	return &a, out.Attestation, nil
+}
+
+func (c *Client) History(ctx context.Context, msisdn string) ([]History, error) {
+	var out list[History]
+	err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/history", nil, &out)
+	return out.Records, err
+}
+
+// HistoryPage returns up to pageSize history entries following afterTxID,
+// the previous page's Next, or from the start when it is empty.
+func (c *Client) HistoryPage(ctx context.Context, msisdn string, pageSize int, afterTxID string) (*HistoryPage, error) {
+	q := url.Values{"pageSize": {strconv.Itoa(pageSize)}}
+	if afterTxID != "" {
+		q.Set("after", afterTxID)
+	}
+	var out HistoryPage
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/history?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// HistoryV2 returns up to pageSize history entries in the version 2 shape
+// following after, the previous page's Meta.NextCursor, or from the start
+// when it is empty.
+func (c *Client) HistoryV2(ctx context.Context, msisdn string, pageSize int, after string) (*HistoryPageV2, error) {
+	q := url.Values{"pageSize": {strconv.Itoa(pageSize)}}
+	if after != "" {
+		q.Set("after", after)
+	}
+	var out HistoryPageV2
+	if err := c.do(ctx, http.MethodGet, "/api/v2/assets/"+url.PathEscape(msisdn)+"/history?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ExportHistory returns the raw history export; format is "csv" or "jsonl".
+func (c *Client) ExportHistory(ctx context.Context, msisdn, format string) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/history/export?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return nil, decodeError(res)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (c *Client) RecentTransactions(ctx context.Context, msisdn string, n int) ([]TxSummary, error) {
+	var out list[TxSummary]
+	err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/recent-transactions?n="+strconv.Itoa(n), nil, &out)
+	return out.Records, err
+}
+
+func (c *Client) MiniStatement(ctx context.Context, msisdn string, count int) (*MiniStatement, error) {
+	var out MiniStatement
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/ministatement?count="+strconv.Itoa(count), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Analytics returns the account's monthly aggregates for the last months
+// calendar months, oldest first.
+func (c *Client) Analytics(ctx context.Context, msisdn string, months int) (*Analytics, error) {
+	var out Analytics
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/analytics?months="+strconv.Itoa(months), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Transfer runs a transfer, and its fee if any, as a saga. A rolled back or
+// failed transfer is an *Error; tag ctx with WithOperationID to be able to
+// look the saga up with Operation afterwards, and to make retries safe.
+func (c *Client) Transfer(ctx context.Context, req TransferRequest) (*Saga, error) {
+	var out Saga
+	if err := c.do(ctx, http.MethodPost, "/operations/transfers", req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Operation(ctx context.Context, id string) (*Saga, error) {
+	var out Saga
+	if err := c.do(ctx, http.MethodGet, "/operations/"+url.PathEscape(id), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// TransferFunds moves amount from one account to another and charges the
+// transfer fee to the sender, all in one transaction.
+func (c *Client) TransferFunds(ctx context.Context, from, to string, amount int64, remarks string) (*FeeQuote, error) {
+	var out FeeQuote
+	body := map[string]any{"to": to, "amount": amount, "remarks": remarks}
+	if err := c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(from)+"/transfer", body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Debit(ctx context.Context, msisdn string, amount int64, remarks string) (*FeeQuote, error) {
+	var out FeeQuote
+	body := map[string]any{"amount": amount, "remarks": remarks}
+	if err := c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(msisdn)+"/debit", body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Holds returns msisdn's active holds and its available balance.
+func (c *Client) Holds(ctx context.Context, msisdn string) (*Holds, error) {
+	var out Holds
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/holds", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PlaceHold reserves amount of msisdn's available balance under ref until
+// expiresAt.
+func (c *Client) PlaceHold(ctx context.Context, msisdn, ref string, amount int64, expiresAt time.Time) (*Hold, error) {
+	var out Hold
+	body := map[string]any{"ref": ref, "amount": amount, "expiresAt": strconv.FormatInt(expiresAt.Unix(), 10)}
+	if err := c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(msisdn)+"/holds", body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) ReleaseHold(ctx context.Context, msisdn, ref string) (*Hold, error) {
+	var out Hold
+	if err := c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(msisdn)+"/holds/"+url.PathEscape(ref)+"/release", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CaptureHold debits amount of the hold, or all of it when amount is 0,
+// charging the debit fee, and releases the rest.
+func (c *Client) CaptureHold(ctx context.Context, msisdn, ref string, amount int64, remarks string) (*FeeQuote, error) {
+	var out FeeQuote
+	body := map[string]any{"amount": amount, "remarks": remarks}
+	if err := c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(msisdn)+"/holds/"+url.PathEscape(ref)+"/capture", body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CloseAccount closes msisdn for good, moving any balance to settlement,
+// which may be empty when the balance is zero.
+func (c *Client) CloseAccount(ctx context.Context, msisdn, settlement string) (*Closure, error) {
+	var out Closure
+	if err := c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(msisdn)+"/close", map[string]any{"settlementMsisdn": settlement}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) GetClosure(ctx context.Context, msisdn string) (*Closure, error) {
+	var out Closure
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/closure", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Sync returns the changes after cursor, empty for a full download. Apply
+// Upserts and Deletes, keep NextCursor, and call again while HasMore.
+func (c *Client) Sync(ctx context.Context, cursor string, limit int) (*SyncPage, error) {
+	var out SyncPage
+	q := url.Values{"cursor": {cursor}, "limit": {strconv.Itoa(limit)}}
+	if err := c.do(ctx, http.MethodGet, "/sync?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetReceipt looks up a receipt number such as R0000000042.
+func (c *Client) GetReceipt(ctx context.Context, number string) (*Receipt, error) {
+	var out Receipt
+	if err := c.do(ctx, http.MethodGet, "/receipts/"+url.PathEscape(number), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ChangeMPIN replaces msisdn's MPIN. A wrong old PIN answers MPIN_MISMATCH
+// and counts towards the lock; a locked PIN answers MPIN_LOCKED.
+func (c *Client) ChangeMPIN(ctx context.Context, msisdn, oldPinHash, newPinHash string) (*PINResult, error) {
+	var out PINResult
+	if err := c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(msisdn)+"/mpin", map[string]any{"oldPinHash": oldPinHash, "newPinHash": newPinHash}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// VerifyMPIN checks a PIN, with the same errors as ChangeMPIN.
+func (c *Client) VerifyMPIN(ctx context.Context, msisdn, pinHash string) (*PINResult, error) {
+	var out PINResult
+	if err := c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(msisdn)+"/mpin/verify", map[string]any{"pinHash": pinHash}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) GetPINStatus(ctx context.Context, msisdn string) (*PINResult, error) {
+	var out PINResult
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/mpin", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UnlockMPIN clears msisdn's failed attempts and lock. It needs an admin key.
+func (c *Client) UnlockMPIN(ctx context.Context, msisdn string) (*PINResult, error) {
+	var out PINResult
+	if err := c.do(ctx, http.MethodPost, "/admin/assets/"+url.PathEscape(msisdn)+"/mpin/unlock", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DailySummaries returns msisdn's per-day totals from one YYYY-MM-DD date to
+// another; empty dates mean today.
+func (c *Client) DailySummaries(ctx context.Context, msisdn, from, to string) ([]DailySummary, error) {
+	q := url.Values{}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	var out list[DailySummary]
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/daily-summaries?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Records, nil
+}
+
+// EachDealerAsset streams a dealer's accounts from the NDJSON export,
+// calling fn for each as it is read, and stops at the first error returned
+// by fn. A failure after the stream started is returned as an *Error.
+func (c *Client) EachDealerAsset(ctx context.Context, dealerID string, fn func(Account) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/dealers/"+url.PathEscape(dealerID)+"/assets.ndjson", nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return decodeError(res)
+	}
+	dec := json.NewDecoder(res.Body)
+	for {
+		var line struct {
+			Account
+			Error *Error `json:"error"`
+		}
+		if err := dec.Decode(&line); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if line.Error != nil {
+			line.Error.StatusCode = res.StatusCode
+			return line.Error
+		}
+		if err := fn(line.Account); err != nil {
+			return err
+		}
+	}
+}
+
+// DeletedAssets returns a page of deleted accounts, only msisdn's when it
+// is not empty. It needs an admin or operator key.
+func (c *Client) DeletedAssets(ctx context.Context, msisdn string, pageSize int, bookmark string) (*TombstonePage, error) {
+	q := url.Values{"pageSize": {strconv.Itoa(pageSize)}}
+	if msisdn != "" {
+		q.Set("msisdn", msisdn)
+	}
+	if bookmark != "" {
+		q.Set("bookmark", bookmark)
+	}
+	var out TombstonePage
+	if err := c.do(ctx, http.MethodGet, "/assets/deleted?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DealerQuota returns dealerID's account quota and usage.
+func (c *Client) DealerQuota(ctx context.Context, dealerID string) (*DealerQuota, error) {
+	var out DealerQuota
+	if err := c.do(ctx, http.MethodGet, "/dealers/"+url.PathEscape(dealerID)+"/quota", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SetDealerQuota limits dealerID to quota accounts; 0 falls back to the
+// default, which dealer "*" sets. It needs an admin key.
+func (c *Client) SetDealerQuota(ctx context.Context, dealerID string, quota int64) (*DealerQuota, error) {
+	var out DealerQuota
+	if err := c.do(ctx, http.MethodPut, "/admin/dealers/"+url.PathEscape(dealerID)+"/quota", map[string]int64{"quota": quota}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// TransactionOrigin returns the request context recorded for txID.
+func (c *Client) TransactionOrigin(ctx context.Context, txID string) (*Origin, error) {
+	var out Origin
+	if err := c.do(ctx, http.MethodGet, "/transactions/"+url.PathEscape(txID)+"/origin", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AdminOperations returns the admin operation log of one UTC day, as
+// YYYY-MM-DD; "" means today.
+func (c *Client) AdminOperations(ctx context.Context, day string) (*AdminOperationLog, error) {
+	path := "/admin/operations"
+	if day != "" {
+		path += "?" + url.Values{"day": {day}}.Encode()
+	}
+	var out AdminOperationLog
+	if err := c.do(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PublicStatus returns whether msisdn exists and its STATUS from the
+// public route, which needs no API key.
+func (c *Client) PublicStatus(ctx context.Context, msisdn string) (*PublicStatus, error) {
+	var out PublicStatus
+	if err := c.do(ctx, http.MethodGet, "/public/assets/"+url.PathEscape(msisdn)+"/status", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PlanMerge is the dry run for MergeAccounts.
+func (c *Client) PlanMerge(ctx context.Context, primary, secondary string) (*MergePlan, error) {
+	var out MergePlan
+	path := "/admin/assets/" + url.PathEscape(primary) + "/merge?" + url.Values{"secondary": {secondary}}.Encode()
+	if err := c.do(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// MergeAccounts merges secondary into primary; confirmToken comes from
+// PlanMerge.
+func (c *Client) MergeAccounts(ctx context.Context, primary, secondary, confirmToken string) (*MergeLineage, error) {
+	var out MergeLineage
+	in := map[string]string{"secondary": secondary, "confirmToken": confirmToken}
+	if err := c.do(ctx, http.MethodPost, "/admin/assets/"+url.PathEscape(primary)+"/merge", in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) MergeLineage(ctx context.Context, msisdn string) (*MergeLineage, error) {
+	var out MergeLineage
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/merges", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// QuoteFee previews the fee for a "transfer" or "debit" of amount.
+func (c *Client) QuoteFee(ctx context.Context, operation string, amount int64) (*FeeQuote, error) {
+	var out FeeQuote
+	q := url.Values{"operation": {operation}, "amount": {strconv.FormatInt(amount, 10)}}
+	if err := c.do(ctx, http.MethodGet, "/fees/quote?"+q.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) BalanceProof(ctx context.Context, msisdn string, block uint64) (*BalanceProof, error) {
+	var out BalanceProof
+	path := "/assets/" + url.PathEscape(msisdn) + "/balance-proof?block=" + strconv.FormatUint(block, 10)
+	if err := c.do(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) SubAccounts(ctx context.Context, msisdn string) (*Rollup, error) {
+	var out Rollup
+	if err := c.do(ctx, http.MethodGet, "/assets/"+url.PathEscape(msisdn)+"/subaccounts", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) CreateSubAccount(ctx context.Context, parent string, a Account) error {
+	return c.do(ctx, http.MethodPost, "/assets/"+url.PathEscape(parent)+"/subaccounts", a, nil)
+}
+
+func (c *Client) CreateAsset(ctx context.Context, a Account) error {
+	return c.do(ctx, http.MethodPost, "/assets", a, nil)
+}
+
+func (c *Client) UpdateAsset(ctx context.Context, a Account) error {
+	return c.do(ctx, http.MethodPut, "/assets/"+url.PathEscape(a.MSISDN), a, nil)
+}
+
+func (c *Client) DeleteAsset(ctx context.Context, msisdn string) error {
+	return c.do(ctx, http.MethodDelete, "/assets/"+url.PathEscape(msisdn), nil, nil)
+}
+
+func (c *Client) Dashboard(ctx context.Context, topDealers int) (*Dashboard, error) {
+	var out Dashboard
+	if err := c.do(ctx, http.MethodGet, "/dashboard?topDealers="+strconv.Itoa(topDealers), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+type passthroughRequest struct {
+	Function  string            `json:"function"`
+	Args      []string          `json:"args"`
+	Transient map[string]string `json:"transient,omitempty"`
+}
+
+func (c *Client) Invoke(ctx context.Context, function string, args []string, transient map[string]string) (*InvokeResult, error) {
+	var out InvokeResult
+	if err := c.do(ctx, http.MethodPost, "/invoke", passthroughRequest{function, args, transient}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// InvokeAsync submits like Invoke but returns as soon as the transaction ID
+// is known. ctx must carry an idempotency key; follow the submission with
+// Submission.
+func (c *Client) InvokeAsync(ctx context.Context, function string, args []string, transient map[string]string) (*Submission, error) {
+	if key, _ := ctx.Value(idempotencyKey{}).(string); key == "" {
+		return nil, errors.New("apiclient: InvokeAsync needs WithIdempotencyKey")
+	}
+	var out Submission
+	if err := c.do(context.WithValue(ctx, asyncKey{}, true), http.MethodPost, "/invoke", passthroughRequest{function, args, transient}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Submission(ctx context.Context, key string) (*Submission, error) {
+	var out Submission
+	if err := c.do(ctx, http.MethodGet, "/submissions/"+url.PathEscape(key), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) Query(ctx context.Context, function string, args []string, transient map[string]string) (json.RawMessage, error) {
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/query", passthroughRequest{function, args, transient}, &out); err != nil {
+		return nil, err
+	}
+	return out.Result, nil
+}
+
+// PrepareOffline builds a proposal for the identity in certPEM, whose key
+// stays with the caller.
+func (c *Client) PrepareOffline(ctx context.Context, mspID, certPEM, function string, args []string, transient map[string]string) (*OfflineProposal, error) {
+	var out OfflineProposal
+	body := map[string]any{"mspId": mspID, "certificate": certPEM, "function": function, "args": args, "transient": transient}
+	if err := c.do(ctx, http.MethodPost, "/offline/proposals", body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// EndorseOffline endorses a prepared proposal with the signature over its
+// digest.
+func (c *Client) EndorseOffline(ctx context.Context, txID string, signature []byte) (*OfflineTransaction, error) {
+	var out OfflineTransaction
+	if err := c.do(ctx, http.MethodPost, "/offline/proposals/"+url.PathEscape(txID)+"/endorsement", map[string]any{"signature": signature}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// SubmitOffline submits an endorsed transaction with the signature over its
+// digest. It succeeds at most once per transaction.
+func (c *Client) SubmitOffline(ctx context.Context, txID string, signature []byte) (*InvokeResult, error) {
+	var out InvokeResult
+	if err := c.do(ctx, http.MethodPost, "/offline/proposals/"+url.PathEscape(txID)+"/submission", map[string]any{"signature": signature}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// StartRunbook starts action (index-resync, dealer-index-rebuild or
+// outbox-redrive) and returns the job at once; follow it with RunbookJob.
+// It needs an admin key.
+func (c *Client) StartRunbook(ctx context.Context, action string, req RunbookRequest) (*RunbookJob, error) {
+	var out RunbookJob
+	if err := c.do(ctx, http.MethodPost, "/admin/runbooks/"+url.PathEscape(action), req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) RunbookJob(ctx context.Context, id string) (*RunbookJob, error) {
+	var out RunbookJob
+	if err := c.do(ctx, http.MethodGet, "/admin/runbooks/jobs/"+url.PathEscape(id), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RunbookJobs lists the jobs of the last 30 days, newest first.
+func (c *Client) RunbookJobs(ctx context.Context) ([]RunbookJob, error) {
+	var out struct {
+		Jobs []RunbookJob `json:"jobs"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/admin/runbooks/jobs", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Jobs, nil
+}
+
+// CancelRunbookJob asks a running job to stop at its next progress report.
+func (c *Client) CancelRunbookJob(ctx context.Context, id string) (*RunbookJob, error) {
+	var out RunbookJob
+	if err := c.do(ctx, http.MethodDelete, "/admin/runbooks/jobs/"+url.PathEscape(id), nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,34 @@
+package apiclient
+
+import "fmt"
+
+type ErrorDetail struct {
+	Address string `json:"address"`
+	MspID   string `json:"mspId"`
+	Message string `json:"message"`
+}
+
+// Error is a non-2xx response. ErrorCode is the API's stable error code;
+// Category and Retryable are set for failures that reached Fabric, with the
+// gateway's text in Detail.
+type Error struct {
+	StatusCode int           `json:"-"`
+	Message    string        `json:"error"`
+	ErrorCode  string        `json:"code"`
+	Detail     string        `json:"detail"`
+	Category   string        `json:"category"`
+	Retryable  bool          `json:"retryable"`
+	Code       string        `json:"grpcCode"`
+	TxID       string        `json:"txId"`
+	Details    []ErrorDetail `json:"details"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("apiclient: %d %s: %s: %s", e.StatusCode, e.ErrorCode, e.Message, e.Detail)
+	}
+	if e.Category != "" {
+		return fmt.Sprintf("apiclient: %d %s: %s", e.StatusCode, e.Category, e.Message)
+	}
+	return fmt.Sprintf("apiclient: %d: %s", e.StatusCode, e.Message)
+}
@@ -0,0 +1,89 @@
+package apiclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Subscribe streams chaincode events committed from now on. It needs an API
+// key; with a dealer key only that dealer's events arrive. The channel is
+// closed when ctx is cancelled or the connection drops; Err on the returned
+// Subscription reports why.
+func (c *Client) Subscribe(ctx context.Context) (*Subscription, error) {
+	return c.subscribe(ctx, "/events")
+}
+
+// SubscribeFrom replays chaincode events starting at block before following
+// new ones.
+func (c *Client) SubscribeFrom(ctx context.Context, block uint64) (*Subscription, error) {
+	return c.subscribe(ctx, "/events?startBlock="+strconv.FormatUint(block, 10))
+}
+
+type Subscription struct {
+	Events <-chan Event
+	err    error
+	done   chan struct{}
+}
+
+// Err waits for the stream to end and returns the error that ended it, or nil
+// if ctx was cancelled.
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+func (c *Client) subscribe(ctx context.Context, path string) (*Subscription, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	// The client-wide timeout would cut off a long-lived stream.
+	h := *c.http
+	h.Timeout = 0
+	res, err := h.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		return nil, decodeError(res)
+	}
+	events := make(chan Event)
+	sub := &Subscription{Events: events, done: make(chan struct{})}
+	go func() {
+		defer close(sub.done)
+		defer close(events)
+		defer res.Body.Close()
+		sc := bufio.NewScanner(res.Body)
+		sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		var data strings.Builder
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "data:"):
+				data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			case line == "" && data.Len() > 0:
+				var ev Event
+				if err := json.Unmarshal([]byte(data.String()), &ev); err != nil {
+					sub.err = err
+					return
+				}
+				data.Reset()
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if ctx.Err() == nil {
+			sub.err = sc.Err()
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,582 @@
+package apiclient
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Account is an account as written to and read from the API. MPIN is only
+// sent, when an account is created; reads leave it empty.
+type Account struct {
+	DEALERID    string `json:"DEALERID"`
+	MSISDN      string `json:"MSISDN"`
+	MPIN        string `json:"MPIN,omitempty"`
+	BALANCE     int64  `json:"BALANCE"`
+	STATUS      string `json:"STATUS"`
+	TRANSAMOUNT int64  `json:"TRANSAMOUNT"`
+	TRANSTYPE   string `json:"TRANSTYPE"`
+	REMARKS     string `json:"REMARKS"`
+	PARENT      string `json:"PARENT,omitempty"`
+	CURRENCY    string `json:"CURRENCY,omitempty"`
+	// CreatedAt and LastModified are the Unix seconds of the transactions
+	// that created and last wrote the account, taken from the ledger. Older
+	// accounts may have no CreatedAt. Both are ignored on writes.
+	CreatedAt    int64 `json:"createdAt,omitempty"`
+	LastModified int64 `json:"lastModified,omitempty"`
+}
+
+type History struct {
+	TxID           string   `json:"txId"`
+	Value          *Account `json:"value,omitempty"`
+	IsDelete       bool     `json:"isDelete"`
+	Timestamp      int64    `json:"timestamp"`
+	TimestampNanos int64    `json:"timestampNanos"`
+	ValueHash      string   `json:"valueHash,omitempty"`
+	BlockNumber    uint64   `json:"blockNumber,omitempty"`
+	Origin         *Origin  `json:"origin,omitempty"`
+}
+
+// AuditRecord is who wrote one version of an account and how the peer
+// validated it.
+type AuditRecord struct {
+	TxID           string  `json:"txId"`
+	Timestamp      int64   `json:"timestamp"`
+	IsDelete       bool    `json:"isDelete"`
+	SubmitterMSP   string  `json:"submitterMsp"`
+	ValidationCode string  `json:"validationCode"`
+	Valid          bool    `json:"valid"`
+	Origin         *Origin `json:"origin,omitempty"`
+}
+
+// ExpansionError is an expansion the API could not fetch; the account and
+// the other expansions are still returned.
+type ExpansionError struct {
+	Expansion string `json:"expansion"`
+	Code      string `json:"code"`
+	Error     string `json:"error"`
+	Retryable bool   `json:"retryable"`
+}
+
+// Expansion is one embedded list of an ExpandedAccount.
+type Expansion[T any] struct {
+	Records []T      `json:"records"`
+	Meta    ListMeta `json:"meta"`
+}
+
+// ExpandedAccount is an account with its latest history entries and audit
+// records, newest first; each is nil unless requested.
+type ExpandedAccount struct {
+	Account
+	History         *Expansion[History]     `json:"history,omitempty"`
+	Audit           *Expansion[AuditRecord] `json:"audit,omitempty"`
+	ExpansionErrors []ExpansionError        `json:"expansionErrors,omitempty"`
+}
+
+// HistoryPage is a window of an account's history; Next is empty after the
+// last.
+type HistoryPage struct {
+	Records []History `json:"records"`
+	Next    string    `json:"next"`
+	Meta    ListMeta  `json:"meta"`
+}
+
+// HistoryV2 is a history entry in the version 2 shape. DeleteReason is
+// deleted, dealer_deletion, merged or unknown.
+type HistoryV2 struct {
+	TxID         string    `json:"txId"`
+	Timestamp    time.Time `json:"timestamp"`
+	UnixNanos    int64     `json:"unixNanos"`
+	BlockNumber  uint64    `json:"blockNumber"`
+	SubmitterMSP string    `json:"submitterMsp"`
+	IsDelete     bool      `json:"isDelete"`
+	DeleteReason string    `json:"deleteReason,omitempty"`
+	MergedInto   string    `json:"mergedInto,omitempty"`
+	Value        *Account  `json:"value,omitempty"`
+	ValueHash    string    `json:"valueHash,omitempty"`
+	Origin       *Origin   `json:"origin,omitempty"`
+}
+
+type HistoryPageV2 struct {
+	Records []HistoryV2 `json:"records"`
+	Meta    ListMeta    `json:"meta"`
+}
+
+type AssetPage struct {
+	Records  []Account `json:"records"`
+	Bookmark string    `json:"bookmark"`
+	Fetched  int32     `json:"fetchedCount"`
+	Meta     ListMeta  `json:"meta"`
+}
+
+// ListMeta describes a page of a list. FetchedAtBlockHeight is the channel
+// height read before the query, zero if the server could not read it; pages
+// fetched at different heights may have seen different ledger states.
+type ListMeta struct {
+	ReturnedCount        int    `json:"returnedCount"`
+	PageSize             int    `json:"pageSize,omitempty"`
+	NextCursor           string `json:"nextCursor,omitempty"`
+	HasMore              bool   `json:"hasMore"`
+	FetchedAtBlockHeight uint64 `json:"fetchedAtBlockHeight,omitempty"`
+}
+
+// list is the body of list endpoints without a page type of their own.
+type list[T any] struct {
+	Records []T      `json:"records"`
+	Meta    ListMeta `json:"meta"`
+}
+
+type Rollup struct {
+	MSISDN             string    `json:"MSISDN"`
+	BALANCE            int64     `json:"BALANCE"`
+	SubAccounts        []Account `json:"subAccounts"`
+	SubAccountsBalance int64     `json:"subAccountsBalance"`
+	TotalBalance       int64     `json:"totalBalance"`
+}
+
+type TxSummary struct {
+	TxID        string `json:"txId"`
+	TRANSTYPE   string `json:"TRANSTYPE"`
+	TRANSAMOUNT int64  `json:"TRANSAMOUNT"`
+	BALANCE     int64  `json:"BALANCE"`
+	REMARKS     string `json:"REMARKS"`
+	Timestamp   int64  `json:"timestamp"`
+	Receipt     string `json:"receipt,omitempty"`
+}
+
+type BalanceProof struct {
+	MSISDN          string   `json:"msisdn"`
+	BlockNumber     uint64   `json:"blockNumber"`
+	Exists          bool     `json:"exists"`
+	Balance         int64    `json:"balance"`
+	TxID            string   `json:"txId,omitempty"`
+	TxBlock         uint64   `json:"txBlock,omitempty"`
+	SupportingTxIDs []string `json:"supportingTxIds"`
+}
+
+type InvokeResult struct {
+	TxID        string          `json:"txId"`
+	BlockNumber uint64          `json:"blockNumber"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// Submission is an Invoke made with an idempotency key: pending, endorsed,
+// committed or failed.
+type Submission struct {
+	Key         string          `json:"key"`
+	TxID        string          `json:"txId"`
+	Function    string          `json:"function"`
+	State       string          `json:"state"`
+	BlockNumber uint64          `json:"blockNumber,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       *Error          `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+type Event struct {
+	BlockNumber   uint64          `json:"blockNumber"`
+	TransactionID string          `json:"txId"`
+	EventName     string          `json:"eventName"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+type DealerBalance struct {
+	DEALERID string `json:"DEALERID"`
+	Accounts int    `json:"accounts"`
+	BALANCE  int64  `json:"BALANCE"`
+}
+
+type Dashboard struct {
+	TotalAccounts int             `json:"totalAccounts"`
+	TotalBalance  int64           `json:"totalBalance"`
+	ByStatus      map[string]int  `json:"byStatus"`
+	TopDealers    []DealerBalance `json:"topDealers"`
+	Volume24h     int64           `json:"volume24h"`
+	Count24h      int             `json:"transactions24h"`
+	AsOf          int64           `json:"asOf"`
+}
+
+type StatementLine struct {
+	Date    string `json:"date"`
+	Type    string `json:"type"`
+	Amount  int64  `json:"amount"`
+	Balance int64  `json:"balanceAfter"`
+	Line    string `json:"line"`
+}
+
+type MiniStatement struct {
+	MSISDN  string          `json:"MSISDN"`
+	Balance int64           `json:"balance"`
+	Lines   []StatementLine `json:"lines"`
+}
+
+type TransferRequest struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Amount     int64  `json:"amount"`
+	Fee        int64  `json:"fee,omitempty"`
+	FeeAccount string `json:"feeAccount,omitempty"`
+	Remarks    string `json:"remarks,omitempty"`
+}
+
+type SagaStep struct {
+	Name             string `json:"name"`
+	MSISDN           string `json:"MSISDN"`
+	TransType        string `json:"TRANSTYPE"`
+	Amount           int64  `json:"amount"`
+	Remarks          string `json:"remarks"`
+	State            string `json:"state"`
+	TxID             string `json:"txId"`
+	CompensationTxID string `json:"compensationTxId"`
+	Error            string `json:"error"`
+}
+
+// Saga is a multi-transaction operation: running, completed, compensating,
+// compensated or failed.
+type Saga struct {
+	ID        string     `json:"id"`
+	Type      string     `json:"type"`
+	State     string     `json:"state"`
+	Steps     []SagaStep `json:"steps"`
+	Error     string     `json:"error"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// FeeQuote is the fee for an amount; TxID is set when it was charged.
+type FeeQuote struct {
+	Operation  string `json:"operation"`
+	Amount     int64  `json:"amount"`
+	Fee        int64  `json:"fee"`
+	Total      int64  `json:"total"`
+	FeeAccount string `json:"feeAccount"`
+	Receipt    string `json:"receipt"`
+	TxID       string `json:"txId"`
+	// FX is set when a transfer converted between currencies.
+	FX *FXConversion `json:"fx,omitempty"`
+}
+
+// FXConversion is the rate a transfer between currencies used. Amount is in
+// From, Converted in To.
+type FXConversion struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Rate      string `json:"rate"`
+	Source    string `json:"source"`
+	Chaincode string `json:"chaincode"`
+	Amount    int64  `json:"amount"`
+	Converted int64  `json:"converted"`
+}
+
+// MonthlyAggregate sums an account's postings in one calendar month; Debits
+// is positive.
+type MonthlyAggregate struct {
+	Month       string         `json:"month"`
+	Credits     int64          `json:"credits"`
+	Debits      int64          `json:"debits"`
+	Count       int            `json:"count"`
+	CountByType map[string]int `json:"countByType"`
+}
+
+type Analytics struct {
+	MSISDN         string             `json:"MSISDN"`
+	Months         []MonthlyAggregate `json:"months"`
+	IndexedThrough int64              `json:"indexedThrough"`
+}
+
+type EndorsementProof struct {
+	MSPID     string `json:"mspId"`
+	Endorser  []byte `json:"endorser"`
+	Signature []byte `json:"signature"`
+}
+
+// Attestation proves a read came from the ledger: each endorser signed
+// ProposalResponsePayload followed by its Endorser bytes (a serialized
+// identity), and Payload is the chaincode result within that payload.
+type Attestation struct {
+	TxID                    string             `json:"txId"`
+	Channel                 string             `json:"channel"`
+	Chaincode               string             `json:"chaincode"`
+	Function                string             `json:"function"`
+	SignedProposal          []byte             `json:"signedProposal"`
+	ProposalResponsePayload []byte             `json:"proposalResponsePayload"`
+	Endorsements            []EndorsementProof `json:"endorsements"`
+	Payload                 []byte             `json:"payload"`
+}
+
+type Change struct {
+	MSISDN      string `json:"MSISDN"`
+	BlockNumber uint64 `json:"blockNumber"`
+	TxID        string `json:"txId"`
+	Timestamp   int64  `json:"timestamp"`
+	Deleted     bool   `json:"deleted"`
+}
+
+type Changes struct {
+	Changes        []Change `json:"changes"`
+	IndexedThrough int64    `json:"indexedThrough"`
+	Meta           ListMeta `json:"meta"`
+}
+
+// OfflineProposal is a proposal prepared for the caller's own key: sign
+// Digest and send the signature to EndorseOffline before ExpiresAt.
+type OfflineProposal struct {
+	TxID      string    `json:"txId"`
+	Proposal  []byte    `json:"proposal"`
+	Digest    []byte    `json:"digest"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// OfflineTransaction is an endorsed offline transaction: sign Digest and send
+// the signature to SubmitOffline, once, before ExpiresAt.
+type OfflineTransaction struct {
+	TxID        string          `json:"txId"`
+	Transaction []byte          `json:"transaction"`
+	Digest      []byte          `json:"digest"`
+	Result      json.RawMessage `json:"result"`
+	ExpiresAt   time.Time       `json:"expiresAt"`
+}
+
+// Hold reserves Amount of an account's balance under Ref until ExpiresAt,
+// in Unix seconds.
+type Hold struct {
+	MSISDN    string `json:"MSISDN"`
+	Ref       string `json:"ref"`
+	Amount    int64  `json:"amount"`
+	CreatedAt int64  `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+	CreatedBy string `json:"createdBy"`
+	TxID      string `json:"txId"`
+}
+
+// Holds is an account's active holds; Available is Balance less Held.
+type Holds struct {
+	MSISDN    string `json:"MSISDN"`
+	Balance   int64  `json:"balance"`
+	Held      int64  `json:"held"`
+	Available int64  `json:"available"`
+	Holds     []Hold `json:"holds"`
+}
+
+// Closure records how an account was closed; SettledAmount went to
+// SettlementMSISDN.
+type Closure struct {
+	MSISDN           string `json:"MSISDN"`
+	PreviousStatus   string `json:"previousStatus"`
+	SettlementMSISDN string `json:"settlementMsisdn"`
+	SettledAmount    int64  `json:"settledAmount"`
+	ClosedAt         int64  `json:"closedAt"`
+	ClosedBy         string `json:"closedBy"`
+	TxID             string `json:"txId"`
+	Receipt          string `json:"receipt"`
+}
+
+// SyncPage is one step of a delta sync; see Client.Sync.
+type SyncPage struct {
+	Upserts        []Account `json:"upserts"`
+	Deletes        []string  `json:"deletes"`
+	NextCursor     string    `json:"nextCursor"`
+	HasMore        bool      `json:"hasMore"`
+	IndexedThrough int64     `json:"indexedThrough"`
+	Meta           ListMeta  `json:"meta"`
+}
+
+// Receipt names the transaction and accounts behind a receipt number.
+type Receipt struct {
+	Number    string        `json:"receipt"`
+	TxID      string        `json:"txId"`
+	MSISDNs   []string      `json:"MSISDNs"`
+	Timestamp int64         `json:"timestamp"`
+	FX        *FXConversion `json:"fx,omitempty"`
+}
+
+// PINResult is the outcome of a PIN check or change, or a PIN's status.
+// LockedUntil is in Unix seconds.
+type PINResult struct {
+	MSISDN      string `json:"MSISDN"`
+	Verified    bool   `json:"verified"`
+	Changed     bool   `json:"changed"`
+	Failures    int    `json:"failures"`
+	Locked      bool   `json:"locked"`
+	LockedUntil int64  `json:"lockedUntil"`
+}
+
+// DailySummary totals an account's postings on one UTC day. Date is
+// YYYYMMDD, Debits is positive and ByType holds the net amount per TRANSTYPE.
+type DailySummary struct {
+	MSISDN         string           `json:"MSISDN"`
+	Date           string           `json:"date"`
+	Count          int              `json:"count"`
+	Credits        int64            `json:"credits"`
+	Debits         int64            `json:"debits"`
+	ByType         map[string]int64 `json:"byType"`
+	OpeningBalance int64            `json:"openingBalance"`
+	ClosingBalance int64            `json:"closingBalance"`
+}
+
+// Tombstone records a deleted account's last state (without the MPIN) and
+// who removed it when.
+type Tombstone struct {
+	MSISDN    string   `json:"MSISDN"`
+	DEALERID  string   `json:"DEALERID"`
+	Account   *Account `json:"account"`
+	DeletedAt int64    `json:"deletedAt"`
+	DeletedBy string   `json:"deletedBy"`
+	TxID      string   `json:"txId"`
+	Function  string   `json:"function"`
+	Origin    *Origin  `json:"origin,omitempty"`
+	// MergedInto is the account this one was merged into, if it was.
+	MergedInto string `json:"mergedInto,omitempty"`
+}
+
+type TombstonePage struct {
+	Records  []Tombstone `json:"records"`
+	Bookmark string      `json:"bookmark"`
+	Fetched  int32       `json:"fetchedCount"`
+	Meta     ListMeta    `json:"meta"`
+}
+
+// DealerQuota is a dealer's account quota (0 for no limit) and usage.
+// Default is set when the dealer has no quota of its own.
+type DealerQuota struct {
+	DEALERID string `json:"DEALERID"`
+	Quota    int64  `json:"quota"`
+	Default  bool   `json:"default"`
+	Used     int64  `json:"used"`
+}
+
+// Origin is the request context the chain recorded for a transaction:
+// request ID, client application and channel, and the SHA-256 fingerprint
+// of the full context the API sent.
+type Origin struct {
+	RequestID   string `json:"requestId"`
+	AppID       string `json:"appId,omitempty"`
+	Channel     string `json:"channel,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// AdminOperation is one entry of an API replica's admin operation log.
+// Hash chains it to the entry before, PrevHash.
+type AdminOperation struct {
+	Seq       int64           `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Actor     string          `json:"actor"`
+	Role      string          `json:"role,omitempty"`
+	Operation string          `json:"operation"`
+	Method    string          `json:"method,omitempty"`
+	Path      string          `json:"path,omitempty"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	PrevHash  string          `json:"prevHash"`
+	Hash      string          `json:"hash"`
+}
+
+// AdminOperationLog is a day of the log. Verified says whether the entries'
+// hash chain holds; Anchor is set once the day's final hash is on the ledger.
+type AdminOperationLog struct {
+	Day        string           `json:"day"`
+	Replica    string           `json:"replica"`
+	Operations []AdminOperation `json:"operations"`
+	Verified   bool             `json:"verified"`
+	Hash       string           `json:"hash,omitempty"`
+	Anchor     *AdminLogAnchor  `json:"anchor,omitempty"`
+}
+
+type AdminLogAnchor struct {
+	Day        string `json:"day"`
+	Replica    string `json:"replica"`
+	Hash       string `json:"hash"`
+	Entries    int    `json:"entries"`
+	AnchoredAt int64  `json:"anchoredAt"`
+	AnchoredBy string `json:"anchoredBy"`
+	TxID       string `json:"txId"`
+}
+
+// PublicStatus is the public route's view of an account.
+type PublicStatus struct {
+	MSISDN string `json:"msisdn"`
+	Exists bool   `json:"exists"`
+	STATUS string `json:"STATUS,omitempty"`
+}
+
+type MergeRecord struct {
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+	DEALERID  string `json:"DEALERID"`
+	Balance   int64  `json:"balance"`
+	MergedAt  int64  `json:"mergedAt"`
+	MergedBy  string `json:"mergedBy"`
+	TxID      string `json:"txId"`
+}
+
+// MergeLineage lists every account merged into MSISDN, oldest first.
+type MergeLineage struct {
+	MSISDN string        `json:"MSISDN"`
+	Merges []MergeRecord `json:"merges"`
+}
+
+type MergeCandidate struct {
+	MSISDN  string `json:"MSISDN"`
+	BALANCE int64  `json:"BALANCE"`
+	STATUS  string `json:"STATUS"`
+	PARENT  string `json:"PARENT,omitempty"`
+}
+
+// MergePlan is what a merge would do. ConfirmToken stops matching as soon
+// as either account changes.
+type MergePlan struct {
+	Primary         MergeCandidate `json:"primary"`
+	Secondary       MergeCandidate `json:"secondary"`
+	ResultBalance   int64          `json:"resultBalance"`
+	InheritedMerges int            `json:"inheritedMerges"`
+	ConfirmToken    string         `json:"confirmToken"`
+}
+
+// RunbookRequest starts a runbook action; each action reads only its own
+// fields. A nil DryRun is a dry run.
+type RunbookRequest struct {
+	DryRun    *bool    `json:"dryRun,omitempty"`
+	FromBlock *uint64  `json:"fromBlock,omitempty"`
+	PageSize  int      `json:"pageSize,omitempty"`
+	Bookmark  string   `json:"bookmark,omitempty"`
+	TxIDs     []string `json:"txIds,omitempty"`
+	OlderThan string   `json:"olderThan,omitempty"`
+}
+
+type RunbookItem struct {
+	Key    string `json:"key"`
+	Action string `json:"action"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RunbookProgress is Done of Total units, Total being 0 while unknown.
+// Bookmark is where a failed dealer index rebuild can resume.
+type RunbookProgress struct {
+	Unit      string           `json:"unit"`
+	Done      int64            `json:"done"`
+	Total     int64            `json:"total,omitempty"`
+	Phase     string           `json:"phase,omitempty"`
+	Counts    map[string]int64 `json:"counts"`
+	Bookmark  string           `json:"bookmark,omitempty"`
+	Items     []RunbookItem    `json:"items,omitempty"`
+	Truncated bool             `json:"truncated,omitempty"`
+}
+
+// RunbookJob is one run of a runbook action; State is running, succeeded,
+// failed or cancelled.
+type RunbookJob struct {
+	ID         string          `json:"id"`
+	Action     string          `json:"action"`
+	DryRun     bool            `json:"dryRun"`
+	Params     RunbookRequest  `json:"params"`
+	State      string          `json:"state"`
+	Replica    string          `json:"replica"`
+	Actor      string          `json:"actor,omitempty"`
+	Progress   RunbookProgress `json:"progress"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
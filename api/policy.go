@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/cel-go/cel"
+)
+
+// maxPolicyBody is how much of a request body is read for its amount and
+// function. While policies are loaded, larger bodies are refused, as the
+// policy could not see their amount.
+const maxPolicyBody = 1 << 20
+
+// Policy effects.
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// PolicyBundle is one file under POLICY_PATH: rules evaluated for every
+// request on top of the roles each route requires. Default is the decision
+// when no rule matches; any bundle saying "deny" makes it deny.
+type PolicyBundle struct {
+	Name    string        `json:"name"`
+	Default string        `json:"default,omitempty"`
+	Rules   []*PolicyRule `json:"rules"`
+}
+
+// PolicyRule matches requests by method and route, the gin pattern such as
+// /assets/:msisdn/debit ("*" for all, a trailing "*" for a prefix), and by
+// When, a CEL expression over the input that must be true.
+type PolicyRule struct {
+	ID      string   `json:"id"`
+	Effect  string   `json:"effect"`
+	Reason  string   `json:"reason,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+	Routes  []string `json:"routes,omitempty"`
+	When    string   `json:"when,omitempty"`
+	Bundle  string   `json:"bundle,omitempty"`
+
+	program cel.Program
+}
+
+// policyEnv declares the input as CEL variables: principal, params and
+// query are string maps, amount is a double and the rest are strings.
+var policyEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("principal", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("method", cel.StringType),
+		cel.Variable("route", cel.StringType),
+		cel.Variable("path", cel.StringType),
+		cel.Variable("params", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("query", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("dealer", cel.StringType),
+		cel.Variable("amount", cel.DoubleType),
+		cel.Variable("function", cel.StringType),
+		cel.CrossTypeNumericComparisons(true),
+	)
+})
+
+// compile checks r.When and prepares it for evaluation.
+func (r *PolicyRule) compile() error {
+	if r.When == "" {
+		return nil
+	}
+	env, err := policyEnv()
+	if err != nil {
+		return err
+	}
+	ast, iss := env.Compile(r.When)
+	if iss.Err() != nil {
+		return iss.Err()
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) {
+		return fmt.Errorf("evaluates to %s, not bool", ast.OutputType())
+	}
+	r.program, err = env.Program(ast)
+	return err
+}
+
+// PolicyInput is what rules are evaluated against. Amount is the JSON body's
+// amount and Function its chaincode function, when there is one.
+type PolicyInput struct {
+	Principal *Principal        `json:"principal,omitempty"`
+	Method    string            `json:"method"`
+	Route     string            `json:"route"`
+	Path      string            `json:"path"`
+	Params    map[string]string `json:"params,omitempty"`
+	Query     map[string]string `json:"query,omitempty"`
+	Dealer    string            `json:"dealer,omitempty"`
+	Amount    *float64          `json:"amount,omitempty"`
+	Function  string            `json:"function,omitempty"`
+}
+
+// PolicyDecision is the outcome of evaluating an input: the deny rule that
+// refused it, else whether an allow rule or the default let it through.
+type PolicyDecision struct {
+	Allowed bool     `json:"allowed"`
+	Rule    string   `json:"rule,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	Matched []string `json:"matched"`
+}
+
+// PolicySet is the bundles in force.
+type PolicySet struct {
+	Path     string         `json:"path"`
+	Default  string         `json:"default"`
+	LoadedAt time.Time      `json:"loadedAt"`
+	Bundles  []PolicyBundle `json:"bundles"`
+}
+
+type policySet struct {
+	PolicySet
+	rules []*PolicyRule
+	hash  [32]byte
+}
+
+var policies = struct {
+	path    string
+	current atomic.Pointer[policySet]
+	denials atomic.Int64
+	reload  sync.Mutex
+}{}
+
+// loadPolicies reads POLICY_PATH, a bundle file or a directory of .json
+// bundles. Unset, no policy is evaluated.
+func loadPolicies() {
+	policies.path = os.Getenv("POLICY_PATH")
+	if policies.path == "" {
+		return
+	}
+	if _, err := reloadPolicies(); err != nil {
+		log.Fatalf("POLICY_PATH: %v", err)
+	}
+}
+
+func policyFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") && filepath.Ext(e.Name()) == ".json" {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+// readPolicies parses and validates every bundle under path, in file name
+// order.
+func readPolicies(path string) (*policySet, error) {
+	files, err := policyFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &policySet{PolicySet: PolicySet{Path: path, Default: PolicyAllow, LoadedAt: time.Now().UTC(), Bundles: []PolicyBundle{}}}
+	h := sha256.New()
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		h.Write([]byte(f))
+		h.Write(b)
+		var bundle PolicyBundle
+		if err := json.Unmarshal(b, &bundle); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		if bundle.Name == "" {
+			bundle.Name = strings.TrimSuffix(filepath.Base(f), ".json")
+		}
+		if err := set.add(bundle); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+	}
+	copy(set.hash[:], h.Sum(nil))
+	return set, nil
+}
+
+func (s *policySet) add(b PolicyBundle) error {
+	switch b.Default {
+	case "", PolicyAllow:
+	case PolicyDeny:
+		s.Default = PolicyDeny
+	default:
+		return errors.New(`default must be "allow" or "deny"`)
+	}
+	ids := map[string]bool{}
+	for _, r := range s.rules {
+		ids[r.ID] = true
+	}
+	for i, r := range b.Rules {
+		if r == nil {
+			return fmt.Errorf("rule %d is empty", i)
+		}
+		if r.ID == "" {
+			r.ID = fmt.Sprintf("%s/%d", b.Name, i+1)
+		}
+		if ids[r.ID] {
+			return fmt.Errorf("rule %s is defined twice", r.ID)
+		}
+		ids[r.ID] = true
+		if r.Effect != PolicyAllow && r.Effect != PolicyDeny {
+			return fmt.Errorf(`rule %s: effect must be "allow" or "deny"`, r.ID)
+		}
+		for _, rt := range r.Routes {
+			if rt != "*" && !strings.HasPrefix(rt, "/") {
+				return fmt.Errorf("rule %s: route %q must start with /", r.ID, rt)
+			}
+		}
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("rule %s: when: %v", r.ID, err)
+		}
+		r.Bundle = b.Name
+		s.rules = append(s.rules, r)
+	}
+	if b.Rules == nil {
+		b.Rules = []*PolicyRule{}
+	}
+	s.Bundles = append(s.Bundles, b)
+	return nil
+}
+
+// reloadPolicies reads POLICY_PATH again and swaps the bundles in if they
+// changed. On error the previous bundles stay in force.
+func reloadPolicies() (bool, error) {
+	policies.reload.Lock()
+	defer policies.reload.Unlock()
+	set, err := readPolicies(policies.path)
+	if err != nil {
+		return false, err
+	}
+	if old := policies.current.Load(); old != nil && old.hash == set.hash {
+		return false, nil
+	}
+	policies.current.Store(set)
+	return true, nil
+}
+
+// runPolicyReload picks up edited, added and removed bundles without a
+// restart.
+func runPolicyReload(ctx context.Context) {
+	if policies.path == "" {
+		return
+	}
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			changed, err := reloadPolicies()
+			if err != nil {
+				log.Printf("policies: keeping previous bundles: %v", err)
+				recordSystemOperation("policy reload", err)
+			} else if changed {
+				log.Printf("policies reloaded: %d rules", len(policies.current.Load().rules))
+				recordSystemOperation("policy reload", nil)
+			}
+		}
+	}
+}
+
+// vars binds the input to policyEnv's variables. The principal only has
+// the dealerId and tenant keys when the key has them, and amount is 0 when
+// the body has none.
+func (in PolicyInput) vars() map[string]any {
+	principal := map[string]string{}
+	if p := in.Principal; p != nil {
+		principal["name"], principal["role"] = p.Name, p.Role
+		if p.DealerID != "" {
+			principal["dealerId"] = p.DealerID
+		}
+		if p.Tenant != "" {
+			principal["tenant"] = p.Tenant
+		}
+	}
+	params, query := in.Params, in.Query
+	if params == nil {
+		params = map[string]string{}
+	}
+	if query == nil {
+		query = map[string]string{}
+	}
+	var amount float64
+	if in.Amount != nil {
+		amount = *in.Amount
+	}
+	return map[string]any{"principal": principal, "method": in.Method, "route": in.Route, "path": in.Path, "params": params, "query": query, "dealer": in.Dealer, "amount": amount, "function": in.Function}
+}
+
+func policyNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// matches reports whether r applies to in. A When that fails to evaluate,
+// such as one reading principal.role for an anonymous caller, matches for a
+// deny rule and not for an allow rule, so errors never let a request in.
+func (r *PolicyRule) matches(in PolicyInput, vars map[string]any) bool {
+	if len(r.Methods) > 0 && !containsFold(r.Methods, in.Method) {
+		return false
+	}
+	if len(r.Routes) > 0 {
+		found := false
+		for _, rt := range r.Routes {
+			prefix, wild := strings.CutSuffix(rt, "*")
+			found = found || rt == in.Route || wild && strings.HasPrefix(in.Route, prefix)
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.program == nil {
+		return true
+	}
+	out, _, err := r.program.Eval(vars)
+	if err != nil {
+		return r.Effect == PolicyDeny
+	}
+	return out.Value() == true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate decides in: any matching deny rule refuses it, the first one
+// named; otherwise a matching allow rule or an "allow" default lets it
+// through.
+func (s *policySet) evaluate(in PolicyInput) PolicyDecision {
+	vars := in.vars()
+	d := PolicyDecision{Allowed: s.Default == PolicyAllow, Matched: []string{}}
+	var deny *PolicyRule
+	allowed := false
+	for _, r := range s.rules {
+		if !r.matches(in, vars) {
+			continue
+		}
+		d.Matched = append(d.Matched, r.ID)
+		if r.Effect == PolicyDeny && deny == nil {
+			deny = r
+		}
+		allowed = allowed || r.Effect == PolicyAllow
+	}
+	switch {
+	case deny != nil:
+		d.Allowed, d.Rule, d.Reason = false, deny.ID, deny.Reason
+		if d.Reason == "" {
+			d.Reason = "rule " + deny.ID
+		}
+	case allowed:
+		d.Allowed = true
+	case !d.Allowed:
+		d.Reason = "no rule allows the request"
+	}
+	return d
+}
+
+// policyInput builds the input for c. The principal is looked up from the
+// presented key here, ahead of the route's own authentication, and the body
+// is read for its amount and function and put back for the handler. Handlers
+// bind JSON whatever the Content-Type, so the body is read whatever it says.
+// It reports false for a body too large or broken to read.
+func policyInput(c *gin.Context) (PolicyInput, bool) {
+	in := PolicyInput{Method: c.Request.Method, Route: c.FullPath(), Path: c.Request.URL.Path, Params: map[string]string{}, Query: map[string]string{}}
+	if p, ok := principalForKey(presentedKey(c)); ok {
+		in.Principal = &p
+	}
+	for _, p := range c.Params {
+		in.Params[p.Key] = p.Value
+	}
+	for k, v := range c.Request.URL.Query() {
+		in.Query[k] = v[0]
+	}
+	in.Dealer = c.Param("dealerId")
+	if in.Dealer == "" && in.Principal != nil {
+		in.Dealer = in.Principal.DealerID
+	}
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return in, true
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPolicyBody+1))
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+	if err != nil || len(body) > maxPolicyBody {
+		return in, false
+	}
+	var fields struct {
+		Amount   json.RawMessage `json:"amount"`
+		Function string          `json:"function"`
+	}
+	if json.Unmarshal(body, &fields) == nil {
+		if n, ok := policyNumber(decodeAmount(fields.Amount)); ok {
+			in.Amount = &n
+		}
+		in.Function = fields.Function
+	}
+	return in, true
+}
+
+func decodeAmount(raw json.RawMessage) any {
+	var v any
+	if len(raw) == 0 || json.Unmarshal(raw, &v) != nil {
+		return nil
+	}
+	return v
+}
+
+// policyGuard refuses requests the policy bundles deny with 403
+// POLICY_DENIED. Health probes are never evaluated.
+func policyGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		set := policies.current.Load()
+		if set == nil || c.FullPath() == "/health" || c.FullPath() == "/readyz" {
+			c.Next()
+			return
+		}
+		in, ok := policyInput(c)
+		if !ok {
+			apiError(c, 413, ErrInvalidBody, gin.H{"detail": fmt.Sprintf("body over %d bytes", maxPolicyBody)})
+			return
+		}
+		d := set.evaluate(in)
+		if !d.Allowed {
+			policies.denials.Add(1)
+			apiError(c, 403, ErrPolicyDenied, gin.H{"rule": d.Rule, "reason": d.Reason})
+			return
+		}
+		c.Next()
+	}
+}
+
+func listPoliciesHandler(c *gin.Context) {
+	set := policies.current.Load()
+	if set == nil {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	c.JSON(200, set.PolicySet)
+}
+
+func reloadPoliciesHandler(c *gin.Context) {
+	if policies.path == "" {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	if _, err := reloadPolicies(); err != nil {
+		apiError(c, 422, ErrInvalidPolicy, gin.H{"reason": err.Error()})
+		return
+	}
+	c.JSON(200, policies.current.Load().PolicySet)
+}
+
+// policyEvalRequest is a dry run: input is evaluated against the bundles in
+// force, or against bundles when given, so a policy can be tried before it
+// is deployed. Nothing is enforced or counted.
+type policyEvalRequest struct {
+	Input   PolicyInput    `json:"input"`
+	Bundles []PolicyBundle `json:"bundles"`
+}
+
+func evaluatePolicyHandler(c *gin.Context) {
+	var req policyEvalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	set := policies.current.Load()
+	if req.Bundles != nil {
+		set = &policySet{PolicySet: PolicySet{Default: PolicyAllow}}
+		for i, b := range req.Bundles {
+			if b.Name == "" {
+				b.Name = fmt.Sprintf("bundle%d", i+1)
+			}
+			if err := set.add(b); err != nil {
+				apiError(c, 400, ErrInvalidPolicy, gin.H{"reason": err.Error()})
+				return
+			}
+		}
+	}
+	if set == nil {
+		set = &policySet{PolicySet: PolicySet{Default: PolicyAllow}}
+	}
+	if req.Input.Method == "" || req.Input.Route == "" {
+		apiError(c, 400, ErrParamRequired, gin.H{"param": "input.method, input.route"})
+		return
+	}
+	req.Input.Method = strings.ToUpper(req.Input.Method)
+	if req.Input.Path == "" {
+		req.Input.Path = req.Input.Route
+	}
+	if req.Input.Dealer == "" {
+		if d := req.Input.Params["dealerId"]; d != "" {
+			req.Input.Dealer = d
+		} else if req.Input.Principal != nil {
+			req.Input.Dealer = req.Input.Principal.DealerID
+		}
+	}
+	c.JSON(200, set.evaluate(req.Input))
+}
+
+func writePolicyMetrics(b *strings.Builder) {
+	set := policies.current.Load()
+	if set == nil {
+		return
+	}
+	b.WriteString("# HELP fabric_api_policy_rules Policy rules in force.\n")
+	b.WriteString("# TYPE fabric_api_policy_rules gauge\n")
+	fmt.Fprintf(b, "fabric_api_policy_rules %d\n", len(set.rules))
+	b.WriteString("# HELP fabric_api_policy_denials_total Requests refused by a policy rule or the deny default.\n")
+	b.WriteString("# TYPE fabric_api_policy_denials_total counter\n")
+	fmt.Fprintf(b, "fabric_api_policy_denials_total %d\n", policies.denials.Load())
+	b.WriteString("# HELP fabric_api_policy_loaded_timestamp_seconds When the bundles in force were loaded.\n")
+	b.WriteString("# TYPE fabric_api_policy_loaded_timestamp_seconds gauge\n")
+	fmt.Fprintf(b, "fabric_api_policy_loaded_timestamp_seconds %d\n", set.LoadedAt.Unix())
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// pooledConn is one connection of the pool. inflight counts unary calls in
+// progress and streams still open.
+type pooledConn struct {
+	conn     *grpc.ClientConn
+	inflight atomic.Int64
+	healthy  atomic.Bool
+}
+
+// connPool spreads gateway calls over several connections to the gateway
+// peer, so concurrent requests are not all multiplexed onto one HTTP/2
+// connection and its stream limit. Each call goes to the healthy connection
+// with the fewest calls in flight.
+type connPool struct {
+	conns []*pooledConn
+	// next rotates where the search starts, so idle connections share the
+	// load instead of the first one taking every call.
+	next     atomic.Uint64
+	interval time.Duration
+}
+
+// dialPool opens size connections to target with opts. Connections are
+// considered healthy until a check or a call says otherwise.
+func dialPool(target string, size int, opts ...grpc.DialOption) (*connPool, error) {
+	p := &connPool{interval: 10 * time.Second}
+	if v := os.Getenv("GRPC_POOL_HEALTH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("GRPC_POOL_HEALTH_INTERVAL: must be a positive duration")
+		}
+		p.interval = d
+	}
+	for i := 0; i < size; i++ {
+		conn, err := grpc.Dial(target, opts...)
+		if err != nil {
+			for _, c := range p.conns {
+				c.conn.Close()
+			}
+			return nil, err
+		}
+		c := &pooledConn{conn: conn}
+		c.healthy.Store(true)
+		p.conns = append(p.conns, c)
+	}
+	return p, nil
+}
+
+// poolSize is GRPC_POOL_SIZE, default 4. With DISCOVERY the manual resolver
+// can only serve one connection, which already balances over the
+// discovered gateways, so the pool has one.
+func poolSize() int {
+	if os.Getenv("DISCOVERY") == "true" {
+		return 1
+	}
+	v := os.Getenv("GRPC_POOL_SIZE")
+	if v == "" {
+		return 4
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > 64 {
+		log.Fatalf("GRPC_POOL_SIZE: must be 1-64")
+	}
+	return n
+}
+
+// pick returns the least loaded healthy connection, or the least loaded of
+// all when none is healthy, so calls still go out and can succeed as soon as
+// the peer is back.
+func (p *connPool) pick() *pooledConn {
+	start := int(p.next.Add(1) % uint64(len(p.conns)))
+	var best *pooledConn
+	for i := range p.conns {
+		c := p.conns[(start+i)%len(p.conns)]
+		switch {
+		case best == nil,
+			c.healthy.Load() && !best.healthy.Load(),
+			c.healthy.Load() == best.healthy.Load() && c.inflight.Load() < best.inflight.Load():
+			best = c
+		}
+	}
+	return best
+}
+
+// failed marks c unhealthy after a call found the peer unreachable; the
+// next health check brings it back once it reconnects.
+func (c *pooledConn) failed(err error) {
+	if status.Code(err) == codes.Unavailable {
+		c.healthy.Store(false)
+	}
+}
+
+func (p *connPool) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	c := p.pick()
+	c.inflight.Add(1)
+	defer c.inflight.Add(-1)
+	err := c.conn.Invoke(ctx, method, args, reply, opts...)
+	c.failed(err)
+	return err
+}
+
+// NewStream opens a stream, such as chaincode or block events, on the least
+// loaded connection. It counts as in flight until it ends.
+func (p *connPool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	c := p.pick()
+	c.inflight.Add(1)
+	s, err := c.conn.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		c.inflight.Add(-1)
+		c.failed(err)
+		return nil, err
+	}
+	ps := &pooledStream{ClientStream: s, conn: c}
+	go func() {
+		<-s.Context().Done()
+		ps.finish()
+	}()
+	return ps, nil
+}
+
+// pooledStream releases its connection's count when it ends, whether the
+// server closed it or its context was cancelled.
+type pooledStream struct {
+	grpc.ClientStream
+	conn *pooledConn
+	once sync.Once
+}
+
+func (s *pooledStream) finish() {
+	s.once.Do(func() { s.conn.inflight.Add(-1) })
+}
+
+func (s *pooledStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.finish()
+	}
+	return err
+}
+
+// watch checks every connection's state each interval until ctx is
+// cancelled. Idle connections are asked to connect, so a reconnect is
+// noticed without waiting for a call; a connection is healthy unless it is
+// in transient failure or shut down.
+func (p *connPool) watch(ctx context.Context) {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		for _, c := range p.conns {
+			state := c.conn.GetState()
+			if state == connectivity.Idle {
+				c.conn.Connect()
+			}
+			c.healthy.Store(state != connectivity.TransientFailure && state != connectivity.Shutdown)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func writePoolMetrics(b *strings.Builder) {
+	if peerPool == nil {
+		return
+	}
+	b.WriteString("# HELP fabric_api_grpc_pool_inflight Gateway calls and streams in flight per pooled connection.\n")
+	b.WriteString("# TYPE fabric_api_grpc_pool_inflight gauge\n")
+	for i, c := range peerPool.conns {
+		fmt.Fprintf(b, "fabric_api_grpc_pool_inflight{conn=\"%d\"} %d\n", i, c.inflight.Load())
+	}
+	b.WriteString("# HELP fabric_api_grpc_pool_healthy Whether a pooled connection is healthy (1) or not (0).\n")
+	b.WriteString("# TYPE fabric_api_grpc_pool_healthy gauge\n")
+	for i, c := range peerPool.conns {
+		v := 0
+		if c.healthy.Load() {
+			v = 1
+		}
+		fmt.Fprintf(b, "fabric_api_grpc_pool_healthy{conn=\"%d\"} %d\n", i, v)
+	}
+}
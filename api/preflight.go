@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// preflightReport collects the startup checks and writes each as it
+// completes, so a check that hangs or a config loader that exits later still
+// leaves the earlier results on screen.
+type preflightReport struct {
+	out    io.Writer
+	warn   time.Duration
+	failed bool
+}
+
+func newPreflightReport(out io.Writer) *preflightReport {
+	r := &preflightReport{out: out, warn: 30 * 24 * time.Hour}
+	if v := os.Getenv("PREFLIGHT_CERT_WARN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("PREFLIGHT_CERT_WARN: must be a duration")
+		}
+		r.warn = d
+	}
+	return r
+}
+
+func (r *preflightReport) result(status, name, detail string) {
+	fmt.Fprintf(r.out, "%-4s  %-14s %s\n", status, name, detail)
+}
+
+func (r *preflightReport) ok(name, detail string)    { r.result("ok", name, detail) }
+func (r *preflightReport) warnf(name, detail string) { r.result("WARN", name, detail) }
+
+func (r *preflightReport) fail(name string, err error) {
+	r.failed = true
+	r.result("FAIL", name, err.Error())
+}
+
+// validity checks cert's validity period against now and the warning window.
+func (r *preflightReport) validity(name string, cert *x509.Certificate) {
+	now := time.Now()
+	detail := fmt.Sprintf("%s, expires %s", cert.Subject.CommonName, cert.NotAfter.UTC().Format(time.RFC3339))
+	switch {
+	case now.Before(cert.NotBefore):
+		r.fail(name, fmt.Errorf("%s, not valid before %s", cert.Subject.CommonName, cert.NotBefore.UTC().Format(time.RFC3339)))
+	case now.After(cert.NotAfter):
+		r.fail(name, fmt.Errorf("%s, expired %s", cert.Subject.CommonName, cert.NotAfter.UTC().Format(time.RFC3339)))
+	case cert.NotAfter.Sub(now) < r.warn:
+		r.warnf(name, fmt.Sprintf("%s (in %s)", detail, cert.NotAfter.Sub(now).Round(time.Hour)))
+	default:
+		r.ok(name, detail)
+	}
+}
+
+func parseCertificates(p string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no PEM certificate", p)
+	}
+	return certs, nil
+}
+
+// preflightLocal checks the connection settings and credentials, then dials
+// the gateway peer over TLS and checks its certificate the way the gateway
+// connection will. It returns false if connecting would fail.
+func preflightLocal(r *preflightReport) bool {
+	var missing []string
+	for _, k := range []string{"PEER_ENDPOINT", "GATEWAY_PEER", "MSP_ID", "CHANNEL_NAME", "CHAINCODE_NAME", "TLS_CERT_PATH", "CERT_PATH", "KEY_PATH"} {
+		if os.Getenv(k) == "" {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		r.fail("environment", fmt.Errorf("missing %s", strings.Join(missing, ", ")))
+		return false
+	}
+	r.ok("environment", "peer "+os.Getenv("PEER_ENDPOINT")+", channel "+os.Getenv("CHANNEL_NAME")+", chaincode "+os.Getenv("CHAINCODE_NAME"))
+
+	certs, err := parseCertificates(os.Getenv("CERT_PATH"))
+	if err != nil {
+		r.fail("identity cert", err)
+	} else {
+		r.validity("identity cert", certs[0])
+	}
+	keyPEM, err := os.ReadFile(os.Getenv("KEY_PATH"))
+	if err == nil {
+		var key *ecdsa.PrivateKey
+		key, err = privateKeyFromPEM(keyPEM)
+		if err == nil && certs != nil {
+			if pub, ok := certs[0].PublicKey.(*ecdsa.PublicKey); !ok || !pub.Equal(&key.PublicKey) {
+				err = errors.New("does not match the identity certificate")
+			}
+		}
+	}
+	if err != nil {
+		r.fail("private key", fmt.Errorf("%s: %w", os.Getenv("KEY_PATH"), err))
+	} else {
+		r.ok("private key", "matches the identity certificate")
+	}
+
+	roots, err := loadTLSRoots(os.Getenv("TLS_CERT_PATH"))
+	if err != nil {
+		r.fail("TLS roots", err)
+		return false
+	}
+	for _, c := range roots.certs {
+		r.validity("TLS roots", c)
+	}
+	pool := roots.pool
+
+	peer, serverName := os.Getenv("PEER_ENDPOINT"), os.Getenv("GATEWAY_PEER")
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: 10 * time.Second}, Config: &tls.Config{ServerName: serverName, InsecureSkipVerify: true}}
+	conn, err := dialer.Dial("tcp", peer)
+	if err != nil {
+		r.fail("peer dial", err)
+		return false
+	}
+	state := conn.(*tls.Conn).ConnectionState()
+	conn.Close()
+	r.ok("peer dial", peer+" ("+tls.VersionName(state.Version)+")")
+	leaf := state.PeerCertificates[0]
+	inter := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		inter.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: pool, Intermediates: inter}); err != nil {
+		r.fail("peer TLS", fmt.Errorf("not signed by TLS_CERT_PATH: %w", err))
+		return false
+	}
+	r.validity("peer TLS", leaf)
+	if err := leaf.VerifyHostname(serverName); err != nil {
+		r.fail("TLS hostname", fmt.Errorf("GATEWAY_PEER %s: %w", serverName, err))
+		return false
+	}
+	r.ok("TLS hostname", serverName)
+	return !r.failed
+}
+
+// preflightGateway pings the chaincode through the connected gateway, which
+// proves the identity, channel, chaincode name and chaincode container
+// together, and compares the chaincode's clock with ours.
+func preflightGateway(r *preflightReport) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p, err := pingChaincode(ctx)
+	if err != nil {
+		fe := classify(err)
+		msg := fe.Error
+		for _, d := range fe.Details {
+			msg += "; " + d.Address + ": " + d.Message
+		}
+		r.fail("chaincode", errors.New(msg))
+		return
+	}
+	r.ok("chaincode", fmt.Sprintf("Ping answered in %dms: version %s, marked height %d", p.LatencyMs, p.Version, p.Height))
+	skew := time.Since(time.UnixMilli(p.ServerTime)) - time.Duration(p.LatencyMs)*time.Millisecond/2
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > time.Minute {
+		r.warnf("clock skew", fmt.Sprintf("chaincode clock is %s off; transaction timestamps and expiries will disagree", skew.Round(time.Second)))
+	} else {
+		r.ok("clock skew", skew.Round(time.Millisecond).String())
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// BalanceProof is chaincode ProveBalanceAtBlock's answer.
+type BalanceProof struct {
+	MSISDN          string   `json:"msisdn"`
+	BlockNumber     uint64   `json:"blockNumber"`
+	Exists          bool     `json:"exists"`
+	Balance         int64    `json:"balance"`
+	TxID            string   `json:"txId,omitempty"`
+	TxBlock         uint64   `json:"txBlock,omitempty"`
+	SupportingTxIDs []string `json:"supportingTxIds"`
+}
+
+// Committed blocks never change, so txID to block lookups are cached forever.
+var txBlocks sync.Map
+
+func blockOfTx(txID string) (uint64, error) {
+	if n, ok := txBlocks.Load(txID); ok {
+		return n.(uint64), nil
+	}
+	res, err := qscc.EvaluateTransaction("GetBlockByTxID", network.Name(), txID)
+	if err != nil {
+		return 0, err
+	}
+	var b common.Block
+	if err := proto.Unmarshal(res, &b); err != nil {
+		return 0, err
+	}
+	n := b.GetHeader().GetNumber()
+	txBlocks.Store(txID, n)
+	return n, nil
+}
+
+// proofBlocks looks up the blocks ProveBalanceAtBlock needs, which the
+// chaincode cannot look up itself: those of the first and last history
+// entries, which give the order, and of each entry a binary search for block
+// visits. The chaincode repeats the same search over the same history.
+func proofBlocks(h []History, block uint64) (map[string]uint64, error) {
+	known := map[string]uint64{}
+	lookup := func(txID string) (uint64, error) {
+		n, err := blockOfTx(txID)
+		if err == nil {
+			known[txID] = n
+		}
+		return n, err
+	}
+	if len(h) == 0 {
+		return known, nil
+	}
+	first, err := lookup(h[0].TxID)
+	if err != nil {
+		return nil, err
+	}
+	last, err := lookup(h[len(h)-1].TxID)
+	if err != nil {
+		return nil, err
+	}
+	if first > last {
+		for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+			h[i], h[j] = h[j], h[i]
+		}
+	}
+	var searchErr error
+	sort.Search(len(h), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		n, err := lookup(h[i].TxID)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return n > block
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	return known, nil
+}
+
+func balanceProofHandler(c *gin.Context) {
+	msisdn := c.Param("msisdn")
+	block, err := strconv.ParseUint(c.Query("block"), 10, 64)
+	if err != nil {
+		apiError(c, 400, ErrInvalidBlockNumber, gin.H{"param": "block"})
+		return
+	}
+	opts, ok := proposalOptions(c, msisdn)
+	if !ok {
+		return
+	}
+	res, err := evaluate(c, "GetAssetHistory", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var h []History
+	if err := json.Unmarshal(res, &h); err != nil {
+		internalError(c, err)
+		return
+	}
+	known, err := proofBlocks(h, block)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	arg, err := json.Marshal(known)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if opts, ok = proposalOptions(c, msisdn, strconv.FormatUint(block, 10), string(arg)); !ok {
+		return
+	}
+	res, err = evaluate(c, "ProveBalanceAtBlock", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var proof BalanceProof
+	if err := json.Unmarshal(res, &proof); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, &proof)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// PublicStatus is all the public API says about an account.
+type PublicStatus struct {
+	MSISDN string `json:"msisdn"`
+	Exists bool   `json:"exists"`
+	STATUS string `json:"STATUS,omitempty"`
+}
+
+// rateBucket is a token bucket refilled at rate tokens per second up to
+// burst.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *rateBucket) take(now time.Time, rate, burst float64) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+var public = struct {
+	sync.Mutex
+	origins []string
+	rate    float64 // per client IP, per second
+	burst   float64
+	global  float64 // all clients together, per second
+	clients map[string]*rateBucket
+	all     rateBucket
+	limited int64
+	allowed int64
+}{clients: map[string]*rateBucket{}}
+
+// loadPublic reads PUBLIC_RATE, requests per minute from one client IP
+// (default 30), PUBLIC_BURST (default 10), PUBLIC_RATE_GLOBAL, requests per
+// minute from all clients together (default 600), and PUBLIC_CORS_ORIGINS,
+// the origins allowed to call the public routes from a browser (default
+// any).
+func loadPublic() {
+	perMinute := func(name string, def float64) float64 {
+		v := os.Getenv(name)
+		if v == "" {
+			return def
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("%s must be a positive number", name)
+		}
+		return n
+	}
+	public.Lock()
+	defer public.Unlock()
+	public.rate = perMinute("PUBLIC_RATE", 30) / 60
+	public.burst = perMinute("PUBLIC_BURST", 10)
+	public.global = perMinute("PUBLIC_RATE_GLOBAL", 600) / 60
+	public.all = rateBucket{tokens: public.global * 10, last: time.Now()}
+	for _, o := range strings.Split(os.Getenv("PUBLIC_CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			public.origins = append(public.origins, o)
+		}
+	}
+}
+
+// publicCORS allows simple cross-origin GETs without credentials and
+// answers preflights itself.
+func publicCORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin != "" {
+			allowed := len(public.origins) == 0
+			for _, o := range public.origins {
+				allowed = allowed || o == origin
+			}
+			if allowed {
+				if len(public.origins) == 0 {
+					c.Header("Access-Control-Allow-Origin", "*")
+				} else {
+					c.Header("Access-Control-Allow-Origin", origin)
+					c.Header("Vary", "Origin")
+				}
+				c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
+				c.Header("Access-Control-Allow-Headers", "Accept-Language")
+				c.Header("Access-Control-Max-Age", "600")
+			}
+		}
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
+
+// publicRateLimit answers 429 once the client IP or all public clients
+// together are over their rate. Idle client buckets are dropped once full
+// again.
+func publicRateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		ip := c.ClientIP()
+		public.Lock()
+		b, ok := public.clients[ip]
+		if !ok {
+			if len(public.clients) > 10000 {
+				for k, v := range public.clients {
+					if now.Sub(v.last).Seconds()*public.rate >= public.burst {
+						delete(public.clients, k)
+					}
+				}
+			}
+			b = &rateBucket{tokens: public.burst, last: now}
+			public.clients[ip] = b
+		}
+		allowed := b.take(now, public.rate, public.burst) && public.all.take(now, public.global, public.global*10)
+		if allowed {
+			public.allowed++
+		} else {
+			public.limited++
+		}
+		public.Unlock()
+		if !allowed {
+			c.Header("Retry-After", "60")
+			apiError(c, 429, ErrRateLimited, gin.H{"retryable": true})
+			return
+		}
+		c.Next()
+	}
+}
+
+// publicStatusHandler reports whether :msisdn exists and its STATUS. It
+// reads through the API's own identity, so the answer is the same for every
+// caller, and nothing else of the account leaves the handler.
+func publicStatusHandler(c *gin.Context) {
+	msisdn := c.Param("msisdn")
+	if fromIndex(c, "public-status") {
+		a, found := indexedAccount(msisdn)
+		c.JSON(200, PublicStatus{MSISDN: msisdn, Exists: found, STATUS: a.STATUS})
+		return
+	}
+	res, err := evaluateNearest(c.Request.Context(), "ReadAsset", client.WithArguments(msisdn))
+	if err != nil {
+		if notFound(err) {
+			c.JSON(200, PublicStatus{MSISDN: msisdn})
+			return
+		}
+		// The gateway's own text names peers and chaincode internals, so
+		// public callers only learn that the ledger did not answer.
+		log.Printf("public status %s: %v", msisdn, err)
+		apiError(c, 503, ErrFabricUnavailable, gin.H{"retryable": true})
+		return
+	}
+	var a struct{ STATUS string }
+	if err := json.Unmarshal(res, &a); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(200, PublicStatus{MSISDN: msisdn, Exists: true, STATUS: a.STATUS})
+}
+
+func writePublicMetrics(b *strings.Builder) {
+	public.Lock()
+	defer public.Unlock()
+	b.WriteString("# HELP fabric_api_public_requests_total Public route requests by rate limit outcome.\n")
+	b.WriteString("# TYPE fabric_api_public_requests_total counter\n")
+	fmt.Fprintf(b, "fabric_api_public_requests_total{result=\"allowed\"} %d\n", public.allowed)
+	fmt.Fprintf(b, "fabric_api_public_requests_total{result=\"limited\"} %d\n", public.limited)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// pageKey identifies a cached page: the query function, its selector (the
+// dealer, or "" for all accounts), the bookmark it starts at and its size.
+type pageKey struct {
+	function string
+	dealer   string
+	bookmark string
+	pageSize int
+}
+
+type cachedPage struct {
+	page    AssetPage
+	expires time.Time
+	members map[string]bool // MSISDNs on the page and their parents
+}
+
+// covers reports whether a write to msisdn can change the page. Bookmarks are
+// keys, so a page holds the first pageSize matching keys from its bookmark up
+// to the next page's bookmark.
+func (p *cachedPage) covers(key pageKey, msisdn string) bool {
+	return msisdn >= key.bookmark && (p.page.Bookmark == "" || msisdn < p.page.Bookmark)
+}
+
+// queryCache keeps asset pages until a chaincode event touches them or ttl
+// passes. Every replica follows events itself; while it is not subscribed
+// nothing is served from or stored in the cache.
+var queryCache = struct {
+	sync.Mutex
+	ttl          time.Duration
+	size         int
+	live         bool
+	pages        map[pageKey]*cachedPage
+	gen          uint64 // bumped by every invalidation
+	hits, misses int
+}{ttl: 30 * time.Second, size: 1000, pages: map[pageKey]*cachedPage{}}
+
+// loadQueryCache reads QUERY_CACHE_TTL (default 30s, 0 disables caching) and
+// QUERY_CACHE_SIZE (default 1000 pages).
+func loadQueryCache() {
+	if v := os.Getenv("QUERY_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("QUERY_CACHE_TTL: %v", err)
+		}
+		queryCache.ttl = d
+	}
+	if v := os.Getenv("QUERY_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatal("QUERY_CACHE_SIZE must be a positive number")
+		}
+		queryCache.size = n
+	}
+}
+
+// cachedAssetsPage evaluates a paging function (GetAssetsPage or
+// GetAssetsPageByDealer) through the cache and writes the page or the error.
+// A cached page keeps the meta of the query that filled it, height included.
+// Requests carrying an encryption key bypass it, so decrypted fields are
+// never kept.
+func cachedAssetsPage(c *gin.Context, key pageKey, opts []client.ProposalOption) {
+	usable := c.GetHeader(encryptionKeyHeader) == "" && !signedRequested(c)
+	var gen uint64
+	if usable {
+		queryCache.Lock()
+		gen = queryCache.gen
+		p, ok := queryCache.pages[key]
+		if ok && time.Now().After(p.expires) {
+			delete(queryCache.pages, key)
+			ok = false
+		}
+		if ok {
+			queryCache.hits++
+		} else {
+			queryCache.misses++
+		}
+		queryCache.Unlock()
+		if ok {
+			c.Header("X-Cache", "HIT")
+			respond(c, 200, p.page)
+			return
+		}
+	}
+	height := chainHeight(c)
+	res, err := evaluate(c, key.function, opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var page AssetPage
+	if err := json.Unmarshal(res, &page); err != nil {
+		internalError(c, err)
+		return
+	}
+	page.Meta = ListMeta{ReturnedCount: len(page.Records), PageSize: key.pageSize, NextCursor: page.Bookmark, HasMore: pageHasMore(page.Bookmark, page.Fetched, key.pageSize), FetchedAtBlockHeight: height}
+	if usable {
+		storePage(key, page, gen)
+		c.Header("X-Cache", "MISS")
+	}
+	respond(c, 200, page)
+}
+
+// storePage caches page unless an invalidation ran since gen was read, in
+// which case the page may predate the write that caused it.
+func storePage(key pageKey, page AssetPage, gen uint64) {
+	queryCache.Lock()
+	defer queryCache.Unlock()
+	if !queryCache.live || queryCache.ttl <= 0 || queryCache.gen != gen {
+		return
+	}
+	if len(queryCache.pages) >= queryCache.size {
+		var oldest pageKey
+		var first time.Time
+		for k, p := range queryCache.pages {
+			if first.IsZero() || p.expires.Before(first) {
+				oldest, first = k, p.expires
+			}
+		}
+		delete(queryCache.pages, oldest)
+	}
+	members := map[string]bool{}
+	for _, a := range page.Records {
+		members[a.MSISDN] = true
+		if a.PARENT != "" {
+			// Status cascades rewrite sub-accounts without an event of
+			// their own, so the parent's event stands in for them.
+			members[a.PARENT] = true
+		}
+	}
+	queryCache.pages[key] = &cachedPage{page: page, expires: time.Now().Add(queryCache.ttl), members: members}
+}
+
+type cacheEvent struct {
+	MSISDN   string   `json:"MSISDN"`
+	DEALERID string   `json:"DEALERID"`
+	MSISDNs  []string `json:"MSISDNs"`
+}
+
+// invalidatePages drops every page the event can have changed: pages that
+// hold the account, and pages of a matching selector whose key range takes
+// it in. AssetDeleted's dealer adds nothing, as a deleted account can only
+// change pages that held it. AssetsPosted, which only changes balances of
+// existing accounts, and AssetsMerged, which also removes one, carry none.
+func invalidatePages(ev *client.ChaincodeEvent) {
+	var e cacheEvent
+	if err := json.Unmarshal(ev.Payload, &e); err != nil {
+		log.Printf("query cache: event %s: %v", ev.EventName, err)
+		flushPages(true)
+		return
+	}
+	queryCache.Lock()
+	defer queryCache.Unlock()
+	queryCache.gen++
+	for k, p := range queryCache.pages {
+		switch ev.EventName {
+		case "DealerAssetsDeleted":
+			if k.dealer == "" || k.dealer == e.DEALERID {
+				delete(queryCache.pages, k)
+			}
+		case "AssetsPosted", "AssetsMerged":
+			for _, m := range e.MSISDNs {
+				if p.members[m] {
+					delete(queryCache.pages, k)
+					break
+				}
+			}
+		default:
+			if p.members[e.MSISDN] || (k.dealer == "" || k.dealer == e.DEALERID) && p.covers(k, e.MSISDN) {
+				delete(queryCache.pages, k)
+			}
+		}
+	}
+}
+
+func flushPages(live bool) {
+	queryCache.Lock()
+	defer queryCache.Unlock()
+	queryCache.pages = map[pageKey]*cachedPage{}
+	queryCache.live = live
+	queryCache.gen++
+}
+
+// runQueryCache follows chaincode events from the current block on and
+// invalidates cached pages until ctx is cancelled. Whenever the stream is down
+// the cache is emptied and bypassed, since writes could go unseen.
+func runQueryCache(ctx context.Context) {
+	if queryCache.ttl <= 0 {
+		return
+	}
+	for ctx.Err() == nil {
+		events, err := network.ChaincodeEvents(ctx, contract.ChaincodeName())
+		if err != nil {
+			log.Printf("query cache events: %v", err)
+		} else {
+			flushPages(true)
+			for ev := range events {
+				invalidatePages(ev)
+			}
+		}
+		flushPages(false)
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func queryCacheStats() (hits, misses, pages int) {
+	queryCache.Lock()
+	defer queryCache.Unlock()
+	return queryCache.hits, queryCache.misses, len(queryCache.pages)
+}
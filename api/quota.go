@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// DealerQuota is a dealer's account quota (0 for no limit) and how many
+// accounts it has.
+type DealerQuota struct {
+	DEALERID string `json:"DEALERID" xml:"DEALERID"`
+	Quota    int64  `json:"quota" xml:"quota"`
+	Default  bool   `json:"default" xml:"default"`
+	Used     int64  `json:"used" xml:"used"`
+}
+
+type setQuotaRequest struct {
+	Quota *int64 `json:"quota"`
+}
+
+func quotaAnswer(c *gin.Context, res []byte, write bool) {
+	var q DealerQuota
+	if err := json.Unmarshal(res, &q); err != nil {
+		internalError(c, err)
+		return
+	}
+	if write {
+		c.JSON(200, q)
+		return
+	}
+	respond(c, 200, q)
+}
+
+// dealerQuotaHandler shows :dealerId's quota usage; a dealer key only its
+// own.
+func dealerQuotaHandler(c *gin.Context) {
+	dealerID := c.Param("dealerId")
+	if p := principal(c); p.Role == RoleDealer && p.DealerID != dealerID {
+		apiError(c, 403, ErrForbidden, nil)
+		return
+	}
+	res, err := evaluate(c, "GetDealerQuota", client.WithArguments(dealerID))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	quotaAnswer(c, res, false)
+}
+
+// setDealerQuotaHandler sets :dealerId's quota; "*" is the default for
+// dealers without their own.
+func setDealerQuotaHandler(c *gin.Context) {
+	var req setQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if req.Quota == nil {
+		apiError(c, 400, ErrInvalidBody, gin.H{"detail": "quota is required"})
+		return
+	}
+	res, _, err := submit("SetDealerQuota", client.WithArguments(c.Param("dealerId"), strconv.FormatInt(*req.Quota, 10)))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	quotaAnswer(c, res, true)
+}
+
+func recountDealerHandler(c *gin.Context) {
+	res, _, err := submit("RecountDealer", client.WithArguments(c.Param("dealerId")))
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	quotaAnswer(c, res, true)
+}
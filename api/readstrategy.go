@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	readLedger = "ledger"
+	readIndex  = "index"
+	readHybrid = "hybrid"
+
+	defaultMaxLagBlocks = 5
+)
+
+// readEndpoints are the reads the change index can answer, by the names
+// READ_STRATEGIES and /admin/read-strategies use: GET /assets/:msisdn,
+// GET /assets without pageSize and the public status route.
+var readEndpoints = []string{"account", "accounts", "public-status"}
+
+// ReadStrategy says where an endpoint reads from: ledger evaluates on a
+// peer, index answers from this replica's change index, and hybrid uses the
+// index while it is at most MaxLagBlocks behind the channel height and the
+// ledger otherwise.
+type ReadStrategy struct {
+	Mode         string `json:"mode"`
+	MaxLagBlocks uint64 `json:"maxLagBlocks,omitempty"`
+}
+
+var readStrategies = struct {
+	sync.RWMutex
+	byEndpoint map[string]ReadStrategy
+	// served counts reads by endpoint and source.
+	served map[[2]string]uint64
+}{byEndpoint: map[string]ReadStrategy{}, served: map[[2]string]uint64{}}
+
+// loadReadStrategies reads READ_STRATEGIES, e.g. "account=hybrid:5,
+// accounts=index", where hybrid's number is the lag allowed in blocks
+// (default 5). Endpoints not named read from the ledger.
+func loadReadStrategies() {
+	v := os.Getenv("READ_STRATEGIES")
+	if v == "" {
+		return
+	}
+	set := map[string]ReadStrategy{}
+	for _, kv := range strings.Split(v, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(kv, "=")
+		if !ok {
+			log.Fatalf("READ_STRATEGIES: bad entry %q", kv)
+		}
+		mode, lag, hasLag := strings.Cut(strings.TrimSpace(spec), ":")
+		st := ReadStrategy{Mode: mode}
+		if hasLag {
+			n, err := strconv.ParseUint(lag, 10, 64)
+			if err != nil {
+				log.Fatalf("READ_STRATEGIES: bad lag in %q", kv)
+			}
+			st.MaxLagBlocks = n
+		}
+		set[strings.TrimSpace(name)] = st
+	}
+	if err := setReadStrategies(set); err != nil {
+		log.Fatalf("READ_STRATEGIES: %v", err)
+	}
+}
+
+// setReadStrategies checks and applies strategies for the endpoints in set,
+// leaving the others as they are.
+func setReadStrategies(set map[string]ReadStrategy) error {
+	for name, st := range set {
+		if !knownReadEndpoint(name) {
+			return fmt.Errorf("unknown endpoint %q", name)
+		}
+		switch st.Mode {
+		case readLedger, readIndex:
+			if st.MaxLagBlocks != 0 {
+				return fmt.Errorf("%s: maxLagBlocks only applies to %s", name, readHybrid)
+			}
+		case readHybrid:
+			if st.MaxLagBlocks == 0 {
+				st.MaxLagBlocks = defaultMaxLagBlocks
+				set[name] = st
+			}
+		default:
+			return fmt.Errorf("%s: mode must be %s, %s or %s", name, readLedger, readIndex, readHybrid)
+		}
+		if st.Mode != readLedger && !changeIndex.enabled {
+			return fmt.Errorf("%s: %s needs the change index", name, st.Mode)
+		}
+	}
+	readStrategies.Lock()
+	defer readStrategies.Unlock()
+	for name, st := range set {
+		if st.Mode == readLedger {
+			delete(readStrategies.byEndpoint, name)
+		} else {
+			readStrategies.byEndpoint[name] = st
+		}
+	}
+	return nil
+}
+
+func knownReadEndpoint(name string) bool {
+	for _, e := range readEndpoints {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// indexLag is how many blocks the change index is behind the channel height
+// last read from qscc. It is unknown until that height has been read.
+func indexLag() (uint64, bool) {
+	sli.Lock()
+	height := sli.height
+	sli.Unlock()
+	if height == 0 {
+		return 0, false
+	}
+	changeIndex.RLock()
+	next := changeIndex.Next
+	changeIndex.RUnlock()
+	if next >= height {
+		return 0, true
+	}
+	return height - next, true
+}
+
+// fromIndex decides whether this request to endpoint is answered from the
+// change index and sets X-Read-Source to match. Requests with an
+// encryption key or asking for a signed response always go to the ledger:
+// the index holds state as stored and has no endorsement to return.
+func fromIndex(c *gin.Context, endpoint string) bool {
+	readStrategies.RLock()
+	st, ok := readStrategies.byEndpoint[endpoint]
+	readStrategies.RUnlock()
+	use := ok && c.GetHeader(encryptionKeyHeader) == "" && !signedRequested(c) && tenantOf(c).featureEnabled(featureIndexReads)
+	if use && st.Mode == readHybrid {
+		lag, known := indexLag()
+		use = known && lag <= st.MaxLagBlocks
+	}
+	source := readLedger
+	if use {
+		source = readIndex
+	}
+	c.Header("X-Read-Source", source)
+	readStrategies.Lock()
+	readStrategies.served[[2]string{endpoint, source}]++
+	readStrategies.Unlock()
+	return use
+}
+
+// indexedAccount returns msisdn's state as of the index's last block.
+func indexedAccount(msisdn string) (Account, bool) {
+	changeIndex.RLock()
+	defer changeIndex.RUnlock()
+	a, ok := changeIndex.Accounts[msisdn]
+	if !ok {
+		return Account{}, false
+	}
+	return *a, true
+}
+
+// indexedAccounts returns the indexed accounts in MSISDN order, only
+// dealer's unless it is empty, and the height they are current to.
+func indexedAccounts(dealer string) ([]Account, uint64) {
+	changeIndex.RLock()
+	out := []Account{}
+	for _, a := range changeIndex.Accounts {
+		if dealer == "" || a.DEALERID == dealer {
+			out = append(out, *a)
+		}
+	}
+	height := changeIndex.Next
+	changeIndex.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].MSISDN < out[j].MSISDN })
+	return out, height
+}
+
+// indexedAssetsHandler answers GET /assets from the index, like GetAllAssets
+// or, for a dealer, GetAssetsByDealer.
+func indexedAssetsHandler(c *gin.Context, dealer string) {
+	out, height := indexedAccounts(dealer)
+	respond(c, 200, ListResponse[Account]{Records: out, Meta: ListMeta{ReturnedCount: len(out), FetchedAtBlockHeight: height}})
+}
+
+type readStrategiesState struct {
+	Strategies     map[string]ReadStrategy `json:"strategies"`
+	IndexedThrough int64                   `json:"indexedThrough"`
+	Lag            *uint64                 `json:"lagBlocks,omitempty"`
+}
+
+func currentReadStrategies() readStrategiesState {
+	st := readStrategiesState{Strategies: map[string]ReadStrategy{}}
+	readStrategies.RLock()
+	for _, e := range readEndpoints {
+		s, ok := readStrategies.byEndpoint[e]
+		if !ok {
+			s = ReadStrategy{Mode: readLedger}
+		}
+		st.Strategies[e] = s
+	}
+	readStrategies.RUnlock()
+	changeIndex.RLock()
+	st.IndexedThrough = int64(changeIndex.Next) - 1
+	changeIndex.RUnlock()
+	if lag, ok := indexLag(); ok {
+		st.Lag = &lag
+	}
+	return st
+}
+
+func getReadStrategiesHandler(c *gin.Context) {
+	c.JSON(200, currentReadStrategies())
+}
+
+// setReadStrategiesHandler changes the strategies of the endpoints in the
+// body on this replica only, until it restarts; READ_STRATEGIES is what
+// every replica starts with.
+func setReadStrategiesHandler(c *gin.Context) {
+	var set map[string]ReadStrategy
+	if err := c.ShouldBindJSON(&set); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if err := setReadStrategies(set); err != nil {
+		apiError(c, 400, ErrInvalidReadStrategy, gin.H{"reason": err.Error()})
+		return
+	}
+	c.JSON(200, currentReadStrategies())
+}
+
+func writeReadStrategyMetrics(b *strings.Builder) {
+	b.WriteString("# HELP fabric_api_reads_total Reads of index-capable endpoints by the source that answered them.\n")
+	b.WriteString("# TYPE fabric_api_reads_total counter\n")
+	readStrategies.RLock()
+	defer readStrategies.RUnlock()
+	for k, n := range readStrategies.served {
+		fmt.Fprintf(b, "fabric_api_reads_total{endpoint=%q,source=%q} %d\n", k[0], k[1], n)
+	}
+	if lag, ok := indexLag(); ok {
+		b.WriteString("# HELP fabric_api_index_lag_blocks Blocks the change index is behind the last channel height read.\n")
+		b.WriteString("# TYPE fabric_api_index_lag_blocks gauge\n")
+		fmt.Fprintf(b, "fabric_api_index_lag_blocks %d\n", lag)
+	}
+}
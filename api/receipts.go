@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Receipt is the chaincode's receipt record: every transaction that records
+// a recent transaction gets the next number of the paying account's own
+// "receipt/<msisdn>" sequence, as R<msisdn>-NNNNNN. Receipts from before the
+// per-account sequences are numbered from the single "receipt" sequence.
+type Receipt struct {
+	Number    string        `json:"receipt" xml:"receipt"`
+	TxID      string        `json:"txId" xml:"txId"`
+	MSISDNs   []string      `json:"MSISDNs" xml:"MSISDNs>MSISDN"`
+	Timestamp int64         `json:"timestamp" xml:"timestamp"`
+	FX        *FXConversion `json:"fx,omitempty" xml:"fx,omitempty"`
+}
+
+// receiptHandler looks up a receipt number from a transfer, debit, closure
+// or recent transaction. Receipt numbers are easy to guess, so it needs a
+// key, and a dealer key only finds receipts naming its own dealer's accounts.
+func receiptHandler(c *gin.Context) {
+	opts, ok := proposalOptions(c, c.Param("receipt"))
+	if !ok {
+		return
+	}
+	res, err := evaluate(c, "GetReceipt", opts...)
+	if notFound(err) {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var r Receipt
+	if err := json.Unmarshal(res, &r); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, r)
+}
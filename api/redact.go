@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	MaskFull    = "full"
+	MaskPartial = "partial"
+	MaskHash    = "hash"
+	MaskNone    = "none"
+
+	PIIMSISDN  = "msisdn"
+	PIIMPIN    = "mpin"
+	PIIBalance = "balance"
+)
+
+// piiFields are the field names, matched case-insensitively as JSON keys or
+// key=value pairs, whose values belong to each kind of PII.
+var piiFields = map[string]string{
+	PIIMSISDN:  `msisdns?|parentMsisdn`,
+	PIIMPIN:    `mpin|pin`,
+	PIIBalance: `\w*balance\w*|transamount|amount`,
+}
+
+// piiPattern finds, in one pass so masked output is never matched again:
+// a JSON field, a key=value pair, an account path segment (one with a digit,
+// so /assets/changes stays readable), or a bare run of 8 to 15 digits, which
+// is taken to be an MSISDN.
+var piiPattern = func() *regexp.Regexp {
+	keys := piiFields[PIIMSISDN] + "|" + piiFields[PIIMPIN] + "|" + piiFields[PIIBalance]
+	return regexp.MustCompile(`(?i)"(` + keys + `)"\s*:\s*("[^"]*"|-?\d+)` +
+		`|\b(` + keys + `)=([^\s&,;"]+)` +
+		`|(/assets/)([^/?\s"]*\d[^/?\s"]*)` +
+		`|(\+?\b\d{8,15}\b)`)
+}()
+
+// redaction holds the mask applied to each kind of PII, from LOG_MASKING.
+var redaction = struct {
+	modes map[string]string
+	key   []byte
+}{modes: map[string]string{PIIMSISDN: MaskPartial, PIIMPIN: MaskFull, PIIBalance: MaskFull}}
+
+// loadRedaction routes the standard logger and gin's request log through the
+// redactor, so every log line is masked whoever wrote it. LOG_MASKING
+// overrides the defaults, e.g. "msisdn=hash,balance=none"; hashes are keyed
+// with LOG_MASK_KEY, or a random per-process key if unset.
+func loadRedaction() {
+	for _, kv := range strings.Split(os.Getenv("LOG_MASKING"), ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		kind, mode, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		_, known := redaction.modes[kind]
+		if !ok || !known || mode != MaskFull && mode != MaskPartial && mode != MaskHash && mode != MaskNone {
+			log.Fatalf("LOG_MASKING: bad entry %q", kv)
+		}
+		redaction.modes[kind] = mode
+	}
+	redaction.key = []byte(os.Getenv("LOG_MASK_KEY"))
+	if len(redaction.key) == 0 {
+		redaction.key = make([]byte, 32)
+		if _, err := rand.Read(redaction.key); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.SetOutput(redactingWriter{os.Stderr})
+	gin.DefaultWriter = redactingWriter{os.Stdout}
+	gin.DefaultErrorWriter = redactingWriter{os.Stderr}
+}
+
+type redactingWriter struct{ w io.Writer }
+
+// Write masks p as a whole; the log package and gin write one line per call.
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write([]byte(redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func redact(s string) string {
+	return piiPattern.ReplaceAllStringFunc(s, func(m string) string {
+		g := piiPattern.FindStringSubmatch(m)
+		switch {
+		case g[1] != "":
+			v, quoted := strings.CutPrefix(g[2], `"`)
+			v = strings.TrimSuffix(v, `"`)
+			masked := mask(fieldKind(g[1]), v)
+			if quoted {
+				masked = `"` + masked + `"`
+			}
+			return m[:len(m)-len(g[2])] + masked
+		case g[3] != "":
+			return g[3] + "=" + mask(fieldKind(g[3]), g[4])
+		case g[5] != "":
+			return g[5] + mask(PIIMSISDN, g[6])
+		}
+		return mask(PIIMSISDN, g[7])
+	})
+}
+
+var fieldKinds = func() map[string]*regexp.Regexp {
+	out := map[string]*regexp.Regexp{}
+	for kind, names := range piiFields {
+		out[kind] = regexp.MustCompile(`(?i)^(` + names + `)$`)
+	}
+	return out
+}()
+
+func fieldKind(name string) string {
+	for _, kind := range []string{PIIMPIN, PIIBalance} {
+		if fieldKinds[kind].MatchString(name) {
+			return kind
+		}
+	}
+	return PIIMSISDN
+}
+
+// mask applies kind's mode to v. partial keeps the last four characters of
+// values longer than four; hash gives a keyed digest, stable for the life
+// of the key, so lines about the same account can still be correlated.
+func mask(kind, v string) string {
+	switch redaction.modes[kind] {
+	case MaskNone:
+		return v
+	case MaskPartial:
+		if len(v) > 4 {
+			return strings.Repeat("*", len(v)-4) + v[len(v)-4:]
+		}
+	case MaskHash:
+		h := hmac.New(sha256.New, redaction.key)
+		h.Write([]byte(kind + ":" + v))
+		return "#" + hex.EncodeToString(h.Sum(nil))[:12]
+	}
+	return "****"
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc"
+)
+
+// readRegion is a gateway peer that serves evaluations. The home region is
+// the main gateway connection, which also endorses and submits.
+type readRegion struct {
+	name     string
+	endpoint string
+	contract *client.Contract
+
+	mu        sync.Mutex
+	healthy   bool
+	latency   time.Duration // smoothed probe latency
+	reads     int64
+	failures  int64
+	readTotal time.Duration
+}
+
+type RegionState struct {
+	Name      string  `json:"name"`
+	Endpoint  string  `json:"endpoint"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMs float64 `json:"probeLatencyMs"`
+	Reads     int64   `json:"reads"`
+	Failures  int64   `json:"failures"`
+	Selected  bool    `json:"selected"`
+}
+
+var regions struct {
+	home *readRegion
+	list []*readRegion
+}
+
+// loadRegions reads READ_REGIONS, "eu=peer0.eu.example.com:7051,us=...",
+// and connects a gateway to each. The peers must trust the same TLS CA and
+// serve the same channel and chaincode; their host name is the TLS server
+// name. REGION names the home region (default "home").
+func loadRegions() {
+	name := os.Getenv("REGION")
+	if name == "" {
+		name = "home"
+	}
+	regions.home = &readRegion{name: name, endpoint: mustEnv("PEER_ENDPOINT"), contract: contract, healthy: true}
+	regions.list = []*readRegion{regions.home}
+	for _, entry := range strings.Split(os.Getenv("READ_REGIONS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, endpoint, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		host, _, err := net.SplitHostPort(endpoint)
+		if !ok || name == "" || err != nil {
+			log.Fatalf("READ_REGIONS: bad entry %q", entry)
+		}
+		conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(ids.transportCredentials(host)), grpc.WithUnaryInterceptor(slowQueryInterceptor))
+		if err != nil {
+			log.Fatalf("region %s: %v", name, err)
+		}
+		rgw, err := client.Connect(ids, append(ids.connectOptions(), client.WithClientConnection(conn), client.WithEvaluateTimeout(10*time.Second))...)
+		if err != nil {
+			log.Fatalf("region %s: %v", name, err)
+		}
+		regions.list = append(regions.list, &readRegion{name: name, endpoint: endpoint, contract: rgw.GetNetwork(network.Name()).GetContract(contract.ChaincodeName())})
+	}
+}
+
+// nearestRegion is the healthy region with the lowest probe latency. Other
+// regions only become candidates once a probe has succeeded.
+func nearestRegion() *readRegion {
+	best := regions.home
+	bestLatency := time.Duration(-1)
+	for _, r := range regions.list {
+		r.mu.Lock()
+		ok, lat := r.healthy && r.latency > 0, r.latency
+		r.mu.Unlock()
+		if ok && (bestLatency < 0 || lat < bestLatency) {
+			best, bestLatency = r, lat
+		}
+	}
+	return best
+}
+
+func (r *readRegion) observe(d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reads++
+	r.readTotal += d
+	if err != nil {
+		r.failures++
+	}
+}
+
+// evaluateNearest evaluates on the nearest healthy region. A region that
+// cannot be reached is marked down and the read retried at home.
+func evaluateNearest(ctx context.Context, fn string, opts ...client.ProposalOption) ([]byte, error) {
+	r := nearestRegion()
+	start := time.Now()
+	res, err := r.contract.EvaluateWithContext(ctx, fn, opts...)
+	r.observe(time.Since(start), err)
+	if err == nil || r == regions.home || ctx.Err() != nil {
+		return res, err
+	}
+	if cat := classify(err).Category; cat != CategoryUnavailable && cat != CategoryTimeout {
+		return res, err
+	}
+	r.mu.Lock()
+	r.healthy = false
+	r.mu.Unlock()
+	start = time.Now()
+	res, err = contract.EvaluateWithContext(ctx, fn, opts...)
+	regions.home.observe(time.Since(start), err)
+	return res, err
+}
+
+// runRegionProbes measures every region with a cheap evaluation every
+// REGION_PROBE_INTERVAL (default 15s) until ctx is cancelled.
+func runRegionProbes(ctx context.Context) {
+	if len(regions.list) < 2 {
+		return
+	}
+	interval := 15 * time.Second
+	if v := os.Getenv("REGION_PROBE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("REGION_PROBE_INTERVAL: %v", err)
+		}
+		interval = d
+	}
+	for {
+		var wg sync.WaitGroup
+		for _, r := range regions.list {
+			wg.Add(1)
+			go func(r *readRegion) {
+				defer wg.Done()
+				r.probe(ctx)
+			}(r)
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (r *readRegion) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := r.contract.EvaluateWithContext(ctx, "GetMaintenance")
+	d := time.Since(start)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if r.healthy {
+			log.Printf("region %s: down: %v", r.name, err)
+		}
+		r.healthy = false
+		return
+	}
+	if r.latency == 0 || !r.healthy {
+		r.latency = d
+	} else {
+		r.latency = (3*r.latency + d) / 4
+	}
+	r.healthy = true
+}
+
+func regionStates() []RegionState {
+	selected := nearestRegion()
+	out := make([]RegionState, 0, len(regions.list))
+	for _, r := range regions.list {
+		r.mu.Lock()
+		out = append(out, RegionState{Name: r.name, Endpoint: r.endpoint, Healthy: r.healthy, LatencyMs: float64(r.latency.Microseconds()) / 1000, Reads: r.reads, Failures: r.failures, Selected: r == selected})
+		r.mu.Unlock()
+	}
+	return out
+}
+
+func regionsHandler(c *gin.Context) {
+	c.JSON(200, regionStates())
+}
+
+func writeRegionMetrics(b *strings.Builder) {
+	b.WriteString("# HELP fabric_api_region_healthy Whether a read region answered its last probe.\n")
+	b.WriteString("# TYPE fabric_api_region_healthy gauge\n")
+	for _, r := range regions.list {
+		r.mu.Lock()
+		v := 0
+		if r.healthy {
+			v = 1
+		}
+		fmt.Fprintf(b, "fabric_api_region_healthy{region=%q} %d\n", r.name, v)
+		r.mu.Unlock()
+	}
+	b.WriteString("# HELP fabric_api_region_probe_latency_seconds Smoothed probe latency per read region.\n")
+	b.WriteString("# TYPE fabric_api_region_probe_latency_seconds gauge\n")
+	for _, r := range regions.list {
+		r.mu.Lock()
+		fmt.Fprintf(b, "fabric_api_region_probe_latency_seconds{region=%q} %g\n", r.name, r.latency.Seconds())
+		r.mu.Unlock()
+	}
+	b.WriteString("# HELP fabric_api_region_read_seconds Evaluations served per read region.\n")
+	b.WriteString("# TYPE fabric_api_region_read_seconds summary\n")
+	for _, r := range regions.list {
+		r.mu.Lock()
+		fmt.Fprintf(b, "fabric_api_region_read_seconds_sum{region=%q} %g\n", r.name, r.readTotal.Seconds())
+		fmt.Fprintf(b, "fabric_api_region_read_seconds_count{region=%q} %d\n", r.name, r.reads)
+		r.mu.Unlock()
+	}
+	b.WriteString("# HELP fabric_api_region_read_failures_total Failed evaluations per read region.\n")
+	b.WriteString("# TYPE fabric_api_region_read_failures_total counter\n")
+	for _, r := range regions.list {
+		r.mu.Lock()
+		fmt.Fprintf(b, "fabric_api_region_read_failures_total{region=%q} %d\n", r.name, r.failures)
+		r.mu.Unlock()
+	}
+}
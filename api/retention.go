@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRetentionSample is how many of a store's eligible items the dry run
+// names.
+const maxRetentionSample = 20
+
+// retentionTarget is one store the janitor trims. scan lists the items
+// older than cutoff; purge removes them, checking again that each still
+// qualifies, and returns how many went.
+type retentionTarget struct {
+	name  string
+	env   string
+	keep  time.Duration
+	scan  func(cutoff time.Time) ([]string, error)
+	purge func(cutoff time.Time, items []string) int
+}
+
+var retention = struct {
+	sync.Mutex
+	targets  []*retentionTarget
+	interval time.Duration
+	purged   map[string]uint64
+	lastRun  time.Time
+}{interval: time.Hour, purged: map[string]uint64{}}
+
+// loadRetention reads a retention period per store, e.g. RETENTION_OUTBOX=7d
+// (Go durations, or whole days with a d suffix). Unset or 0 keeps a store's
+// items forever. RETENTION_INTERVAL is how often the janitor runs (default
+// 1h).
+func loadRetention() {
+	retention.targets = []*retentionTarget{
+		{name: "index", env: "RETENTION_INDEX", scan: scanIndexRetention, purge: purgeIndexRetention},
+		{name: "outbox", env: "RETENTION_OUTBOX", scan: scanOutboxRetention, purge: purgeOutboxRetention},
+		{name: "sagas", env: "RETENTION_SAGAS", scan: scanSagaRetention, purge: purgeSagaRetention},
+		{name: "admin-log", env: "RETENTION_ADMIN_LOG", scan: scanAdminLogRetention, purge: purgeAdminLogRetention},
+		{name: "checkpoints", env: "RETENTION_CHECKPOINTS", scan: scanCheckpointRetention, purge: purgeCheckpointRetention},
+	}
+	for _, t := range retention.targets {
+		if v := os.Getenv(t.env); v != "" {
+			d, err := parseRetention(v)
+			if err != nil {
+				log.Fatalf("%s: %v", t.env, err)
+			}
+			t.keep = d
+		}
+	}
+	if v := os.Getenv("RETENTION_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			log.Fatalf("RETENTION_INTERVAL must be a duration of at least 1m")
+		}
+		retention.interval = d
+	}
+}
+
+func parseRetention(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad number of days %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("bad duration %q", v)
+	}
+	return d, nil
+}
+
+// runRetention purges every store with a retention period, at start and
+// then every RETENTION_INTERVAL, until ctx is cancelled. Every replica runs
+// it: the change index and admin log are its own, and removing a file from
+// a shared outbox or saga volume twice is harmless.
+func runRetention(ctx context.Context) {
+	t := time.NewTicker(retention.interval)
+	defer t.Stop()
+	for {
+		purgeRetention()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func purgeRetention() {
+	now := time.Now()
+	for _, t := range retention.targets {
+		if t.keep <= 0 {
+			continue
+		}
+		cutoff := now.Add(-t.keep)
+		items, err := t.scan(cutoff)
+		if err != nil {
+			log.Printf("retention %s: %v", t.name, err)
+			continue
+		}
+		if len(items) == 0 {
+			continue
+		}
+		n := t.purge(cutoff, items)
+		log.Printf("retention %s: purged %d older than %s", t.name, n, cutoff.UTC().Format(time.RFC3339))
+		retention.Lock()
+		retention.purged[t.name] += uint64(n)
+		retention.Unlock()
+	}
+	retention.Lock()
+	retention.lastRun = now
+	retention.Unlock()
+}
+
+// RetentionStoreReport is what the janitor would purge from one store if it
+// ran now.
+type RetentionStoreReport struct {
+	Store     string     `json:"store"`
+	Retention string     `json:"retention,omitempty"`
+	Cutoff    *time.Time `json:"cutoff,omitempty"`
+	Eligible  int        `json:"eligible"`
+	Sample    []string   `json:"sample,omitempty"`
+	Purged    uint64     `json:"purgedTotal"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// retentionReportHandler is the janitor's dry run: per store, how many items
+// are past retention and the first few of them. Nothing is removed.
+func retentionReportHandler(c *gin.Context) {
+	now := time.Now()
+	out := []RetentionStoreReport{}
+	for _, t := range retention.targets {
+		r := RetentionStoreReport{Store: t.name}
+		retention.Lock()
+		r.Purged = retention.purged[t.name]
+		retention.Unlock()
+		if t.keep > 0 {
+			cutoff := now.Add(-t.keep).UTC()
+			r.Retention, r.Cutoff = t.keep.String(), &cutoff
+			items, err := t.scan(cutoff)
+			if err != nil {
+				r.Error = err.Error()
+			}
+			r.Eligible = len(items)
+			r.Sample = items[:min(len(items), maxRetentionSample)]
+		}
+		out = append(out, r)
+	}
+	resp := gin.H{"dryRun": true, "interval": retention.interval.String(), "stores": out}
+	retention.Lock()
+	if !retention.lastRun.IsZero() {
+		resp["lastRun"] = retention.lastRun.UTC()
+	}
+	retention.Unlock()
+	c.JSON(200, resp)
+}
+
+// The change index keeps a row for every deleted account, analytics for
+// every month and postings for every settlement day; all go once older than
+// the cutoff.
+
+func scanIndexRetention(cutoff time.Time) ([]string, error) {
+	month := cutoff.UTC().Format("2006-01")
+	changeIndex.RLock()
+	defer changeIndex.RUnlock()
+	var out []string
+	for m, ch := range changeIndex.Changes {
+		if ch.Deleted && ch.Timestamp < cutoff.Unix() {
+			out = append(out, "change/"+m)
+		}
+	}
+	for m, months := range changeIndex.Analytics {
+		for k := range months {
+			if k < month {
+				out = append(out, "analytics/"+m+"/"+k)
+			}
+		}
+	}
+	for day := range changeIndex.Settlements {
+		if day < cutoff.UTC().Format(time.DateOnly) {
+			out = append(out, "settlements/"+day)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func purgeIndexRetention(cutoff time.Time, items []string) int {
+	month := cutoff.UTC().Format("2006-01")
+	changeIndex.Lock()
+	defer changeIndex.Unlock()
+	n := 0
+	for _, item := range items {
+		kind, rest, _ := strings.Cut(item, "/")
+		switch kind {
+		case "change":
+			if ch := changeIndex.Changes[rest]; ch != nil && ch.Deleted && ch.Timestamp < cutoff.Unix() {
+				delete(changeIndex.Changes, rest)
+				n++
+			}
+		case "analytics":
+			i := strings.LastIndex(rest, "/")
+			m, k := rest[:i], rest[i+1:]
+			if _, ok := changeIndex.Analytics[m][k]; ok && k < month {
+				delete(changeIndex.Analytics[m], k)
+				if len(changeIndex.Analytics[m]) == 0 {
+					delete(changeIndex.Analytics, m)
+				}
+				n++
+			}
+		case "settlements":
+			if _, ok := changeIndex.Settlements[rest]; ok && rest < cutoff.UTC().Format(time.DateOnly) {
+				delete(changeIndex.Settlements, rest)
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Outbox entries still waiting for delivery or confirmation go once they
+// were created before the cutoff; the notification is given up.
+
+func scanOutboxRetention(cutoff time.Time) ([]string, error) {
+	if outbox == nil {
+		return nil, nil
+	}
+	entries, err := outbox.list()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if e.CreatedAt.Before(cutoff) {
+			out = append(out, e.TxID)
+		}
+	}
+	return out, nil
+}
+
+func purgeOutboxRetention(cutoff time.Time, items []string) int {
+	n := 0
+	for _, txID := range items {
+		e, err := outbox.get(txID)
+		if err != nil || e == nil || !e.CreatedAt.Before(cutoff) {
+			continue
+		}
+		log.Printf("retention outbox: giving up on %s after %d attempts", txID, e.Attempts)
+		outbox.drop(txID)
+		n++
+	}
+	return n
+}
+
+// Sagas go once finished and last updated before the cutoff. Unfinished
+// ones are kept whatever their age: runSagas still owes them an outcome.
+
+func scanSagaRetention(cutoff time.Time) ([]string, error) {
+	list, err := sagas.list()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, s := range list {
+		if s.finished() && s.UpdatedAt.Before(cutoff) {
+			out = append(out, s.ID)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func purgeSagaRetention(cutoff time.Time, items []string) int {
+	n := 0
+	for _, id := range items {
+		sagas.mu.Lock()
+		s, err := sagas.get(id)
+		if err == nil && s != nil && s.finished() && s.UpdatedAt.Before(cutoff) && os.Remove(sagas.path(id)) == nil {
+			n++
+		}
+		sagas.mu.Unlock()
+	}
+	return n
+}
+
+// Admin log days go once the whole day is before the cutoff and, when
+// anchoring is on, the day's hash is on the ledger.
+
+func scanAdminLogRetention(cutoff time.Time) ([]string, error) {
+	if adminLog == nil {
+		return nil, nil
+	}
+	days, err := adminLog.days()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, day := range days {
+		if adminLogExpired(day, cutoff) {
+			out = append(out, day)
+		}
+	}
+	return out, nil
+}
+
+func adminLogExpired(day string, cutoff time.Time) bool {
+	d, err := time.Parse("2006-01-02", day)
+	if err != nil || !d.Add(24*time.Hour).Before(cutoff) {
+		return false
+	}
+	if adminLog.anchor {
+		if _, err := os.Stat(filepath.Join(adminLog.dir, day+".anchor")); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func purgeAdminLogRetention(cutoff time.Time, items []string) int {
+	n := 0
+	for _, day := range items {
+		if !adminLogExpired(day, cutoff) {
+			continue
+		}
+		if err := os.Remove(adminLog.path(day)); err != nil {
+			log.Printf("retention admin-log: %v", err)
+			continue
+		}
+		os.Remove(filepath.Join(adminLog.dir, day+".anchor"))
+		n++
+	}
+	return n
+}
+
+// Block checkpoints go once no consumer has advanced them since the cutoff;
+// a consumer that comes back later starts from the block it asks for.
+
+func scanCheckpointRetention(cutoff time.Time) ([]string, error) {
+	files, err := os.ReadDir(checkpointDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if info, err := f.Info(); err == nil && info.ModTime().Before(cutoff) {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+func purgeCheckpointRetention(cutoff time.Time, items []string) int {
+	n := 0
+	for _, name := range items {
+		if !claimConsumer(name) {
+			continue
+		}
+		p := filepath.Join(checkpointDir(), name+".json")
+		if info, err := os.Stat(p); err == nil && info.ModTime().Before(cutoff) && os.Remove(p) == nil {
+			n++
+		}
+		releaseConsumer(name)
+	}
+	return n
+}
+
+func writeRetentionMetrics(b *strings.Builder) {
+	b.WriteString("# HELP fabric_api_retention_purged_total Items the retention janitor removed, by store.\n")
+	b.WriteString("# TYPE fabric_api_retention_purged_total counter\n")
+	retention.Lock()
+	defer retention.Unlock()
+	for _, t := range retention.targets {
+		fmt.Fprintf(b, "fabric_api_retention_purged_total{store=%q} %d\n", t.name, retention.purged[t.name])
+	}
+	if !retention.lastRun.IsZero() {
+		b.WriteString("# HELP fabric_api_retention_last_run_timestamp_seconds When the retention janitor last ran.\n")
+		b.WriteString("# TYPE fabric_api_retention_last_run_timestamp_seconds gauge\n")
+		fmt.Fprintf(b, "fabric_api_retention_last_run_timestamp_seconds %d\n", retention.lastRun.Unix())
+	}
+}
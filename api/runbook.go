@@ -0,0 +1,700 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// The runbook actions. Each runs as a job that reports its progress.
+const (
+	RunbookIndexResync   = "index-resync"
+	RunbookDealerIndex   = "dealer-index-rebuild"
+	RunbookOutboxRedrive = "outbox-redrive"
+
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+
+	// runbookStale is how long a running job may go without saving its
+	// progress before it is taken to have died with its replica.
+	runbookStale = 2 * time.Minute
+	// runbookRetention is how long finished jobs are kept.
+	runbookRetention = 30 * 24 * time.Hour
+	// maxRunbookItems caps the keys a job lists in its report.
+	maxRunbookItems = 100
+	// runbookAttempts is how often a dealer index page is tried before the
+	// job fails; its bookmark is kept to resume from.
+	runbookAttempts = 5
+)
+
+// RunbookRequest is the body of POST /admin/runbooks/:action. Each action
+// reads only its own fields. DryRun is true unless set to false.
+type RunbookRequest struct {
+	DryRun    *bool    `json:"dryRun,omitempty"`
+	FromBlock *uint64  `json:"fromBlock,omitempty"`
+	PageSize  int      `json:"pageSize,omitempty"`
+	Bookmark  string   `json:"bookmark,omitempty"`
+	TxIDs     []string `json:"txIds,omitempty"`
+	OlderThan string   `json:"olderThan,omitempty"`
+}
+
+// RunbookItem is a key a job acted on, or would have on a dry run.
+type RunbookItem struct {
+	Key    string `json:"key" xml:"key"`
+	Action string `json:"action" xml:"action"`
+	Detail string `json:"detail,omitempty" xml:"detail,omitempty"`
+}
+
+// RunbookProgress is how far a job has got: Done of Total units (Total is 0
+// while unknown), its counters and, for the dealer index, the bookmark to
+// resume from.
+type RunbookProgress struct {
+	Unit     string           `json:"unit" xml:"unit"`
+	Done     int64            `json:"done" xml:"done"`
+	Total    int64            `json:"total,omitempty" xml:"total,omitempty"`
+	Phase    string           `json:"phase,omitempty" xml:"phase,omitempty"`
+	Counts   map[string]int64 `json:"counts" xml:"-"`
+	Bookmark string           `json:"bookmark,omitempty" xml:"bookmark,omitempty"`
+	Items    []RunbookItem    `json:"items,omitempty" xml:"items>item,omitempty"`
+	// Truncated is set once more keys were acted on than Items lists.
+	Truncated bool `json:"truncated,omitempty" xml:"truncated,omitempty"`
+}
+
+func (p *RunbookProgress) add(item RunbookItem) {
+	if len(p.Items) == maxRunbookItems {
+		p.Truncated = true
+		return
+	}
+	p.Items = append(p.Items, item)
+}
+
+// RunbookJob is one run of a runbook action.
+type RunbookJob struct {
+	ID         string          `json:"id" xml:"id"`
+	Action     string          `json:"action" xml:"action"`
+	DryRun     bool            `json:"dryRun" xml:"dryRun"`
+	Params     RunbookRequest  `json:"params" xml:"-"`
+	State      string          `json:"state" xml:"state"`
+	Replica    string          `json:"replica" xml:"replica"`
+	Actor      string          `json:"actor,omitempty" xml:"actor,omitempty"`
+	Progress   RunbookProgress `json:"progress" xml:"progress"`
+	Error      string          `json:"error,omitempty" xml:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt" xml:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt" xml:"updatedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty" xml:"finishedAt,omitempty"`
+}
+
+// RunbookJobs is GET /admin/runbooks/jobs.
+type RunbookJobs struct {
+	Jobs []*RunbookJob `json:"jobs" xml:"job"`
+}
+
+// runbookStore keeps one file per job in RUNBOOK_DIR, so any replica can
+// report a job's progress or cancel it. As with the outbox, several replicas
+// need a shared volume.
+type runbookStore struct {
+	dir     string
+	replica string
+	mu      sync.Mutex
+}
+
+var runbooks *runbookStore
+
+func loadRunbooks() {
+	dir := os.Getenv("RUNBOOK_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-api-runbooks")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("runbooks: %v", err)
+	}
+	replica := os.Getenv("POD_NAME")
+	if replica == "" {
+		var err error
+		if replica, err = os.Hostname(); err != nil {
+			log.Fatalf("runbooks: %v", err)
+		}
+	}
+	runbooks = &runbookStore{dir: dir, replica: replica}
+}
+
+func (st *runbookStore) path(id string) string {
+	return filepath.Join(st.dir, id+".json")
+}
+
+// cancelPath is a marker of its own, so a cancel never races the runner's
+// progress writes.
+func (st *runbookStore) cancelPath(id string) string {
+	return filepath.Join(st.dir, id+".cancel")
+}
+
+func (st *runbookStore) put(j *RunbookJob) error {
+	j.UpdatedAt = time.Now().UTC()
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp := st.path(j.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path(j.ID))
+}
+
+func (st *runbookStore) get(id string) (*RunbookJob, error) {
+	b, err := os.ReadFile(st.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var j RunbookJob
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, err
+	}
+	if j.State == JobRunning && time.Since(j.UpdatedAt) > runbookStale {
+		j.State = JobFailed
+		j.Error = "abandoned: replica " + j.Replica + " stopped reporting progress"
+	}
+	return &j, nil
+}
+
+func (st *runbookStore) cancelled(id string) bool {
+	_, err := os.Stat(st.cancelPath(id))
+	return err == nil
+}
+
+// list returns every job, newest first, and removes finished ones older
+// than runbookRetention.
+func (st *runbookStore) list() ([]*RunbookJob, error) {
+	files, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*RunbookJob
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(f.Name(), ".json")
+		j, err := st.get(id)
+		if err != nil {
+			return nil, err
+		}
+		if j == nil {
+			continue
+		}
+		if j.State != JobRunning && time.Since(j.UpdatedAt) > runbookRetention {
+			os.Remove(st.path(id))
+			os.Remove(st.cancelPath(id))
+			continue
+		}
+		out = append(out, j)
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].CreatedAt.After(out[k].CreatedAt) })
+	return out, nil
+}
+
+// create stores j unless a job of the same action is running, which it
+// returns instead. Index re-syncs only conflict on the same replica, as each
+// replica has its own index. The check is per replica, so two replicas
+// started at the same instant could both run.
+func (st *runbookStore) create(j *RunbookJob) (*RunbookJob, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	jobs, err := st.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range jobs {
+		if other.State == JobRunning && other.Action == j.Action && (j.Action != RunbookIndexResync || other.Replica == j.Replica) {
+			return other, nil
+		}
+	}
+	return nil, st.put(j)
+}
+
+var errJobCancelled = errors.New("cancelled")
+
+// runbookRun is a job being run. save writes its progress at most once a
+// second unless forced, and reports errJobCancelled once the job has been
+// cancelled.
+type runbookRun struct {
+	job   *RunbookJob
+	saved time.Time
+}
+
+func (r *runbookRun) save(force bool) error {
+	if !force && time.Since(r.saved) < time.Second {
+		return nil
+	}
+	r.saved = time.Now()
+	if err := runbooks.put(r.job); err != nil {
+		log.Printf("runbook %s: %v", r.job.ID, err)
+	}
+	if runbooks.cancelled(r.job.ID) {
+		return errJobCancelled
+	}
+	return nil
+}
+
+func (r *runbookRun) finish(err error) {
+	now := time.Now().UTC()
+	r.job.FinishedAt = &now
+	switch {
+	case err == nil:
+		r.job.State = JobSucceeded
+	case errors.Is(err, errJobCancelled):
+		r.job.State = JobCancelled
+	default:
+		r.job.State = JobFailed
+		r.job.Error = err.Error()
+	}
+	if err := runbooks.put(r.job); err != nil {
+		log.Printf("runbook %s: %v", r.job.ID, err)
+	}
+	log.Printf("runbook %s %s (dry run %t): %s", r.job.ID, r.job.Action, r.job.DryRun, r.job.State)
+}
+
+// runbookRunners start an action; they check its parameters and answer the
+// request themselves when they cannot run.
+var runbookRunners = map[string]func(c *gin.Context, req *RunbookRequest) (func(ctx context.Context, r *runbookRun) error, bool){
+	RunbookIndexResync:   indexResyncRunner,
+	RunbookDealerIndex:   dealerIndexRunner,
+	RunbookOutboxRedrive: outboxRedriveRunner,
+}
+
+// startRunbookHandler is POST /admin/runbooks/:action. It answers 202 with
+// the job as soon as it is stored, and a Location to follow its progress.
+func startRunbookHandler(c *gin.Context) {
+	start, ok := runbookRunners[c.Param("action")]
+	if !ok {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	var req RunbookRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		bodyError(c, err)
+		return
+	}
+	run, ok := start(c, &req)
+	if !ok {
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		internalError(c, err)
+		return
+	}
+	now := time.Now().UTC()
+	j := &RunbookJob{
+		ID: hex.EncodeToString(b), Action: c.Param("action"), DryRun: req.DryRun == nil || *req.DryRun, Params: req,
+		State: JobRunning, Replica: runbooks.replica, Actor: principal(c).Name, Progress: RunbookProgress{Counts: map[string]int64{}}, CreatedAt: now,
+	}
+	running, err := runbooks.create(j)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if running != nil {
+		apiError(c, 409, ErrRunbookRunning, gin.H{"action": running.Action, "id": running.ID})
+		return
+	}
+	r := &runbookRun{job: j, saved: time.Now()}
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r.finish(run(ctx, r))
+	}()
+	c.Header("Location", "/admin/runbooks/jobs/"+j.ID)
+	c.JSON(202, j)
+}
+
+func listRunbookJobsHandler(c *gin.Context) {
+	jobs, err := runbooks.list()
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if jobs == nil {
+		jobs = []*RunbookJob{}
+	}
+	respond(c, 200, RunbookJobs{Jobs: jobs})
+}
+
+// runbookJob looks up :id, answering 404 itself when there is none.
+func runbookJob(c *gin.Context) (*RunbookJob, bool) {
+	if !sagaID.MatchString(c.Param("id")) {
+		apiError(c, 404, ErrNotFound, nil)
+		return nil, false
+	}
+	j, err := runbooks.get(c.Param("id"))
+	if err != nil {
+		internalError(c, err)
+		return nil, false
+	}
+	if j == nil {
+		apiError(c, 404, ErrNotFound, nil)
+		return nil, false
+	}
+	return j, true
+}
+
+func getRunbookJobHandler(c *gin.Context) {
+	if j, ok := runbookJob(c); ok {
+		respond(c, 200, j)
+	}
+}
+
+// cancelRunbookJobHandler asks a running job to stop. It stops at its next
+// progress report, on whichever replica runs it; work already done stays.
+func cancelRunbookJobHandler(c *gin.Context) {
+	j, ok := runbookJob(c)
+	if !ok {
+		return
+	}
+	if j.State != JobRunning {
+		c.JSON(200, j)
+		return
+	}
+	if err := os.WriteFile(runbooks.cancelPath(j.ID), nil, 0o600); err != nil {
+		internalError(c, err)
+		return
+	}
+	c.JSON(202, j)
+}
+
+// indexResyncRunner replays the blocks from fromBlock into a shadow index
+// and, once that has caught up with the live one, merges it in: the latest
+// write of every account it saw and the settlement postings from fromBlock
+// on replace the indexed ones. From block 0 the shadow replaces the whole
+// index, monthly aggregates included; from a later block aggregates are
+// left as they are, as they cannot be recounted from part of the chain. A
+// dry run reports the differences and merges nothing. Each replica has its
+// own index, so this re-syncs the replica that runs it.
+func indexResyncRunner(c *gin.Context, req *RunbookRequest) (func(ctx context.Context, r *runbookRun) error, bool) {
+	if !changeIndex.enabled {
+		apiError(c, 503, ErrChangeIndexDisabled, nil)
+		return nil, false
+	}
+	if req.FromBlock == nil {
+		apiError(c, 400, ErrInvalidBlockNumber, gin.H{"param": "fromBlock"})
+		return nil, false
+	}
+	from := *req.FromBlock
+	changeIndex.RLock()
+	next := changeIndex.Next
+	changeIndex.RUnlock()
+	if from > next {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "fromBlock", "min": 0, "max": next})
+		return nil, false
+	}
+	return func(ctx context.Context, r *runbookRun) error {
+		p := &r.job.Progress
+		p.Unit = "blocks"
+		shadow := emptySnapshot()
+		shadow.Next = from
+		blocks, err := network.BlockEvents(ctx, client.WithStartBlock(from))
+		if err != nil {
+			return err
+		}
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			changeIndex.Lock()
+			if shadow.Next >= changeIndex.Next {
+				resyncMerge(&shadow, from, p, !r.job.DryRun)
+				changeIndex.Unlock()
+				if !r.job.DryRun {
+					saveChangeIndex()
+				}
+				return nil
+			}
+			if n := int64(changeIndex.Next - from); n > p.Total {
+				p.Total = n
+			}
+			changeIndex.Unlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case b, ok := <-blocks:
+				if !ok {
+					return errors.New("block stream closed")
+				}
+				shadow.apply(b.GetHeader().GetNumber(), blockWrites(b))
+				p.Done = int64(shadow.Next - from)
+			case <-tick.C:
+			}
+			if err := r.save(false); err != nil {
+				return err
+			}
+		}
+	}, true
+}
+
+// resyncMerge compares shadow, replayed from block from, with the live
+// index and, if apply is set, merges it in. The caller holds changeIndex.
+func resyncMerge(shadow *indexSnapshot, from uint64, p *RunbookProgress, apply bool) {
+	var keys []string
+	for m := range shadow.Changes {
+		keys = append(keys, m)
+	}
+	if from == 0 {
+		for m := range changeIndex.Changes {
+			if shadow.Changes[m] == nil {
+				keys = append(keys, m)
+			}
+		}
+	}
+	sort.Strings(keys)
+	for _, m := range keys {
+		old, cur := changeIndex.Changes[m], shadow.Changes[m]
+		switch {
+		case cur == nil:
+			p.Counts["removed"]++
+			p.add(RunbookItem{Key: m, Action: "remove", Detail: "not written since block 0"})
+		case old == nil:
+			p.Counts["added"]++
+			p.add(RunbookItem{Key: m, Action: "add", Detail: "tx " + cur.TxID})
+		case old.TxID != cur.TxID || old.Deleted != cur.Deleted || !reflect.DeepEqual(changeIndex.Accounts[m], shadow.Accounts[m]):
+			p.Counts["updated"]++
+			p.add(RunbookItem{Key: m, Action: "update", Detail: "tx " + old.TxID + " -> " + cur.TxID})
+		}
+	}
+	var before, after int64
+	for _, dealers := range changeIndex.Settlements {
+		for _, entries := range dealers {
+			for _, e := range entries {
+				if e.BlockNumber >= from {
+					before++
+				}
+			}
+		}
+	}
+	for _, dealers := range shadow.Settlements {
+		for _, entries := range dealers {
+			after += int64(len(entries))
+		}
+	}
+	p.Counts["settlementEntriesBefore"] = before
+	p.Counts["settlementEntriesAfter"] = after
+	if !apply {
+		return
+	}
+	next := max(changeIndex.Next, shadow.Next)
+	if from == 0 {
+		changeIndex.indexSnapshot = *shadow
+		changeIndex.Next = next
+		return
+	}
+	for m, ch := range shadow.Changes {
+		changeIndex.Changes[m] = ch
+		if ch.Deleted {
+			delete(changeIndex.Accounts, m)
+			delete(changeIndex.Analytics, m)
+		} else {
+			changeIndex.Accounts[m] = shadow.Accounts[m]
+		}
+	}
+	for day, dealers := range changeIndex.Settlements {
+		for dealer, entries := range dealers {
+			kept := entries[:0]
+			for _, e := range entries {
+				if e.BlockNumber < from {
+					kept = append(kept, e)
+				}
+			}
+			dealers[dealer] = kept
+		}
+		for dealer, entries := range shadow.Settlements[day] {
+			dealers[dealer] = append(dealers[dealer], entries...)
+		}
+	}
+	for day, dealers := range shadow.Settlements {
+		if changeIndex.Settlements[day] == nil {
+			changeIndex.Settlements[day] = dealers
+		}
+	}
+	changeIndex.Next = next
+}
+
+// dealerIndexRunner runs chaincode RebuildDealerIndex page by page from
+// bookmark, submitting each page, or evaluating it on a dry run. A page that
+// keeps failing fails the job with its bookmark in the progress, to resume
+// from.
+func dealerIndexRunner(c *gin.Context, req *RunbookRequest) (func(ctx context.Context, r *runbookRun) error, bool) {
+	if req.PageSize == 0 {
+		req.PageSize = 500
+	}
+	if req.PageSize < 1 || req.PageSize > 1000 {
+		apiError(c, 400, ErrOutOfRange, gin.H{"param": "pageSize", "min": 1, "max": 1000})
+		return nil, false
+	}
+	return func(ctx context.Context, r *runbookRun) error {
+		p := &r.job.Progress
+		p.Unit = "keys"
+		p.Bookmark = req.Bookmark
+		args := func() []string { return []string{strconv.Itoa(req.PageSize), p.Bookmark} }
+		for {
+			var res []byte
+			var err error
+			for attempt := 1; attempt <= runbookAttempts; attempt++ {
+				if r.job.DryRun {
+					res, err = contract.Evaluate("RebuildDealerIndex", client.WithArguments(args()...))
+				} else {
+					res, _, err = submit("RebuildDealerIndex", client.WithArguments(args()...))
+				}
+				if err == nil || !classify(err).Retryable {
+					break
+				}
+				log.Printf("runbook %s: page at %q (attempt %d): %v", r.job.ID, p.Bookmark, attempt, err)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Duration(attempt) * 2 * time.Second):
+				}
+			}
+			if err != nil {
+				return err
+			}
+			var page struct {
+				Phase    string `json:"phase"`
+				Scanned  int64  `json:"scanned"`
+				Added    int64  `json:"added"`
+				Removed  int64  `json:"removed"`
+				Bookmark string `json:"bookmark"`
+			}
+			if err := json.Unmarshal(res, &page); err != nil {
+				return err
+			}
+			p.Phase = page.Phase
+			p.Done += page.Scanned
+			p.Counts["pages"]++
+			p.Counts["added"] += page.Added
+			p.Counts["removed"] += page.Removed
+			p.Bookmark = page.Bookmark
+			if p.Bookmark == "" {
+				return nil
+			}
+			if err := r.save(true); err != nil {
+				return err
+			}
+		}
+	}, true
+}
+
+// outboxRedriveRunner delivers stuck outbox entries now instead of at their
+// next backoff: the listed txIds, or else every committed entry that has
+// failed delivery and every uncommitted one past OUTBOX_CONFIRM_TIMEOUT,
+// created at least olderThan ago. Uncommitted entries are first looked up
+// on the ledger, as the leader does when their commit event never arrives.
+// A dry run lists what would be done.
+func outboxRedriveRunner(c *gin.Context, req *RunbookRequest) (func(ctx context.Context, r *runbookRun) error, bool) {
+	if outbox == nil {
+		apiError(c, 503, ErrOutboxDisabled, nil)
+		return nil, false
+	}
+	var olderThan time.Duration
+	if req.OlderThan != "" {
+		var err error
+		if olderThan, err = time.ParseDuration(req.OlderThan); err != nil {
+			bodyError(c, fmt.Errorf("olderThan: %w", err))
+			return nil, false
+		}
+	}
+	listed := map[string]bool{}
+	for _, id := range req.TxIDs {
+		listed[id] = true
+	}
+	return func(ctx context.Context, r *runbookRun) error {
+		p := &r.job.Progress
+		p.Unit = "entries"
+		entries, err := outbox.list()
+		if err != nil {
+			return err
+		}
+		var stuck []*OutboxEntry
+		for _, e := range entries {
+			if time.Since(e.CreatedAt) < olderThan {
+				continue
+			}
+			if len(listed) > 0 {
+				if listed[e.TxID] {
+					stuck = append(stuck, e)
+				}
+			} else if e.Confirmed && e.Attempts > 0 || !e.Confirmed && time.Since(e.CreatedAt) > outbox.timeout {
+				stuck = append(stuck, e)
+			}
+		}
+		p.Total = int64(len(stuck))
+		for _, e := range stuck {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			redrive(ctx, e, p, r.job.DryRun)
+			p.Done++
+			if err := r.save(false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, true
+}
+
+func redrive(ctx context.Context, e *OutboxEntry, p *RunbookProgress, dryRun bool) {
+	if dryRun {
+		action := "deliver"
+		if !e.Confirmed {
+			action = "resolve"
+		}
+		p.Counts[action]++
+		p.add(RunbookItem{Key: e.TxID, Action: action, Detail: fmt.Sprintf("%d attempts", e.Attempts)})
+		return
+	}
+	if !e.Confirmed {
+		outbox.resolve(e)
+		cur, err := outbox.get(e.TxID)
+		switch {
+		case err != nil:
+			p.Counts["failed"]++
+			p.add(RunbookItem{Key: e.TxID, Action: "resolve", Detail: err.Error()})
+			return
+		case cur == nil:
+			p.Counts["dropped"]++
+			p.add(RunbookItem{Key: e.TxID, Action: "drop", Detail: "did not commit"})
+			return
+		case !cur.Confirmed:
+			p.Counts["unresolved"]++
+			p.add(RunbookItem{Key: e.TxID, Action: "resolve", Detail: "commit status unknown"})
+			return
+		}
+		e = cur
+	}
+	if err := outbox.deliver(ctx, e); err != nil {
+		p.Counts["failed"]++
+		p.add(RunbookItem{Key: e.TxID, Action: "deliver", Detail: err.Error()})
+		return
+	}
+	outbox.drop(e.TxID)
+	p.Counts["delivered"]++
+	p.add(RunbookItem{Key: e.TxID, Action: "deliver"})
+}
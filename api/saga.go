@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+const (
+	SagaRunning      = "running"
+	SagaCompleted    = "completed"
+	SagaCompensating = "compensating"
+	SagaCompensated  = "compensated"
+	SagaFailed       = "failed"
+
+	StepPending     = "pending"
+	StepDone        = "done"
+	StepFailed      = "failed"
+	StepCompensated = "compensated"
+
+	sagaAttempts = 3
+	// sagaStale is how long a saga, and its lock, must sit untouched before
+	// the leader resumes it.
+	sagaStale = time.Minute
+)
+
+// SagaStep is one PostEntry. Its operation ID, and that of its compensation,
+// derive from the saga ID, so the chaincode applies each at most once however
+// often it is retried.
+type SagaStep struct {
+	Name             string `json:"name" xml:"name"`
+	MSISDN           string `json:"MSISDN" xml:"MSISDN"`
+	TransType        string `json:"TRANSTYPE" xml:"TRANSTYPE"`
+	Amount           int64  `json:"amount" xml:"amount"`
+	Remarks          string `json:"remarks" xml:"remarks"`
+	State            string `json:"state" xml:"state"`
+	TxID             string `json:"txId,omitempty" xml:"txId,omitempty"`
+	CompensationTxID string `json:"compensationTxId,omitempty" xml:"compensationTxId,omitempty"`
+	Error            string `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// Saga is a multi-transaction operation. Steps run in order; when one fails
+// for good, the steps already done are undone in reverse with ADJUSTMENT
+// postings. A saga that cannot reach Fabric stays running or compensating
+// and is resumed later.
+type Saga struct {
+	ID    string      `json:"id" xml:"id"`
+	Type  string      `json:"type" xml:"type"`
+	State string      `json:"state" xml:"state"`
+	Steps []*SagaStep `json:"steps" xml:"steps>step"`
+	Error string      `json:"error,omitempty" xml:"error,omitempty"`
+	// Origin is the context of the request that started the saga, sent
+	// with every step, resumed ones included.
+	Origin    *RequestContext `json:"origin,omitempty" xml:"origin,omitempty"`
+	CreatedAt time.Time       `json:"createdAt" xml:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt" xml:"updatedAt"`
+}
+
+func (s *Saga) finished() bool {
+	return s.State == SagaCompleted || s.State == SagaCompensated || s.State == SagaFailed
+}
+
+// sagaStore keeps one file per saga in SAGA_DIR. As with the outbox, several
+// replicas need a shared volume.
+type sagaStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var sagas *sagaStore
+
+var sagaID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+func loadSagas() {
+	dir := os.Getenv("SAGA_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-api-sagas")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("sagas: %v", err)
+	}
+	sagas = &sagaStore{dir: dir}
+}
+
+func (st *sagaStore) path(id string) string {
+	return filepath.Join(st.dir, id+".json")
+}
+
+func (st *sagaStore) put(s *Saga) error {
+	s.UpdatedAt = time.Now().UTC()
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := st.path(s.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path(s.ID))
+}
+
+func (st *sagaStore) get(id string) (*Saga, error) {
+	b, err := os.ReadFile(st.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Saga
+	return &s, json.Unmarshal(b, &s)
+}
+
+// create stores s unless a saga with its ID exists, which it returns instead.
+func (st *sagaStore) create(s *Saga) (*Saga, bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	existing, err := st.get(s.ID)
+	if err != nil || existing != nil {
+		return existing, false, err
+	}
+	return s, true, st.put(s)
+}
+
+func (st *sagaStore) list() ([]*Saga, error) {
+	files, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Saga
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		s, err := st.get(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// sagaLease is a saga's lock file in SAGA_DIR, held by whoever runs the
+// saga and touched before every attempt, so a step retrying for longer than
+// sagaStale is not resumed a second time in parallel.
+type sagaLease struct{ path string }
+
+// acquire takes the lock of saga id, or returns nil if someone holds it. With
+// takeOver, a lock untouched for sagaStale, left by a replica that died mid
+// saga, is taken over; only the leader does that, in runSagas, so two
+// replicas never take over the same lock.
+func (st *sagaStore) acquire(id string, takeOver bool) (*sagaLease, error) {
+	p := filepath.Join(st.dir, id+".lock")
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) && takeOver {
+		if fi, serr := os.Stat(p); serr == nil && time.Since(fi.ModTime()) > sagaStale {
+			os.Remove(p)
+			f, err = os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		}
+	}
+	if errors.Is(err, os.ErrExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sagaLease{path: p}, f.Close()
+}
+
+func (l *sagaLease) renew() {
+	now := time.Now()
+	if err := os.Chtimes(l.path, now, now); err != nil {
+		log.Printf("saga lock: %v", err)
+	}
+}
+
+func (l *sagaLease) release() {
+	if err := os.Remove(l.path); err != nil {
+		log.Printf("saga lock: %v", err)
+	}
+}
+
+// post submits one PostEntry under opID. An operation the chaincode reports
+// as a duplicate was applied by an earlier attempt and counts as success.
+// final is false for errors after which the posting may still have happened
+// or may succeed later.
+func post(opID string, origin *RequestContext, msisdn, transType string, amount int64, remarks string) (txID string, final bool, err error) {
+	transient := map[string][]byte{"operationId": []byte(opID)}
+	addRequestContext(transient, origin)
+	_, st, err := submit("PostEntry", client.WithArguments(msisdn, transType, strconv.FormatInt(amount, 10), remarks), client.WithTransient(transient))
+	if err == nil {
+		return st.TransactionID, true, nil
+	}
+	fe := classify(err)
+	if strings.Contains(fe.Error, "duplicate operation") {
+		return "", true, nil
+	}
+	switch {
+	case fe.Retryable, fe.Category == CategoryCommitStatus, fe.Category == CategoryUnknown:
+		return "", false, err
+	}
+	return "", true, err
+}
+
+// runSaga drives s as far as it can go and persists every transition. The
+// caller holds lease.
+func runSaga(s *Saga, lease *sagaLease) {
+	save := func() {
+		if err := sagas.put(s); err != nil {
+			log.Printf("saga %s: %v", s.ID, err)
+		}
+	}
+	for i, step := range s.Steps {
+		if s.State != SagaRunning {
+			break
+		}
+		if step.State != StepPending {
+			continue
+		}
+		var err error
+		var final bool
+		for attempt := 0; attempt < sagaAttempts; attempt++ {
+			lease.renew()
+			var txID string
+			if txID, final, err = post(s.ID+":"+strconv.Itoa(i), s.Origin, step.MSISDN, step.TransType, step.Amount, step.Remarks); err == nil {
+				step.TxID = txID
+				break
+			}
+			if final {
+				break
+			}
+			time.Sleep(time.Second << attempt)
+		}
+		switch {
+		case err == nil:
+			step.State = StepDone
+			step.Error = ""
+		case final:
+			step.State = StepFailed
+			step.Error = classify(err).Error
+			s.State = SagaCompensating
+			s.Error = fmt.Sprintf("step %s failed", step.Name)
+		default:
+			step.Error = classify(err).Error
+			save()
+			return
+		}
+		save()
+	}
+	if s.State == SagaRunning {
+		s.State = SagaCompleted
+		save()
+		return
+	}
+	if s.State != SagaCompensating {
+		return
+	}
+	for i := len(s.Steps) - 1; i >= 0; i-- {
+		step := s.Steps[i]
+		if step.State != StepDone {
+			continue
+		}
+		lease.renew()
+		txID, final, err := post(s.ID+":"+strconv.Itoa(i)+":undo", s.Origin, step.MSISDN, "ADJUSTMENT", -step.Amount, "compensates "+s.ID+"/"+step.Name)
+		switch {
+		case err == nil:
+			step.State = StepCompensated
+			step.CompensationTxID = txID
+		case final:
+			// Needs an operator: the money cannot be put back automatically.
+			step.Error = "compensation failed: " + classify(err).Error
+			s.State = SagaFailed
+			save()
+			return
+		default:
+			step.Error = "compensation pending: " + classify(err).Error
+			save()
+			return
+		}
+		save()
+	}
+	s.State = SagaCompensated
+	save()
+}
+
+// runSagas resumes sagas left unfinished by a crash or an unreachable peer.
+// It runs on the leader.
+func runSagas(ctx context.Context) {
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		list, err := sagas.list()
+		if err != nil {
+			log.Printf("sagas: %v", err)
+			continue
+		}
+		for _, s := range list {
+			if ctx.Err() != nil {
+				return
+			}
+			if !s.finished() && time.Since(s.UpdatedAt) > sagaStale {
+				resumeSaga(s.ID)
+			}
+		}
+	}
+}
+
+// resumeSaga runs saga id unless someone still holds its lock, from its
+// state once the lock is taken.
+func resumeSaga(id string) {
+	lease, err := sagas.acquire(id, true)
+	if err != nil || lease == nil {
+		if err != nil {
+			log.Printf("saga %s: %v", id, err)
+		}
+		return
+	}
+	defer lease.release()
+	s, err := sagas.get(id)
+	if err != nil {
+		log.Printf("saga %s: %v", id, err)
+		return
+	}
+	if s != nil && !s.finished() {
+		runSaga(s, lease)
+	}
+}
+
+type transferRequest struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Amount     int64  `json:"amount"`
+	Fee        int64  `json:"fee"`
+	FeeAccount string `json:"feeAccount"`
+	Remarks    string `json:"remarks"`
+}
+
+// transferSteps moves amount from From to To, then the fee from From to
+// FeeAccount.
+func transferSteps(r *transferRequest) []*SagaStep {
+	steps := []*SagaStep{
+		{Name: "debit-sender", MSISDN: r.From, TransType: "TRANSFER_OUT", Amount: -r.Amount, Remarks: r.Remarks},
+		{Name: "credit-receiver", MSISDN: r.To, TransType: "TRANSFER_IN", Amount: r.Amount, Remarks: r.Remarks},
+	}
+	if r.Fee > 0 {
+		steps = append(steps,
+			&SagaStep{Name: "debit-fee", MSISDN: r.From, TransType: "DEBIT", Amount: -r.Fee, Remarks: "fee"},
+			&SagaStep{Name: "credit-fee-account", MSISDN: r.FeeAccount, TransType: "CREDIT", Amount: r.Fee, Remarks: "fee from " + r.From},
+		)
+	}
+	for _, s := range steps {
+		s.State = StepPending
+	}
+	return steps
+}
+
+// transferHandler runs a transfer with an optional fee as a saga and answers
+// with its final state. X-Operation-ID, if given, is the saga ID, so a
+// retried request returns the first one's saga instead of starting another.
+func transferHandler(c *gin.Context) {
+	var req transferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		bodyError(c, err)
+		return
+	}
+	switch {
+	case req.From == "" || req.To == "" || req.From == req.To:
+		apiError(c, 400, ErrInvalidTransfer, gin.H{"detail": "from and to must be two different accounts"})
+		return
+	case req.Amount <= 0 || req.Fee < 0:
+		apiError(c, 400, ErrInvalidTransfer, gin.H{"detail": "amount must be positive and fee not negative"})
+		return
+	case req.Fee > 0 && req.FeeAccount == "":
+		apiError(c, 400, ErrInvalidTransfer, gin.H{"detail": "feeAccount required with a fee"})
+		return
+	}
+	id := c.GetHeader(operationIDHeader)
+	if id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			internalError(c, err)
+			return
+		}
+		id = hex.EncodeToString(b)
+	} else if !sagaID.MatchString(id) {
+		apiError(c, 400, ErrInvalidOperationID, gin.H{"header": operationIDHeader})
+		return
+	}
+	// A retried operation was screened when first submitted.
+	if existing, _ := sagas.get(id); existing == nil {
+		args := []string{req.From, req.To, strconv.FormatInt(req.Amount, 10), req.Remarks}
+		if !checkAccountStatus(c, "Transfer", args) {
+			return
+		}
+		op := monetaryOperation(c, "Transfer", args)
+		op.OperationID = id
+		if !screenOperation(c, op) {
+			return
+		}
+	}
+	s, created, err := sagas.create(&Saga{ID: id, Type: "transfer", State: SagaRunning, Steps: transferSteps(&req), Origin: currentRequestContext(c), CreatedAt: time.Now().UTC()})
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if created {
+		lease, err := sagas.acquire(s.ID, false)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		if lease != nil {
+			runSaga(s, lease)
+			lease.release()
+		}
+	}
+	sagaResponse(c, s)
+}
+
+func sagaResponse(c *gin.Context, s *Saga) {
+	switch s.State {
+	case SagaCompleted:
+		c.JSON(200, s)
+	case SagaCompensated:
+		c.JSON(409, errorBody(c, ErrOperationCompensated, gin.H{"operation": s}))
+	case SagaFailed:
+		c.JSON(500, errorBody(c, ErrOperationFailed, gin.H{"operation": s}))
+	default:
+		c.JSON(202, s)
+	}
+}
+
+func operationHandler(c *gin.Context) {
+	if !sagaID.MatchString(c.Param("id")) {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	s, err := sagas.get(c.Param("id"))
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if s == nil {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	respond(c, 200, s)
+}
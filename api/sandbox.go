@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The sandbox runs the API against an in-process ledger instead of a Fabric
+// network. It serves the Gateway gRPC service over an in-memory connection,
+// so the gateway client, and everything the API builds on it, runs exactly
+// as against a peer; only the chaincode is replaced, by the Go emulation of
+// its account functions in sandboxcc.go, over map-backed state.
+
+const sandboxMSP = "SandboxMSP"
+
+// sandboxMod is one entry of a key's history.
+type sandboxMod struct {
+	txID     string
+	value    []byte
+	isDelete bool
+	ts       *timestamppb.Timestamp
+}
+
+// sandboxLedger is the emulated channel: world state with a version per key
+// for read conflicts, key history, and one block per submitted transaction.
+type sandboxLedger struct {
+	sync.Mutex
+	state    map[string][]byte
+	versions map[string]uint64
+	history  map[string][]sandboxMod
+	version  uint64
+	height   uint64
+	blocks   map[string]uint64
+	codes    map[string]peer.TxValidationCode
+	pending  map[string]*sandboxTx
+	events   []*gateway.ChaincodeEventsResponse
+	subs     map[chan *gateway.ChaincodeEventsResponse]bool
+}
+
+func newSandboxLedger() *sandboxLedger {
+	// Block 0 is the genesis block, as on a real channel.
+	return &sandboxLedger{state: map[string][]byte{}, versions: map[string]uint64{}, history: map[string][]sandboxMod{}, height: 1, blocks: map[string]uint64{}, codes: map[string]peer.TxValidationCode{}, pending: map[string]*sandboxTx{}, subs: map[chan *gateway.ChaincodeEventsResponse]bool{}}
+}
+
+// sandboxTx is a proposal being executed. Reads see committed state, like
+// chaincode on a peer, and record the version they saw; writes are kept
+// until the transaction is submitted.
+type sandboxTx struct {
+	ledger    *sandboxLedger
+	header    *common.Header
+	txID      string
+	chaincode string
+	fn        string
+	args      []string
+	transient map[string][]byte
+	ts        *timestamppb.Timestamp
+	reads     map[string]uint64
+	writes    map[string][]byte
+	deletes   map[string]bool
+	event     *peer.ChaincodeEvent
+	// conflict makes the commit fail as an MVCC read conflict whatever was
+	// read; see sandboxFault.
+	conflict bool
+}
+
+// sandboxFault is what fault injection does to one gateway call: wait
+// before answering, fail it with err, corrupt the payload it returns, or
+// let a submitted transaction commit as an MVCC read conflict.
+type sandboxFault struct {
+	delay    time.Duration
+	err      error
+	malform  bool
+	conflict bool
+}
+
+// sandboxFaults picks the fault for a call ("evaluate", "endorse" or
+// "submit") of tx. Only builds with the sandboxfaults tag set it; see
+// sandboxfaults.go.
+var sandboxFaults func(call string, tx *sandboxTx) sandboxFault
+
+// faultRoutes registers /admin/faults in sandbox mode; like sandboxFaults
+// it is only set by the sandboxfaults build.
+var faultRoutes func(admin *gin.RouterGroup)
+
+// fault returns the fault for call once its delay has passed, or the
+// context's error if the client gave up first.
+func (tx *sandboxTx) fault(ctx context.Context, call string) (sandboxFault, error) {
+	if sandboxFaults == nil {
+		return sandboxFault{}, nil
+	}
+	f := sandboxFaults(call, tx)
+	if f.delay > 0 {
+		t := time.NewTimer(f.delay)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return f, status.FromContextError(ctx.Err()).Err()
+		case <-t.C:
+		}
+	}
+	return f, f.err
+}
+
+// malformed is res cut short and followed by bytes no JSON decoder takes.
+func malformed(res []byte) []byte {
+	return append(res[:len(res)/2:len(res)/2], "\x00<html>"...)
+}
+
+func (tx *sandboxTx) get(key string) []byte {
+	tx.ledger.Lock()
+	defer tx.ledger.Unlock()
+	tx.reads[key] = tx.ledger.versions[key]
+	return tx.ledger.state[key]
+}
+
+func (tx *sandboxTx) put(key string, value []byte) {
+	delete(tx.deletes, key)
+	tx.writes[key] = value
+}
+
+func (tx *sandboxTx) del(key string) {
+	delete(tx.writes, key)
+	tx.deletes[key] = true
+}
+
+func (tx *sandboxTx) setEvent(name string, payload []byte) {
+	tx.event = &peer.ChaincodeEvent{ChaincodeId: tx.chaincode, TxId: tx.txID, EventName: name, Payload: payload}
+}
+
+// keys lists the committed simple keys from start, in order, as a range
+// query does; composite keys are left out.
+func (tx *sandboxTx) keys(start string) []string {
+	tx.ledger.Lock()
+	defer tx.ledger.Unlock()
+	var out []string
+	for k := range tx.ledger.state {
+		if !strings.HasPrefix(k, compositeKeyNamespace) && k >= start {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (tx *sandboxTx) keyHistory(key string) []sandboxMod {
+	tx.ledger.Lock()
+	defer tx.ledger.Unlock()
+	return append([]sandboxMod(nil), tx.ledger.history[key]...)
+}
+
+const compositeKeyNamespace = "\x00"
+
+// compositeKey builds a key the way the shim's CreateCompositeKey does.
+func compositeKey(objectType string, attrs ...string) string {
+	return compositeKeyNamespace + objectType + "\x00" + strings.Join(attrs, "\x00") + "\x00"
+}
+
+// parseSandboxProposal unpacks what the gateway client signed: the channel
+// header for the transaction ID and timestamp, and the invocation's
+// chaincode, function, arguments and transient data.
+func (l *sandboxLedger) parseSandboxProposal(sp *peer.SignedProposal) (*sandboxTx, error) {
+	var prop peer.Proposal
+	if err := proto.Unmarshal(sp.GetProposalBytes(), &prop); err != nil {
+		return nil, err
+	}
+	var hdr common.Header
+	if err := proto.Unmarshal(prop.GetHeader(), &hdr); err != nil {
+		return nil, err
+	}
+	var ch common.ChannelHeader
+	if err := proto.Unmarshal(hdr.GetChannelHeader(), &ch); err != nil {
+		return nil, err
+	}
+	var ext peer.ChaincodeHeaderExtension
+	if err := proto.Unmarshal(ch.GetExtension(), &ext); err != nil {
+		return nil, err
+	}
+	var payload peer.ChaincodeProposalPayload
+	if err := proto.Unmarshal(prop.GetPayload(), &payload); err != nil {
+		return nil, err
+	}
+	var spec peer.ChaincodeInvocationSpec
+	if err := proto.Unmarshal(payload.GetInput(), &spec); err != nil {
+		return nil, err
+	}
+	args := spec.GetChaincodeSpec().GetInput().GetArgs()
+	if len(args) == 0 {
+		return nil, errors.New("no function in proposal")
+	}
+	tx := &sandboxTx{ledger: l, header: &hdr, txID: ch.GetTxId(), chaincode: ext.GetChaincodeId().GetName(), fn: string(args[0]), transient: payload.GetTransientMap(), ts: ch.GetTimestamp(), reads: map[string]uint64{}, writes: map[string][]byte{}, deletes: map[string]bool{}}
+	for _, a := range args[1:] {
+		tx.args = append(tx.args, string(a))
+	}
+	// The contract API's own namespacing, "contract:function".
+	if _, fn, ok := strings.Cut(tx.fn, ":"); ok {
+		tx.fn = fn
+	}
+	return tx, nil
+}
+
+// commit validates tx against the reads it made and applies its writes in
+// a new block.
+func (l *sandboxLedger) commit(tx *sandboxTx) {
+	l.Lock()
+	defer l.Unlock()
+	if _, dup := l.codes[tx.txID]; dup {
+		return
+	}
+	block := l.height
+	l.height++
+	l.blocks[tx.txID] = block
+	if tx.conflict {
+		l.codes[tx.txID] = peer.TxValidationCode_MVCC_READ_CONFLICT
+		return
+	}
+	for k, v := range tx.reads {
+		if l.versions[k] != v {
+			l.codes[tx.txID] = peer.TxValidationCode_MVCC_READ_CONFLICT
+			return
+		}
+	}
+	l.codes[tx.txID] = peer.TxValidationCode_VALID
+	keys := make([]string, 0, len(tx.writes)+len(tx.deletes))
+	for k := range tx.writes {
+		keys = append(keys, k)
+	}
+	for k := range tx.deletes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		l.version++
+		l.versions[k] = l.version
+		mod := sandboxMod{txID: tx.txID, ts: tx.ts}
+		if v, ok := tx.writes[k]; ok {
+			l.state[k] = v
+			mod.value = v
+		} else {
+			delete(l.state, k)
+			mod.isDelete = true
+		}
+		l.history[k] = append(l.history[k], mod)
+	}
+	if tx.event != nil {
+		ev := &gateway.ChaincodeEventsResponse{Events: []*peer.ChaincodeEvent{tx.event}, BlockNumber: block}
+		l.events = append(l.events, ev)
+		for sub := range l.subs {
+			select {
+			case sub <- ev:
+			default:
+				// A subscriber this far behind is cut off; the client
+				// reconnects from its last block.
+				delete(l.subs, sub)
+				close(sub)
+			}
+		}
+	}
+}
+
+// sandboxGateway serves the Gateway service from a sandboxLedger.
+type sandboxGateway struct {
+	gateway.UnimplementedGatewayServer
+	ledger *sandboxLedger
+}
+
+// chaincodeStatus is a chaincode failure as the gateway reports it, so the
+// API classifies it as a rejection, as it would from a peer.
+func chaincodeStatus(code codes.Code, msg string, err error) error {
+	detail := fmt.Sprintf("chaincode response 500, %v", err)
+	st, derr := status.New(code, msg+": "+detail).WithDetails(&gateway.ErrorDetail{Address: "sandbox", MspId: sandboxMSP, Message: detail})
+	if derr != nil {
+		return status.Error(code, msg+": "+detail)
+	}
+	return st.Err()
+}
+
+func (g *sandboxGateway) Evaluate(ctx context.Context, req *gateway.EvaluateRequest) (*gateway.EvaluateResponse, error) {
+	tx, err := g.ledger.parseSandboxProposal(req.GetProposedTransaction())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	f, err := tx.fault(ctx, "evaluate")
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.execute()
+	if err != nil {
+		return nil, chaincodeStatus(codes.Unknown, "evaluate call to endorser returned error", err)
+	}
+	if f.malform {
+		res = malformed(res)
+	}
+	return &gateway.EvaluateResponse{Result: &peer.Response{Status: 200, Payload: res}}, nil
+}
+
+// Endorse runs the proposal and keeps its writes for Submit, returning the
+// transaction envelope the client signs.
+func (g *sandboxGateway) Endorse(ctx context.Context, req *gateway.EndorseRequest) (*gateway.EndorseResponse, error) {
+	tx, err := g.ledger.parseSandboxProposal(req.GetProposedTransaction())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	f, err := tx.fault(ctx, "endorse")
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.execute()
+	if err != nil {
+		return nil, chaincodeStatus(codes.Aborted, "failed to endorse transaction, see attached details for more info", err)
+	}
+	if f.malform {
+		res = malformed(res)
+	}
+	env, err := tx.envelope(res)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	g.ledger.Lock()
+	g.ledger.pending[tx.txID] = tx
+	g.ledger.Unlock()
+	return &gateway.EndorseResponse{PreparedTransaction: env}, nil
+}
+
+// envelope builds the endorsed transaction around res the way a peer's
+// proposal response carries it, which is where the client reads the result.
+func (tx *sandboxTx) envelope(res []byte) (*common.Envelope, error) {
+	var events []byte
+	if tx.event != nil {
+		var err error
+		if events, err = proto.Marshal(tx.event); err != nil {
+			return nil, err
+		}
+	}
+	action, err := proto.Marshal(&peer.ChaincodeAction{Response: &peer.Response{Status: 200, Payload: res}, Events: events, ChaincodeId: &peer.ChaincodeID{Name: tx.chaincode}})
+	if err != nil {
+		return nil, err
+	}
+	prp, err := proto.Marshal(&peer.ProposalResponsePayload{Extension: action})
+	if err != nil {
+		return nil, err
+	}
+	actionPayload, err := proto.Marshal(&peer.ChaincodeActionPayload{Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: prp}})
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(&peer.Transaction{Actions: []*peer.TransactionAction{{Header: tx.header.GetSignatureHeader(), Payload: actionPayload}}})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := proto.Marshal(&common.Payload{Header: tx.header, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return &common.Envelope{Payload: payload}, nil
+}
+
+// Submit commits an endorsed transaction at once, in its own block.
+func (g *sandboxGateway) Submit(ctx context.Context, req *gateway.SubmitRequest) (*gateway.SubmitResponse, error) {
+	g.ledger.Lock()
+	tx, ok := g.ledger.pending[req.GetTransactionId()]
+	delete(g.ledger.pending, req.GetTransactionId())
+	g.ledger.Unlock()
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "transaction "+req.GetTransactionId()+" was not endorsed")
+	}
+	f, err := tx.fault(ctx, "submit")
+	if err != nil {
+		return nil, err
+	}
+	tx.conflict = f.conflict
+	g.ledger.commit(tx)
+	return &gateway.SubmitResponse{}, nil
+}
+
+func (g *sandboxGateway) CommitStatus(ctx context.Context, req *gateway.SignedCommitStatusRequest) (*gateway.CommitStatusResponse, error) {
+	var r gateway.CommitStatusRequest
+	if err := proto.Unmarshal(req.GetRequest(), &r); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	g.ledger.Lock()
+	defer g.ledger.Unlock()
+	code, ok := g.ledger.codes[r.GetTransactionId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "transaction "+r.GetTransactionId()+" not found")
+	}
+	return &gateway.CommitStatusResponse{Result: code, BlockNumber: g.ledger.blocks[r.GetTransactionId()]}, nil
+}
+
+// ChaincodeEvents replays the committed events from the requested block,
+// then streams new ones until the client goes away.
+func (g *sandboxGateway) ChaincodeEvents(req *gateway.SignedChaincodeEventsRequest, stream gateway.Gateway_ChaincodeEventsServer) error {
+	var r gateway.ChaincodeEventsRequest
+	if err := proto.Unmarshal(req.GetRequest(), &r); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	start := r.GetStartPosition().GetSpecified().GetNumber()
+	sub := make(chan *gateway.ChaincodeEventsResponse, 256)
+	g.ledger.Lock()
+	// Without a start block the client asks for the next commit on.
+	var backlog []*gateway.ChaincodeEventsResponse
+	if r.GetStartPosition().GetSpecified() != nil {
+		backlog = g.ledger.events
+	}
+	g.ledger.subs[sub] = true
+	g.ledger.Unlock()
+	defer func() {
+		g.ledger.Lock()
+		if g.ledger.subs[sub] {
+			delete(g.ledger.subs, sub)
+			close(sub)
+		}
+		g.ledger.Unlock()
+	}()
+	send := func(ev *gateway.ChaincodeEventsResponse) error {
+		if ev.GetBlockNumber() < start || ev.GetEvents()[0].GetChaincodeId() != r.GetChaincodeId() {
+			return nil
+		}
+		return stream.Send(ev)
+	}
+	for _, ev := range backlog {
+		if err := send(ev); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case ev, ok := <-sub:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "event subscriber too slow")
+			}
+			if err := send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// newSandboxIdentity is a throwaway key and self-signed certificate for
+// signing proposals; the sandbox checks no signatures.
+func newSandboxIdentity() (*credentialStore, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "sandbox"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().AddDate(10, 0, 0)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	s := &credentialStore{mspID: sandboxMSP}
+	s.current.Store(&material{certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key: key})
+	s.roots.Store(&tlsRoots{pool: x509.NewCertPool()})
+	return s, nil
+}
+
+// connectSandbox is connect for --sandbox: the same gateway client and
+// connection pool, dialled to the in-process ledger. CHANNEL_NAME and
+// CHAINCODE_NAME are optional.
+func connectSandbox() {
+	var err error
+	if ids, err = newSandboxIdentity(); err != nil {
+		log.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	gateway.RegisterGatewayServer(srv, &sandboxGateway{ledger: newSandboxLedger()})
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("sandbox: %v", err)
+		}
+	}()
+	dial := func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }
+	peerPool, err = dialPool("passthrough:///sandbox", 1, grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithChainUnaryInterceptor(peerLatencyInterceptor, slowQueryInterceptor))
+	if err != nil {
+		log.Fatal(err)
+	}
+	peerConn = peerPool
+	gw, err = client.Connect(ids, append(ids.connectOptions(), client.WithClientConnection(peerConn), client.WithEvaluateTimeout(10*time.Second), client.WithEndorseTimeout(10*time.Second), client.WithSubmitTimeout(10*time.Second), client.WithCommitStatusTimeout(10*time.Second))...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	channel, ccName := os.Getenv("CHANNEL_NAME"), os.Getenv("CHAINCODE_NAME")
+	if channel == "" {
+		channel = "sandbox"
+	}
+	if ccName == "" {
+		ccName = "asset-management"
+	}
+	network = gw.GetNetwork(channel)
+	contract = network.GetContract(ccName)
+	qscc = network.GetContract("qscc")
+	log.Printf("sandbox: in-memory ledger, channel %s, chaincode %s; nothing is persisted", channel, ccName)
+}
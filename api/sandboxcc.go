@@ -0,0 +1,549 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// sandboxFunctions emulate the account functions of the asset-management
+// chaincode with its validation, error messages, events and origin
+// records. Any other function fails with sandboxUnsupported, which the API
+// answers as 501 SANDBOX_UNSUPPORTED.
+// Accounts are stored as JSON and never encrypted: the sandbox ignores
+// X-Encryption-Key.
+var sandboxFunctions = map[string]func(tx *sandboxTx) (any, error){
+	"CreateAsset":         sandboxCreateAsset,
+	"UpdateAsset":         sandboxUpdateAsset,
+	"CreateAssetJSON":     sandboxCreateAssetJSON,
+	"UpdateAssetJSON":     sandboxUpdateAssetJSON,
+	"DeleteAsset":         sandboxDeleteAsset,
+	"ReadAsset":           sandboxReadAsset,
+	"ReadAssets":          sandboxReadAssets,
+	"GetAllAssets":        sandboxAllAssets,
+	"GetAssetsPage":       sandboxAssetsPage,
+	"GetAssetsByDealer":   sandboxAssetsByDealer,
+	"GetAssetHistory":     sandboxHistory,
+	"GetAssetHistoryPage": sandboxHistoryPage,
+	"GetMaintenance":      sandboxGetMaintenance,
+	"SetMaintenance":      sandboxSetMaintenance,
+	"GetOrigin":           sandboxGetOrigin,
+	"Ping":                sandboxPing,
+}
+
+// sandboxUnsupported prefixes the name of a function the sandbox does not
+// emulate in the error it endorses.
+const sandboxUnsupported = "not emulated by the sandbox: "
+
+// unsupportedInSandbox answers 501 if err is the sandbox refusing a
+// function it does not emulate, so clients can tell a gap in the emulator
+// from a chaincode failure.
+func unsupportedInSandbox(c *gin.Context, err error) bool {
+	_, fn, ok := strings.Cut(err.Error(), sandboxUnsupported)
+	if !ok {
+		return false
+	}
+	if i := strings.IndexFunc(fn, func(r rune) bool { return r == ' ' || r == ',' || r == '"' }); i >= 0 {
+		fn = fn[:i]
+	}
+	apiError(c, 501, ErrSandboxUnsupported, gin.H{"function": fn})
+	return true
+}
+
+// sandboxArity is the number of arguments each function takes.
+var sandboxArity = map[string]int{
+	"CreateAsset": 8, "UpdateAsset": 8, "CreateAssetJSON": 1, "UpdateAssetJSON": 1, "DeleteAsset": 1, "ReadAsset": 1, "ReadAssets": 1, "GetAllAssets": 0, "GetAssetsPage": 2,
+	"GetAssetsByDealer": 1, "GetAssetHistory": 1, "GetAssetHistoryPage": 3, "GetMaintenance": 0, "SetMaintenance": 1, "GetOrigin": 1,
+	"Ping": 0,
+}
+
+// sandboxWrites are the functions refused while the maintenance flag is set.
+var sandboxWrites = map[string]bool{"CreateAsset": true, "UpdateAsset": true, "CreateAssetJSON": true, "UpdateAssetJSON": true, "DeleteAsset": true}
+
+// execute runs tx's function and returns its result as the contract API
+// would serialise it.
+func (tx *sandboxTx) execute() ([]byte, error) {
+	if tx.chaincode == "qscc" {
+		return tx.qscc()
+	}
+	fn, ok := sandboxFunctions[tx.fn]
+	if !ok {
+		return nil, fmt.Errorf("%s%s", sandboxUnsupported, tx.fn)
+	}
+	if n := sandboxArity[tx.fn]; len(tx.args) != n {
+		return nil, fmt.Errorf("Incorrect number of params. Expected %d, received %d", n, len(tx.args))
+	}
+	if sandboxWrites[tx.fn] && len(tx.get(compositeKey("config", "maintenance"))) == 1 {
+		return nil, errors.New("maintenance mode: writes are frozen")
+	}
+	res, err := fn(tx)
+	if err != nil || res == nil {
+		return nil, err
+	}
+	if b, ok := res.(bool); ok {
+		return []byte(strconv.FormatBool(b)), nil
+	}
+	return json.Marshal(res)
+}
+
+// qscc answers the system chaincode queries the API makes.
+func (tx *sandboxTx) qscc() ([]byte, error) {
+	l := tx.ledger
+	l.Lock()
+	defer l.Unlock()
+	switch {
+	case tx.fn == "GetChainInfo":
+		return proto.Marshal(&common.BlockchainInfo{Height: l.height})
+	case tx.fn == "GetBlockByTxID" && len(tx.args) == 2:
+		n, ok := l.blocks[tx.args[1]]
+		if !ok {
+			return nil, fmt.Errorf("no such transaction ID [%s] in index", tx.args[1])
+		}
+		return proto.Marshal(&common.Block{Header: &common.BlockHeader{Number: n}})
+	}
+	return nil, fmt.Errorf("%s is not available in the sandbox", tx.fn)
+}
+
+func (tx *sandboxTx) account(msisdn string) (*Account, error) {
+	b := tx.get(msisdn)
+	if b == nil {
+		return nil, nil
+	}
+	var a Account
+	return &a, json.Unmarshal(b, &a)
+}
+
+func sandboxParseAccount(args []string) (*Account, error) {
+	bal, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	tamt, err := strconv.ParseInt(args[5], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return sandboxValidate(&Account{DEALERID: args[0], MSISDN: args[1], MPIN: args[2], BALANCE: bal, STATUS: args[4], TRANSAMOUNT: tamt, TRANSTYPE: args[6], REMARKS: args[7]})
+}
+
+// sandboxPayloadFields are the chaincode's account payload schema: each
+// field's type and its length bounds for strings (-1 for none).
+var sandboxPayloadFields = map[string]struct {
+	integer        bool
+	minLen, maxLen int
+}{
+	"DEALERID":    {minLen: 1, maxLen: 64},
+	"MSISDN":      {minLen: 1, maxLen: 64},
+	"MPIN":        {maxLen: -1},
+	"BALANCE":     {integer: true},
+	"STATUS":      {minLen: 1, maxLen: 32},
+	"TRANSAMOUNT": {integer: true},
+	"TRANSTYPE":   {maxLen: -1},
+	"REMARKS":     {maxLen: 256},
+}
+
+// sandboxParseAccountPayload checks a CreateAssetJSON or UpdateAssetJSON
+// payload as the chaincode's schema does, with the same messages.
+func sandboxParseAccountPayload(payload string) (*Account, error) {
+	dec := json.NewDecoder(strings.NewReader(payload))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	if dec.More() {
+		return nil, errors.New("payload: trailing data after the object")
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("payload: $: must be an object")
+	}
+	for _, name := range []string{"DEALERID", "MSISDN", "MPIN", "BALANCE", "STATUS"} {
+		if _, ok := obj[name]; !ok {
+			return nil, fmt.Errorf("payload: $.%s: required", name)
+		}
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, ok := sandboxPayloadFields[name]
+		if !ok {
+			return nil, fmt.Errorf("payload: $.%s: unknown field", name)
+		}
+		if f.integer {
+			if n, ok := obj[name].(json.Number); !ok {
+				return nil, fmt.Errorf("payload: $.%s: must be an integer", name)
+			} else if _, err := n.Int64(); err != nil {
+				return nil, fmt.Errorf("payload: $.%s: must be a 64-bit integer", name)
+			}
+			continue
+		}
+		s, ok := obj[name].(string)
+		if !ok {
+			return nil, fmt.Errorf("payload: $.%s: must be a string", name)
+		}
+		if n := utf8.RuneCountInString(s); n < f.minLen {
+			return nil, fmt.Errorf("payload: $.%s: must be at least %d characters", name, f.minLen)
+		} else if f.maxLen >= 0 && n > f.maxLen {
+			return nil, fmt.Errorf("payload: $.%s: exceeds %d characters", name, f.maxLen)
+		}
+		if _, ok := sandboxTransSigns[s]; name == "TRANSTYPE" && s != "" && !ok {
+			return nil, errors.New("payload: $.TRANSTYPE: must be one of , CREDIT, DEBIT, TRANSFER_IN, TRANSFER_OUT, ADJUSTMENT")
+		}
+	}
+	var a Account
+	if err := json.Unmarshal([]byte(payload), &a); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	return sandboxValidate(&a)
+}
+
+var sandboxTransSigns = map[string]int{"CREDIT": 1, "DEBIT": -1, "TRANSFER_IN": 1, "TRANSFER_OUT": -1, "ADJUSTMENT": 0}
+
+func sandboxValidate(a *Account) (*Account, error) {
+	if a.TRANSTYPE == "" {
+		if a.TRANSAMOUNT != 0 {
+			return nil, errors.New("TRANSTYPE required when TRANSAMOUNT is set")
+		}
+	} else if sign, ok := sandboxTransSigns[a.TRANSTYPE]; !ok {
+		return nil, fmt.Errorf("invalid TRANSTYPE %q", a.TRANSTYPE)
+	} else if sign > 0 && a.TRANSAMOUNT < 0 || sign < 0 && a.TRANSAMOUNT > 0 {
+		return nil, fmt.Errorf("TRANSAMOUNT %d inconsistent with TRANSTYPE %s", a.TRANSAMOUNT, a.TRANSTYPE)
+	}
+	if utf8.RuneCountInString(a.REMARKS) > 256 {
+		return nil, errors.New("REMARKS exceeds 256 characters")
+	}
+	return a, nil
+}
+
+// putAccount stores a, claiming the request's operation ID, recording its
+// origin and announcing it with event, as the chaincode's putAccount does.
+func (tx *sandboxTx) putAccount(a *Account, event string) error {
+	now := tx.ts.GetSeconds()
+	if opID := string(tx.transient["operationId"]); opID != "" {
+		key := compositeKey("dedup", opID)
+		var prev struct {
+			TxID      string `json:"txId"`
+			Timestamp int64  `json:"timestamp"`
+		}
+		if b := tx.get(key); b != nil && json.Unmarshal(b, &prev) == nil && now-prev.Timestamp < 24*60*60 {
+			return fmt.Errorf("duplicate operation %s: already applied in %s", opID, prev.TxID)
+		}
+		b, _ := json.Marshal(map[string]any{"txId": tx.txID, "MSISDN": a.MSISDN, "timestamp": now})
+		tx.put(key, b)
+	}
+	a.LastModified = now
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if err := tx.recordOrigin(); err != nil {
+		return err
+	}
+	tx.put(a.MSISDN, b)
+	ev := *a
+	ev.MPIN = ""
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	tx.setEvent(event, raw)
+	return nil
+}
+
+func sandboxCreateAsset(tx *sandboxTx) (any, error) {
+	a, err := sandboxParseAccount(tx.args)
+	if err != nil {
+		return nil, err
+	}
+	return tx.createAccount(a)
+}
+
+func sandboxCreateAssetJSON(tx *sandboxTx) (any, error) {
+	a, err := sandboxParseAccountPayload(tx.args[0])
+	if err != nil {
+		return nil, err
+	}
+	return tx.createAccount(a)
+}
+
+func (tx *sandboxTx) createAccount(a *Account) (any, error) {
+	if tx.get(a.MSISDN) != nil {
+		return nil, errors.New("asset exists")
+	}
+	a.CreatedAt = tx.ts.GetSeconds()
+	return nil, tx.putAccount(a, "AssetCreated")
+}
+
+func sandboxUpdateAsset(tx *sandboxTx) (any, error) {
+	a, err := sandboxParseAccount(tx.args)
+	if err != nil {
+		return nil, err
+	}
+	return tx.updateAccount(a)
+}
+
+func sandboxUpdateAssetJSON(tx *sandboxTx) (any, error) {
+	a, err := sandboxParseAccountPayload(tx.args[0])
+	if err != nil {
+		return nil, err
+	}
+	return tx.updateAccount(a)
+}
+
+func (tx *sandboxTx) updateAccount(a *Account) (any, error) {
+	existing, err := tx.account(a.MSISDN)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, errors.New("not found")
+	}
+	if existing.STATUS == "CLOSED" {
+		return nil, errors.New("account is CLOSED")
+	}
+	a.PARENT, a.CreatedAt = existing.PARENT, existing.CreatedAt
+	return nil, tx.putAccount(a, "AssetUpdated")
+}
+
+func sandboxDeleteAsset(tx *sandboxTx) (any, error) {
+	a, err := tx.account(tx.args[0])
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, errors.New("not found")
+	}
+	if err := tx.recordOrigin(); err != nil {
+		return nil, err
+	}
+	tx.del(tx.args[0])
+	raw, err := json.Marshal(Account{MSISDN: tx.args[0], DEALERID: a.DEALERID})
+	if err != nil {
+		return nil, err
+	}
+	tx.setEvent("AssetDeleted", raw)
+	return nil, nil
+}
+
+func sandboxReadAsset(tx *sandboxTx) (any, error) {
+	a, err := tx.account(tx.args[0])
+	if err == nil && a == nil {
+		err = errors.New("not found")
+	}
+	return a, err
+}
+
+func sandboxReadAssets(tx *sandboxTx) (any, error) {
+	var list []string
+	if err := json.Unmarshal([]byte(tx.args[0]), &list); err != nil {
+		return nil, fmt.Errorf("msisdns: %w", err)
+	}
+	if len(list) > 500 {
+		return nil, errors.New("at most 500 accounts per call")
+	}
+	out := []*Account{}
+	for _, m := range list {
+		a, err := tx.account(m)
+		if err != nil {
+			return nil, err
+		}
+		if a != nil {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+// accounts returns up to limit accounts from start, all for limit 0, and
+// the key following the last one.
+func (tx *sandboxTx) accounts(start string, limit int, keep func(*Account) bool) ([]*Account, string, error) {
+	out := []*Account{}
+	keys := tx.keys(start)
+	for i, k := range keys {
+		if limit > 0 && len(out) == limit {
+			return out, keys[i], nil
+		}
+		a, err := tx.account(k)
+		if err != nil {
+			return nil, "", err
+		}
+		if a != nil && keep(a) {
+			out = append(out, a)
+		}
+	}
+	return out, "", nil
+}
+
+func sandboxAllAssets(tx *sandboxTx) (any, error) {
+	out, _, err := tx.accounts("", 0, func(*Account) bool { return true })
+	return out, err
+}
+
+func sandboxAssetsPage(tx *sandboxTx) (any, error) {
+	pageSize, err := strconv.Atoi(tx.args[0])
+	if err != nil {
+		return nil, err
+	}
+	if pageSize < 1 {
+		return nil, errors.New("pageSize must be positive")
+	}
+	records, next, err := tx.accounts(tx.args[1], pageSize, func(*Account) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	page := AssetPage{Bookmark: next, Fetched: int32(len(records))}
+	for _, a := range records {
+		page.Records = append(page.Records, *a)
+	}
+	if page.Records == nil {
+		page.Records = []Account{}
+	}
+	return page, nil
+}
+
+func sandboxAssetsByDealer(tx *sandboxTx) (any, error) {
+	out, _, err := tx.accounts("", 0, func(a *Account) bool { return a.DEALERID == tx.args[0] })
+	return out, err
+}
+
+// history returns msisdn's history newest first, the order of
+// GetHistoryForKey on Fabric 2.
+func (tx *sandboxTx) history(msisdn string, skip func(txID string) bool, limit int) ([]History, string, error) {
+	mods := tx.keyHistory(msisdn)
+	out := []History{}
+	for i := len(mods) - 1; i >= 0; i-- {
+		m := mods[i]
+		if skip != nil && skip(m.txID) {
+			continue
+		}
+		if limit > 0 && len(out) == limit {
+			return out, out[limit-1].TxID, nil
+		}
+		h := History{TxID: m.txID, IsDelete: m.isDelete, Timestamp: m.ts.GetSeconds(), TimestampNanos: m.ts.GetSeconds()*1e9 + int64(m.ts.GetNanos())}
+		if !m.isDelete {
+			sum := sha256.Sum256(m.value)
+			h.ValueHash = hex.EncodeToString(sum[:])
+			var a Account
+			if err := json.Unmarshal(m.value, &a); err != nil {
+				return nil, "", err
+			}
+			h.Value = &a
+		}
+		o, err := tx.origin(m.txID)
+		if err != nil {
+			return nil, "", err
+		}
+		h.Origin = o
+		out = append(out, h)
+	}
+	return out, "", nil
+}
+
+func sandboxHistory(tx *sandboxTx) (any, error) {
+	h, _, err := tx.history(tx.args[0], nil, 0)
+	return h, err
+}
+
+func sandboxHistoryPage(tx *sandboxTx) (any, error) {
+	maxRecords, err := strconv.Atoi(tx.args[1])
+	if err != nil {
+		return nil, err
+	}
+	if maxRecords < 1 {
+		return nil, errors.New("maxRecords must be positive")
+	}
+	after := tx.args[2]
+	found := after == ""
+	skip := func(txID string) bool {
+		if found {
+			return false
+		}
+		found = txID == after
+		return true
+	}
+	records, next, err := tx.history(tx.args[0], skip, maxRecords)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s is not in the history of %s", after, tx.args[0])
+	}
+	return HistoryPage{Records: records, Next: next}, nil
+}
+
+func sandboxGetMaintenance(tx *sandboxTx) (any, error) {
+	return len(tx.get(compositeKey("config", "maintenance"))) == 1, nil
+}
+
+func sandboxSetMaintenance(tx *sandboxTx) (any, error) {
+	on, err := strconv.ParseBool(tx.args[0])
+	if err != nil {
+		return nil, err
+	}
+	if on {
+		tx.put(compositeKey("config", "maintenance"), []byte{1})
+	} else {
+		tx.del(compositeKey("config", "maintenance"))
+	}
+	return nil, nil
+}
+
+// sandboxPing reports the emulator's real height: it has no need for the
+// chaincode's marker.
+func sandboxPing(tx *sandboxTx) (any, error) {
+	tx.ledger.Lock()
+	defer tx.ledger.Unlock()
+	return Ping{Version: "sandbox", Height: tx.ledger.height, MarkedAt: time.Now().Unix(), ServerTime: time.Now().UnixMilli()}, nil
+}
+
+// recordOrigin stores the request context under the transaction ID, as the
+// chaincode does for every account write.
+func (tx *sandboxTx) recordOrigin() error {
+	raw := tx.transient["requestContext"]
+	if raw == nil {
+		return nil
+	}
+	var o Origin
+	if err := json.Unmarshal(raw, &o); err != nil {
+		return fmt.Errorf("requestContext: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	o.Fingerprint = hex.EncodeToString(sum[:])
+	b, err := json.Marshal(&o)
+	if err != nil {
+		return err
+	}
+	tx.put(compositeKey("origin", tx.txID), b)
+	return nil
+}
+
+func (tx *sandboxTx) origin(txID string) (*Origin, error) {
+	b := tx.get(compositeKey("origin", txID))
+	if b == nil {
+		return nil, nil
+	}
+	var o Origin
+	return &o, json.Unmarshal(b, &o)
+}
+
+func sandboxGetOrigin(tx *sandboxTx) (any, error) {
+	o, err := tx.origin(tx.args[0])
+	if err == nil && o == nil {
+		err = errors.New("not found")
+	}
+	return o, err
+}
@@ -0,0 +1,216 @@
+//go:build sandboxfaults
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Fault injection for client teams: with the sandboxfaults build tag and
+// --sandbox, /admin/faults adds rules that slow down or break the emulated
+// gateway's calls the way a Fabric network does. Production builds leave
+// this file out, so sandboxFaults stays nil and nothing is ever injected.
+
+func init() {
+	sandboxFaults = injectFault
+	faultRoutes = func(admin *gin.RouterGroup) {
+		admin.GET("/faults", listFaultsHandler)
+		admin.POST("/faults", addFaultHandler)
+		admin.DELETE("/faults", clearFaultsHandler)
+		admin.DELETE("/faults/:id", deleteFaultHandler)
+	}
+}
+
+// Fault types.
+const (
+	FaultLatency     = "latency"
+	FaultEndorsement = "endorsement"
+	FaultMVCC        = "mvcc"
+	FaultMalformed   = "malformed"
+)
+
+// faultCalls are the gateway calls each fault type can apply to, the
+// default first.
+var faultCalls = map[string][]string{
+	FaultLatency:     {"evaluate", "endorse", "submit"},
+	FaultEndorsement: {"endorse"},
+	FaultMVCC:        {"submit"},
+	FaultMalformed:   {"evaluate", "endorse"},
+}
+
+// FaultRule injects Type into calls of Function ("*" for all) made through
+// the gateway's Calls, each time with Probability, at most Times times when
+// that is set. A latency fault waits Delay; an endorsement fault fails the
+// way an unsatisfied endorsement policy does, with Message as the peer's
+// detail.
+type FaultRule struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Function    string   `json:"function"`
+	Calls       []string `json:"calls"`
+	Probability float64  `json:"probability"`
+	Times       int      `json:"times,omitempty"`
+	Delay       string   `json:"delay,omitempty"`
+	Message     string   `json:"message,omitempty"`
+	Fired       int      `json:"fired"`
+	delay       time.Duration
+}
+
+var faults = struct {
+	sync.Mutex
+	rules []*FaultRule
+	next  int
+}{}
+
+func (r *FaultRule) validate() error {
+	calls, ok := faultCalls[r.Type]
+	if !ok {
+		return fmt.Errorf("type must be %s, %s, %s or %s", FaultLatency, FaultEndorsement, FaultMVCC, FaultMalformed)
+	}
+	if r.Function == "" {
+		r.Function = "*"
+	}
+	if len(r.Calls) == 0 {
+		r.Calls = calls
+	}
+	for _, c := range r.Calls {
+		found := false
+		for _, allowed := range calls {
+			found = found || c == allowed
+		}
+		if !found {
+			return fmt.Errorf("%s faults apply to %v, not %q", r.Type, calls, c)
+		}
+	}
+	if r.Probability == 0 {
+		r.Probability = 1
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return errors.New("probability must be between 0 and 1")
+	}
+	if r.Times < 0 {
+		return errors.New("times must not be negative")
+	}
+	if r.Type == FaultLatency {
+		d, err := time.ParseDuration(r.Delay)
+		if err != nil || d <= 0 || d > 5*time.Minute {
+			return errors.New("delay must be a duration up to 5m")
+		}
+		r.delay = d
+	}
+	if r.Type == FaultEndorsement && r.Message == "" {
+		r.Message = "implicit policy evaluation failed - 0 sub-policies were satisfied, but this policy requires 1 of the 'Endorsement' sub-policies to be satisfied"
+	}
+	return nil
+}
+
+func (r *FaultRule) matches(call string, tx *sandboxTx) bool {
+	if r.Function != "*" && r.Function != tx.fn || r.Times > 0 && r.Fired >= r.Times {
+		return false
+	}
+	for _, c := range r.Calls {
+		if c == call {
+			return rand.Float64() < r.Probability
+		}
+	}
+	return false
+}
+
+// injectFault applies every rule that fires for the call, in the order the
+// rules were added: delays add up, and the first endorsement failure wins.
+func injectFault(call string, tx *sandboxTx) sandboxFault {
+	faults.Lock()
+	defer faults.Unlock()
+	var f sandboxFault
+	for _, r := range faults.rules {
+		if !r.matches(call, tx) {
+			continue
+		}
+		r.Fired++
+		switch r.Type {
+		case FaultLatency:
+			f.delay += r.delay
+		case FaultEndorsement:
+			if f.err == nil {
+				f.err = endorsementFailure(r.Message)
+			}
+		case FaultMVCC:
+			f.conflict = true
+		case FaultMalformed:
+			f.malform = true
+		}
+	}
+	return f
+}
+
+// endorsementFailure is the gateway's answer when the endorsements collected
+// do not satisfy the policy. Unlike a chaincode error it is retryable.
+func endorsementFailure(msg string) error {
+	st, err := status.New(codes.Aborted, "failed to collect enough transaction endorsements, see attached details for more info").WithDetails(&gateway.ErrorDetail{Address: "sandbox", MspId: sandboxMSP, Message: msg})
+	if err != nil {
+		return status.Error(codes.Aborted, msg)
+	}
+	return st.Err()
+}
+
+func faultList() []FaultRule {
+	out := []FaultRule{}
+	for _, r := range faults.rules {
+		out = append(out, *r)
+	}
+	return out
+}
+
+func listFaultsHandler(c *gin.Context) {
+	faults.Lock()
+	defer faults.Unlock()
+	c.JSON(200, faultList())
+}
+
+func addFaultHandler(c *gin.Context) {
+	var r FaultRule
+	if err := c.ShouldBindJSON(&r); err != nil {
+		bodyError(c, err)
+		return
+	}
+	if err := r.validate(); err != nil {
+		apiError(c, 400, ErrInvalidFault, gin.H{"detail": err.Error()})
+		return
+	}
+	faults.Lock()
+	defer faults.Unlock()
+	faults.next++
+	r.ID, r.Fired = strconv.Itoa(faults.next), 0
+	faults.rules = append(faults.rules, &r)
+	c.JSON(201, r)
+}
+
+func deleteFaultHandler(c *gin.Context) {
+	faults.Lock()
+	defer faults.Unlock()
+	for i, r := range faults.rules {
+		if r.ID == c.Param("id") {
+			faults.rules = append(faults.rules[:i], faults.rules[i+1:]...)
+			c.JSON(200, faultList())
+			return
+		}
+	}
+	apiError(c, 404, ErrNotFound, nil)
+}
+
+func clearFaultsHandler(c *gin.Context) {
+	faults.Lock()
+	defer faults.Unlock()
+	faults.rules = nil
+	c.JSON(200, faultList())
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// maxSettlementDays is how many days of postings the change index keeps for
+// settlement files; RETENTION_INDEX can trim them sooner.
+const maxSettlementDays = 400
+
+// camtNamespace is the ISO 20022 account report the camt format follows.
+// It is camt.052 rather than the end-of-day camt.053 because the index has
+// no dealer-level opening and closing balances, which camt.053 requires.
+const camtNamespace = "urn:iso:std:iso:20022:tech:xsd:camt.052.001.08"
+
+// SettlementEntry is one committed posting, filed under its account's
+// dealer and the UTC day of its transaction.
+type SettlementEntry struct {
+	TxID        string `json:"txId"`
+	BlockNumber uint64 `json:"blockNumber"`
+	MSISDN      string `json:"MSISDN"`
+	TRANSTYPE   string `json:"TRANSTYPE"`
+	TRANSAMOUNT int64  `json:"TRANSAMOUNT"`
+	BALANCE     int64  `json:"BALANCE"`
+	Currency    string `json:"currency,omitempty"`
+	REMARKS     string `json:"REMARKS,omitempty"`
+	Receipt     string `json:"receipt,omitempty"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// fileSettlement adds p to its day and dealer. Postings of accounts the
+// index has no state for, such as one deleted in the same transaction, have
+// no dealer and are left out. The caller holds changeIndex if s is its
+// snapshot.
+func (s *indexSnapshot) fileSettlement(p *indexedPosting, block uint64) {
+	acc := s.Accounts[p.MSISDN]
+	if acc == nil || acc.DEALERID == "" {
+		return
+	}
+	day := time.Unix(p.Timestamp, 0).UTC().Format(time.DateOnly)
+	dealers := s.Settlements[day]
+	if dealers == nil {
+		dealers = map[string][]*SettlementEntry{}
+		s.Settlements[day] = dealers
+		if len(s.Settlements) > maxSettlementDays {
+			oldest := day
+			for d := range s.Settlements {
+				if d < oldest {
+					oldest = d
+				}
+			}
+			delete(s.Settlements, oldest)
+		}
+	}
+	dealers[acc.DEALERID] = append(dealers[acc.DEALERID], &SettlementEntry{
+		TxID: p.TxID, BlockNumber: block, MSISDN: p.MSISDN, TRANSTYPE: p.TRANSTYPE, TRANSAMOUNT: p.TRANSAMOUNT,
+		BALANCE: p.BALANCE, Currency: acc.CURRENCY, REMARKS: p.REMARKS, Receipt: p.Receipt, Timestamp: p.Timestamp,
+	})
+}
+
+// settlementEntries copies a dealer's postings on day out of the index.
+func settlementEntries(day, dealerID string) []SettlementEntry {
+	changeIndex.RLock()
+	defer changeIndex.RUnlock()
+	out := make([]SettlementEntry, 0, len(changeIndex.Settlements[day][dealerID]))
+	for _, e := range changeIndex.Settlements[day][dealerID] {
+		out = append(out, *e)
+	}
+	return out
+}
+
+// baseCurrency is the currency of accounts without a CURRENCY, from the
+// chaincode's FX config, or XXX (no currency) when it cannot be read.
+func baseCurrency() string {
+	res, err := contract.Evaluate("GetFXConfig")
+	if err != nil {
+		return "XXX"
+	}
+	var cfg FXConfig
+	if json.Unmarshal(res, &cfg) != nil || cfg.BaseCurrency == "" {
+		return "XXX"
+	}
+	return cfg.BaseCurrency
+}
+
+// settlementFile renders a dealer's day in format, "fixed" or "camt", and
+// returns its body, content type and file name.
+func settlementFile(format, day, dealerID string, entries []SettlementEntry, created time.Time) ([]byte, string, string, error) {
+	base := baseCurrency()
+	for i := range entries {
+		if entries[i].Currency == "" {
+			entries[i].Currency = base
+		}
+	}
+	name := "settlement-" + fileSafe.ReplaceAllString(dealerID, "_") + "-" + strings.ReplaceAll(day, "-", "")
+	switch format {
+	case "fixed":
+		return fixedSettlement(day, dealerID, entries, created), "text/plain; charset=us-ascii", name + ".txt", nil
+	case "camt":
+		b, err := camtSettlement(day, dealerID, entries, created)
+		return b, "application/xml", name + ".xml", err
+	}
+	return nil, "", "", fmt.Errorf("unknown settlement format %q", format)
+}
+
+var fileSafe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// fixedField pads or cuts s to n bytes, replacing anything but printable
+// ASCII with '?'.
+func fixedField(s string, n int) string {
+	b := make([]byte, 0, n)
+	for _, r := range s {
+		if len(b) == n {
+			break
+		}
+		if r < 0x20 || r > 0x7e {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	for len(b) < n {
+		b = append(b, ' ')
+	}
+	return string(b)
+}
+
+func fixedNumber(n int64, width int) string {
+	return fmt.Sprintf("%0*d", width, n)
+}
+
+func fixedSigned(n int64, width int) string {
+	if n < 0 {
+		return "-" + fixedNumber(-n, width)
+	}
+	return "+" + fixedNumber(n, width)
+}
+
+// fixedSettlement writes the fixed-format file: a header record, one detail
+// record per posting in commit order and a trailer record, each ending in
+// "\n". The layout is in the README.
+func fixedSettlement(day, dealerID string, entries []SettlementEntry, created time.Time) []byte {
+	var b bytes.Buffer
+	b.WriteString("H" + "SETTLEMENT" + strings.ReplaceAll(day, "-", "") + fixedField(dealerID, 20) + created.UTC().Format("20060102150405") + "\n")
+	var credits, debits, nCredits, nDebits int64
+	for i, e := range entries {
+		ind, amount := "C", e.TRANSAMOUNT
+		if amount < 0 {
+			ind, amount = "D", -amount
+			debits += amount
+			nDebits++
+		} else {
+			credits += amount
+			nCredits++
+		}
+		b.WriteString("D" + fixedNumber(int64(i+1), 6) + time.Unix(e.Timestamp, 0).UTC().Format("150405") +
+			fixedNumber(int64(e.BlockNumber), 12) + fixedField(e.TxID, 64) + fixedField(e.MSISDN, 20) +
+			fixedField(e.TRANSTYPE, 12) + ind + fixedNumber(amount, 18) + fixedField(e.Currency, 3) +
+			fixedSigned(e.BALANCE, 18) + fixedField(e.Receipt, 32) + fixedField(e.REMARKS, 40) + "\n")
+	}
+	b.WriteString("T" + fixedNumber(int64(len(entries)), 8) + fixedNumber(nCredits, 8) + fixedNumber(credits, 18) +
+		fixedNumber(nDebits, 8) + fixedNumber(debits, 18) + fixedSigned(credits-debits, 18) + "\n")
+	return b.Bytes()
+}
+
+// The camt elements the settlement file uses, in schema order.
+
+type camtDocument struct {
+	XMLName xml.Name   `xml:"Document"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Report  camtReport `xml:"BkToCstmrAcctRpt"`
+}
+
+type camtReport struct {
+	GroupHeader camtGroupHeader `xml:"GrpHdr"`
+	Report      camtRpt         `xml:"Rpt"`
+}
+
+type camtGroupHeader struct {
+	MsgID   string `xml:"MsgId"`
+	Created string `xml:"CreDtTm"`
+}
+
+type camtRpt struct {
+	ID      string      `xml:"Id"`
+	Created string      `xml:"CreDtTm"`
+	Period  camtPeriod  `xml:"FrToDt"`
+	Account camtAccount `xml:"Acct"`
+	Summary camtSummary `xml:"TxsSummry"`
+	Entries []camtEntry `xml:"Ntry"`
+}
+
+type camtPeriod struct {
+	From string `xml:"FrDtTm"`
+	To   string `xml:"ToDtTm"`
+}
+
+type camtAccount struct {
+	ID string `xml:"Id>Othr>Id"`
+}
+
+type camtSummary struct {
+	Total   camtTotal `xml:"TtlNtries"`
+	Credits camtCount `xml:"TtlCdtNtries"`
+	Debits  camtCount `xml:"TtlDbtNtries"`
+}
+
+type camtTotal struct {
+	Count     int    `xml:"NbOfNtries"`
+	Sum       int64  `xml:"Sum"`
+	NetAmount int64  `xml:"TtlNetNtry>Amt"`
+	NetInd    string `xml:"TtlNetNtry>CdtDbtInd"`
+}
+
+type camtCount struct {
+	Count int   `xml:"NbOfNtries"`
+	Sum   int64 `xml:"Sum"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    int64  `xml:",chardata"`
+}
+
+type camtEntry struct {
+	Ref         string        `xml:"NtryRef"`
+	Amount      camtAmount    `xml:"Amt"`
+	Indicator   string        `xml:"CdtDbtInd"`
+	Status      string        `xml:"Sts>Cd"`
+	Booked      string        `xml:"BookgDt>DtTm"`
+	Value       string        `xml:"ValDt>Dt"`
+	ServicerRef string        `xml:"AcctSvcrRef"`
+	TxCode      string        `xml:"BkTxCd>Prtry>Cd"`
+	Details     camtTxDetails `xml:"NtryDtls>TxDtls"`
+}
+
+type camtTxDetails struct {
+	TxID       string          `xml:"Refs>TxId"`
+	Debtor     *camtAccount    `xml:"RltdPties>DbtrAcct"`
+	Creditor   *camtAccount    `xml:"RltdPties>CdtrAcct"`
+	Remittance *camtRemittance `xml:"RmtInf"`
+}
+
+type camtRemittance struct {
+	Unstructured string `xml:"Ustrd"`
+}
+
+// camtSettlement writes the day as an ISO 20022 camt.052 account report on
+// the dealer, one booked entry per posting. Amounts are in the ledger's
+// units; the entry's MSISDN is the creditor or debtor account.
+func camtSettlement(day, dealerID string, entries []SettlementEntry, created time.Time) ([]byte, error) {
+	start, _ := time.Parse(time.DateOnly, day)
+	id := "SETTLEMENT-" + strings.ReplaceAll(day, "-", "") + "-" + dealerID
+	rpt := camtRpt{
+		ID: id, Created: created.UTC().Format(time.RFC3339),
+		Period:  camtPeriod{From: start.Format(time.RFC3339), To: start.Add(24*time.Hour - time.Second).Format(time.RFC3339)},
+		Account: camtAccount{ID: dealerID},
+		Entries: []camtEntry{},
+	}
+	for i, e := range entries {
+		ne := camtEntry{
+			Ref: strconv.Itoa(i + 1), Amount: camtAmount{Currency: e.Currency, Value: e.TRANSAMOUNT}, Indicator: "CRDT", Status: "BOOK",
+			Booked: time.Unix(e.Timestamp, 0).UTC().Format(time.RFC3339), Value: day, ServicerRef: e.TxID, TxCode: e.TRANSTYPE,
+			Details: camtTxDetails{TxID: e.TxID, Creditor: &camtAccount{ID: e.MSISDN}},
+		}
+		if e.REMARKS != "" {
+			// Ustrd holds at most 140 characters.
+			r := []rune(e.REMARKS)
+			ne.Details.Remittance = &camtRemittance{Unstructured: string(r[:min(len(r), 140)])}
+		}
+		if e.TRANSAMOUNT < 0 {
+			ne.Amount.Value, ne.Indicator = -e.TRANSAMOUNT, "DBIT"
+			ne.Details.Creditor, ne.Details.Debtor = nil, &camtAccount{ID: e.MSISDN}
+			rpt.Summary.Debits.Count++
+			rpt.Summary.Debits.Sum += ne.Amount.Value
+		} else {
+			rpt.Summary.Credits.Count++
+			rpt.Summary.Credits.Sum += ne.Amount.Value
+		}
+		rpt.Entries = append(rpt.Entries, ne)
+	}
+	s := &rpt.Summary
+	s.Total.Count, s.Total.Sum = len(entries), s.Credits.Sum+s.Debits.Sum
+	s.Total.NetAmount, s.Total.NetInd = s.Credits.Sum-s.Debits.Sum, "CRDT"
+	if s.Total.NetAmount < 0 {
+		s.Total.NetAmount, s.Total.NetInd = -s.Total.NetAmount, "DBIT"
+	}
+	doc := camtDocument{Xmlns: camtNamespace, Report: camtReport{GroupHeader: camtGroupHeader{MsgID: id, Created: rpt.Created}, Report: rpt}}
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// settlementHandler serves :dealerId's settlement file for :date, a UTC day
+// that has ended, in ?format=fixed (the default) or camt. Dealers get their
+// own only. A day without postings gives a file with no entries.
+func settlementHandler(c *gin.Context) {
+	if !changeIndex.enabled {
+		apiError(c, 503, ErrChangeIndexDisabled, nil)
+		return
+	}
+	dealerID := c.Param("dealerId")
+	if p := principal(c); p.Role == RoleDealer && p.DealerID != dealerID {
+		apiError(c, 403, ErrForbidden, nil)
+		return
+	}
+	day, err := time.Parse(time.DateOnly, c.Param("date"))
+	if err != nil {
+		apiError(c, 400, ErrInvalidDate, gin.H{"param": "date"})
+		return
+	}
+	if !day.Add(24 * time.Hour).Before(time.Now()) {
+		apiError(c, 409, ErrSettlementDayOpen, gin.H{"date": c.Param("date")})
+		return
+	}
+	format := c.DefaultQuery("format", "fixed")
+	if format != "fixed" && format != "camt" {
+		apiError(c, 400, ErrInvalidFormat, nil)
+		return
+	}
+	date := day.Format(time.DateOnly)
+	b, contentType, name, err := settlementFile(format, date, dealerID, settlementEntries(date, dealerID), time.Now())
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+	c.Data(200, contentType, b)
+}
+
+// sftpTarget is where the leader pushes each day's settlement files.
+type sftpTarget struct {
+	addr   string
+	dir    string
+	format string
+	delay  time.Duration
+	state  string
+	config *ssh.ClientConfig
+}
+
+// settlementPush is nil unless SETTLEMENT_SFTP_URL is set.
+var settlementPush *sftpTarget
+
+var settlementStats = struct {
+	sync.Mutex
+	pushed   uint64
+	failures uint64
+}{}
+
+// loadSettlementPush reads SETTLEMENT_SFTP_URL (sftp://user@host[:port]/dir)
+// with the private key in SETTLEMENT_SFTP_KEY and the server's key in the
+// known_hosts file SETTLEMENT_SFTP_KNOWN_HOSTS.
+func loadSettlementPush() {
+	raw := os.Getenv("SETTLEMENT_SFTP_URL")
+	if raw == "" {
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "sftp" || u.Host == "" || u.User.Username() == "" {
+		log.Fatalf("SETTLEMENT_SFTP_URL must be sftp://user@host[:port]/dir")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	key, err := os.ReadFile(mustEnv("SETTLEMENT_SFTP_KEY"))
+	if err != nil {
+		log.Fatalf("SETTLEMENT_SFTP_KEY: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		log.Fatalf("SETTLEMENT_SFTP_KEY: %v", err)
+	}
+	hostKeys, err := knownhosts.New(mustEnv("SETTLEMENT_SFTP_KNOWN_HOSTS"))
+	if err != nil {
+		log.Fatalf("SETTLEMENT_SFTP_KNOWN_HOSTS: %v", err)
+	}
+	t := &sftpTarget{addr: addr, dir: u.Path, format: "fixed", delay: 15 * time.Minute,
+		config: &ssh.ClientConfig{User: u.User.Username(), Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)}, HostKeyCallback: hostKeys, Timeout: 30 * time.Second}}
+	if v := os.Getenv("SETTLEMENT_FORMAT"); v != "" {
+		if v != "fixed" && v != "camt" {
+			log.Fatalf("SETTLEMENT_FORMAT must be fixed or camt")
+		}
+		t.format = v
+	}
+	if v := os.Getenv("SETTLEMENT_PUSH_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("SETTLEMENT_PUSH_DELAY must be a duration")
+		}
+		t.delay = d
+	}
+	dir := os.Getenv("SETTLEMENT_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-api-settlements")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("settlements: %v", err)
+	}
+	t.state = filepath.Join(dir, "pushed.json")
+	settlementPush = t
+}
+
+// runSettlementPush uploads every dealer's file for each day once the day
+// is SETTLEMENT_PUSH_DELAY past its end, giving the index time to catch up,
+// until ctx is cancelled. It runs on the leader.
+func runSettlementPush(ctx context.Context) {
+	t := time.NewTicker(5 * time.Minute)
+	defer t.Stop()
+	for {
+		if err := settlementPush.pushDue(ctx); err != nil {
+			log.Printf("settlement push: %v", err)
+			settlementStats.Lock()
+			settlementStats.failures++
+			settlementStats.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// pushDue pushes the days after the last one pushed, up to the latest that
+// is due. Without a record of earlier pushes it starts at the latest, so a
+// new deployment does not upload the index's whole history.
+func (t *sftpTarget) pushDue(ctx context.Context) error {
+	latest := time.Now().Add(-t.delay).UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	day := latest
+	var state struct {
+		Through string `json:"through"`
+	}
+	if b, err := os.ReadFile(t.state); err == nil {
+		if err := json.Unmarshal(b, &state); err != nil {
+			return fmt.Errorf("%s: %w", t.state, err)
+		}
+		last, err := time.Parse(time.DateOnly, state.Through)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.state, err)
+		}
+		day = last.AddDate(0, 0, 1)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for ; !day.After(latest) && ctx.Err() == nil; day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		if err := t.pushDay(date); err != nil {
+			return fmt.Errorf("%s: %w", date, err)
+		}
+		state.Through = date
+		b, _ := json.Marshal(state)
+		if err := os.WriteFile(t.state+".tmp", b, 0o600); err != nil {
+			return err
+		}
+		if err := os.Rename(t.state+".tmp", t.state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushDay uploads one file per dealer with postings on date, each written
+// under a .part name and renamed once complete.
+func (t *sftpTarget) pushDay(date string) error {
+	changeIndex.RLock()
+	dealers := make([]string, 0, len(changeIndex.Settlements[date]))
+	for d := range changeIndex.Settlements[date] {
+		dealers = append(dealers, d)
+	}
+	changeIndex.RUnlock()
+	if len(dealers) == 0 {
+		return nil
+	}
+	sort.Strings(dealers)
+	conn, err := ssh.Dial("tcp", t.addr, t.config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	for _, dealerID := range dealers {
+		b, _, name, err := settlementFile(t.format, date, dealerID, settlementEntries(date, dealerID), time.Now())
+		if err != nil {
+			return err
+		}
+		p := path.Join(t.dir, name)
+		f, err := client.Create(p + ".part")
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(b)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = client.PosixRename(p+".part", p)
+		}
+		if err != nil {
+			client.Remove(p + ".part")
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		settlementStats.Lock()
+		settlementStats.pushed++
+		settlementStats.Unlock()
+	}
+	log.Printf("settlement push: %s: %d files to %s", date, len(dealers), t.addr)
+	return nil
+}
+
+func writeSettlementMetrics(b *strings.Builder) {
+	if settlementPush == nil {
+		return
+	}
+	settlementStats.Lock()
+	defer settlementStats.Unlock()
+	b.WriteString("# HELP fabric_api_settlement_files_pushed_total Settlement files uploaded to the SFTP target.\n")
+	b.WriteString("# TYPE fabric_api_settlement_files_pushed_total counter\n")
+	fmt.Fprintf(b, "fabric_api_settlement_files_pushed_total %d\n", settlementStats.pushed)
+	b.WriteString("# HELP fabric_api_settlement_push_failures_total Settlement push runs that stopped on an error.\n")
+	b.WriteString("# TYPE fabric_api_settlement_push_failures_total counter\n")
+	fmt.Fprintf(b, "fabric_api_settlement_push_failures_total %d\n", settlementStats.failures)
+}
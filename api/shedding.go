@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+
+	shedWindow     = 30 // seconds of gateway calls considered
+	minShedSamples = 20
+)
+
+type callBucket struct {
+	sec    int64
+	calls  int
+	errors int
+	total  time.Duration
+}
+
+// peerWindow holds per-second gateway call stats for the last shedWindow
+// seconds.
+type peerWindow [shedWindow]callBucket
+
+func (w *peerWindow) add(now time.Time, d time.Duration, failed bool) {
+	sec := now.Unix()
+	b := &w[sec%shedWindow]
+	if b.sec != sec {
+		*b = callBucket{sec: sec}
+	}
+	b.calls++
+	b.total += d
+	if failed {
+		b.errors++
+	}
+}
+
+func (w *peerWindow) stats(now time.Time) (calls int, avg time.Duration, errorRate float64) {
+	var errs int
+	var total time.Duration
+	for _, b := range w {
+		if now.Unix()-b.sec < shedWindow {
+			calls += b.calls
+			errs += b.errors
+			total += b.total
+		}
+	}
+	if calls == 0 {
+		return 0, 0, 0
+	}
+	return calls, total / time.Duration(calls), float64(errs) / float64(calls)
+}
+
+var shedding = struct {
+	sync.Mutex
+	enabled    bool
+	latency    time.Duration
+	errorRate  float64
+	priorities map[string]string
+	peers      map[string]*peerWindow
+}{priorities: map[string]string{}, peers: map[string]*peerWindow{}}
+
+// loadShedding enables shedding when SHED_LATENCY is set. SHED_ERROR_RATE is
+// the share of failed gateway calls that counts as degraded (default 0.2) and
+// SHED_PRIORITIES overrides route priorities, e.g.
+// "GET /assets=low,PUT /assets/:msisdn=high".
+func loadShedding() {
+	v := os.Getenv("SHED_LATENCY")
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("SHED_LATENCY: %v", err)
+	}
+	rate := 0.2
+	if v := os.Getenv("SHED_ERROR_RATE"); v != "" {
+		if rate, err = strconv.ParseFloat(v, 64); err != nil || rate <= 0 || rate > 1 {
+			log.Fatalf("SHED_ERROR_RATE must be in (0, 1]")
+		}
+	}
+	shedding.Lock()
+	defer shedding.Unlock()
+	shedding.enabled = true
+	shedding.latency = d
+	shedding.errorRate = rate
+	for _, kv := range strings.Split(os.Getenv("SHED_PRIORITIES"), ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		route, p, ok := strings.Cut(kv, "=")
+		p = strings.TrimSpace(p)
+		if !ok || p != PriorityLow && p != PriorityNormal && p != PriorityHigh {
+			log.Fatalf("SHED_PRIORITIES: bad entry %q", kv)
+		}
+		shedding.priorities[strings.TrimSpace(route)] = p
+	}
+}
+
+// failedCall counts only errors that say the peer is struggling, not
+// chaincode or validation failures.
+func failedCall(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// peerLatencyInterceptor records the latency and outcome of every unary
+// gateway call (Evaluate, Endorse, Submit, CommitStatus) per peer.
+func peerLatencyInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	now := time.Now()
+	shedding.Lock()
+	w, ok := shedding.peers[cc.Target()]
+	if !ok {
+		w = new(peerWindow)
+		shedding.peers[cc.Target()] = w
+	}
+	w.add(now, now.Sub(start), failedCall(err))
+	shedding.Unlock()
+	return err
+}
+
+// shedLevel is 0 while every peer is healthy, 1 once one passes a threshold
+// and 2 once it passes twice the threshold.
+func shedLevel() int {
+	now := time.Now()
+	shedding.Lock()
+	defer shedding.Unlock()
+	level := 0
+	for _, w := range shedding.peers {
+		calls, avg, rate := w.stats(now)
+		if calls < minShedSamples {
+			continue
+		}
+		switch {
+		case avg > 2*shedding.latency || rate > 2*shedding.errorRate:
+			return 2
+		case avg > shedding.latency || rate > shedding.errorRate:
+			level = 1
+		}
+	}
+	return level
+}
+
+// routePriority defaults to high for writes, which carry payments, low for
+// bulk reads and the public routes and normal for everything else.
+func routePriority(c *gin.Context) string {
+	route := c.Request.Method + " " + c.FullPath()
+	if p, ok := shedding.priorities[route]; ok {
+		return p
+	}
+	switch {
+	case c.Request.Method != "GET":
+		return PriorityHigh
+	case c.FullPath() == "/health" || c.FullPath() == "/readyz" || c.FullPath() == "/metrics":
+		return PriorityHigh
+	case strings.HasSuffix(c.FullPath(), "/export") || c.FullPath() == "/dashboard" || c.FullPath() == "/admin/state-validation",
+		strings.HasPrefix(c.FullPath(), "/public/"):
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+// loadShedder answers 503 to low priority requests while a peer is degraded
+// and to normal ones while it is badly degraded, leaving capacity for high
+// priority work.
+func loadShedder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		shedding.Lock()
+		enabled := shedding.enabled
+		shedding.Unlock()
+		if !enabled {
+			c.Next()
+			return
+		}
+		level := shedLevel()
+		p := routePriority(c)
+		if p == PriorityLow && level >= 1 || p == PriorityNormal && level >= 2 {
+			c.Header("Retry-After", "5")
+			apiError(c, 503, ErrOverloaded, gin.H{"priority": p, "retryable": true})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// commitBuckets are the upper bounds, in seconds, of the commit latency
+// histogram. They are part of the metric's contract: alert rules refer to
+// them by le, so they only ever gain entries.
+var commitBuckets = []float64{0.25, 0.5, 1, 2, 3, 5, 10, 30}
+
+// sloWindow is how far back fabric_api_sli_commit_latency_p99_seconds looks.
+const sloWindow = 5 * time.Minute
+
+type commitSample struct {
+	at time.Time
+	d  time.Duration
+}
+
+// sli holds the series SLO alerts are built on. Counters only grow, so
+// burn-rate rules take their own windows with rate(); the P99 and lag gauges
+// are for dashboards and simple threshold alerts.
+var sli = struct {
+	sync.Mutex
+	requests, requestsGood int64
+	writes, writesGood     int64
+	commitCount            int64
+	commitSum              time.Duration
+	commitBuckets          []int64
+	recent                 []commitSample
+	next                   int
+	height                 uint64
+	eventBlock             uint64
+	eventsSeen             bool
+}{commitBuckets: make([]int64, len(commitBuckets)), recent: make([]commitSample, 0, 4096)}
+
+// sloRecorder counts every request but the probes and metrics by whether it
+// answered 5xx. Shed and breaker-rejected requests count as failures: the
+// client did not get an answer either way.
+func sloRecorder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		switch c.FullPath() {
+		case "/health", "/readyz", "/metrics":
+			return
+		}
+		sli.Lock()
+		sli.requests++
+		if c.Writer.Status() < 500 {
+			sli.requestsGood++
+		}
+		sli.Unlock()
+	}
+}
+
+// recordWrite counts a write that reached the orderer, or failed to be
+// endorsed because the peer was struggling. Writes the chaincode rejected
+// are the client's error and are left out.
+func recordWrite(ok bool) {
+	sli.Lock()
+	sli.writes++
+	if ok {
+		sli.writesGood++
+	}
+	sli.Unlock()
+}
+
+// recordCommit adds the time from submitting a transaction to learning its
+// commit status.
+func recordCommit(d time.Duration) {
+	now := time.Now()
+	sli.Lock()
+	defer sli.Unlock()
+	sli.commitCount++
+	sli.commitSum += d
+	for i, le := range commitBuckets {
+		if d.Seconds() <= le {
+			sli.commitBuckets[i]++
+		}
+	}
+	s := commitSample{at: now, d: d}
+	if len(sli.recent) < cap(sli.recent) {
+		sli.recent = append(sli.recent, s)
+	} else {
+		sli.recent[sli.next] = s
+		sli.next = (sli.next + 1) % len(sli.recent)
+	}
+}
+
+// recordEventBlock notes the block of the last chaincode event the event
+// pipeline delivered.
+func recordEventBlock(n uint64) {
+	sli.Lock()
+	sli.eventBlock = n
+	sli.eventsSeen = true
+	sli.Unlock()
+}
+
+// commitP99 is the 99th percentile of the commit latencies of the last
+// sloWindow, and false without any.
+func commitP99(now time.Time) (time.Duration, bool) {
+	var ds []time.Duration
+	for _, s := range sli.recent {
+		if now.Sub(s.at) <= sloWindow {
+			ds = append(ds, s.d)
+		}
+	}
+	if len(ds) == 0 {
+		return 0, false
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[(len(ds)*99-1)/100], true
+}
+
+// runLedgerHeight polls the channel height from qscc every 15 seconds until
+// ctx is cancelled, for the event lag.
+func runLedgerHeight(ctx context.Context) {
+	t := time.NewTicker(15 * time.Second)
+	defer t.Stop()
+	for {
+		res, err := qscc.EvaluateTransaction("GetChainInfo", network.Name())
+		var info common.BlockchainInfo
+		if err == nil {
+			err = proto.Unmarshal(res, &info)
+		}
+		if err != nil {
+			log.Printf("ledger height: %v", err)
+		} else {
+			sli.Lock()
+			sli.height = info.GetHeight()
+			sli.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func writeSLIMetrics(b *strings.Builder) {
+	sli.Lock()
+	defer sli.Unlock()
+	b.WriteString("# HELP fabric_api_sli_requests_total Requests answered, health probes and metrics excluded.\n")
+	b.WriteString("# TYPE fabric_api_sli_requests_total counter\n")
+	fmt.Fprintf(b, "fabric_api_sli_requests_total %d\n", sli.requests)
+	b.WriteString("# HELP fabric_api_sli_requests_good_total Requests answered without a 5xx status.\n")
+	b.WriteString("# TYPE fabric_api_sli_requests_good_total counter\n")
+	fmt.Fprintf(b, "fabric_api_sli_requests_good_total %d\n", sli.requestsGood)
+	b.WriteString("# HELP fabric_api_sli_writes_total Transactions submitted, chaincode rejections excluded.\n")
+	b.WriteString("# TYPE fabric_api_sli_writes_total counter\n")
+	fmt.Fprintf(b, "fabric_api_sli_writes_total %d\n", sli.writes)
+	b.WriteString("# HELP fabric_api_sli_writes_good_total Transactions committed as valid.\n")
+	b.WriteString("# TYPE fabric_api_sli_writes_good_total counter\n")
+	fmt.Fprintf(b, "fabric_api_sli_writes_good_total %d\n", sli.writesGood)
+	b.WriteString("# HELP fabric_api_sli_commit_latency_seconds Time from submitting a transaction to its commit status.\n")
+	b.WriteString("# TYPE fabric_api_sli_commit_latency_seconds histogram\n")
+	for i, le := range commitBuckets {
+		fmt.Fprintf(b, "fabric_api_sli_commit_latency_seconds_bucket{le=\"%g\"} %d\n", le, sli.commitBuckets[i])
+	}
+	fmt.Fprintf(b, "fabric_api_sli_commit_latency_seconds_bucket{le=\"+Inf\"} %d\n", sli.commitCount)
+	fmt.Fprintf(b, "fabric_api_sli_commit_latency_seconds_sum %g\n", sli.commitSum.Seconds())
+	fmt.Fprintf(b, "fabric_api_sli_commit_latency_seconds_count %d\n", sli.commitCount)
+	if p99, ok := commitP99(time.Now()); ok {
+		b.WriteString("# HELP fabric_api_sli_commit_latency_p99_seconds 99th percentile commit latency over the last 5 minutes.\n")
+		b.WriteString("# TYPE fabric_api_sli_commit_latency_p99_seconds gauge\n")
+		fmt.Fprintf(b, "fabric_api_sli_commit_latency_p99_seconds %g\n", p99.Seconds())
+	}
+	if sli.height > 0 && sli.eventsSeen {
+		lag := uint64(0)
+		if sli.height-1 > sli.eventBlock {
+			lag = sli.height - 1 - sli.eventBlock
+		}
+		b.WriteString("# HELP fabric_api_sli_event_lag_blocks Blocks committed after the last one the event pipeline delivered an event from.\n")
+		b.WriteString("# TYPE fabric_api_sli_event_lag_blocks gauge\n")
+		fmt.Fprintf(b, "fabric_api_sli_event_lag_blocks %d\n", lag)
+	}
+	b.WriteString("# HELP fabric_api_sli_ledger_height Channel height last read from qscc.\n")
+	b.WriteString("# TYPE fabric_api_sli_ledger_height gauge\n")
+	fmt.Fprintf(b, "fabric_api_sli_ledger_height %d\n", sli.height)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// SlowQuery is a gateway call that took longer than SLOW_QUERY_THRESHOLD.
+// Arguments are never kept, only a keyed fingerprint, so identical calls can
+// be grouped without logging account data.
+type SlowQuery struct {
+	Time            time.Time `json:"time"`
+	Call            string    `json:"call"`
+	Function        string    `json:"function,omitempty"`
+	ArgsFingerprint string    `json:"argsFingerprint,omitempty"`
+	Peer            string    `json:"peer"`
+	Route           string    `json:"route,omitempty"`
+	TxID            string    `json:"txId,omitempty"`
+	DurationMs      float64   `json:"durationMs"`
+	Error           string    `json:"error,omitempty"`
+}
+
+type routeKey struct{}
+
+var slowQueries = struct {
+	sync.Mutex
+	threshold time.Duration
+	ring      []SlowQuery
+	next      int
+	total     map[string]int64
+	timeouts  map[string]time.Duration
+}{total: map[string]int64{}, timeouts: map[string]time.Duration{}}
+
+// loadSlowQueries reads SLOW_QUERY_THRESHOLD (default 1s, 0 disables),
+// SLOW_QUERY_BUFFER (default 200 entries) and ENDPOINT_TIMEOUTS, a list of
+// route=duration such as "/assets/:msisdn/history=30s,/dashboard=5s" using
+// the routes as registered.
+func loadSlowQueries() {
+	slowQueries.threshold = time.Second
+	if v := os.Getenv("SLOW_QUERY_THRESHOLD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("SLOW_QUERY_THRESHOLD: must be a duration")
+		}
+		slowQueries.threshold = d
+	}
+	size := 200
+	if v := os.Getenv("SLOW_QUERY_BUFFER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("SLOW_QUERY_BUFFER: must be a positive number")
+		}
+		size = n
+	}
+	slowQueries.ring = make([]SlowQuery, 0, size)
+	for _, entry := range strings.Split(os.Getenv("ENDPOINT_TIMEOUTS"), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		route, v, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		d, err := time.ParseDuration(v)
+		if !ok || !strings.HasPrefix(route, "/") || err != nil || d <= 0 {
+			log.Fatalf("ENDPOINT_TIMEOUTS: bad entry %q", entry)
+		}
+		slowQueries.timeouts[route] = d
+	}
+}
+
+// endpointTimeouts bounds the request context by the route's timeout, which
+// reads through evaluate pass on to the gateway, and tags it with the route
+// for the slow query log. The gateway's own per-call timeout still applies.
+func endpointTimeouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), routeKey{}, c.FullPath())
+		d, ok := slowQueries.timeouts[c.FullPath()]
+		if td, tok := tenantOf(c).tenantTimeout(c.FullPath()); tok {
+			d, ok = td, true
+		}
+		if ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// slowQueryInterceptor times every unary gateway call and records the ones
+// over the threshold, with the chaincode function taken from the proposal.
+func slowQueryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	d := time.Since(start)
+	if slowQueries.threshold == 0 || d < slowQueries.threshold {
+		return err
+	}
+	q := SlowQuery{Time: start.UTC(), Call: method[strings.LastIndex(method, "/")+1:], Peer: cc.Target(), DurationMs: float64(d.Microseconds()) / 1000}
+	q.Route, _ = ctx.Value(routeKey{}).(string)
+	if err != nil {
+		q.Error = classify(err).Category
+	}
+	switch r := req.(type) {
+	case *gateway.EvaluateRequest:
+		q.TxID = r.GetTransactionId()
+		q.Function, q.ArgsFingerprint = invocation(r.GetProposedTransaction())
+	case *gateway.EndorseRequest:
+		q.TxID = r.GetTransactionId()
+		q.Function, q.ArgsFingerprint = invocation(r.GetProposedTransaction())
+	}
+	recordSlowQuery(q)
+	return err
+}
+
+// invocation returns the chaincode function of a signed proposal and a
+// fingerprint of its arguments, keyed like hashed log fields.
+func invocation(sp *peer.SignedProposal) (string, string) {
+	var prop peer.Proposal
+	if err := proto.Unmarshal(sp.GetProposalBytes(), &prop); err != nil {
+		return "", ""
+	}
+	var payload peer.ChaincodeProposalPayload
+	if err := proto.Unmarshal(prop.GetPayload(), &payload); err != nil {
+		return "", ""
+	}
+	var spec peer.ChaincodeInvocationSpec
+	if err := proto.Unmarshal(payload.GetInput(), &spec); err != nil {
+		return "", ""
+	}
+	args := spec.GetChaincodeSpec().GetInput().GetArgs()
+	if len(args) == 0 {
+		return "", ""
+	}
+	mac := hmac.New(sha256.New, redaction.key)
+	for _, a := range args[1:] {
+		binary.Write(mac, binary.BigEndian, uint32(len(a)))
+		mac.Write(a)
+	}
+	return string(args[0]), hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func recordSlowQuery(q SlowQuery) {
+	log.Printf("slow %s %s args=%s peer=%s route=%s tx=%s took %.1fms %s", q.Call, q.Function, q.ArgsFingerprint, q.Peer, q.Route, q.TxID, q.DurationMs, q.Error)
+	slowQueries.Lock()
+	defer slowQueries.Unlock()
+	slowQueries.total[q.Call]++
+	if len(slowQueries.ring) < cap(slowQueries.ring) {
+		slowQueries.ring = append(slowQueries.ring, q)
+		return
+	}
+	slowQueries.ring[slowQueries.next] = q
+	slowQueries.next = (slowQueries.next + 1) % len(slowQueries.ring)
+}
+
+// slowQueriesHandler lists the recorded slow calls, newest first, optionally
+// only those of ?function=.
+func slowQueriesHandler(c *gin.Context) {
+	fn := c.Query("function")
+	slowQueries.Lock()
+	n := len(slowQueries.ring)
+	out := make([]SlowQuery, 0, n)
+	for i := 1; i <= n; i++ {
+		q := slowQueries.ring[(slowQueries.next-i+n)%n]
+		if fn == "" || q.Function == fn {
+			out = append(out, q)
+		}
+	}
+	slowQueries.Unlock()
+	c.JSON(200, gin.H{"thresholdMs": slowQueries.threshold.Milliseconds(), "queries": out})
+}
+
+func writeSlowQueryMetrics(b *strings.Builder) {
+	b.WriteString("# HELP fabric_api_slow_gateway_calls_total Gateway calls over SLOW_QUERY_THRESHOLD per call type.\n")
+	b.WriteString("# TYPE fabric_api_slow_gateway_calls_total counter\n")
+	slowQueries.Lock()
+	defer slowQueries.Unlock()
+	for call, n := range slowQueries.total {
+		fmt.Fprintf(b, "fabric_api_slow_gateway_calls_total{call=%q} %d\n", call, n)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoMarker prefixes accounts the chaincode stores in its protobuf state
+// format (chaincode/asset-management/account.proto); JSON state starts with
+// '{'.
+const protoMarker byte = 0x01
+
+// decodeAccount reads an account value as the chaincode wrote it to the
+// world state, in either state format.
+func decodeAccount(b []byte, a *Account) error {
+	if len(b) == 0 || b[0] != protoMarker {
+		return json.Unmarshal(b, a)
+	}
+	*a = Account{}
+	strs := map[protowire.Number]*string{1: &a.DEALERID, 2: &a.MSISDN, 3: &a.MPIN, 5: &a.STATUS, 7: &a.TRANSTYPE, 8: &a.REMARKS, 9: &a.PARENT, 12: &a.CURRENCY}
+	nums := map[protowire.Number]*int64{4: &a.BALANCE, 6: &a.TRANSAMOUNT, 10: &a.CreatedAt, 11: &a.LastModified}
+	b = b[1:]
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		if p, ok := strs[n]; ok && typ == protowire.BytesType {
+			v, l := protowire.ConsumeString(b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			*p, b = v, b[l:]
+		} else if p, ok := nums[n]; ok && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			*p, b = protowire.DecodeZigZag(v), b[l:]
+		} else {
+			l := protowire.ConsumeFieldValue(n, typ, b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			b = b[l:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// statusResyncPageSize is the GetAssetsPage size a full re-sync reads with.
+const statusResyncPageSize = 500
+
+// statusCache maps MSISDN to STATUS from chaincode events, so writes to a
+// BLOCKED or CLOSED account are refused before endorsement. Every replica
+// follows events itself and re-reads all accounts after subscribing and
+// every interval. Until that first re-sync, and whenever the stream is down,
+// nothing is refused. An account not in the map is let through.
+var statusCache = struct {
+	sync.Mutex
+	enabled  bool
+	interval time.Duration
+	statuses map[string]string
+	live     bool
+	// resyncs are the running re-syncs, each with the accounts events
+	// changed since it started; its older snapshot does not overwrite them.
+	resyncs     []*resyncMarks
+	syncedAt    time.Time
+	rejections  int64
+	corrections int64
+	failures    int64
+}{interval: 15 * time.Minute, statuses: map[string]string{}}
+
+// loadStatusCache reads STATUS_CACHE (true to enable) and
+// STATUS_CACHE_RESYNC, the full re-sync interval (default 15m).
+func loadStatusCache() {
+	statusCache.enabled = os.Getenv("STATUS_CACHE") == "true"
+	if v := os.Getenv("STATUS_CACHE_RESYNC"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			log.Fatal("STATUS_CACHE_RESYNC must be a duration of at least 1m")
+		}
+		statusCache.interval = d
+	}
+}
+
+type resyncMarks struct {
+	touched map[string]bool
+}
+
+// touchStatus marks msisdn as changed by an event for the running re-syncs.
+func touchStatus(msisdn string) {
+	for _, r := range statusCache.resyncs {
+		r.touched[msisdn] = true
+	}
+}
+
+type statusEvent struct {
+	MSISDN  string   `json:"MSISDN"`
+	STATUS  string   `json:"STATUS"`
+	MSISDNs []string `json:"MSISDNs"`
+}
+
+// applyStatusEvent updates the map from one event. AssetsPosted carries no
+// statuses and a posting may close an account, so its accounts become
+// unknown until the next re-sync.
+func applyStatusEvent(ev *client.ChaincodeEvent) {
+	var e statusEvent
+	if err := json.Unmarshal(ev.Payload, &e); err != nil {
+		log.Printf("status cache: event %s: %v", ev.EventName, err)
+		return
+	}
+	statusCache.Lock()
+	defer statusCache.Unlock()
+	forget := func(msisdns ...string) {
+		for _, m := range msisdns {
+			delete(statusCache.statuses, m)
+			touchStatus(m)
+		}
+	}
+	switch ev.EventName {
+	case "AssetCreated", "AssetUpdated":
+		statusCache.statuses[e.MSISDN] = e.STATUS
+		touchStatus(e.MSISDN)
+	case "AssetDeleted":
+		forget(e.MSISDN)
+	case "AssetsPosted", "DealerAssetsDeleted":
+		forget(e.MSISDNs...)
+	case "AssetsMerged":
+		// The secondary, listed second, is removed.
+		if len(e.MSISDNs) == 2 {
+			forget(e.MSISDNs[1])
+		}
+	}
+}
+
+// resyncStatuses reads every account's status and replaces the map with it,
+// keeping what events changed meanwhile. Entries it has to change once the
+// map was synced before count as corrections: changes the events missed.
+func resyncStatuses(ctx context.Context) error {
+	marks := &resyncMarks{touched: map[string]bool{}}
+	statusCache.Lock()
+	statusCache.resyncs = append(statusCache.resyncs, marks)
+	statusCache.Unlock()
+	done := func() {
+		for i, r := range statusCache.resyncs {
+			if r == marks {
+				statusCache.resyncs = append(statusCache.resyncs[:i], statusCache.resyncs[i+1:]...)
+				break
+			}
+		}
+	}
+	fail := func(err error) error {
+		statusCache.Lock()
+		defer statusCache.Unlock()
+		done()
+		if ctx.Err() == nil {
+			statusCache.failures++
+		}
+		return err
+	}
+	snapshot := map[string]string{}
+	bookmark := ""
+	for {
+		res, err := evaluateNearest(ctx, "GetAssetsPage", append(endorsementOptions("GetAssetsPage"), client.WithArguments(strconv.Itoa(statusResyncPageSize), bookmark))...)
+		if err != nil {
+			return fail(err)
+		}
+		var page AssetPage
+		if err := json.Unmarshal(res, &page); err != nil {
+			return fail(err)
+		}
+		for _, a := range page.Records {
+			snapshot[a.MSISDN] = a.STATUS
+		}
+		if page.Bookmark == "" || len(page.Records) == 0 {
+			break
+		}
+		bookmark = page.Bookmark
+	}
+	statusCache.Lock()
+	defer statusCache.Unlock()
+	count := !statusCache.syncedAt.IsZero()
+	done()
+	for m, s := range snapshot {
+		if marks.touched[m] {
+			continue
+		}
+		if count && statusCache.statuses[m] != s {
+			statusCache.corrections++
+		}
+		statusCache.statuses[m] = s
+	}
+	for m := range statusCache.statuses {
+		if _, ok := snapshot[m]; !ok && !marks.touched[m] {
+			delete(statusCache.statuses, m)
+			if count {
+				statusCache.corrections++
+			}
+		}
+	}
+	statusCache.syncedAt = time.Now()
+	// A re-sync outliving its event stream must not make the cache live.
+	statusCache.live = ctx.Err() == nil
+	return nil
+}
+
+// runStatusResync re-syncs until ctx is cancelled, every interval, or after
+// 30 seconds when a re-sync failed.
+func runStatusResync(ctx context.Context) {
+	for {
+		wait := statusCache.interval
+		if err := resyncStatuses(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("status cache: re-sync failed: %v", err)
+			wait = 30 * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runStatusCache follows chaincode events from the current block on, with a
+// re-sync at every (re)subscription so nothing missed while disconnected
+// survives it.
+func runStatusCache(ctx context.Context) {
+	if !statusCache.enabled {
+		return
+	}
+	for ctx.Err() == nil {
+		events, err := network.ChaincodeEvents(ctx, contract.ChaincodeName())
+		if err != nil {
+			log.Printf("status cache events: %v", err)
+		} else {
+			rctx, cancel := context.WithCancel(ctx)
+			go runStatusResync(rctx)
+			for ev := range events {
+				applyStatusEvent(ev)
+			}
+			cancel()
+		}
+		statusCache.Lock()
+		statusCache.live = false
+		statusCache.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// statusChecks are the accounts a call to fn with args writes to, and
+// whether the call may still go ahead for a BLOCKED account.
+func statusChecks(fn string, args []string) (msisdns []string, blockedOK bool) {
+	switch {
+	case fn == "Transfer" && len(args) == 4:
+		return args[:2], false
+	case (fn == "Debit" || fn == "HoldAmount" || fn == "CaptureHold") && len(args) > 0:
+		return args[:1], false
+	case fn == "PostEntry" && len(args) == 4:
+		return args[:1], args[1] == "ADJUSTMENT"
+	}
+	return nil, false
+}
+
+// checkAccountStatus answers 409 ACCOUNT_NOT_ACTIVE and returns false when
+// the status cache has an account fn would write to as BLOCKED or CLOSED,
+// which the chaincode would reject after endorsement anyway.
+func checkAccountStatus(c *gin.Context, fn string, args []string) bool {
+	if !statusCache.enabled {
+		return true
+	}
+	msisdns, blockedOK := statusChecks(fn, args)
+	statusCache.Lock()
+	defer statusCache.Unlock()
+	if !statusCache.live {
+		return true
+	}
+	for _, m := range msisdns {
+		s := statusCache.statuses[m]
+		if s == "CLOSED" || s == "BLOCKED" && !blockedOK {
+			statusCache.rejections++
+			apiError(c, 409, ErrAccountNotActive, gin.H{"msisdn": m, "status": s})
+			return false
+		}
+	}
+	return true
+}
+
+func writeStatusCacheMetrics(b *strings.Builder) {
+	if !statusCache.enabled {
+		return
+	}
+	statusCache.Lock()
+	defer statusCache.Unlock()
+	live, age := 0, -1.0
+	if statusCache.live {
+		live = 1
+	}
+	if !statusCache.syncedAt.IsZero() {
+		age = time.Since(statusCache.syncedAt).Seconds()
+	}
+	b.WriteString("# HELP fabric_api_status_cache_live Whether the account status cache follows events and has been synced (1) or is bypassed (0).\n")
+	b.WriteString("# TYPE fabric_api_status_cache_live gauge\n")
+	fmt.Fprintf(b, "fabric_api_status_cache_live %d\n", live)
+	b.WriteString("# HELP fabric_api_status_cache_accounts Accounts whose status is cached.\n")
+	b.WriteString("# TYPE fabric_api_status_cache_accounts gauge\n")
+	fmt.Fprintf(b, "fabric_api_status_cache_accounts %d\n", len(statusCache.statuses))
+	b.WriteString("# HELP fabric_api_status_cache_sync_age_seconds Seconds since the last full re-sync, -1 before the first.\n")
+	b.WriteString("# TYPE fabric_api_status_cache_sync_age_seconds gauge\n")
+	fmt.Fprintf(b, "fabric_api_status_cache_sync_age_seconds %.0f\n", age)
+	b.WriteString("# HELP fabric_api_status_cache_corrections_total Cached statuses a re-sync found wrong or missing.\n")
+	b.WriteString("# TYPE fabric_api_status_cache_corrections_total counter\n")
+	fmt.Fprintf(b, "fabric_api_status_cache_corrections_total %d\n", statusCache.corrections)
+	b.WriteString("# HELP fabric_api_status_cache_resync_failures_total Full re-syncs that failed.\n")
+	b.WriteString("# TYPE fabric_api_status_cache_resync_failures_total counter\n")
+	fmt.Fprintf(b, "fabric_api_status_cache_resync_failures_total %d\n", statusCache.failures)
+	b.WriteString("# HELP fabric_api_status_cache_rejections_total Writes refused because the cache had an account BLOCKED or CLOSED.\n")
+	b.WriteString("# TYPE fabric_api_status_cache_rejections_total counter\n")
+	fmt.Fprintf(b, "fabric_api_status_cache_rejections_total %d\n", statusCache.rejections)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func subAccountsHandler(c *gin.Context) {
+	opts, ok := proposalOptions(c, c.Param("msisdn"))
+	if !ok {
+		return
+	}
+	res, err := evaluate(c, "GetSubAccounts", opts...)
+	if err != nil {
+		fabricError(c, err)
+		return
+	}
+	var r Rollup
+	if err := json.Unmarshal(res, &r); err != nil {
+		internalError(c, err)
+		return
+	}
+	respond(c, 200, r)
+}
+
+// createSubAccountHandler creates a sub-account under :msisdn; DEALERID is
+// inherited from the parent and ignored in the body.
+func createSubAccountHandler(c *gin.Context) {
+	parent := c.Param("msisdn")
+	var in AccountInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		bodyError(c, err)
+		return
+	}
+	a := in.account()
+	opts, ok := proposalOptions(c, parent, a.MSISDN, a.MPIN, strconv.FormatInt(a.BALANCE, 10), a.STATUS, strconv.FormatInt(a.TRANSAMOUNT, 10), a.TRANSTYPE, a.REMARKS)
+	if !ok {
+		return
+	}
+	if _, _, err := submit("CreateSubAccount", opts...); err != nil {
+		fabricError(c, err)
+		return
+	}
+	c.JSON(201, gin.H{"message": "created", "msisdn": a.MSISDN, "parent": parent})
+}
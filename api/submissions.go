@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+const (
+	SubmissionPending   = "pending"
+	SubmissionEndorsed  = "endorsed"
+	SubmissionCommitted = "committed"
+	SubmissionFailed    = "failed"
+
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// submissionStale is how long a submission must sit untouched before
+	// another request or the leader takes it over from the replica that
+	// started it.
+	submissionStale = time.Minute
+)
+
+// Submission is an /invoke made with an Idempotency-Key. The proposal is
+// built and signed before anything reaches a peer, so its transaction ID is
+// known up front and stored with the signed bytes: whichever replica a retry
+// lands on endorses or resubmits exactly that transaction, never a new one.
+type Submission struct {
+	Key         string       `json:"key"`
+	Owner       string       `json:"owner"`
+	RequestHash string       `json:"requestHash"`
+	TxID        string       `json:"txId"`
+	Function    string       `json:"function"`
+	State       string       `json:"state"`
+	BlockNumber uint64       `json:"blockNumber,omitempty"`
+	Error       *FabricError `json:"error,omitempty"`
+	// The signed proposal and, once endorsed, the signed transaction.
+	Proposal             []byte    `json:"proposal"`
+	ProposalSignature    []byte    `json:"proposalSignature"`
+	Transaction          []byte    `json:"transaction,omitempty"`
+	TransactionSignature []byte    `json:"transactionSignature,omitempty"`
+	CreatedAt            time.Time `json:"createdAt"`
+	UpdatedAt            time.Time `json:"updatedAt"`
+}
+
+func (s *Submission) finished() bool {
+	return s.State == SubmissionCommitted || s.State == SubmissionFailed
+}
+
+// SubmissionStatus is GET /submissions/:key and the 202 answer to an
+// asynchronous /invoke.
+type SubmissionStatus struct {
+	Key         string       `json:"key"`
+	TxID        string       `json:"txId"`
+	Function    string       `json:"function"`
+	State       string       `json:"state"`
+	BlockNumber uint64       `json:"blockNumber,omitempty"`
+	Result      any          `json:"result,omitempty"`
+	Error       *FabricError `json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+}
+
+// submissionStore keeps one file per owner and key in SUBMISSION_DIR. As
+// with sagas and the outbox, several replicas need a shared volume.
+type submissionStore struct {
+	dir     string
+	ttl     time.Duration
+	replays atomic.Int64
+}
+
+var submissions *submissionStore
+
+// loadSubmissions reads SUBMISSION_DIR and SUBMISSION_TTL (default 24h), how
+// long a key is remembered after its submission was created.
+func loadSubmissions() {
+	dir := os.Getenv("SUBMISSION_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-api-submissions")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("submissions: %v", err)
+	}
+	ttl := 24 * time.Hour
+	if v := os.Getenv("SUBMISSION_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < submissionStale {
+			log.Fatal("SUBMISSION_TTL must be a duration of at least 1m")
+		}
+		ttl = d
+	}
+	submissions = &submissionStore{dir: dir, ttl: ttl}
+}
+
+// path names the file for key. Keys are scoped to their owner, so two
+// clients picking the same key do not collide.
+func (st *submissionStore) path(owner, key string) string {
+	h := sha256.Sum256([]byte(owner + "\x00" + key))
+	return filepath.Join(st.dir, hex.EncodeToString(h[:])+".json")
+}
+
+func (st *submissionStore) put(s *Submission) error {
+	s.UpdatedAt = time.Now().UTC()
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	p := st.path(s.Owner, s.Key)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (st *submissionStore) read(path string) (*Submission, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Submission
+	return &s, json.Unmarshal(b, &s)
+}
+
+func (st *submissionStore) get(owner, key string) (*Submission, error) {
+	return st.read(st.path(owner, key))
+}
+
+// create stores s unless its key is taken, in which case it returns the
+// submission already there. The file is linked into place, which fails if
+// it exists, so only one replica ever creates a key.
+func (st *submissionStore) create(s *Submission) (*Submission, bool, error) {
+	s.CreatedAt = time.Now().UTC()
+	s.UpdatedAt = s.CreatedAt
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, false, err
+	}
+	p := st.path(s.Owner, s.Key)
+	f, err := os.CreateTemp(st.dir, ".create-*")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, false, err
+	}
+	if err := os.Link(f.Name(), p); errors.Is(err, os.ErrExist) {
+		existing, err := st.read(p)
+		return existing, false, err
+	} else if err != nil {
+		return nil, false, err
+	}
+	return s, true, nil
+}
+
+func (st *submissionStore) drop(s *Submission) {
+	if err := os.Remove(st.path(s.Owner, s.Key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("submissions: %v", err)
+	}
+}
+
+func (st *submissionStore) list() ([]*Submission, error) {
+	files, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Submission
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		s, err := st.read(filepath.Join(st.dir, f.Name()))
+		if err != nil {
+			log.Printf("submissions: %s: %v", f.Name(), err)
+			continue
+		}
+		if s != nil {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// requestHash identifies what was asked for under a key, so the key cannot
+// be reused for a different transaction.
+func requestHash(req *PassthroughRequest) string {
+	b, _ := json.Marshal(struct {
+		Function      string            `json:"function"`
+		Args          []string          `json:"args"`
+		Transient     map[string]string `json:"transient"`
+		EndorsingOrgs []string          `json:"endorsingOrgs"`
+	}{req.Function, req.Args, req.Transient, req.endorsingOrgs})
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// newSubmission builds and signs the proposal for req without sending it,
+// which fixes the transaction ID.
+func newSubmission(c *gin.Context, req *PassthroughRequest, owner, key string) (*Submission, error) {
+	proposal, err := contract.NewProposal(req.Function, append(endorsementOptions(req.Function), req.options(c)...)...)
+	if err != nil {
+		return nil, err
+	}
+	b, err := proposal.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ids.sign(proposal.Digest())
+	if err != nil {
+		return nil, err
+	}
+	return &Submission{Key: key, Owner: owner, RequestHash: requestHash(req), TxID: proposal.TransactionID(), Function: req.Function, State: SubmissionPending, Proposal: b, ProposalSignature: sig}, nil
+}
+
+// fail records err against s. Errors after which the transaction cannot
+// commit and a retry might succeed drop the key instead, so the retry builds
+// a new transaction.
+func (st *submissionStore) fail(s *Submission, err error) error {
+	fe := classify(err)
+	if fe.Retryable {
+		st.drop(s)
+		return err
+	}
+	s.State, s.Error = SubmissionFailed, &fe
+	if perr := st.put(s); perr != nil {
+		log.Printf("submissions: %v", perr)
+	}
+	return err
+}
+
+// committed records the commit of s, valid or not, as seen in block.
+func (st *submissionStore) committed(s *Submission, block uint64, code peer.TxValidationCode) error {
+	if code != peer.TxValidationCode_VALID {
+		return st.fail(s, &client.CommitError{TransactionID: s.TxID, Code: code})
+	}
+	s.State, s.BlockNumber = SubmissionCommitted, block
+	return st.put(s)
+}
+
+// fromLedger settles s from the ledger when its transaction is there. It
+// reports false when the transaction was not found, or could not be looked
+// up, and may still have to be submitted.
+func (st *submissionStore) fromLedger(s *Submission) (bool, error) {
+	info, err := transactionInfo(s.TxID)
+	if err != nil {
+		return false, nil
+	}
+	block, err := blockOfTx(s.TxID)
+	if err != nil {
+		return false, nil
+	}
+	code := peer.TxValidationCode(peer.TxValidationCode_value[info.ValidationCode])
+	return true, st.committed(s, block, code)
+}
+
+// drive takes s from wherever it stopped to a commit: it endorses the
+// stored proposal if that was not done, then submits the stored transaction.
+// An endorsed submission is first looked up on the ledger, as an earlier
+// attempt may have reached the orderer. Resubmitting the same transaction is
+// safe: the ledger commits one transaction ID at most once.
+func (st *submissionStore) drive(s *Submission) error {
+	if err := submits.acquire(s.Function); err != nil {
+		return err
+	}
+	defer submits.release()
+	if s.State == SubmissionEndorsed {
+		if done, err := st.fromLedger(s); done {
+			return err
+		}
+	}
+	if s.State == SubmissionPending {
+		proposal, err := gw.NewSignedProposal(s.Proposal, s.ProposalSignature)
+		if err != nil {
+			return st.fail(s, err)
+		}
+		if err := outbox.put(&OutboxEntry{TxID: s.TxID, Function: s.Function, CreatedAt: time.Now()}); err != nil {
+			return err
+		}
+		tx, err := proposal.Endorse()
+		if err != nil {
+			outbox.drop(s.TxID)
+			if failedCall(err) {
+				recordWrite(false)
+			}
+			return st.fail(s, err)
+		}
+		b, err := tx.Bytes()
+		if err != nil {
+			return err
+		}
+		sig, err := ids.sign(tx.Digest())
+		if err != nil {
+			return err
+		}
+		s.State, s.Transaction, s.TransactionSignature = SubmissionEndorsed, b, sig
+		if err := st.put(s); err != nil {
+			return err
+		}
+	}
+	tx, err := gw.NewSignedTransaction(s.Transaction, s.TransactionSignature)
+	if err != nil {
+		return st.fail(s, err)
+	}
+	status, err := commitTransaction(tx)
+	if err != nil {
+		// It may still commit: the submission stays endorsed for the next
+		// attempt to look up.
+		if perr := st.put(s); perr != nil {
+			log.Printf("submissions: %v", perr)
+		}
+		return err
+	}
+	if status.Code == peer.TxValidationCode_DUPLICATE_TXID {
+		// An earlier attempt committed it; the ledger has its outcome.
+		if done, err := st.fromLedger(s); done {
+			return err
+		}
+		return errors.New("transaction " + s.TxID + " committed earlier but could not be looked up")
+	}
+	if !status.Successful {
+		outbox.drop(s.TxID)
+	}
+	return st.committed(s, status.BlockNumber, status.Code)
+}
+
+// result is the chaincode's output, from the endorsed transaction.
+func (s *Submission) result() any {
+	if s.Transaction == nil {
+		return nil
+	}
+	tx, err := gw.NewSignedTransaction(s.Transaction, s.TransactionSignature)
+	if err != nil {
+		return nil
+	}
+	return resultJSON(tx.Result())
+}
+
+func (s *Submission) status(c *gin.Context) SubmissionStatus {
+	out := SubmissionStatus{Key: s.Key, TxID: s.TxID, Function: s.Function, State: s.State, BlockNumber: s.BlockNumber, CreatedAt: s.CreatedAt, UpdatedAt: s.UpdatedAt}
+	if s.State == SubmissionCommitted {
+		out.Result = s.result()
+	}
+	if s.Error != nil {
+		fe := localizeFabricError(c, *s.Error)
+		out.Error = &fe
+	}
+	return out
+}
+
+// answer responds to a synchronous /invoke with a finished submission as
+// /invoke does without a key: the same body for every retry.
+func (s *Submission) answer(c *gin.Context) {
+	if s.State == SubmissionFailed && s.Error != nil {
+		fe := localizeFabricError(c, *s.Error)
+		c.Set(fabricErrorKey, fe)
+		c.JSON(500, fe)
+		return
+	}
+	c.JSON(200, gin.H{"txId": s.TxID, "blockNumber": s.BlockNumber, "result": s.result()})
+}
+
+// preferAsync reports whether the client asked not to wait for the commit.
+func preferAsync(c *gin.Context) bool {
+	for _, p := range strings.Split(c.GetHeader("Prefer"), ",") {
+		if strings.TrimSpace(p) == "respond-async" {
+			return true
+		}
+	}
+	return false
+}
+
+func accepted(c *gin.Context, s *Submission) {
+	c.Header("Location", "/submissions/"+s.Key)
+	c.Header("Preference-Applied", "respond-async")
+	c.JSON(202, s.status(c))
+}
+
+// idempotentInvoke is /invoke with an Idempotency-Key. A new key creates the
+// submission and runs it; a known one is answered from the store, and a
+// submission left unfinished for submissionStale is taken over. With Prefer:
+// respond-async the answer is 202 with the transaction ID as soon as it is
+// known, and GET /submissions/:key follows it.
+func idempotentInvoke(c *gin.Context, req *PassthroughRequest, key string) {
+	if !sagaID.MatchString(key) {
+		apiError(c, 400, ErrInvalidOperationID, gin.H{"header": idempotencyKeyHeader})
+		return
+	}
+	owner := principal(c).Name
+	s, err := submissions.get(owner, key)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	created := false
+	if s == nil {
+		// A retry was screened when first submitted.
+		if !checkAccountStatus(c, req.Function, req.Args) || !screenMonetary(c, req.Function, req.Args) {
+			return
+		}
+		fresh, err := newSubmission(c, req, owner, key)
+		if err != nil {
+			fabricError(c, err)
+			return
+		}
+		if s, created, err = submissions.create(fresh); err != nil {
+			internalError(c, err)
+			return
+		}
+	}
+	if s.RequestHash != requestHash(req) {
+		apiError(c, 422, ErrIdempotencyKeyReused, gin.H{"key": key})
+		return
+	}
+	c.Header("X-Transaction-ID", s.TxID)
+	if !created {
+		submissions.replays.Add(1)
+		if s.finished() {
+			s.answer(c)
+			return
+		}
+		if time.Since(s.UpdatedAt) < submissionStale {
+			if preferAsync(c) {
+				accepted(c, s)
+				return
+			}
+			c.Header("Retry-After", "1")
+			apiError(c, 409, ErrSubmissionInProgress, gin.H{"key": key, "txId": s.TxID})
+			return
+		}
+		// Touch it first, so other replicas leave it to this request.
+		if err := submissions.put(s); err != nil {
+			internalError(c, err)
+			return
+		}
+	}
+	if preferAsync(c) {
+		accepted(c, s)
+		go func() {
+			if err := submissions.drive(s); err != nil {
+				log.Printf("submission %s (%s): %v", s.Key, s.TxID, err)
+			}
+		}()
+		return
+	}
+	if err := submissions.drive(s); err != nil {
+		fabricError(c, err)
+		return
+	}
+	s.answer(c)
+}
+
+func submissionHandler(c *gin.Context) {
+	if !sagaID.MatchString(c.Param("key")) {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	s, err := submissions.get(principal(c).Name, c.Param("key"))
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	if s == nil {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	c.JSON(200, s.status(c))
+}
+
+// runSubmissions resumes submissions whose replica went away mid-way and
+// forgets keys older than SUBMISSION_TTL. It runs on the leader.
+func runSubmissions(ctx context.Context) {
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		list, err := submissions.list()
+		if err != nil {
+			log.Printf("submissions: %v", err)
+			continue
+		}
+		for _, s := range list {
+			if ctx.Err() != nil {
+				return
+			}
+			switch {
+			case time.Since(s.CreatedAt) > submissions.ttl:
+				submissions.drop(s)
+			case !s.finished() && time.Since(s.UpdatedAt) > submissionStale:
+				if err := submissions.put(s); err != nil {
+					log.Printf("submissions: %v", err)
+					continue
+				}
+				if err := submissions.drive(s); err != nil {
+					log.Printf("submission %s (%s): %v", s.Key, s.TxID, err)
+				}
+			}
+		}
+	}
+}
+
+func writeSubmissionMetrics(b *strings.Builder) {
+	b.WriteString("# HELP fabric_api_submission_replays_total Requests with a known Idempotency-Key, answered from or continuing its stored submission.\n")
+	b.WriteString("# TYPE fabric_api_submission_replays_total counter\n")
+	fmt.Fprintf(b, "fabric_api_submission_replays_total %d\n", submissions.replays.Load())
+}
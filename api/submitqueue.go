@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Submit classes, highest priority first.
+const (
+	ClassPayment = "payment"
+	ClassUpdate  = "update"
+	ClassBulk    = "bulk"
+)
+
+var submitClassNames = []string{ClassPayment, ClassUpdate, ClassBulk}
+
+// defaultSubmitClasses are the functions outside ClassUpdate: those that
+// move money and the batch and housekeeping jobs.
+var defaultSubmitClasses = map[string]string{
+	"Transfer":             ClassPayment,
+	"Debit":                ClassPayment,
+	"PostEntry":            ClassPayment,
+	"CaptureHold":          ClassPayment,
+	"DeleteAssetsByDealer": ClassBulk,
+	"RecountDealer":        ClassBulk,
+	"RebuildDealerIndex":   ClassBulk,
+	"AnchorAdminLog":       ClassBulk,
+	"MarkHeight":           ClassBulk,
+}
+
+// submitQueueFullError is returned by submit when the queue has no room for
+// the transaction, or when a higher class took its place.
+type submitQueueFullError struct {
+	class string
+}
+
+func (e *submitQueueFullError) Error() string {
+	return "submit queue full (" + e.class + ")"
+}
+
+type submitWaiter struct {
+	ready chan error
+	since time.Time
+}
+
+// submitQueue bounds the transactions in flight toward the gateway. Once
+// all slots are taken submits wait, and a freed slot goes to the oldest
+// waiter of the highest class. When the queue is full a newcomer takes the
+// place of the newest waiter of a lower class, which fails, or fails
+// itself, so bulk work can fill the queue but never keep payments out.
+type submitQueue struct {
+	mu       sync.Mutex
+	limit    int
+	size     int
+	running  int
+	queued   int
+	classes  map[string]int
+	waiting  [3][]*submitWaiter
+	rejected [3]int64
+	waits    [3]int64
+	waitSum  [3]time.Duration
+}
+
+var submits *submitQueue
+
+// loadSubmitQueue reads SUBMIT_CONCURRENCY (transactions in flight, default
+// 32), SUBMIT_QUEUE_SIZE (waiting transactions, default 256) and
+// SUBMIT_CLASSES, which overrides function classes, e.g.
+// "CreateAssetJSON=bulk,CloseAccount=payment".
+func loadSubmitQueue() {
+	q := &submitQueue{limit: 32, size: 256, classes: map[string]int{}}
+	if v := os.Getenv("SUBMIT_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("SUBMIT_CONCURRENCY must be a positive integer")
+		}
+		q.limit = n
+	}
+	if v := os.Getenv("SUBMIT_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("SUBMIT_QUEUE_SIZE must be a non-negative integer")
+		}
+		q.size = n
+	}
+	for fn, class := range defaultSubmitClasses {
+		q.classes[fn] = classIndex(class)
+	}
+	for _, kv := range strings.Split(os.Getenv("SUBMIT_CLASSES"), ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		fn, class, ok := strings.Cut(kv, "=")
+		i := classIndex(strings.TrimSpace(class))
+		if !ok || i < 0 {
+			log.Fatalf("SUBMIT_CLASSES: bad entry %q", kv)
+		}
+		q.classes[strings.TrimSpace(fn)] = i
+	}
+	submits = q
+}
+
+func classIndex(class string) int {
+	for i, c := range submitClassNames {
+		if c == class {
+			return i
+		}
+	}
+	return -1
+}
+
+// class is fn's class index, ClassUpdate unless listed.
+func (q *submitQueue) class(fn string) int {
+	if i, ok := q.classes[fn]; ok {
+		return i
+	}
+	return classIndex(ClassUpdate)
+}
+
+// acquire takes a slot for fn, waiting behind higher and older work. Every
+// successful acquire must be paired with a release.
+func (q *submitQueue) acquire(fn string) error {
+	class := q.class(fn)
+	q.mu.Lock()
+	if q.running < q.limit && q.queued == 0 {
+		q.running++
+		q.mu.Unlock()
+		return nil
+	}
+	if q.queued >= q.size && !q.evict(class) {
+		q.rejected[class]++
+		q.mu.Unlock()
+		return &submitQueueFullError{class: submitClassNames[class]}
+	}
+	w := &submitWaiter{ready: make(chan error, 1), since: time.Now()}
+	q.waiting[class] = append(q.waiting[class], w)
+	q.queued++
+	q.mu.Unlock()
+	return <-w.ready
+}
+
+// evict fails the newest waiter of the lowest class below class, making
+// room for one more. q.mu is held.
+func (q *submitQueue) evict(class int) bool {
+	for i := len(q.waiting) - 1; i > class; i-- {
+		if n := len(q.waiting[i]); n > 0 {
+			w := q.waiting[i][n-1]
+			q.waiting[i] = q.waiting[i][:n-1]
+			q.queued--
+			q.rejected[i]++
+			w.ready <- &submitQueueFullError{class: submitClassNames[i]}
+			return true
+		}
+	}
+	return false
+}
+
+// release hands the slot to the next waiter, if any.
+func (q *submitQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.waiting {
+		if len(q.waiting[i]) == 0 {
+			continue
+		}
+		w := q.waiting[i][0]
+		q.waiting[i] = q.waiting[i][1:]
+		q.queued--
+		q.waits[i]++
+		q.waitSum[i] += time.Since(w.since)
+		w.ready <- nil
+		return
+	}
+	q.running--
+}
+
+// submitQueueFull answers 429 when err is the queue turning a submit away,
+// and reports whether it did.
+func submitQueueFull(c *gin.Context, err error) bool {
+	full, ok := err.(*submitQueueFullError)
+	if !ok {
+		return false
+	}
+	c.Header("Retry-After", "1")
+	apiError(c, 429, ErrSubmitQueueFull, gin.H{"class": full.class, "retryable": true})
+	return true
+}
+
+func writeSubmitQueueMetrics(b *strings.Builder) {
+	if submits == nil {
+		return
+	}
+	submits.mu.Lock()
+	defer submits.mu.Unlock()
+	b.WriteString("# HELP fabric_api_submit_inflight Transactions being endorsed or committed.\n")
+	b.WriteString("# TYPE fabric_api_submit_inflight gauge\n")
+	fmt.Fprintf(b, "fabric_api_submit_inflight %d\n", submits.running)
+	b.WriteString("# HELP fabric_api_submit_queue_depth Transactions waiting for a submit slot, by class.\n")
+	b.WriteString("# TYPE fabric_api_submit_queue_depth gauge\n")
+	for i, class := range submitClassNames {
+		fmt.Fprintf(b, "fabric_api_submit_queue_depth{class=%q} %d\n", class, len(submits.waiting[i]))
+	}
+	b.WriteString("# HELP fabric_api_submit_rejected_total Transactions turned away because the submit queue was full, by class.\n")
+	b.WriteString("# TYPE fabric_api_submit_rejected_total counter\n")
+	for i, class := range submitClassNames {
+		fmt.Fprintf(b, "fabric_api_submit_rejected_total{class=%q} %d\n", class, submits.rejected[i])
+	}
+	b.WriteString("# HELP fabric_api_submit_queue_wait_seconds Time transactions waited for a submit slot, by class.\n")
+	b.WriteString("# TYPE fabric_api_submit_queue_wait_seconds summary\n")
+	for i, class := range submitClassNames {
+		fmt.Fprintf(b, "fabric_api_submit_queue_wait_seconds_sum{class=%q} %g\n", class, submits.waitSum[i].Seconds())
+		fmt.Fprintf(b, "fabric_api_submit_queue_wait_seconds_count{class=%q} %d\n", class, submits.waits[i])
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSyncLimit = 100
+	maxSyncLimit     = 500
+)
+
+// SyncPage is one step of a delta sync. Upserts hold the accounts' current
+// values, so an account may come again in a later page if it changed since.
+type SyncPage struct {
+	Upserts        []Account `json:"upserts" xml:"upserts>account"`
+	Deletes        []string  `json:"deletes" xml:"deletes>MSISDN"`
+	NextCursor     string    `json:"nextCursor" xml:"nextCursor"`
+	HasMore        bool      `json:"hasMore" xml:"hasMore"`
+	IndexedThrough int64     `json:"indexedThrough" xml:"indexedThrough"`
+	Meta           ListMeta  `json:"meta" xml:"meta"`
+}
+
+// syncPosition orders changes by block, then MSISDN. A cursor is the
+// position of the last change a client has applied.
+type syncPosition struct {
+	block  uint64
+	msisdn string
+}
+
+func (p syncPosition) before(ch *Change) bool {
+	return p.block < ch.BlockNumber || p.block == ch.BlockNumber && p.msisdn < ch.MSISDN
+}
+
+func (p syncPosition) cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("v1/%d/%s", p.block, p.msisdn)))
+}
+
+func parseSyncCursor(s string) (syncPosition, bool) {
+	if s == "" {
+		return syncPosition{}, true
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return syncPosition{}, false
+	}
+	parts := strings.SplitN(string(b), "/", 3)
+	if len(parts) != 3 || parts[0] != "v1" {
+		return syncPosition{}, false
+	}
+	n, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return syncPosition{}, false
+	}
+	return syncPosition{block: n, msisdn: parts[2]}, true
+}
+
+// syncHandler returns up to ?limit= changes after ?cursor=, from the change
+// index. Without a cursor it starts at the beginning of the channel, which
+// is the initial download. Clients store nextCursor and come back with it.
+func syncHandler(c *gin.Context) {
+	if !changeIndex.enabled {
+		apiError(c, 503, ErrChangeIndexDisabled, nil)
+		return
+	}
+	pos, ok := parseSyncCursor(c.Query("cursor"))
+	if !ok {
+		apiError(c, 400, ErrInvalidCursor, gin.H{"param": "cursor"})
+		return
+	}
+	limit := defaultSyncLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSyncLimit {
+			apiError(c, 400, ErrOutOfRange, gin.H{"param": "limit", "min": 1, "max": maxSyncLimit})
+			return
+		}
+		limit = n
+	}
+	changeIndex.RLock()
+	var pending []Change
+	for _, ch := range changeIndex.Changes {
+		if pos.before(ch) && ownDealerOnly(c, ch) {
+			pending = append(pending, *ch)
+		}
+	}
+	through := int64(changeIndex.Next) - 1
+	changeIndex.RUnlock()
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].BlockNumber != pending[j].BlockNumber {
+			return pending[i].BlockNumber < pending[j].BlockNumber
+		}
+		return pending[i].MSISDN < pending[j].MSISDN
+	})
+	page := SyncPage{Upserts: []Account{}, Deletes: []string{}, NextCursor: c.Query("cursor"), HasMore: len(pending) > limit, IndexedThrough: through}
+	if page.HasMore {
+		pending = pending[:limit]
+	}
+	var upserts []string
+	for _, ch := range pending {
+		if ch.Deleted {
+			page.Deletes = append(page.Deletes, ch.MSISDN)
+		} else {
+			upserts = append(upserts, ch.MSISDN)
+		}
+	}
+	if len(pending) > 0 {
+		last := pending[len(pending)-1]
+		page.NextCursor = syncPosition{block: last.BlockNumber, msisdn: last.MSISDN}.cursor()
+	}
+	if len(upserts) > 0 {
+		arg, err := json.Marshal(upserts)
+		if err != nil {
+			internalError(c, err)
+			return
+		}
+		opts, ok := proposalOptions(c, string(arg))
+		if !ok {
+			return
+		}
+		// An account deleted since it was indexed is missing here and comes
+		// as a delete in a later page.
+		res, err := evaluate(c, "ReadAssets", opts...)
+		if err != nil {
+			fabricError(c, err)
+			return
+		}
+		if err := json.Unmarshal(res, &page.Upserts); err != nil {
+			internalError(c, err)
+			return
+		}
+	}
+	page.Meta = ListMeta{ReturnedCount: len(pending), PageSize: limit, NextCursor: page.NextCursor, HasMore: page.HasMore, FetchedAtBlockHeight: uint64(through + 1)}
+	respond(c, 200, page)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Features a tenant overlay can switch off. Every feature is on unless the
+// overlay sets it to false.
+const (
+	featurePassthrough = "passthrough"
+	featureOffline     = "offline"
+	featureIndexReads  = "index-reads"
+)
+
+var knownFeatures = []string{featurePassthrough, featureOffline, featureIndexReads}
+
+var tenantName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// TenantRateLimit is a token bucket shared by all of a tenant's keys on one
+// replica.
+type TenantRateLimit struct {
+	PerSecond float64 `json:"perSecond"`
+	Burst     float64 `json:"burst,omitempty"`
+}
+
+// TenantConfig is the overlay applied to requests made with a key whose
+// tenant is Name. Timeouts maps routes as registered, or "*" for every
+// route, to a duration that replaces ENDPOINT_TIMEOUTS. Functions narrows
+// the role's function policy for the pass-through and offline endpoints: a
+// function must be allowed by both.
+type TenantConfig struct {
+	Name      string            `json:"name"`
+	Timeouts  map[string]string `json:"timeouts,omitempty"`
+	RateLimit *TenantRateLimit  `json:"rateLimit,omitempty"`
+	Features  map[string]bool   `json:"features,omitempty"`
+	Functions *FunctionPolicy   `json:"functions,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+
+	timeouts map[string]time.Duration
+}
+
+var tenants = struct {
+	sync.RWMutex
+	dir     string
+	byName  map[string]*TenantConfig
+	buckets map[string]*rateBucket
+	limited map[string]int64
+}{byName: map[string]*TenantConfig{}, buckets: map[string]*rateBucket{}, limited: map[string]int64{}}
+
+// loadTenants reads the overlays in TENANT_DIR, one <name>.json per tenant.
+// Replicas sharing the directory pick up each other's changes within 30
+// seconds.
+func loadTenants() {
+	dir := os.Getenv("TENANT_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "fabric-api-tenants")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Fatalf("tenants: %v", err)
+	}
+	tenants.dir = dir
+	if err := reloadTenants(); err != nil {
+		log.Fatalf("tenants: %v", err)
+	}
+}
+
+func reloadTenants() error {
+	files, err := os.ReadDir(tenants.dir)
+	if err != nil {
+		return err
+	}
+	byName := map[string]*TenantConfig{}
+	for _, f := range files {
+		name, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(tenants.dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		var t TenantConfig
+		if err := json.Unmarshal(b, &t); err != nil {
+			return fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		if t.Name != name {
+			return fmt.Errorf("%s: name is %q", f.Name(), t.Name)
+		}
+		byName[name] = &t
+	}
+	tenants.Lock()
+	tenants.byName = byName
+	tenants.Unlock()
+	return nil
+}
+
+func runTenantReload(ctx context.Context) {
+	t := time.NewTicker(30 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := reloadTenants(); err != nil {
+				log.Printf("tenants: keeping previous overlays: %v", err)
+			}
+		}
+	}
+}
+
+func (t *TenantConfig) validate() error {
+	if !tenantName.MatchString(t.Name) {
+		return errors.New("name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	t.timeouts = map[string]time.Duration{}
+	for route, v := range t.Timeouts {
+		d, err := time.ParseDuration(v)
+		if route != "*" && !strings.HasPrefix(route, "/") || err != nil || d <= 0 {
+			return fmt.Errorf("timeouts: bad entry %q: %q", route, v)
+		}
+		t.timeouts[route] = d
+	}
+	if r := t.RateLimit; r != nil {
+		if r.PerSecond <= 0 {
+			return errors.New("rateLimit.perSecond must be positive")
+		}
+		if r.Burst == 0 {
+			r.Burst = r.PerSecond
+		}
+		if r.Burst < 1 {
+			return errors.New("rateLimit.burst must be at least 1")
+		}
+	}
+	for f := range t.Features {
+		known := false
+		for _, k := range knownFeatures {
+			known = known || k == f
+		}
+		if !known {
+			return fmt.Errorf("features: unknown feature %q (known: %s)", f, strings.Join(knownFeatures, ", "))
+		}
+	}
+	if p := t.Functions; p != nil {
+		for _, fn := range append(append([]string{}, p.Allow...), p.Deny...) {
+			if fn == "" {
+				return errors.New("functions: names must not be empty")
+			}
+		}
+	}
+	return nil
+}
+
+// tenantOf returns the overlay of the request's tenant, or nil when its key
+// has no tenant or the tenant has no overlay. It also resolves the key
+// itself, for middleware that runs before authenticate.
+func tenantOf(c *gin.Context) *TenantConfig {
+	p, ok := c.Get(principalKey)
+	pr, _ := p.(Principal)
+	if !ok {
+		pr, _ = principalForKey(presentedKey(c))
+	}
+	if pr.Tenant == "" {
+		return nil
+	}
+	tenants.RLock()
+	defer tenants.RUnlock()
+	return tenants.byName[pr.Tenant]
+}
+
+// tenantTimeout is the overlay's timeout for route, falling back to "*".
+func (t *TenantConfig) tenantTimeout(route string) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	if d, ok := t.timeouts[route]; ok {
+		return d, true
+	}
+	d, ok := t.timeouts["*"]
+	return d, ok
+}
+
+func (t *TenantConfig) featureEnabled(name string) bool {
+	if t == nil {
+		return true
+	}
+	on, ok := t.Features[name]
+	return !ok || on
+}
+
+func (t *TenantConfig) functionAllowed(fn string) bool {
+	if t == nil || t.Functions == nil {
+		return true
+	}
+	return policyAllows(*t.Functions, fn)
+}
+
+// tenantRateLimit answers 429 once the tenant's bucket is empty. It runs
+// inside authenticate, so only authenticated routes count against it.
+func tenantRateLimit(c *gin.Context, t *TenantConfig) bool {
+	if t == nil || t.RateLimit == nil {
+		return true
+	}
+	now := time.Now()
+	tenants.Lock()
+	b, ok := tenants.buckets[t.Name]
+	if !ok {
+		b = &rateBucket{tokens: t.RateLimit.Burst, last: now}
+		tenants.buckets[t.Name] = b
+	}
+	allowed := b.take(now, t.RateLimit.PerSecond, t.RateLimit.Burst)
+	if !allowed {
+		tenants.limited[t.Name]++
+	}
+	tenants.Unlock()
+	if !allowed {
+		c.Header("Retry-After", "1")
+		apiError(c, 429, ErrRateLimited, gin.H{"retryable": true, "tenant": t.Name})
+	}
+	return allowed
+}
+
+// requireFeature answers 403 FEATURE_DISABLED to tenants whose overlay
+// switches name off.
+func requireFeature(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tenantOf(c).featureEnabled(name) {
+			apiError(c, 403, ErrFeatureDisabled, gin.H{"feature": name})
+			return
+		}
+		c.Next()
+	}
+}
+
+func putTenant(t *TenantConfig) error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(tenants.dir, t.Name+".json")
+	if err := os.WriteFile(p+".tmp", b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(p+".tmp", p); err != nil {
+		return err
+	}
+	tenants.Lock()
+	tenants.byName[t.Name] = t
+	delete(tenants.buckets, t.Name)
+	tenants.Unlock()
+	return nil
+}
+
+func listTenantsHandler(c *gin.Context) {
+	tenants.RLock()
+	out := make([]*TenantConfig, 0, len(tenants.byName))
+	for _, t := range tenants.byName {
+		out = append(out, t)
+	}
+	tenants.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	c.JSON(200, gin.H{"tenants": out})
+}
+
+func getTenantHandler(c *gin.Context) {
+	tenants.RLock()
+	t, ok := tenants.byName[c.Param("tenant")]
+	tenants.RUnlock()
+	if !ok {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	c.JSON(200, t)
+}
+
+// setTenantHandler creates or replaces :tenant's overlay. The body's name,
+// if given, must match the path.
+func setTenantHandler(c *gin.Context) {
+	var t TenantConfig
+	if err := c.ShouldBindJSON(&t); err != nil {
+		bodyError(c, err)
+		return
+	}
+	name := c.Param("tenant")
+	if t.Name != "" && t.Name != name {
+		apiError(c, 400, ErrInvalidTenant, gin.H{"reason": "name does not match the path"})
+		return
+	}
+	t.Name = name
+	if err := t.validate(); err != nil {
+		apiError(c, 400, ErrInvalidTenant, gin.H{"reason": err.Error()})
+		return
+	}
+	tenants.RLock()
+	_, exists := tenants.byName[name]
+	tenants.RUnlock()
+	t.UpdatedAt = time.Now().UTC()
+	if err := putTenant(&t); err != nil {
+		internalError(c, err)
+		return
+	}
+	status := 200
+	if !exists {
+		status = 201
+	}
+	c.JSON(status, &t)
+}
+
+func deleteTenantHandler(c *gin.Context) {
+	name := c.Param("tenant")
+	if !tenantName.MatchString(name) {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	err := os.Remove(filepath.Join(tenants.dir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	tenants.Lock()
+	delete(tenants.byName, name)
+	delete(tenants.buckets, name)
+	tenants.Unlock()
+	c.JSON(200, gin.H{"message": "deleted", "tenant": name})
+}
+
+func writeTenantMetrics(b *strings.Builder) {
+	tenants.RLock()
+	defer tenants.RUnlock()
+	if len(tenants.limited) == 0 {
+		return
+	}
+	b.WriteString("# HELP fabric_api_tenant_rate_limited_total Authenticated requests refused by their tenant's rate limit.\n")
+	b.WriteString("# TYPE fabric_api_tenant_rate_limited_total counter\n")
+	for name, n := range tenants.limited {
+		fmt.Fprintf(b, "fabric_api_tenant_rate_limited_total{tenant=%q} %d\n", name, n)
+	}
+}
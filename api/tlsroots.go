@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tlsRootsReloadInterval is how often TLS_CERT_PATH is read again for added
+// or rotated CAs.
+const tlsRootsReloadInterval = 30 * time.Second
+
+// TLSRoot is one CA trusted for peer TLS and the file it came from.
+type TLSRoot struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"notAfter"`
+	Source      string    `json:"source"`
+}
+
+// TLSRoots is GET /admin/tls-roots.
+type TLSRoots struct {
+	Path     string    `json:"path"`
+	LoadedAt time.Time `json:"loadedAt"`
+	Roots    []TLSRoot `json:"roots"`
+}
+
+type tlsRoots struct {
+	pool     *x509.CertPool
+	certs    []*x509.Certificate
+	sources  []string
+	loadedAt time.Time
+}
+
+// tlsRootFiles expands TLS_CERT_PATH, a comma-separated list of PEM files
+// and directories. A directory contributes its .pem, .crt and .cer files,
+// leaving out hidden entries such as the ..data link of a mounted secret.
+func tlsRootFiles(spec string) ([]string, error) {
+	var files []string
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			ext := filepath.Ext(e.Name())
+			if strings.HasPrefix(e.Name(), ".") || ext != ".pem" && ext != ".crt" && ext != ".cer" {
+				continue
+			}
+			f := filepath.Join(p, e.Name())
+			if fi, err := os.Stat(f); err != nil || fi.IsDir() {
+				continue
+			}
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("TLS_CERT_PATH %q: no certificate files", spec)
+	}
+	return files, nil
+}
+
+// loadTLSRoots reads every certificate under spec, once each however many
+// files repeat it.
+func loadTLSRoots(spec string) (*tlsRoots, error) {
+	files, err := tlsRootFiles(spec)
+	if err != nil {
+		return nil, err
+	}
+	r := &tlsRoots{pool: x509.NewCertPool(), loadedAt: time.Now().UTC()}
+	seen := map[[32]byte]bool{}
+	for _, f := range files {
+		certs, err := parseCertificates(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range certs {
+			fp := sha256.Sum256(c.Raw)
+			if seen[fp] {
+				continue
+			}
+			seen[fp] = true
+			r.pool.AddCert(c)
+			r.certs = append(r.certs, c)
+			r.sources = append(r.sources, f)
+		}
+	}
+	return r, nil
+}
+
+func (r *tlsRoots) fingerprints() []string {
+	out := make([]string, len(r.certs))
+	for i, c := range r.certs {
+		fp := sha256.Sum256(c.Raw)
+		out[i] = hex.EncodeToString(fp[:])
+	}
+	slices.Sort(out)
+	return out
+}
+
+// reloadRoots reads TLS_CERT_PATH again and swaps the roots in if the set of
+// CAs changed. New handshakes verify against them at once; established
+// connections are kept. On error the previous roots stay in use.
+func (s *credentialStore) reloadRoots() (bool, error) {
+	r, err := loadTLSRoots(s.tlsRootsPath)
+	if err != nil {
+		return false, err
+	}
+	if old := s.roots.Load(); old != nil && slices.Equal(old.fingerprints(), r.fingerprints()) {
+		return false, nil
+	}
+	s.roots.Store(r)
+	return true, nil
+}
+
+// reloadRootsLogged is reloadRoots for the background reloads, which log
+// and record what happened instead of returning it.
+func (s *credentialStore) reloadRootsLogged() {
+	changed, err := s.reloadRoots()
+	if err != nil {
+		log.Printf("TLS roots reload failed, keeping previous roots: %v", err)
+		recordSystemOperation("tls roots reload", err)
+		return
+	}
+	if changed {
+		log.Printf("TLS roots reloaded: %d trusted", len(s.roots.Load().certs))
+		recordSystemOperation("tls roots reload", nil)
+	}
+}
+
+// runTLSRootsReload picks up CAs added to or removed from TLS_CERT_PATH, so
+// a new org's peers or a rotated TLS CA need no restart.
+func runTLSRootsReload(ctx context.Context) {
+	if ids.tlsRootsPath == "" {
+		return
+	}
+	t := time.NewTicker(tlsRootsReloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			ids.reloadRootsLogged()
+		}
+	}
+}
+
+func currentTLSRoots() TLSRoots {
+	r := ids.roots.Load()
+	out := TLSRoots{Path: ids.tlsRootsPath, LoadedAt: r.loadedAt, Roots: []TLSRoot{}}
+	for i, c := range r.certs {
+		fp := sha256.Sum256(c.Raw)
+		out.Roots = append(out.Roots, TLSRoot{Subject: c.Subject.String(), Issuer: c.Issuer.String(), Fingerprint: hex.EncodeToString(fp[:]), NotAfter: c.NotAfter, Source: r.sources[i]})
+	}
+	return out
+}
+
+func tlsRootsHandler(c *gin.Context) {
+	c.JSON(200, currentTLSRoots())
+}
+
+// reloadTLSRootsHandler reloads TLS_CERT_PATH now rather than at the next
+// poll. A bad file answers 422 and leaves the previous roots in place.
+func reloadTLSRootsHandler(c *gin.Context) {
+	if ids.tlsRootsPath == "" {
+		apiError(c, 404, ErrNotFound, nil)
+		return
+	}
+	if _, err := ids.reloadRoots(); err != nil {
+		apiError(c, 422, ErrInvalidTLSRoots, gin.H{"detail": err.Error()})
+		return
+	}
+	c.JSON(200, currentTLSRoots())
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+type TxInfo struct {
+	ValidationCode string `json:"validationCode"`
+	Valid          bool   `json:"valid"`
+	SubmitterMSP   string `json:"submitterMsp"`
+}
+
+var txInfos sync.Map
+
+// transactionInfo looks up how the peer validated txID and which MSP signed
+// it, neither of which is part of the key history.
+func transactionInfo(txID string) (*TxInfo, error) {
+	if v, ok := txInfos.Load(txID); ok {
+		return v.(*TxInfo), nil
+	}
+	res, err := qscc.EvaluateTransaction("GetTransactionByID", network.Name(), txID)
+	if err != nil {
+		return nil, err
+	}
+	var ptx peer.ProcessedTransaction
+	if err := proto.Unmarshal(res, &ptx); err != nil {
+		return nil, err
+	}
+	code := peer.TxValidationCode(ptx.GetValidationCode())
+	info := &TxInfo{ValidationCode: code.String(), Valid: code == peer.TxValidationCode_VALID}
+
+	var payload common.Payload
+	if err := proto.Unmarshal(ptx.GetTransactionEnvelope().GetPayload(), &payload); err != nil {
+		return nil, err
+	}
+	var sh common.SignatureHeader
+	if err := proto.Unmarshal(payload.GetHeader().GetSignatureHeader(), &sh); err != nil {
+		return nil, err
+	}
+	var creator msp.SerializedIdentity
+	if err := proto.Unmarshal(sh.GetCreator(), &creator); err != nil {
+		return nil, err
+	}
+	info.SubmitterMSP = creator.GetMspid()
+	txInfos.Store(txID, info)
+	return info, nil
+}
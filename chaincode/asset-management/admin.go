@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const roleAttr = "role"
+
+// requireAdmin accepts callers whose certificate carries the admin NodeOU or
+// a Fabric CA attribute role=admin.
+func requireAdmin(ctx contractapi.TransactionContextInterface) error {
+	id := ctx.GetClientIdentity()
+	if v, ok, err := id.GetAttributeValue(roleAttr); err == nil && ok && v == "admin" {
+		return nil
+	}
+	cert, err := id.GetX509Certificate()
+	if err != nil {
+		return err
+	}
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == "admin" {
+			return nil
+		}
+	}
+	return errors.New("admin identity required")
+}
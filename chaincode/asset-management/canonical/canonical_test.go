@@ -0,0 +1,147 @@
+package canonical
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// account mirrors the chaincode's Account, which lives in package main.
+type account struct {
+	DEALERID     string `json:"DEALERID"`
+	MSISDN       string `json:"MSISDN"`
+	MPIN         string `json:"MPIN"`
+	BALANCE      int64  `json:"BALANCE"`
+	STATUS       string `json:"STATUS"`
+	TRANSAMOUNT  int64  `json:"TRANSAMOUNT"`
+	TRANSTYPE    string `json:"TRANSTYPE"`
+	REMARKS      string `json:"REMARKS"`
+	PARENT       string `json:"PARENT,omitempty"`
+	CURRENCY     string `json:"CURRENCY,omitempty"`
+	CreatedAt    int64  `json:"createdAt,omitempty"`
+	LastModified int64  `json:"lastModified,omitempty"`
+}
+
+func TestAccountRoundTrip(t *testing.T) {
+	in := account{
+		DEALERID:     "D1",
+		MSISDN:       "254700000001",
+		MPIN:         "$2a$10$abc",
+		BALANCE:      math.MaxInt64,
+		STATUS:       "active",
+		TRANSAMOUNT:  -250,
+		TRANSTYPE:    "debit",
+		REMARKS:      "tea & <cake>",
+		PARENT:       "254700000000",
+		CURRENCY:     "KES",
+		CreatedAt:    1700000000,
+		LastModified: 1700000100,
+	}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out account
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Fatalf("round trip: got %+v, want %+v", out, in)
+	}
+	again, err := Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(b) {
+		t.Fatalf("second marshal differs:\n%s\n%s", again, b)
+	}
+}
+
+func TestFieldOrderIndependent(t *testing.T) {
+	type ab struct {
+		A int    `json:"a"`
+		B string `json:"b"`
+	}
+	type ba struct {
+		B string `json:"b"`
+		A int    `json:"a"`
+	}
+	x, err := Marshal(ab{1, "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	y, err := Marshal(ba{"x", 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(x) != string(y) || string(x) != `{"a":1,"b":"x"}` {
+		t.Fatalf("got %s and %s", x, y)
+	}
+}
+
+func TestMapOrderIndependent(t *testing.T) {
+	m := map[string]any{}
+	for _, k := range []string{"z", "a", "m", "b", "y", "c"} {
+		m[k] = map[string]int{"q": 1, "p": 2}
+	}
+	want := `{"a":{"p":2,"q":1},"b":{"p":2,"q":1},"c":{"p":2,"q":1},"m":{"p":2,"q":1},"y":{"p":2,"q":1},"z":{"p":2,"q":1}}`
+	for i := 0; i < 20; i++ {
+		b, err := Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Fatalf("got %s", b)
+		}
+	}
+}
+
+func TestLargeIntegers(t *testing.T) {
+	for _, tc := range []struct {
+		in   any
+		want string
+	}{
+		{int64(math.MaxInt64), "9223372036854775807"},
+		{int64(math.MinInt64), "-9223372036854775808"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{int64(1<<53 + 1), "9007199254740993"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+	} {
+		b, err := Marshal(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("Marshal(%v) = %s, want %s", tc.in, b, tc.want)
+		}
+	}
+}
+
+func TestHTMLNotEscaped(t *testing.T) {
+	b, err := Marshal(map[string]string{"<k>": "a & b <c> \"d\"\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"<k>":"a & b <c> \"d\"\n"}`; string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+	var back map[string]string
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, map[string]string{"<k>": "a & b <c> \"d\"\n"}) {
+		t.Fatalf("round trip: %v", back)
+	}
+}
+
+func TestNoWhitespace(t *testing.T) {
+	b, err := Marshal([]any{1, []int{}, map[string]any{}, nil, true, "x y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[1,[],{},null,true,"x y"]`; string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+}
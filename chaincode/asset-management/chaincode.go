@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Account struct {
@@ -17,6 +21,15 @@ type Account struct {
 	TRANSAMOUNT int64  `json:"TRANSAMOUNT"`
 	TRANSTYPE   string `json:"TRANSTYPE"`
 	REMARKS     string `json:"REMARKS"`
+	PARENT      string `json:"PARENT,omitempty"`
+	// CURRENCY is empty for accounts in the FX config's base currency; see
+	// SetAccountCurrency.
+	CURRENCY string `json:"CURRENCY,omitempty"`
+	// CreatedAt and LastModified are transaction timestamps in Unix seconds.
+	// Accounts written before they existed have no CreatedAt until updated
+	// through UpdateAsset, which keeps whatever was stored.
+	CreatedAt    int64 `json:"createdAt,omitempty"`
+	LastModified int64 `json:"lastModified,omitempty"`
 }
 
 type SmartContract struct {
@@ -31,81 +44,336 @@ func (s *SmartContract) exists(ctx contractapi.TransactionContextInterface, key
 	return b != nil, nil
 }
 
-func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, dealerID, msisdn, mpin, balance, status, transAmount, transType, remarks string) error {
-	ok, err := s.exists(ctx, msisdn)
+// txSeconds is the transaction timestamp in Unix seconds. It is chosen by the
+// client and identical on every endorser, unlike the local clock, so it is
+// the only time chaincode may write to state.
+func txSeconds(ctx contractapi.TransactionContextInterface) (int64, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	return ts.GetSeconds(), nil
+}
+
+// readAccount returns the stored account, still encrypted, or nil if msisdn
+// does not exist.
+func (s *SmartContract) readAccount(ctx contractapi.TransactionContextInterface, msisdn string) (*Account, error) {
+	b, err := ctx.GetStub().GetState(msisdn)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	var acc Account
+	if err := decodeAccount(b, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// writeAccount stores acc, stamping it with the transaction's timestamp,
+// keeping the dealer~msisdn index in step and recording the request's origin.
+func (s *SmartContract) writeAccount(ctx contractapi.TransactionContextInterface, acc *Account) error {
+	now, err := txSeconds(ctx)
 	if err != nil {
 		return err
 	}
-	if ok {
-		return errors.New("asset exists")
+	acc.LastModified = now
+	format, err := stateFormat(ctx)
+	if err != nil {
+		return err
 	}
-	bal, err := strconv.ParseInt(balance, 10, 64)
+	raw, err := encodeAccount(format, acc)
 	if err != nil {
 		return err
 	}
+	if err := indexDealer(ctx, acc); err != nil {
+		return err
+	}
+	if _, err := recordOrigin(ctx); err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(acc.MSISDN, raw)
+}
+
+// putAccount validates and stores acc as a customer-initiated change: it is
+// recorded in the recent transactions ring and announced with event.
+func (s *SmartContract) putAccount(ctx contractapi.TransactionContextInterface, acc *Account, event string) error {
+	if err := acc.validate(); err != nil {
+		return err
+	}
+	if err := claimOperation(ctx, acc.MSISDN); err != nil {
+		return err
+	}
+	if err := encryptAccount(ctx, acc); err != nil {
+		return err
+	}
+	if err := s.writeAccount(ctx, acc); err != nil {
+		return err
+	}
+	if err := s.recordTransaction(ctx, acc); err != nil {
+		return err
+	}
+	return emitEvent(ctx, event, acc)
+}
+
+func parseAccount(dealerID, msisdn, mpin, balance, status, transAmount, transType, remarks string) (*Account, error) {
+	bal, err := strconv.ParseInt(balance, 10, 64)
+	if err != nil {
+		return nil, err
+	}
 	tamt, err := strconv.ParseInt(transAmount, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPlain("MPIN", mpin); err != nil {
+		return nil, err
+	}
+	if err := checkPlain("REMARKS", remarks); err != nil {
+		return nil, err
+	}
+	return &Account{DEALERID: dealerID, MSISDN: msisdn, MPIN: mpin, BALANCE: bal, STATUS: status, TRANSAMOUNT: tamt, TRANSTYPE: transType, REMARKS: remarks}, nil
+}
+
+// CreateAsset takes the account as positional strings; CreateAssetJSON is
+// the same with a typed payload.
+func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, dealerID, msisdn, mpin, balance, status, transAmount, transType, remarks string) error {
+	acc, err := parseAccount(dealerID, msisdn, mpin, balance, status, transAmount, transType, remarks)
 	if err != nil {
 		return err
 	}
-	acc := Account{DEALERID: dealerID, MSISDN: msisdn, MPIN: mpin, BALANCE: bal, STATUS: status, TRANSAMOUNT: tamt, TRANSTYPE: transType, REMARKS: remarks}
-	raw, err := json.Marshal(acc)
+	return s.createAccount(ctx, acc)
+}
+
+func (s *SmartContract) createAccount(ctx contractapi.TransactionContextInterface, acc *Account) error {
+	ok, err := s.exists(ctx, acc.MSISDN)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return errors.New("asset exists")
+	}
+	own, err := callerDealer(ctx)
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(msisdn, raw)
+	if own != "" && own != acc.DEALERID {
+		return errors.New("dealer identity may only create its own accounts")
+	}
+	if acc.CreatedAt, err = txSeconds(ctx); err != nil {
+		return err
+	}
+	if err := countDealerAccounts(ctx, acc.DEALERID, 1); err != nil {
+		return err
+	}
+	return s.putAccount(ctx, acc, EventAssetCreated)
 }
 
 func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, msisdn string) (*Account, error) {
-	b, err := ctx.GetStub().GetState(msisdn)
+	acc, err := s.readAccount(ctx, msisdn)
 	if err != nil {
 		return nil, err
 	}
-	if b == nil {
+	if acc == nil {
 		return nil, errors.New("not found")
 	}
-	var acc Account
-	if err := json.Unmarshal(b, &acc); err != nil {
+	own, err := callerDealer(ctx)
+	if err != nil {
 		return nil, err
 	}
-	return &acc, nil
+	if own != "" && own != acc.DEALERID {
+		return nil, errors.New("not found")
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := readableAccount(key, acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
 }
 
-func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, dealerID, msisdn, mpin, balance, status, transAmount, transType, remarks string) error {
-	ok, err := s.exists(ctx, msisdn)
+const maxReadAssets = 500
+
+// ReadAssets returns the accounts named in msisdns, a JSON array, that exist
+// and the caller may see, in the order given. Missing ones are skipped.
+func (s *SmartContract) ReadAssets(ctx contractapi.TransactionContextInterface, msisdns string) ([]*Account, error) {
+	var list []string
+	if err := json.Unmarshal([]byte(msisdns), &list); err != nil {
+		return nil, fmt.Errorf("msisdns: %w", err)
+	}
+	if len(list) > maxReadAssets {
+		return nil, fmt.Errorf("at most %d accounts per call", maxReadAssets)
+	}
+	own, err := callerDealer(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if !ok {
-		return errors.New("not found")
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
 	}
-	bal, err := strconv.ParseInt(balance, 10, 64)
+	out := []*Account{}
+	for _, m := range list {
+		acc, err := s.readAccount(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if acc == nil || own != "" && own != acc.DEALERID {
+			continue
+		}
+		if err := readableAccount(key, acc); err != nil {
+			return nil, err
+		}
+		out = append(out, acc)
+	}
+	return out, nil
+}
+
+// UpdateAsset takes the account as positional strings; UpdateAssetJSON is
+// the same with a typed payload. The mpin argument is ignored: the account
+// keeps its MPIN, which only ChangeMPIN replaces.
+func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, dealerID, msisdn, mpin, balance, status, transAmount, transType, remarks string) error {
+	acc, err := parseAccount(dealerID, msisdn, mpin, balance, status, transAmount, transType, remarks)
 	if err != nil {
 		return err
 	}
-	tamt, err := strconv.ParseInt(transAmount, 10, 64)
+	return s.updateAccount(ctx, acc)
+}
+
+func (s *SmartContract) updateAccount(ctx contractapi.TransactionContextInterface, acc *Account) error {
+	existing, err := s.readAccount(ctx, acc.MSISDN)
 	if err != nil {
 		return err
 	}
-	acc := Account{DEALERID: dealerID, MSISDN: msisdn, MPIN: mpin, BALANCE: bal, STATUS: status, TRANSAMOUNT: tamt, TRANSTYPE: transType, REMARKS: remarks}
-	raw, err := json.Marshal(acc)
+	own, err := callerDealer(ctx)
 	if err != nil {
 		return err
 	}
-	return ctx.GetStub().PutState(msisdn, raw)
+	if existing == nil || own != "" && own != existing.DEALERID {
+		return errors.New("not found")
+	}
+	if own != "" && own != acc.DEALERID {
+		return errors.New("dealer identity may not move accounts to another dealer")
+	}
+	if existing.STATUS == StatusClosed {
+		return errors.New("account is CLOSED")
+	}
+	// Funds on hold stay in BALANCE, so a raw write may not take it below
+	// them any more than a posting may.
+	if acc.BALANCE < existing.BALANCE {
+		now, err := txSeconds(ctx)
+		if err != nil {
+			return err
+		}
+		held, err := heldAmount(ctx, acc.MSISDN, now, nil)
+		if err != nil {
+			return err
+		}
+		if acc.BALANCE < held {
+			return fmt.Errorf("balance below the %d on hold", held)
+		}
+	}
+	// Only ChangeMPIN changes the MPIN, under the PIN policy.
+	acc.MPIN = existing.MPIN
+	acc.PARENT = existing.PARENT
+	acc.CURRENCY = existing.CURRENCY
+	acc.CreatedAt = existing.CreatedAt
+	if err := s.checkParentStatus(ctx, acc); err != nil {
+		return err
+	}
+	if acc.DEALERID != existing.DEALERID {
+		if err := countDealerAccounts(ctx, existing.DEALERID, -1); err != nil {
+			return err
+		}
+		if err := countDealerAccounts(ctx, acc.DEALERID, 1); err != nil {
+			return err
+		}
+	}
+	if err := s.putAccount(ctx, acc, EventAssetUpdated); err != nil {
+		return err
+	}
+	if frozen(acc.STATUS) && acc.STATUS != existing.STATUS {
+		return s.cascadeStatus(ctx, acc)
+	}
+	return nil
 }
 
 func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, msisdn string) error {
-	ok, err := s.exists(ctx, msisdn)
+	if err := s.checkDealerAccess(ctx, msisdn); err != nil {
+		return err
+	}
+	acc, err := s.readAccount(ctx, msisdn)
 	if err != nil {
 		return err
 	}
-	if !ok {
+	if acc == nil {
 		return errors.New("not found")
 	}
-	return ctx.GetStub().DelState(msisdn)
+	children, err := s.childMSISDNs(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return errors.New("account has sub-accounts")
+	}
+	if err := s.removeAccount(ctx, acc, ""); err != nil {
+		return err
+	}
+	return emitEvent(ctx, EventAssetDeleted, &Account{MSISDN: msisdn, DEALERID: acc.DEALERID})
+}
+
+// removeAccount deletes acc and every record kept beside it, leaving a
+// tombstone that points to mergedInto when the account was merged away.
+func (s *SmartContract) removeAccount(ctx contractapi.TransactionContextInterface, acc *Account, mergedInto string) error {
+	if err := s.removeAccountRecords(ctx, acc, mergedInto); err != nil {
+		return err
+	}
+	return countDealerAccounts(ctx, acc.DEALERID, -1)
+}
+
+// removeAccountRecords is removeAccount without the change to the dealer's
+// account count, for callers that count many removals at once.
+func (s *SmartContract) removeAccountRecords(ctx contractapi.TransactionContextInterface, acc *Account, mergedInto string) error {
+	msisdn := acc.MSISDN
+	if err := putTombstone(ctx, acc, mergedInto); err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(msisdn); err != nil {
+		return err
+	}
+	if err := s.unlinkChild(ctx, acc); err != nil {
+		return err
+	}
+	if err := s.deleteRecent(ctx, msisdn); err != nil {
+		return err
+	}
+	if err := s.deleteClosure(ctx, msisdn); err != nil {
+		return err
+	}
+	if err := deletePINState(ctx, msisdn); err != nil {
+		return err
+	}
+	if err := deleteDailySummaries(ctx, msisdn); err != nil {
+		return err
+	}
+	if err := deleteHolds(ctx, msisdn); err != nil {
+		return err
+	}
+	return unindexDealer(ctx, acc.DEALERID, msisdn)
 }
 
 func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*Account, error) {
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if own != "" {
+		return s.GetAssetsByDealer(ctx, own)
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
 	it, err := ctx.GetStub().GetStateByRange("", "")
 	if err != nil {
 		return nil, err
@@ -118,7 +386,10 @@ func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface
 			return nil, err
 		}
 		var a Account
-		if err := json.Unmarshal(kv.Value, &a); err != nil {
+		if err := decodeAccount(kv.Value, &a); err != nil {
+			return nil, err
+		}
+		if err := readableAccount(key, &a); err != nil {
 			return nil, err
 		}
 		out = append(out, &a)
@@ -126,14 +397,71 @@ func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface
 	return out, nil
 }
 
+type AssetPage struct {
+	Records  []*Account `json:"records"`
+	Bookmark string     `json:"bookmark"`
+	Fetched  int32      `json:"fetchedCount"`
+}
+
+func (s *SmartContract) GetAssetsPage(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*AssetPage, error) {
+	if pageSize < 1 {
+		return nil, errors.New("pageSize must be positive")
+	}
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if own != "" {
+		return s.dealerPage(ctx, own, pageSize, bookmark)
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	it, meta, err := ctx.GetStub().GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	page := &AssetPage{Records: []*Account{}, Bookmark: meta.GetBookmark(), Fetched: meta.GetFetchedRecordsCount()}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		var a Account
+		if err := decodeAccount(kv.Value, &a); err != nil {
+			return nil, err
+		}
+		if err := readableAccount(key, &a); err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, &a)
+	}
+	return page, nil
+}
+
+// History keeps Timestamp in whole seconds for existing clients;
+// TimestampNanos is the same instant in Unix nanoseconds. ValueHash is the
+// hex SHA-256 of the value bytes exactly as stored.
 type History struct {
-	TxID      string   `json:"txId"`
-	Value     *Account `json:"value,omitempty"`
-	IsDelete  bool     `json:"isDelete"`
-	Timestamp int64    `json:"timestamp"`
+	TxID           string   `json:"txId"`
+	Value          *Account `json:"value,omitempty"`
+	IsDelete       bool     `json:"isDelete"`
+	Timestamp      int64    `json:"timestamp"`
+	TimestampNanos int64    `json:"timestampNanos"`
+	ValueHash      string   `json:"valueHash,omitempty"`
+	Origin         *Origin  `json:"origin,omitempty"`
 }
 
 func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, msisdn string) ([]*History, error) {
+	if err := s.checkDealerAccess(ctx, msisdn); err != nil {
+		return nil, err
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
 	it, err := ctx.GetStub().GetHistoryForKey(msisdn)
 	if err != nil {
 		return nil, err
@@ -145,25 +473,107 @@ func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterf
 		if err != nil {
 			return nil, err
 		}
-		var val *Account
-		if rec.Value != nil && !rec.IsDelete {
-			var a Account
-			if err := json.Unmarshal(rec.Value, &a); err != nil {
-				return nil, err
-			}
-			val = &a
+		e, err := historyEntry(ctx, key, rec.TxId, rec.Value, rec.IsDelete, rec.Timestamp)
+		if err != nil {
+			return nil, err
 		}
-		h = append(h, &History{TxID: rec.TxId, Value: val, IsDelete: rec.IsDelete, Timestamp: rec.Timestamp.GetSeconds()})
+		h = append(h, e)
 	}
 	return h, nil
 }
 
+// HistoryPage is a window of an account's history. Next is the TxID to pass
+// as afterTxID for the following window, empty after the last.
+type HistoryPage struct {
+	Records []*History `json:"records"`
+	Next    string     `json:"next"`
+}
+
+// GetAssetHistoryPage returns up to maxRecords history entries following
+// afterTxID, or from the start when it is empty, in GetAssetHistory's
+// order. GetHistoryForKey cannot start mid-way, so entries up to the anchor
+// are still read, but only the window is decoded and returned.
+func (s *SmartContract) GetAssetHistoryPage(ctx contractapi.TransactionContextInterface, msisdn string, maxRecords int, afterTxID string) (*HistoryPage, error) {
+	if maxRecords < 1 {
+		return nil, errors.New("maxRecords must be positive")
+	}
+	if err := s.checkDealerAccess(ctx, msisdn); err != nil {
+		return nil, err
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	it, err := ctx.GetStub().GetHistoryForKey(msisdn)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	page := &HistoryPage{Records: []*History{}}
+	found := afterTxID == ""
+	for it.HasNext() {
+		rec, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			found = rec.TxId == afterTxID
+			continue
+		}
+		if len(page.Records) == maxRecords {
+			page.Next = page.Records[maxRecords-1].TxID
+			break
+		}
+		e, err := historyEntry(ctx, key, rec.TxId, rec.Value, rec.IsDelete, rec.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, e)
+	}
+	if !found {
+		return nil, fmt.Errorf("%s is not in the history of %s", afterTxID, msisdn)
+	}
+	return page, nil
+}
+
+// historyEntry decodes one history record, made readable with key.
+func historyEntry(ctx contractapi.TransactionContextInterface, key []byte, txID string, value []byte, isDelete bool, ts *timestamppb.Timestamp) (*History, error) {
+	var val *Account
+	var hash string
+	if value != nil && !isDelete {
+		sum := sha256.Sum256(value)
+		hash = hex.EncodeToString(sum[:])
+		var a Account
+		if err := decodeAccount(value, &a); err != nil {
+			return nil, err
+		}
+		if err := readableAccount(key, &a); err != nil {
+			return nil, err
+		}
+		val = &a
+	}
+	origin, err := readOrigin(ctx, txID)
+	if err != nil {
+		return nil, err
+	}
+	return &History{TxID: txID, Value: val, IsDelete: isDelete, Timestamp: ts.GetSeconds(), TimestampNanos: ts.GetSeconds()*1e9 + int64(ts.GetNanos()), ValueHash: hash, Origin: origin}, nil
+}
+
+func beforeTransaction(ctx *TransactionContext) error {
+	countInvocation(ctx)
+	return checkMaintenance(ctx)
+}
+
 func main() {
-	chaincode, err := contractapi.NewChaincode(new(SmartContract))
+	sc := new(SmartContract)
+	sc.TransactionContextHandler = new(TransactionContext)
+	sc.BeforeTransaction = beforeTransaction
+	sc.AfterTransaction = afterTransaction
+	chaincode, err := contractapi.NewChaincode(sc)
 	if err != nil {
 		panic(err)
 	}
-	if err := chaincode.Start(); err != nil {
+	if err := start(chaincode); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const closurePrefix = "closure"
+
+// Closure records how an account was closed. It stays in state, next to the
+// CLOSED account, until the account is deleted.
+type Closure struct {
+	MSISDN           string `json:"MSISDN"`
+	PreviousStatus   string `json:"previousStatus"`
+	SettlementMSISDN string `json:"settlementMsisdn,omitempty"`
+	SettledAmount    int64  `json:"settledAmount"`
+	ClosedAt         int64  `json:"closedAt"`
+	ClosedBy         string `json:"closedBy"`
+	TxID             string `json:"txId"`
+	Receipt          string `json:"receipt"`
+}
+
+func closureKey(ctx contractapi.TransactionContextInterface, msisdn string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(closurePrefix, []string{msisdn})
+}
+
+// CloseAccount marks msisdn CLOSED for good. A non-zero balance is moved to
+// settlementMsisdn in the same transaction; without one the balance must be
+// zero. Sub-accounts have to be closed first. A closed account takes no
+// further writes, adjustments included.
+func (s *SmartContract) CloseAccount(ctx contractapi.TransactionContextInterface, msisdn, settlementMsisdn string) (*Closure, error) {
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, errors.New("not found")
+	}
+	if acc.STATUS == StatusClosed {
+		return nil, errors.New("account is already CLOSED")
+	}
+	children, err := s.childMSISDNs(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range children {
+		child, err := s.readAccount(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if child != nil && child.STATUS != StatusClosed {
+			return nil, fmt.Errorf("sub-account %s must be closed first", m)
+		}
+	}
+	if settlementMsisdn == msisdn {
+		return nil, errors.New("cannot settle to the account being closed")
+	}
+	if acc.BALANCE != 0 && settlementMsisdn == "" {
+		return nil, fmt.Errorf("balance is %d: a settlement account is required", acc.BALANCE)
+	}
+	closedAt, err := txSeconds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cl := &Closure{MSISDN: msisdn, PreviousStatus: acc.STATUS, SettledAmount: acc.BALANCE, ClosedAt: closedAt, ClosedBy: clientID(ctx), TxID: ctx.GetStub().GetTxID()}
+	postings := []posting{{msisdn: msisdn, remarks: "account closed", status: StatusClosed}}
+	if acc.BALANCE != 0 {
+		cl.SettlementMSISDN = settlementMsisdn
+		postings = []posting{
+			{msisdn: msisdn, transType: TransTransferOut, amount: -acc.BALANCE, remarks: "closure settlement to " + settlementMsisdn, status: StatusClosed},
+			{msisdn: settlementMsisdn, transType: TransTransferIn, amount: acc.BALANCE, remarks: "closure settlement from " + msisdn},
+		}
+	}
+	if cl.Receipt, err = s.applyPostings(ctx, postings); err != nil {
+		return nil, err
+	}
+	key, err := closureKey(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := canonical.Marshal(cl)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(key, b); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// GetClosure returns how msisdn was closed.
+func (s *SmartContract) GetClosure(ctx contractapi.TransactionContextInterface, msisdn string) (*Closure, error) {
+	if err := s.checkDealerAccess(ctx, msisdn); err != nil {
+		return nil, err
+	}
+	key, err := closureKey(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, errors.New("not found")
+	}
+	var cl Closure
+	return &cl, json.Unmarshal(b, &cl)
+}
+
+func (s *SmartContract) deleteClosure(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	key, err := closureKey(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// clientID is the submitting identity's ID, or empty if it cannot be read.
+func clientID(ctx contractapi.TransactionContextInterface) string {
+	id, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return ""
+	}
+	return id
+}
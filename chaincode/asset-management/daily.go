@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	// dailyPrefix keys summaries as asset~msisdn~YYYYMMDD.
+	dailyPrefix  = "asset"
+	dailyLayout  = "20060102"
+	maxDailyDays = 366
+)
+
+// DailySummary totals an account's balance-moving transactions for one UTC
+// day. Credits and Debits are the sums of inflows and outflows, ByType the
+// net amount per TRANSTYPE. It is updated with every such transaction, so a
+// day's totals are one read.
+type DailySummary struct {
+	MSISDN         string           `json:"MSISDN"`
+	Date           string           `json:"date"`
+	Count          int              `json:"count"`
+	Credits        int64            `json:"credits"`
+	Debits         int64            `json:"debits"`
+	ByType         map[string]int64 `json:"byType"`
+	OpeningBalance int64            `json:"openingBalance"`
+	ClosingBalance int64            `json:"closingBalance"`
+}
+
+func dailyKey(ctx contractapi.TransactionContextInterface, msisdn, date string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(dailyPrefix, []string{msisdn, date})
+}
+
+func readDailySummary(ctx contractapi.TransactionContextInterface, msisdn, date string) (*DailySummary, error) {
+	key, err := dailyKey(ctx, msisdn, date)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	var d DailySummary
+	return &d, json.Unmarshal(b, &d)
+}
+
+// addToDailySummary folds msisdn's transactions, oldest first, into today's
+// summary. Like the recent transactions ring it must get all of a chaincode
+// transaction's entries for msisdn at once.
+func addToDailySummary(ctx contractapi.TransactionContextInterface, msisdn string, txs []*Account) error {
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return err
+	}
+	date := time.Unix(now, 0).UTC().Format(dailyLayout)
+	d, err := readDailySummary(ctx, msisdn, date)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, acc := range txs {
+		if acc.TRANSAMOUNT == 0 {
+			continue
+		}
+		if d == nil {
+			d = &DailySummary{MSISDN: msisdn, Date: date, ByType: map[string]int64{}, OpeningBalance: acc.BALANCE - acc.TRANSAMOUNT}
+		}
+		d.Count++
+		if acc.TRANSAMOUNT > 0 {
+			d.Credits += acc.TRANSAMOUNT
+		} else {
+			d.Debits -= acc.TRANSAMOUNT
+		}
+		d.ByType[acc.TRANSTYPE] += acc.TRANSAMOUNT
+		d.ClosingBalance = acc.BALANCE
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	raw, err := canonical.Marshal(d)
+	if err != nil {
+		return err
+	}
+	key, err := dailyKey(ctx, msisdn, date)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, raw)
+}
+
+func deleteDailySummaries(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	it, err := ctx.GetStub().GetStateByPartialCompositeKey(dailyPrefix, []string{msisdn})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().DelState(kv.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDailySummaries returns msisdn's summaries from one YYYYMMDD date to
+// another, inclusive and at most a year apart, oldest first. Days without
+// transactions are left out.
+func (s *SmartContract) GetDailySummaries(ctx contractapi.TransactionContextInterface, msisdn, from, to string) ([]*DailySummary, error) {
+	f, err := time.Parse(dailyLayout, from)
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	t, err := time.Parse(dailyLayout, to)
+	if err != nil {
+		return nil, fmt.Errorf("to: %w", err)
+	}
+	if t.Before(f) || t.Sub(f) >= maxDailyDays*24*time.Hour {
+		return nil, fmt.Errorf("to must be 0-%d days after from", maxDailyDays-1)
+	}
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, errors.New("not found")
+	}
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if own != "" && own != acc.DEALERID {
+		return nil, errors.New("not found")
+	}
+	out := []*DailySummary{}
+	for day := f; !day.After(t); day = day.AddDate(0, 0, 1) {
+		d, err := readDailySummary(ctx, msisdn, day.Format(dailyLayout))
+		if err != nil {
+			return nil, err
+		}
+		if d != nil {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+type DealerBalance struct {
+	DEALERID string `json:"DEALERID"`
+	Accounts int    `json:"accounts"`
+	BALANCE  int64  `json:"BALANCE"`
+}
+
+// Dashboard summarises the ledger in one read. Volume24h, the sum of the
+// absolute amounts of the last 24 hours' transactions, is taken from the
+// recent transactions rings, so an account with more than maxRecent
+// transactions in the window is undercounted.
+type Dashboard struct {
+	TotalAccounts int              `json:"totalAccounts"`
+	TotalBalance  int64            `json:"totalBalance"`
+	ByStatus      map[string]int   `json:"byStatus"`
+	TopDealers    []*DealerBalance `json:"topDealers"`
+	Volume24h     int64            `json:"volume24h"`
+	Count24h      int              `json:"transactions24h"`
+	AsOf          int64            `json:"asOf"`
+}
+
+func (s *SmartContract) GetDashboard(ctx contractapi.TransactionContextInterface, topDealers int) (*Dashboard, error) {
+	if topDealers < 0 {
+		return nil, errors.New("topDealers must not be negative")
+	}
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dashboard{ByStatus: map[string]int{}, TopDealers: []*DealerBalance{}, AsOf: now}
+
+	it, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	dealers := map[string]*DealerBalance{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		var a Account
+		if err := decodeAccount(kv.Value, &a); err != nil {
+			return nil, err
+		}
+		if own != "" && a.DEALERID != own {
+			continue
+		}
+		d.TotalAccounts++
+		d.TotalBalance += a.BALANCE
+		d.ByStatus[a.STATUS]++
+		db, ok := dealers[a.DEALERID]
+		if !ok {
+			db = &DealerBalance{DEALERID: a.DEALERID}
+			dealers[a.DEALERID] = db
+		}
+		db.Accounts++
+		db.BALANCE += a.BALANCE
+	}
+	for _, db := range dealers {
+		d.TopDealers = append(d.TopDealers, db)
+	}
+	sort.Slice(d.TopDealers, func(i, j int) bool {
+		if d.TopDealers[i].BALANCE != d.TopDealers[j].BALANCE {
+			return d.TopDealers[i].BALANCE > d.TopDealers[j].BALANCE
+		}
+		return d.TopDealers[i].DEALERID < d.TopDealers[j].DEALERID
+	})
+	if len(d.TopDealers) > topDealers {
+		d.TopDealers = d.TopDealers[:topDealers]
+	}
+
+	since := d.AsOf - 24*60*60
+	rings, err := ctx.GetStub().GetStateByPartialCompositeKey(recentPrefix, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer rings.Close()
+	for rings.HasNext() {
+		kv, err := rings.Next()
+		if err != nil {
+			return nil, err
+		}
+		if own != "" {
+			_, parts, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+			if err != nil {
+				return nil, err
+			}
+			acc, err := s.readAccount(ctx, parts[0])
+			if err != nil {
+				return nil, err
+			}
+			if acc == nil || acc.DEALERID != own {
+				continue
+			}
+		}
+		var ring []*TxSummary
+		if err := json.Unmarshal(kv.Value, &ring); err != nil {
+			return nil, err
+		}
+		// Rings are newest first.
+		for _, t := range ring {
+			if t.Timestamp < since {
+				break
+			}
+			// Debits are negative; volume is money moved either way.
+			if t.TRANSAMOUNT < 0 {
+				d.Volume24h -= t.TRANSAMOUNT
+			} else {
+				d.Volume24h += t.TRANSAMOUNT
+			}
+			d.Count24h++
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const dealerAttr = "dealerId"
+
+// callerDealer returns the dealer the caller is scoped to, or "" for callers
+// that are not: the dealerId attribute of the calling certificate or, for
+// gateways acting for a dealer with a certificate of their own, the dealerId
+// transient field. A transient scope can only narrow what the caller sees.
+func callerDealer(ctx contractapi.TransactionContextInterface) (string, error) {
+	v, ok, err := ctx.GetClientIdentity().GetAttributeValue(dealerAttr)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return v, nil
+	}
+	t, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", err
+	}
+	return string(t[dealerAttr]), nil
+}
+
+// checkDealerAccess answers "not found" when a dealer-scoped caller asks
+// about an account that is not its dealer's, as if it did not exist.
+func (s *SmartContract) checkDealerAccess(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	own, err := callerDealer(ctx)
+	if err != nil || own == "" {
+		return err
+	}
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if acc == nil || acc.DEALERID != own {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+// dealerScope resolves which dealer's accounts the caller may see: a scoped
+// caller is held to its own dealer whatever it asked for.
+func dealerScope(ctx contractapi.TransactionContextInterface, dealerID string) (string, error) {
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return "", err
+	}
+	if own == "" {
+		return dealerID, nil
+	}
+	if dealerID != "" && dealerID != own {
+		return "", errors.New("dealer identity may only read its own accounts")
+	}
+	return own, nil
+}
+
+func (s *SmartContract) GetAssetsByDealer(ctx contractapi.TransactionContextInterface, dealerID string) ([]*Account, error) {
+	dealerID, err := dealerScope(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	if dealerID == "" {
+		return nil, errors.New("dealerID required")
+	}
+	page, err := s.dealerPage(ctx, dealerID, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return page.Records, nil
+}
+
+func (s *SmartContract) GetAssetsPageByDealer(ctx contractapi.TransactionContextInterface, dealerID string, pageSize int, bookmark string) (*AssetPage, error) {
+	if pageSize < 1 {
+		return nil, errors.New("pageSize must be positive")
+	}
+	dealerID, err := dealerScope(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	if dealerID == "" {
+		return nil, errors.New("dealerID required")
+	}
+	return s.dealerPage(ctx, dealerID, pageSize, bookmark)
+}
+
+// dealerPage scans accounts from bookmark on and collects up to pageSize
+// (0 for no limit) belonging to dealerID. The bookmark is the key to resume
+// from, so pages stay full even though most keys are skipped.
+func (s *SmartContract) dealerPage(ctx contractapi.TransactionContextInterface, dealerID string, pageSize int, bookmark string) (*AssetPage, error) {
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	it, err := ctx.GetStub().GetStateByRange(bookmark, "")
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	page := &AssetPage{Records: []*Account{}}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if pageSize > 0 && len(page.Records) == pageSize {
+			page.Bookmark = kv.Key
+			break
+		}
+		var a Account
+		if err := decodeAccount(kv.Value, &a); err != nil {
+			return nil, err
+		}
+		if a.DEALERID != dealerID {
+			continue
+		}
+		if err := readableAccount(key, &a); err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, &a)
+	}
+	page.Fetched = int32(len(page.Records))
+	return page, nil
+}
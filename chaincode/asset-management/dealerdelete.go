@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	// dealerDeleteBatch bounds the accounts one DeleteAssetsByDealer call
+	// removes, keeping the write set and event payload small.
+	dealerDeleteBatch = 100
+
+	EventDealerAssetsDeleted = "DealerAssetsDeleted"
+)
+
+var ErrConfirmTokenMismatch = errors.New("confirm token does not match the dealer's accounts")
+
+type DeletionCandidate struct {
+	MSISDN  string `json:"MSISDN"`
+	BALANCE int64  `json:"BALANCE"`
+	STATUS  string `json:"STATUS"`
+	PARENT  string `json:"PARENT,omitempty"`
+}
+
+// DealerDeletionPlan lists, in deletion order, the accounts that would be
+// removed. ConfirmToken is a hash of the dealer ID and every listed account's
+// MSISDN, balance and status, so it stops matching as soon as any of them
+// changes.
+type DealerDeletionPlan struct {
+	DEALERID     string               `json:"DEALERID"`
+	Accounts     []*DeletionCandidate `json:"accounts"`
+	TotalBalance int64                `json:"totalBalance"`
+	BatchSize    int                  `json:"batchSize"`
+	ConfirmToken string               `json:"confirmToken"`
+}
+
+// DealerDeletion is the result of one batch. ConfirmToken covers the
+// accounts still remaining and is empty once none are left.
+type DealerDeletion struct {
+	DEALERID     string   `json:"DEALERID"`
+	Deleted      []string `json:"deleted"`
+	Remaining    int      `json:"remaining"`
+	ConfirmToken string   `json:"confirmToken,omitempty"`
+}
+
+type dealerAssetsDeleted struct {
+	DEALERID string   `json:"DEALERID"`
+	MSISDNs  []string `json:"MSISDNs"`
+}
+
+// deletionOrder returns dealerID's accounts with sub-accounts first, so no
+// primary is deleted while it still has children.
+func (s *SmartContract) deletionOrder(ctx contractapi.TransactionContextInterface, dealerID string) ([]*DeletionCandidate, error) {
+	page, err := s.dealerPage(ctx, dealerID, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*DeletionCandidate, 0, len(page.Records))
+	for _, a := range page.Records {
+		out = append(out, &DeletionCandidate{MSISDN: a.MSISDN, BALANCE: a.BALANCE, STATUS: a.STATUS, PARENT: a.PARENT})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].PARENT != "" && out[j].PARENT == "" })
+	return out, nil
+}
+
+func confirmToken(dealerID string, accounts []*DeletionCandidate) string {
+	if len(accounts) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", dealerID)
+	for _, a := range accounts {
+		fmt.Fprintf(h, "%s:%d:%s\n", a.MSISDN, a.BALANCE, a.STATUS)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PlanDealerDeletion is the dry run for DeleteAssetsByDealer: it changes
+// nothing and returns the accounts that would go and the token to confirm.
+func (s *SmartContract) PlanDealerDeletion(ctx contractapi.TransactionContextInterface, dealerID string) (*DealerDeletionPlan, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if dealerID == "" {
+		return nil, errors.New("dealerID required")
+	}
+	accounts, err := s.deletionOrder(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	plan := &DealerDeletionPlan{DEALERID: dealerID, Accounts: accounts, BatchSize: dealerDeleteBatch, ConfirmToken: confirmToken(dealerID, accounts)}
+	for _, a := range accounts {
+		plan.TotalBalance += a.BALANCE
+	}
+	return plan, nil
+}
+
+// DeleteAssetsByDealer removes up to dealerDeleteBatch of a terminated
+// dealer's accounts, with their recent transactions and sub-account links.
+// confirm must be the token PlanDealerDeletion (or the previous batch)
+// returned for exactly the accounts the dealer has now. A single
+// DealerAssetsDeleted event lists the removed MSISDNs.
+func (s *SmartContract) DeleteAssetsByDealer(ctx contractapi.TransactionContextInterface, dealerID, confirm string) (*DealerDeletion, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if dealerID == "" {
+		return nil, errors.New("dealerID required")
+	}
+	accounts, err := s.deletionOrder(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, errors.New("dealer has no accounts")
+	}
+	if confirm != confirmToken(dealerID, accounts) {
+		return nil, ErrConfirmTokenMismatch
+	}
+	n := min(len(accounts), dealerDeleteBatch)
+	res := &DealerDeletion{DEALERID: dealerID, Deleted: []string{}}
+	// Reads do not see this transaction's own deletes, so children removed
+	// earlier in the batch are tracked here.
+	deleted := map[string]bool{}
+	for _, a := range accounts[:n] {
+		children, err := s.childMSISDNs(ctx, a.MSISDN)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			if !deleted[c] {
+				return nil, fmt.Errorf("account %s has sub-account %s outside this dealer", a.MSISDN, c)
+			}
+		}
+		acc, err := s.readAccount(ctx, a.MSISDN)
+		if err != nil {
+			return nil, err
+		}
+		// The batch's accounts are counted off together below.
+		if err := s.removeAccountRecords(ctx, acc, ""); err != nil {
+			return nil, err
+		}
+		deleted[a.MSISDN] = true
+		res.Deleted = append(res.Deleted, a.MSISDN)
+	}
+	if err := countDealerAccounts(ctx, dealerID, -int64(n)); err != nil {
+		return nil, err
+	}
+	res.Remaining = len(accounts) - n
+	res.ConfirmToken = confirmToken(dealerID, accounts[n:])
+	raw, err := canonical.Marshal(&dealerAssetsDeleted{DEALERID: dealerID, MSISDNs: res.Deleted})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().SetEvent(EventDealerAssetsDeleted, raw); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const dealerIndex = "dealer~msisdn"
+
+// indexDealer points dealer~msisdn at acc, moving the entry if the stored
+// account belonged to another dealer. It runs on every account write, so
+// accounts written since the index exists are always in it.
+func indexDealer(ctx contractapi.TransactionContextInterface, acc *Account) error {
+	b, err := ctx.GetStub().GetState(acc.MSISDN)
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		var prev Account
+		if err := decodeAccount(b, &prev); err != nil {
+			return err
+		}
+		if prev.DEALERID != acc.DEALERID {
+			if err := unindexDealer(ctx, prev.DEALERID, acc.MSISDN); err != nil {
+				return err
+			}
+		}
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(dealerIndex, []string{acc.DEALERID, acc.MSISDN})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, []byte{0})
+}
+
+func unindexDealer(ctx contractapi.TransactionContextInterface, dealerID, msisdn string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(dealerIndex, []string{dealerID, msisdn})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// GetAssetsPageByDealerIndex pages through dealerID's accounts by the
+// dealer~msisdn index, reading only that dealer's keys, in MSISDN order.
+// Unlike GetAssetsPageByDealer it does not scan other dealers' accounts, but
+// accounts last written before the index existed are missing until
+// IndexDealers has run. Paginated queries only work in evaluations.
+func (s *SmartContract) GetAssetsPageByDealerIndex(ctx contractapi.TransactionContextInterface, dealerID string, pageSize int, bookmark string) (*AssetPage, error) {
+	if pageSize < 1 {
+		return nil, errors.New("pageSize must be positive")
+	}
+	dealerID, err := dealerScope(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	if dealerID == "" {
+		return nil, errors.New("dealerID required")
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	it, meta, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(dealerIndex, []string{dealerID}, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	page := &AssetPage{Records: []*Account{}, Bookmark: meta.GetBookmark(), Fetched: meta.GetFetchedRecordsCount()}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attrs, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		acc, err := s.readAccount(ctx, attrs[1])
+		if err != nil {
+			return nil, err
+		}
+		// A stale entry from before a write moved the account is skipped.
+		if acc == nil || acc.DEALERID != dealerID {
+			continue
+		}
+		if err := readableAccount(key, acc); err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, acc)
+	}
+	return page, nil
+}
+
+// IndexDealers adds the dealer~msisdn entries of one page of accounts, for
+// accounts written before the index existed. Call it with the returned
+// bookmark until that is empty.
+func (s *SmartContract) IndexDealers(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (string, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return "", err
+	}
+	if pageSize < 1 {
+		return "", errors.New("pageSize must be positive")
+	}
+	it, err := ctx.GetStub().GetStateByRange(bookmark, "")
+	if err != nil {
+		return "", err
+	}
+	defer it.Close()
+	for n := 0; it.HasNext(); n++ {
+		kv, err := it.Next()
+		if err != nil {
+			return "", err
+		}
+		if n == pageSize {
+			return kv.Key, nil
+		}
+		var a Account
+		if err := decodeAccount(kv.Value, &a); err != nil {
+			return "", err
+		}
+		key, err := ctx.GetStub().CreateCompositeKey(dealerIndex, []string{a.DEALERID, a.MSISDN})
+		if err != nil {
+			return "", err
+		}
+		if err := ctx.GetStub().PutState(key, []byte{0}); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// IndexRebuildPage reports one page of RebuildDealerIndex. Phase is the one
+// the page worked on; Bookmark is empty once both are done.
+type IndexRebuildPage struct {
+	Phase    string `json:"phase"`
+	Scanned  int    `json:"scanned"`
+	Added    int    `json:"added"`
+	Removed  int    `json:"removed"`
+	Bookmark string `json:"bookmark"`
+}
+
+// RebuildDealerIndex brings the dealer~msisdn index in line with the
+// accounts, up to pageSize keys at a time. It first adds the entries missing
+// for accounts, as IndexDealers does, then deletes entries whose account is
+// gone or belongs to another dealer; the index phase's bookmarks are index
+// keys, which is how a call tells the phases apart. Call it with the returned
+// bookmark until that is empty. Evaluating it instead of submitting it is a
+// dry run: nothing is written but the counts are the same.
+func (s *SmartContract) RebuildDealerIndex(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*IndexRebuildPage, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if pageSize < 1 {
+		return nil, errors.New("pageSize must be positive")
+	}
+	if strings.HasPrefix(bookmark, "\x00") {
+		return s.pruneDealerIndex(ctx, pageSize, bookmark)
+	}
+	it, err := ctx.GetStub().GetStateByRange(bookmark, "")
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	page := &IndexRebuildPage{Phase: "accounts"}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if page.Scanned == pageSize {
+			page.Bookmark = kv.Key
+			return page, nil
+		}
+		page.Scanned++
+		var a Account
+		if err := decodeAccount(kv.Value, &a); err != nil {
+			return nil, fmt.Errorf("%s: %w", kv.Key, err)
+		}
+		key, err := ctx.GetStub().CreateCompositeKey(dealerIndex, []string{a.DEALERID, a.MSISDN})
+		if err != nil {
+			return nil, err
+		}
+		b, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			continue
+		}
+		if err := ctx.GetStub().PutState(key, []byte{0}); err != nil {
+			return nil, err
+		}
+		page.Added++
+	}
+	// Every index key sorts after the bare prefix, so the next call starts
+	// the index phase from its first entry.
+	page.Bookmark, err = ctx.GetStub().CreateCompositeKey(dealerIndex, []string{})
+	return page, err
+}
+
+// pruneDealerIndex is RebuildDealerIndex's index phase. Composite keys cannot
+// be range queried from a bookmark in a submit, so each page skips the
+// entries before it.
+func (s *SmartContract) pruneDealerIndex(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*IndexRebuildPage, error) {
+	it, err := ctx.GetStub().GetStateByPartialCompositeKey(dealerIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	page := &IndexRebuildPage{Phase: "index"}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if page.Scanned == pageSize {
+			page.Bookmark = kv.Key
+			return page, nil
+		}
+		page.Scanned++
+		_, attrs, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		acc, err := s.readAccount(ctx, attrs[1])
+		if err != nil {
+			return nil, err
+		}
+		if acc != nil && acc.DEALERID == attrs[0] {
+			continue
+		}
+		if err := ctx.GetStub().DelState(kv.Key); err != nil {
+			return nil, err
+		}
+		page.Removed++
+	}
+	return page, nil
+}
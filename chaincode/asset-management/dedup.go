@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	dedupPrefix = "dedup"
+
+	// operationIDName is the transient field carrying the client-generated
+	// operation ID of a write.
+	operationIDName = "operationId"
+
+	// dedupWindow is how long, in seconds, an operation ID blocks repeats.
+	dedupWindow = 24 * 60 * 60
+)
+
+var ErrDuplicateOperation = errors.New("duplicate operation")
+
+type operationRecord struct {
+	TxID      string `json:"txId"`
+	MSISDN    string `json:"MSISDN"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// claimOperation records the caller's operation ID for this transaction and
+// rejects it if the same ID was already used within dedupWindow. Writes
+// without an operation ID are not checked.
+func claimOperation(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	t, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return err
+	}
+	opID := string(t[operationIDName])
+	if opID == "" {
+		return nil
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(dedupPrefix, []string{opID})
+	if err != nil {
+		return err
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		var prev operationRecord
+		if err := json.Unmarshal(b, &prev); err != nil {
+			return err
+		}
+		if now-prev.Timestamp < dedupWindow {
+			return fmt.Errorf("%w %s: already applied in %s", ErrDuplicateOperation, opID, prev.TxID)
+		}
+	}
+	raw, err := canonical.Marshal(&operationRecord{TxID: ctx.GetStub().GetTxID(), MSISDN: msisdn, Timestamp: now})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, raw)
+}
+
+// PruneOperations deletes up to limit operation IDs older than the dedup
+// window and returns how many it removed. Expired IDs are already ignored,
+// so this only reclaims state.
+func (s *SmartContract) PruneOperations(ctx contractapi.TransactionContextInterface, limit int) (int, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+	if limit < 1 {
+		return 0, errors.New("limit must be positive")
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return 0, err
+	}
+	it, err := ctx.GetStub().GetStateByPartialCompositeKey(dedupPrefix, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	pruned := 0
+	for it.HasNext() && pruned < limit {
+		kv, err := it.Next()
+		if err != nil {
+			return pruned, err
+		}
+		var rec operationRecord
+		if err := json.Unmarshal(kv.Value, &rec); err != nil {
+			return pruned, err
+		}
+		if now-rec.Timestamp < dedupWindow {
+			continue
+		}
+		if err := ctx.GetStub().DelState(kv.Key); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
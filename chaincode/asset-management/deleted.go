@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// deletedPrefix keys tombstones as deleted~msisdn~txid, so an MSISDN that is
+// reused and deleted again keeps every removal.
+const deletedPrefix = "deleted"
+
+// Tombstone records an account's removal with its last state. Unlike the
+// account's other records it is never deleted.
+type Tombstone struct {
+	MSISDN    string   `json:"MSISDN"`
+	DEALERID  string   `json:"DEALERID"`
+	Account   *Account `json:"account"`
+	DeletedAt int64    `json:"deletedAt"`
+	DeletedBy string   `json:"deletedBy"`
+	TxID      string   `json:"txId"`
+	Function  string   `json:"function"`
+	Origin    *Origin  `json:"origin,omitempty"`
+	// MergedInto is the account this one was merged into, if it was.
+	MergedInto string `json:"mergedInto,omitempty"`
+}
+
+type TombstonePage struct {
+	Records  []*Tombstone `json:"records"`
+	Bookmark string       `json:"bookmark"`
+	Fetched  int32        `json:"fetchedCount"`
+}
+
+// putTombstone records that acc, as stored, is being deleted by this
+// transaction, and by which request. The MPIN is left out.
+func putTombstone(ctx contractapi.TransactionContextInterface, acc *Account, mergedInto string) error {
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return err
+	}
+	origin, err := recordOrigin(ctx)
+	if err != nil {
+		return err
+	}
+	last := *acc
+	last.MPIN = ""
+	t := &Tombstone{MSISDN: acc.MSISDN, DEALERID: acc.DEALERID, Account: &last, DeletedAt: now, DeletedBy: clientID(ctx), TxID: ctx.GetStub().GetTxID(), Function: functionName(ctx), Origin: origin, MergedInto: mergedInto}
+	b, err := canonical.Marshal(t)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(deletedPrefix, []string{acc.MSISDN, t.TxID})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, b)
+}
+
+// GetDeletedAssets pages through the tombstones of deleted accounts, in
+// MSISDN order, or only msisdn's when it is given. Dealer identities only
+// get their own dealer's, so their pages may come back short. Accounts
+// deleted before tombstones were kept are not listed; their removal is only
+// in the key history. Paginated queries only work in evaluations.
+func (s *SmartContract) GetDeletedAssets(ctx contractapi.TransactionContextInterface, msisdn string, pageSize int, bookmark string) (*TombstonePage, error) {
+	if pageSize < 1 {
+		return nil, errors.New("pageSize must be positive")
+	}
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []string{}
+	if msisdn != "" {
+		attrs = []string{msisdn}
+	}
+	it, meta, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(deletedPrefix, attrs, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	page := &TombstonePage{Records: []*Tombstone{}, Bookmark: meta.GetBookmark(), Fetched: meta.GetFetchedRecordsCount()}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		var t Tombstone
+		if err := json.Unmarshal(kv.Value, &t); err != nil {
+			return nil, err
+		}
+		if own != "" && own != t.DEALERID {
+			continue
+		}
+		if t.Account != nil {
+			if err := readableAccount(key, t.Account); err != nil {
+				return nil, err
+			}
+		}
+		page.Records = append(page.Records, &t)
+	}
+	return page, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	"asset-management/fieldcrypt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// encryptionKeyName is the transient field carrying the AES key. Transient
+// data is never written to the ledger.
+const encryptionKeyName = "encryptionKey"
+
+func encryptionKey(ctx contractapi.TransactionContextInterface) ([]byte, error) {
+	t, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	return t[encryptionKeyName], nil
+}
+
+func encryptField(key []byte, txID, name string, v *string) error {
+	if *v == "" || fieldcrypt.IsEncrypted(*v) {
+		return nil
+	}
+	enc, err := fieldcrypt.Encrypt(key, txID, name, *v)
+	if err != nil {
+		return fmt.Errorf("encrypt %s: %w", name, err)
+	}
+	*v = enc
+	return nil
+}
+
+// checkPlain rejects a caller-supplied value that already carries the
+// encryption prefix: encryptField would store it as it is, and every read
+// would then fail to decrypt it.
+func checkPlain(name, v string) error {
+	if fieldcrypt.IsEncrypted(v) {
+		return fmt.Errorf("%s may not start with the encryption prefix", name)
+	}
+	return nil
+}
+
+func decryptField(key []byte, name string, v *string) error {
+	if !fieldcrypt.IsEncrypted(*v) {
+		return nil
+	}
+	plain, err := fieldcrypt.Decrypt(key, name, *v)
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %w", name, err)
+	}
+	*v = plain
+	return nil
+}
+
+// encryptAccount encrypts MPIN and REMARKS when the caller supplied a key and
+// leaves acc untouched otherwise.
+func encryptAccount(ctx contractapi.TransactionContextInterface, acc *Account) error {
+	return encryptAccountAs(ctx, acc, ctx.GetStub().GetTxID())
+}
+
+// encryptAccountAs binds the nonces to nonceID instead of the transaction ID.
+// A transaction encrypting several values of one field must give each a
+// different nonceID, or they would share a nonce.
+func encryptAccountAs(ctx contractapi.TransactionContextInterface, acc *Account, nonceID string) error {
+	key, err := encryptionKey(ctx)
+	if err != nil || key == nil {
+		return err
+	}
+	if err := encryptField(key, nonceID, "MPIN", &acc.MPIN); err != nil {
+		return err
+	}
+	return encryptField(key, nonceID, "REMARKS", &acc.REMARKS)
+}
+
+// readableAccount prepares acc to be returned to a caller: the MPIN is
+// dropped, since PINs are only ever checked inside the chaincode, and the
+// other encrypted fields are decrypted when a key is supplied.
+func readableAccount(key []byte, acc *Account) error {
+	if acc == nil {
+		return nil
+	}
+	acc.MPIN = ""
+	return decryptAccount(key, acc)
+}
+
+// decryptAccount reveals encrypted fields only when a key is supplied;
+// without one the ciphertext is returned as stored.
+func decryptAccount(key []byte, acc *Account) error {
+	if key == nil || acc == nil {
+		return nil
+	}
+	if err := decryptField(key, "MPIN", &acc.MPIN); err != nil {
+		return err
+	}
+	return decryptField(key, "REMARKS", &acc.REMARKS)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	EventAssetCreated = "AssetCreated"
+	EventAssetUpdated = "AssetUpdated"
+	EventAssetDeleted = "AssetDeleted"
+)
+
+// emitEvent publishes acc as the transaction's chaincode event. Events are
+// readable by every channel member, so the MPIN is never included.
+func emitEvent(ctx contractapi.TransactionContextInterface, name string, acc *Account) error {
+	ev := *acc
+	ev.MPIN = ""
+	raw, err := canonical.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent(name, raw)
+}
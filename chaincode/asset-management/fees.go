@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	FeeFlat       = "flat"
+	FeePercentage = "percentage"
+	FeeTiered     = "tiered"
+
+	FeeOpTransfer = "transfer"
+	FeeOpDebit    = "debit"
+
+	EventAssetsPosted = "AssetsPosted"
+)
+
+// FeeTier charges Fee plus BasisPoints of the amount for amounts up to and
+// including UpTo. Only the last tier may leave UpTo at 0, meaning no limit.
+type FeeTier struct {
+	UpTo        int64 `json:"upTo"`
+	Fee         int64 `json:"fee"`
+	BasisPoints int64 `json:"basisPoints"`
+}
+
+// FeeRule prices one operation: a flat Fee, BasisPoints of the amount
+// (100 = 1%), or the first tier taking the amount in. Min and Max, when set,
+// bound percentage and tiered fees.
+type FeeRule struct {
+	Type        string    `json:"type"`
+	Fee         int64     `json:"fee,omitempty"`
+	BasisPoints int64     `json:"basisPoints,omitempty"`
+	Min         int64     `json:"min,omitempty"`
+	Max         int64     `json:"max,omitempty"`
+	Tiers       []FeeTier `json:"tiers,omitempty"`
+}
+
+// FeeSchedule holds a rule per operation (transfer, debit) and the account
+// every fee is credited to.
+type FeeSchedule struct {
+	FeeAccount string              `json:"feeAccount"`
+	Rules      map[string]*FeeRule `json:"rules"`
+}
+
+type FeeQuote struct {
+	Operation  string `json:"operation"`
+	Amount     int64  `json:"amount"`
+	Fee        int64  `json:"fee"`
+	Total      int64  `json:"total"`
+	FeeAccount string `json:"feeAccount,omitempty"`
+	Receipt    string `json:"receipt,omitempty"`
+	// FX is set for a transfer between currencies; the payee is credited
+	// FX.Converted.
+	FX *FXConversion `json:"fx,omitempty"`
+}
+
+func feeScheduleKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configPrefix, []string{"feeSchedule"})
+}
+
+func (r *FeeRule) validate() error {
+	if r.Fee < 0 || r.BasisPoints < 0 || r.Min < 0 || r.Max < 0 {
+		return errors.New("fees must not be negative")
+	}
+	if r.BasisPoints > 10000 {
+		return errors.New("basisPoints must be at most 10000")
+	}
+	if r.Max > 0 && r.Min > r.Max {
+		return errors.New("min exceeds max")
+	}
+	switch r.Type {
+	case FeeFlat, FeePercentage:
+		if len(r.Tiers) > 0 {
+			return fmt.Errorf("%s rule takes no tiers", r.Type)
+		}
+	case FeeTiered:
+		if len(r.Tiers) == 0 {
+			return errors.New("tiered rule needs tiers")
+		}
+		var prev int64
+		for i, t := range r.Tiers {
+			if t.Fee < 0 || t.BasisPoints < 0 || t.BasisPoints > 10000 {
+				return fmt.Errorf("tier %d: invalid fee", i)
+			}
+			if t.UpTo == 0 && i != len(r.Tiers)-1 {
+				return fmt.Errorf("tier %d: only the last tier may be unbounded", i)
+			}
+			if t.UpTo != 0 && t.UpTo <= prev {
+				return fmt.Errorf("tier %d: upTo must increase", i)
+			}
+			prev = t.UpTo
+		}
+	default:
+		return fmt.Errorf("unknown fee type %q", r.Type)
+	}
+	return nil
+}
+
+// fee prices amount, rounding percentages half up.
+func (r *FeeRule) fee(amount int64) (int64, error) {
+	if amount > math.MaxInt64/10000 {
+		return 0, errors.New("amount too large")
+	}
+	flat, bp := r.Fee, r.BasisPoints
+	switch r.Type {
+	case FeeFlat:
+		return flat, nil
+	case FeeTiered:
+		flat, bp = -1, 0
+		for _, t := range r.Tiers {
+			if t.UpTo == 0 || amount <= t.UpTo {
+				flat, bp = t.Fee, t.BasisPoints
+				break
+			}
+		}
+		if flat < 0 {
+			return 0, fmt.Errorf("no fee tier for amount %d", amount)
+		}
+	case FeePercentage:
+		flat = 0
+	}
+	fee := flat + (amount*bp+5000)/10000
+	if fee < r.Min {
+		fee = r.Min
+	}
+	if r.Max > 0 && fee > r.Max {
+		fee = r.Max
+	}
+	return fee, nil
+}
+
+func feeSchedule(ctx contractapi.TransactionContextInterface) (*FeeSchedule, error) {
+	key, err := feeScheduleKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FeeSchedule{Rules: map[string]*FeeRule{}}
+	if b == nil {
+		return fs, nil
+	}
+	if err := json.Unmarshal(b, fs); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// SetFeeSchedule replaces the fee schedule with the JSON-encoded schedule.
+// A schedule without rules removes all fees.
+func (s *SmartContract) SetFeeSchedule(ctx contractapi.TransactionContextInterface, schedule string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(schedule)))
+	dec.DisallowUnknownFields()
+	var fs FeeSchedule
+	if err := dec.Decode(&fs); err != nil {
+		return fmt.Errorf("invalid fee schedule: %w", err)
+	}
+	key, err := feeScheduleKey(ctx)
+	if err != nil {
+		return err
+	}
+	if len(fs.Rules) == 0 {
+		return ctx.GetStub().DelState(key)
+	}
+	for op, r := range fs.Rules {
+		if op != FeeOpTransfer && op != FeeOpDebit {
+			return fmt.Errorf("unknown operation %q", op)
+		}
+		if r == nil {
+			return fmt.Errorf("%s: rule required", op)
+		}
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	acc, err := s.readAccount(ctx, fs.FeeAccount)
+	if err != nil {
+		return err
+	}
+	if acc == nil {
+		return errors.New("fee account not found")
+	}
+	raw, err := canonical.Marshal(&fs)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, raw)
+}
+
+func (s *SmartContract) GetFeeSchedule(ctx contractapi.TransactionContextInterface) (*FeeSchedule, error) {
+	return feeSchedule(ctx)
+}
+
+func quoteFee(ctx contractapi.TransactionContextInterface, operation string, amount int64) (*FeeQuote, error) {
+	if operation != FeeOpTransfer && operation != FeeOpDebit {
+		return nil, fmt.Errorf("unknown operation %q", operation)
+	}
+	if amount <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	fs, err := feeSchedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := &FeeQuote{Operation: operation, Amount: amount, Total: amount}
+	r := fs.Rules[operation]
+	if r == nil {
+		return q, nil
+	}
+	if q.Fee, err = r.fee(amount); err != nil {
+		return nil, err
+	}
+	q.Total += q.Fee
+	if q.Fee > 0 {
+		q.FeeAccount = fs.FeeAccount
+	}
+	return q, nil
+}
+
+// QuoteFee previews what Transfer or Debit would charge for amount.
+func (s *SmartContract) QuoteFee(ctx contractapi.TransactionContextInterface, operation, amount string) (*FeeQuote, error) {
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	return quoteFee(ctx, operation, n)
+}
+
+// Transfer moves amount from one account to another and charges the transfer
+// fee to the sender, crediting the fee account, all in one transaction.
+func (s *SmartContract) Transfer(ctx contractapi.TransactionContextInterface, from, to, amount, remarks string) (*FeeQuote, error) {
+	if from == to {
+		return nil, errors.New("cannot transfer to the same account")
+	}
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	q, err := quoteFee(ctx, FeeOpTransfer, n)
+	if err != nil {
+		return nil, err
+	}
+	postings := []posting{
+		{msisdn: from, transType: TransTransferOut, amount: -n, remarks: remarks},
+		{msisdn: to, transType: TransTransferIn, amount: n, remarks: remarks},
+	}
+	if q.FX, err = s.transferFX(ctx, from, to, n); err != nil {
+		return nil, err
+	}
+	if q.FX != nil {
+		postings[1].amount, postings[1].fx = q.FX.Converted, q.FX
+	}
+	if q.Receipt, err = s.applyPostings(ctx, withFee(postings, from, q)); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Debit takes amount and the debit fee from msisdn, crediting the fee
+// account in the same transaction.
+func (s *SmartContract) Debit(ctx contractapi.TransactionContextInterface, msisdn, amount, remarks string) (*FeeQuote, error) {
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	q, err := quoteFee(ctx, FeeOpDebit, n)
+	if err != nil {
+		return nil, err
+	}
+	postings := []posting{{msisdn: msisdn, transType: TransDebit, amount: -n, remarks: remarks}}
+	if q.Receipt, err = s.applyPostings(ctx, withFee(postings, msisdn, q)); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+type posting struct {
+	msisdn    string
+	transType string
+	amount    int64
+	remarks   string
+	// status, when set, becomes the account's STATUS with this posting.
+	status string
+	// fx, when set, converted amount into this account's currency.
+	// Otherwise the account must hold the first posting's currency.
+	fx *FXConversion
+	// hold, when set, is the ref of the hold on this account the posting
+	// captures, so it no longer counts against the available balance.
+	hold string
+}
+
+func withFee(postings []posting, payer string, q *FeeQuote) []posting {
+	if q.Fee == 0 {
+		return postings
+	}
+	return append(postings,
+		posting{msisdn: payer, transType: TransDebit, amount: -q.Fee, remarks: q.Operation + " fee"},
+		posting{msisdn: q.FeeAccount, transType: TransCredit, amount: q.Fee, remarks: q.Operation + " fee from " + payer},
+	)
+}
+
+// applyPostings applies postings in order as one transaction. Each account
+// is written once, showing its last posting, while every posting goes to its
+// recent transactions. Debits may only spend what active holds leave
+// available. A transaction carries a single chaincode event, so one
+// AssetsPosted event names all accounts touched. It returns the
+// transaction's receipt number.
+func (s *SmartContract) applyPostings(ctx contractapi.TransactionContextInterface, postings []posting) (string, error) {
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return "", err
+	}
+	captured := map[string]map[string]bool{}
+	for _, p := range postings {
+		if p.hold != "" {
+			if captured[p.msisdn] == nil {
+				captured[p.msisdn] = map[string]bool{}
+			}
+			captured[p.msisdn][p.hold] = true
+		}
+	}
+	accounts := map[string]*Account{}
+	held := map[string]int64{}
+	history := map[string][]*Account{}
+	var order []string
+	for _, p := range postings {
+		if err := checkPlain("remarks", p.remarks); err != nil {
+			return "", err
+		}
+		acc := accounts[p.msisdn]
+		if acc == nil {
+			var err error
+			if acc, err = s.readAccount(ctx, p.msisdn); err != nil {
+				return "", err
+			}
+			if acc == nil {
+				return "", fmt.Errorf("%s: not found", p.msisdn)
+			}
+			if held[p.msisdn], err = heldAmount(ctx, p.msisdn, now, captured[p.msisdn]); err != nil {
+				return "", err
+			}
+			accounts[p.msisdn] = acc
+			order = append(order, p.msisdn)
+		}
+		if p.fx == nil && acc.CURRENCY != accounts[postings[0].msisdn].CURRENCY {
+			return "", fmt.Errorf("%s: account holds a different currency", p.msisdn)
+		}
+		// A blocked account may still be closed.
+		if frozen(acc.STATUS) && (acc.STATUS == StatusClosed || p.status != StatusClosed) {
+			return "", fmt.Errorf("%s: account is %s", p.msisdn, acc.STATUS)
+		}
+		if acc.BALANCE+p.amount < 0 {
+			return "", fmt.Errorf("%s: insufficient balance", p.msisdn)
+		}
+		if p.amount < 0 && acc.BALANCE+p.amount < held[p.msisdn] {
+			return "", fmt.Errorf("%s: insufficient available balance", p.msisdn)
+		}
+		acc.BALANCE += p.amount
+		acc.TRANSAMOUNT = p.amount
+		acc.TRANSTYPE = p.transType
+		acc.REMARKS = p.remarks
+		if p.status != "" {
+			acc.STATUS = p.status
+		}
+		if err := acc.validate(); err != nil {
+			return "", err
+		}
+		snap := *acc
+		history[p.msisdn] = append(history[p.msisdn], &snap)
+	}
+	if err := claimOperation(ctx, postings[0].msisdn); err != nil {
+		return "", err
+	}
+	var fx *FXConversion
+	for _, p := range postings {
+		if p.fx != nil {
+			fx = p.fx
+		}
+	}
+	receipt, err := assignReceipt(ctx, order, fx)
+	if err != nil {
+		return "", err
+	}
+	txID := ctx.GetStub().GetTxID()
+	for _, m := range order {
+		if err := encryptAccountAs(ctx, accounts[m], txID+"/"+m); err != nil {
+			return "", err
+		}
+		if err := s.writeAccount(ctx, accounts[m]); err != nil {
+			return "", err
+		}
+		for i, a := range history[m] {
+			if err := encryptAccountAs(ctx, a, txID+"/"+m+"/"+strconv.Itoa(i)); err != nil {
+				return "", err
+			}
+		}
+		if err := s.recordTransactions(ctx, m, history[m], receipt); err != nil {
+			return "", err
+		}
+	}
+	raw, err := canonical.Marshal(struct {
+		MSISDNs []string `json:"MSISDNs"`
+	}{order})
+	if err != nil {
+		return "", err
+	}
+	return receipt, ctx.GetStub().SetEvent(EventAssetsPosted, raw)
+}
@@ -0,0 +1,68 @@
+// Package fieldcrypt encrypts individual state fields with AES-GCM.
+//
+// Every endorsing peer must produce the same ciphertext, so the nonce is not
+// random: it is derived from the key, the transaction ID and the field name,
+// which is unique for each field written by a transaction.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const prefix = "enc:v1:"
+
+var ErrNotEncrypted = errors.New("fieldcrypt: value is not encrypted")
+
+func IsEncrypted(v string) bool {
+	return strings.HasPrefix(v, prefix)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt seals plaintext under key. txID and field bind the nonce to this
+// write; only field is authenticated as additional data.
+func Encrypt(key []byte, txID, field, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(txID + "\x00" + field))
+	nonce := mac.Sum(nil)[:gcm.NonceSize()]
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(field))
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func Decrypt(key []byte, field, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", ErrNotEncrypted
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return "", errors.New("fieldcrypt: ciphertext too short")
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const defaultRatesFunction = "GetRate"
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// FXConfig is the on-chain configuration of cross-currency transfers.
+// Accounts without a CURRENCY hold BaseCurrency. RatesChaincode, on the same
+// channel, is called as RatesFunction(from, to) and must return the JSON
+// {"rate": "<decimal>", "source": "<name>"}, the amount of to one unit of
+// from buys. Without RatesChaincode, transfers between currencies fail.
+type FXConfig struct {
+	BaseCurrency   string `json:"baseCurrency"`
+	RatesChaincode string `json:"ratesChaincode,omitempty"`
+	RatesFunction  string `json:"ratesFunction,omitempty"`
+}
+
+// FXConversion is the rate a cross-currency transfer used, kept on its
+// receipt. Amount is in From and Converted, rounded half up, in To.
+type FXConversion struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Rate      string `json:"rate"`
+	Source    string `json:"source"`
+	Chaincode string `json:"chaincode"`
+	Amount    int64  `json:"amount"`
+	Converted int64  `json:"converted"`
+}
+
+type rateAnswer struct {
+	Rate   string `json:"rate"`
+	Source string `json:"source"`
+}
+
+func fxConfigKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configPrefix, []string{"fx"})
+}
+
+// fxConfig returns the stored configuration, empty when there is none.
+func fxConfig(ctx contractapi.TransactionContextInterface) (*FXConfig, error) {
+	key, err := fxConfigKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &FXConfig{}
+	if b == nil {
+		return cfg, nil
+	}
+	return cfg, json.Unmarshal(b, cfg)
+}
+
+// SetFXConfig replaces the FX configuration with the JSON-encoded config.
+// The base currency cannot change once set: accounts without a CURRENCY
+// would change currency with it. Admin identities only.
+func (s *SmartContract) SetFXConfig(ctx contractapi.TransactionContextInterface, config string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(config)))
+	dec.DisallowUnknownFields()
+	var cfg FXConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return fmt.Errorf("invalid FX config: %w", err)
+	}
+	if !currencyCode.MatchString(cfg.BaseCurrency) {
+		return errors.New("baseCurrency must be an ISO 4217 code such as USD")
+	}
+	if cfg.RatesChaincode == "" && cfg.RatesFunction != "" {
+		return errors.New("ratesFunction needs ratesChaincode")
+	}
+	if cfg.RatesChaincode != "" && cfg.RatesFunction == "" {
+		cfg.RatesFunction = defaultRatesFunction
+	}
+	old, err := fxConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if old.BaseCurrency != "" && old.BaseCurrency != cfg.BaseCurrency {
+		return fmt.Errorf("baseCurrency is %s and cannot change", old.BaseCurrency)
+	}
+	raw, err := canonical.Marshal(&cfg)
+	if err != nil {
+		return err
+	}
+	key, err := fxConfigKey(ctx)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, raw)
+}
+
+func (s *SmartContract) GetFXConfig(ctx contractapi.TransactionContextInterface) (*FXConfig, error) {
+	return fxConfig(ctx)
+}
+
+// SetAccountCurrency sets the currency msisdn's balance is held in. The
+// balance is not converted, so the account must be empty, and sub-accounts
+// take their parent's currency, so it must have neither parent nor
+// sub-accounts. Setting the base currency clears CURRENCY. Admin identities
+// only.
+func (s *SmartContract) SetAccountCurrency(ctx contractapi.TransactionContextInterface, msisdn, currency string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if !currencyCode.MatchString(currency) {
+		return errors.New("currency must be an ISO 4217 code such as USD")
+	}
+	cfg, err := fxConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if cfg.BaseCurrency == "" {
+		return errors.New("set the FX config's baseCurrency first")
+	}
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if acc == nil {
+		return errors.New("not found")
+	}
+	if acc.STATUS == StatusClosed {
+		return errors.New("account is CLOSED")
+	}
+	if acc.BALANCE != 0 {
+		return errors.New("balance must be 0 to change currency")
+	}
+	if acc.PARENT != "" {
+		return errors.New("sub-accounts take their parent's currency")
+	}
+	children, err := s.childMSISDNs(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if len(children) > 0 {
+		return fmt.Errorf("%s has sub-accounts", msisdn)
+	}
+	if currency == cfg.BaseCurrency {
+		currency = ""
+	}
+	acc.CURRENCY = currency
+	return s.putAccount(ctx, acc, EventAssetUpdated)
+}
+
+// transferFX prices a transfer of amount from one account to another when
+// their currencies differ, and returns nil when they do not. Missing
+// accounts are left for applyPostings to report.
+func (s *SmartContract) transferFX(ctx contractapi.TransactionContextInterface, from, to string, amount int64) (*FXConversion, error) {
+	src, err := s.readAccount(ctx, from)
+	if err != nil || src == nil {
+		return nil, err
+	}
+	dst, err := s.readAccount(ctx, to)
+	if err != nil || dst == nil {
+		return nil, err
+	}
+	if src.CURRENCY == dst.CURRENCY {
+		return nil, nil
+	}
+	cfg, err := fxConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RatesChaincode == "" {
+		return nil, errors.New("transfers between currencies need ratesChaincode in the FX config")
+	}
+	fx := &FXConversion{From: cfg.currency(src), To: cfg.currency(dst), Chaincode: cfg.RatesChaincode, Amount: amount}
+	res := ctx.GetStub().InvokeChaincode(cfg.RatesChaincode, [][]byte{[]byte(cfg.RatesFunction), []byte(fx.From), []byte(fx.To)}, "")
+	if res.Status != 200 {
+		return nil, fmt.Errorf("rates chaincode %s: %s", cfg.RatesChaincode, res.Message)
+	}
+	var ans rateAnswer
+	if err := json.Unmarshal(res.Payload, &ans); err != nil {
+		return nil, fmt.Errorf("rates chaincode %s: %w", cfg.RatesChaincode, err)
+	}
+	rate, ok := new(big.Rat).SetString(ans.Rate)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("rates chaincode %s: invalid rate %q for %s/%s", cfg.RatesChaincode, ans.Rate, fx.From, fx.To)
+	}
+	v := new(big.Rat).Mul(rate, new(big.Rat).SetInt64(amount))
+	// Half up: floor((2n + d) / 2d) for positive n/d.
+	n := new(big.Int).Lsh(v.Num(), 1)
+	n.Add(n, v.Denom())
+	n.Quo(n, new(big.Int).Lsh(v.Denom(), 1))
+	if !n.IsInt64() || n.Sign() <= 0 {
+		return nil, fmt.Errorf("%d %s converts to an amount out of range", amount, fx.From)
+	}
+	fx.Rate, fx.Source, fx.Converted = ans.Rate, ans.Source, n.Int64()
+	return fx, nil
+}
+
+// currency is the currency acc's balance is held in.
+func (cfg *FXConfig) currency(acc *Account) string {
+	if acc.CURRENCY != "" {
+		return acc.CURRENCY
+	}
+	return cfg.BaseCurrency
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	StatusActive  = "ACTIVE"
+	StatusBlocked = "BLOCKED"
+	StatusClosed  = "CLOSED"
+
+	childIndex = "parent~child"
+)
+
+// Rollup is a primary account together with its sub-accounts.
+type Rollup struct {
+	MSISDN             string     `json:"MSISDN"`
+	BALANCE            int64      `json:"BALANCE"`
+	SubAccounts        []*Account `json:"subAccounts"`
+	SubAccountsBalance int64      `json:"subAccountsBalance"`
+	TotalBalance       int64      `json:"totalBalance"`
+}
+
+func frozen(status string) bool {
+	return status == StatusBlocked || status == StatusClosed
+}
+
+func (s *SmartContract) CreateSubAccount(ctx contractapi.TransactionContextInterface, parentMsisdn, msisdn, mpin, balance, status, transAmount, transType, remarks string) error {
+	parent, err := s.readAccount(ctx, parentMsisdn)
+	if err != nil {
+		return err
+	}
+	if parent == nil {
+		return errors.New("parent not found")
+	}
+	if parent.PARENT != "" {
+		return errors.New("sub-accounts cannot have sub-accounts")
+	}
+	if frozen(parent.STATUS) {
+		return fmt.Errorf("parent is %s", parent.STATUS)
+	}
+	ok, err := s.exists(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return errors.New("asset exists")
+	}
+	acc, err := parseAccount(parent.DEALERID, msisdn, mpin, balance, status, transAmount, transType, remarks)
+	if err != nil {
+		return err
+	}
+	acc.PARENT = parentMsisdn
+	acc.CURRENCY = parent.CURRENCY
+	if acc.CreatedAt, err = txSeconds(ctx); err != nil {
+		return err
+	}
+	if err := countDealerAccounts(ctx, acc.DEALERID, 1); err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(childIndex, []string{parentMsisdn, msisdn})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, []byte{0}); err != nil {
+		return err
+	}
+	return s.putAccount(ctx, acc, EventAssetCreated)
+}
+
+func (s *SmartContract) childMSISDNs(ctx contractapi.TransactionContextInterface, parent string) ([]string, error) {
+	it, err := ctx.GetStub().GetStateByPartialCompositeKey(childIndex, []string{parent})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var out []string
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, parts, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, parts[1])
+	}
+	return out, nil
+}
+
+func (s *SmartContract) unlinkChild(ctx contractapi.TransactionContextInterface, acc *Account) error {
+	if acc.PARENT == "" {
+		return nil
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(childIndex, []string{acc.PARENT, acc.MSISDN})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// checkParentStatus stops a sub-account from being reactivated while its
+// parent is blocked or closed.
+func (s *SmartContract) checkParentStatus(ctx contractapi.TransactionContextInterface, acc *Account) error {
+	if acc.PARENT == "" || frozen(acc.STATUS) {
+		return nil
+	}
+	parent, err := s.readAccount(ctx, acc.PARENT)
+	if err != nil {
+		return err
+	}
+	if parent != nil && frozen(parent.STATUS) {
+		return fmt.Errorf("parent %s is %s", parent.MSISDN, parent.STATUS)
+	}
+	return nil
+}
+
+// cascadeStatus applies a parent's BLOCKED or CLOSED status to its
+// sub-accounts. Closed sub-accounts stay closed.
+func (s *SmartContract) cascadeStatus(ctx contractapi.TransactionContextInterface, parent *Account) error {
+	children, err := s.childMSISDNs(ctx, parent.MSISDN)
+	if err != nil {
+		return err
+	}
+	for _, m := range children {
+		child, err := s.readAccount(ctx, m)
+		if err != nil {
+			return err
+		}
+		if child == nil || child.STATUS == parent.STATUS || child.STATUS == StatusClosed {
+			continue
+		}
+		child.STATUS = parent.STATUS
+		child.TRANSTYPE = ""
+		child.TRANSAMOUNT = 0
+		child.REMARKS = "status cascaded from " + parent.MSISDN
+		if err := s.writeAccount(ctx, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SmartContract) GetSubAccounts(ctx contractapi.TransactionContextInterface, msisdn string) (*Rollup, error) {
+	parent, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, errors.New("not found")
+	}
+	if err := s.checkDealerAccess(ctx, msisdn); err != nil {
+		return nil, err
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	children, err := s.childMSISDNs(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rollup{MSISDN: msisdn, BALANCE: parent.BALANCE, SubAccounts: []*Account{}}
+	for _, m := range children {
+		child, err := s.readAccount(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			continue
+		}
+		if err := readableAccount(key, child); err != nil {
+			return nil, err
+		}
+		r.SubAccounts = append(r.SubAccounts, child)
+		r.SubAccountsBalance += child.BALANCE
+	}
+	r.TotalBalance = r.BALANCE + r.SubAccountsBalance
+	return r, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	// holdPrefix keys holds as hold~msisdn~ref.
+	holdPrefix = "hold"
+
+	// maxHoldSeconds is how far past the transaction timestamp a hold may
+	// expire.
+	maxHoldSeconds = 30 * 24 * 60 * 60
+)
+
+// Hold reserves Amount of an account's balance under the caller's reference
+// until it is captured, released or expires. Held funds stay in BALANCE but
+// no posting may spend them. A hold expires once a transaction timestamp
+// reaches ExpiresAt; it is then ignored, and removed by the account's next
+// hold or by ReleaseHold.
+type Hold struct {
+	MSISDN    string `json:"MSISDN"`
+	Ref       string `json:"ref"`
+	Amount    int64  `json:"amount"`
+	CreatedAt int64  `json:"createdAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+	CreatedBy string `json:"createdBy"`
+	TxID      string `json:"txId"`
+}
+
+// Holds is an account's active holds, oldest first, and the balance left to
+// spend once they are taken out.
+type Holds struct {
+	MSISDN    string  `json:"MSISDN"`
+	Balance   int64   `json:"balance"`
+	Held      int64   `json:"held"`
+	Available int64   `json:"available"`
+	Holds     []*Hold `json:"holds"`
+}
+
+func holdKey(ctx contractapi.TransactionContextInterface, msisdn, ref string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(holdPrefix, []string{msisdn, ref})
+}
+
+func readHold(ctx contractapi.TransactionContextInterface, msisdn, ref string) (*Hold, error) {
+	key, err := holdKey(ctx, msisdn, ref)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	var h Hold
+	return &h, json.Unmarshal(b, &h)
+}
+
+// accountHolds returns all of msisdn's holds, expired ones included.
+func accountHolds(ctx contractapi.TransactionContextInterface, msisdn string) ([]*Hold, error) {
+	it, err := ctx.GetStub().GetStateByPartialCompositeKey(holdPrefix, []string{msisdn})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var out []*Hold
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		var h Hold
+		if err := json.Unmarshal(kv.Value, &h); err != nil {
+			return nil, err
+		}
+		out = append(out, &h)
+	}
+	return out, nil
+}
+
+// heldAmount sums msisdn's holds that have not expired at now, leaving out
+// the refs in except: holds a posting is capturing.
+func heldAmount(ctx contractapi.TransactionContextInterface, msisdn string, now int64, except map[string]bool) (int64, error) {
+	holds, err := accountHolds(ctx, msisdn)
+	if err != nil {
+		return 0, err
+	}
+	var held int64
+	for _, h := range holds {
+		if h.ExpiresAt > now && !except[h.Ref] {
+			held += h.Amount
+		}
+	}
+	return held, nil
+}
+
+func deleteHolds(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	holds, err := accountHolds(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	for _, h := range holds {
+		if err := deleteHold(ctx, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteHold(ctx contractapi.TransactionContextInterface, h *Hold) error {
+	key, err := holdKey(ctx, h.MSISDN, h.Ref)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// HoldAmount reserves amount of msisdn's available balance under ref until
+// expiresAt, in Unix seconds. The ref must not name another active hold on
+// the account; expired holds are cleared first.
+func (s *SmartContract) HoldAmount(ctx contractapi.TransactionContextInterface, msisdn, amount, ref, expiresAt string) (*Hold, error) {
+	if ref == "" {
+		return nil, errors.New("ref is required")
+	}
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	expiry, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry %q", expiresAt)
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if expiry <= now || expiry-now > maxHoldSeconds {
+		return nil, fmt.Errorf("expiry must be within %d seconds of the transaction", maxHoldSeconds)
+	}
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, errors.New("not found")
+	}
+	if frozen(acc.STATUS) {
+		return nil, fmt.Errorf("account is %s", acc.STATUS)
+	}
+	holds, err := accountHolds(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	var held int64
+	for _, h := range holds {
+		if h.ExpiresAt > now {
+			if h.Ref == ref {
+				return nil, fmt.Errorf("hold %s already exists", ref)
+			}
+			held += h.Amount
+			continue
+		}
+		if err := deleteHold(ctx, h); err != nil {
+			return nil, err
+		}
+	}
+	if acc.BALANCE-held < n {
+		return nil, errors.New("insufficient available balance")
+	}
+	if err := claimOperation(ctx, msisdn); err != nil {
+		return nil, err
+	}
+	h := &Hold{MSISDN: msisdn, Ref: ref, Amount: n, CreatedAt: now, ExpiresAt: expiry, CreatedBy: clientID(ctx), TxID: ctx.GetStub().GetTxID()}
+	raw, err := canonical.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	key, err := holdKey(ctx, msisdn, ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(key, raw); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ReleaseHold gives the funds held under ref back to msisdn's available
+// balance. Releasing an expired hold only removes it.
+func (s *SmartContract) ReleaseHold(ctx contractapi.TransactionContextInterface, msisdn, ref string) (*Hold, error) {
+	h, err := readHold(ctx, msisdn, ref)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, errors.New("not found")
+	}
+	if err := claimOperation(ctx, msisdn); err != nil {
+		return nil, err
+	}
+	return h, deleteHold(ctx, h)
+}
+
+// CaptureHold debits amount, at most the held amount, from msisdn and
+// releases the rest of the hold, charging the debit fee like Debit. An empty
+// amount captures the whole hold. Expired holds cannot be captured.
+func (s *SmartContract) CaptureHold(ctx contractapi.TransactionContextInterface, msisdn, ref, amount, remarks string) (*FeeQuote, error) {
+	h, err := readHold(ctx, msisdn, ref)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, errors.New("not found")
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if h.ExpiresAt <= now {
+		return nil, fmt.Errorf("hold %s expired", ref)
+	}
+	n := h.Amount
+	if amount != "" {
+		if n, err = strconv.ParseInt(amount, 10, 64); err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid amount %q", amount)
+		}
+		if n > h.Amount {
+			return nil, fmt.Errorf("amount exceeds the %d held", h.Amount)
+		}
+	}
+	q, err := quoteFee(ctx, FeeOpDebit, n)
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteHold(ctx, h); err != nil {
+		return nil, err
+	}
+	postings := []posting{{msisdn: msisdn, transType: TransDebit, amount: -n, remarks: remarks, hold: ref}}
+	if q.Receipt, err = s.applyPostings(ctx, withFee(postings, msisdn, q)); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// GetHolds returns msisdn's holds that have not expired, with its held and
+// available balance.
+func (s *SmartContract) GetHolds(ctx contractapi.TransactionContextInterface, msisdn string) (*Holds, error) {
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, errors.New("not found")
+	}
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if own != "" && own != acc.DEALERID {
+		return nil, errors.New("not found")
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	holds, err := accountHolds(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	out := &Holds{MSISDN: msisdn, Balance: acc.BALANCE, Holds: []*Hold{}}
+	for _, h := range holds {
+		if h.ExpiresAt > now {
+			out.Holds = append(out.Holds, h)
+			out.Held += h.Amount
+		}
+	}
+	sort.SliceStable(out.Holds, func(i, j int) bool { return out.Holds[i].CreatedAt < out.Holds[j].CreatedAt })
+	out.Available = out.Balance - out.Held
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const configPrefix = "config"
+
+var ErrMaintenance = errors.New("maintenance mode: writes are frozen")
+
+// readFunctions are the functions that still run while the on-chain
+// maintenance flag is set: reads, dry runs and SetMaintenance itself. Every
+// other function is taken to write and is rejected, so a new write function
+// is frozen without being listed.
+var readFunctions = map[string]bool{
+	"ReadAsset":                  true,
+	"ReadAssets":                 true,
+	"GetAllAssets":               true,
+	"GetAssetsPage":              true,
+	"GetAssetsByDealer":          true,
+	"GetAssetsPageByDealer":      true,
+	"GetAssetsPageByDealerIndex": true,
+	"GetAssetHistory":            true,
+	"GetAssetHistoryPage":        true,
+	"ProveBalanceAtBlock":        true,
+	"GetRecentTransactions":      true,
+	"GetMiniStatement":           true,
+	"GetDailySummaries":          true,
+	"GetSubAccounts":             true,
+	"GetHolds":                   true,
+	"GetClosure":                 true,
+	"GetMergeLineage":            true,
+	"GetDeletedAssets":           true,
+	"GetDashboard":               true,
+	"GetStats":                   true,
+	"GetOrigin":                  true,
+	"GetReceipt":                 true,
+	"GetSequence":                true,
+	"GetPINPolicy":               true,
+	"GetPINStatus":               true,
+	"GetFeeSchedule":             true,
+	"QuoteFee":                   true,
+	"GetFXConfig":                true,
+	"GetDealerQuota":             true,
+	"GetAdminLogAnchor":          true,
+	"GetStateFormat":             true,
+	"GetAccountPayloadSchema":    true,
+	"PlanMerge":                  true,
+	"PlanDealerDeletion":         true,
+	"ValidateState":              true,
+	"Ping":                       true,
+	"GetMaintenance":             true,
+	"SetMaintenance":             true,
+}
+
+func maintenanceKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configPrefix, []string{"maintenance"})
+}
+
+func inMaintenance(ctx contractapi.TransactionContextInterface) (bool, error) {
+	key, err := maintenanceKey(ctx)
+	if err != nil {
+		return false, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, err
+	}
+	return len(b) == 1 && b[0] == 1, nil
+}
+
+func checkMaintenance(ctx contractapi.TransactionContextInterface) error {
+	if readFunctions[functionName(ctx)] {
+		return nil
+	}
+	on, err := inMaintenance(ctx)
+	if err != nil {
+		return err
+	}
+	if on {
+		return ErrMaintenance
+	}
+	return nil
+}
+
+func (s *SmartContract) SetMaintenance(ctx contractapi.TransactionContextInterface, enabled bool) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	key, err := maintenanceKey(ctx)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ctx.GetStub().DelState(key)
+	}
+	return ctx.GetStub().PutState(key, []byte{1})
+}
+
+func (s *SmartContract) GetMaintenance(ctx contractapi.TransactionContextInterface) (bool, error) {
+	return inMaintenance(ctx)
+}
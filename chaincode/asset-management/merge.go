@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	mergePrefix = "merge"
+
+	EventAssetsMerged = "AssetsMerged"
+)
+
+var ErrMergeTokenMismatch = errors.New("confirm token does not match the accounts")
+
+// MergeRecord is one account merged into another.
+type MergeRecord struct {
+	Primary   string `json:"primary"`
+	Secondary string `json:"secondary"`
+	DEALERID  string `json:"DEALERID"`
+	Balance   int64  `json:"balance"`
+	MergedAt  int64  `json:"mergedAt"`
+	MergedBy  string `json:"mergedBy"`
+	TxID      string `json:"txId"`
+}
+
+// MergeLineage lists every account merged into MSISDN, oldest first,
+// including those merged into an account before it was merged in turn.
+type MergeLineage struct {
+	MSISDN string         `json:"MSISDN"`
+	Merges []*MergeRecord `json:"merges"`
+}
+
+// MergePlan is the dry run for MergeAccounts. ConfirmToken covers both
+// accounts' MSISDN, balance and status, so it stops matching as soon as
+// either changes.
+type MergePlan struct {
+	Primary         *DeletionCandidate `json:"primary"`
+	Secondary       *DeletionCandidate `json:"secondary"`
+	ResultBalance   int64              `json:"resultBalance"`
+	InheritedMerges int                `json:"inheritedMerges"`
+	ConfirmToken    string             `json:"confirmToken"`
+}
+
+// assetsMerged is the AssetsMerged event: the primary and then the
+// secondary, and the balance moved.
+type assetsMerged struct {
+	MSISDNs []string `json:"MSISDNs"`
+	Balance int64    `json:"balance"`
+}
+
+func mergeKey(ctx contractapi.TransactionContextInterface, msisdn string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(mergePrefix, []string{msisdn})
+}
+
+func readLineage(ctx contractapi.TransactionContextInterface, msisdn string) (*MergeLineage, error) {
+	key, err := mergeKey(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	l := &MergeLineage{MSISDN: msisdn, Merges: []*MergeRecord{}}
+	if b == nil {
+		return l, nil
+	}
+	return l, json.Unmarshal(b, l)
+}
+
+func mergeToken(primary, secondary *Account) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s:%d:%s\n%s:%d:%s\n", primary.MSISDN, primary.BALANCE, primary.STATUS, secondary.MSISDN, secondary.BALANCE, secondary.STATUS)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// mergeable reads both accounts and checks they can be merged: the primary
+// must take postings, and the secondary must not be blocked or have
+// sub-accounts of its own.
+func (s *SmartContract) mergeable(ctx contractapi.TransactionContextInterface, primary, secondary string) (*Account, *Account, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, nil, err
+	}
+	if primary == "" || secondary == "" {
+		return nil, nil, errors.New("primary and secondary required")
+	}
+	if primary == secondary {
+		return nil, nil, errors.New("cannot merge an account into itself")
+	}
+	p, err := s.readAccount(ctx, primary)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p == nil {
+		return nil, nil, fmt.Errorf("%s: not found", primary)
+	}
+	if frozen(p.STATUS) {
+		return nil, nil, fmt.Errorf("%s: account is %s", primary, p.STATUS)
+	}
+	sec, err := s.readAccount(ctx, secondary)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sec == nil {
+		return nil, nil, fmt.Errorf("%s: not found", secondary)
+	}
+	if sec.STATUS == StatusBlocked {
+		return nil, nil, fmt.Errorf("%s: account is %s", secondary, sec.STATUS)
+	}
+	if sec.CURRENCY != p.CURRENCY {
+		return nil, nil, errors.New("accounts hold different currencies")
+	}
+	children, err := s.childMSISDNs(ctx, secondary)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(children) > 0 {
+		return nil, nil, fmt.Errorf("%s has sub-accounts", secondary)
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	held, err := heldAmount(ctx, secondary, now, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if held > 0 {
+		return nil, nil, fmt.Errorf("%s has funds on hold", secondary)
+	}
+	return p, sec, nil
+}
+
+// PlanMerge is the dry run for MergeAccounts: it changes nothing and returns
+// what the merge would do and the token to confirm it. Admin identities
+// only.
+func (s *SmartContract) PlanMerge(ctx contractapi.TransactionContextInterface, primary, secondary string) (*MergePlan, error) {
+	p, sec, err := s.mergeable(ctx, primary, secondary)
+	if err != nil {
+		return nil, err
+	}
+	lineage, err := readLineage(ctx, secondary)
+	if err != nil {
+		return nil, err
+	}
+	return &MergePlan{
+		Primary:         &DeletionCandidate{MSISDN: p.MSISDN, BALANCE: p.BALANCE, STATUS: p.STATUS, PARENT: p.PARENT},
+		Secondary:       &DeletionCandidate{MSISDN: sec.MSISDN, BALANCE: sec.BALANCE, STATUS: sec.STATUS, PARENT: sec.PARENT},
+		ResultBalance:   p.BALANCE + sec.BALANCE,
+		InheritedMerges: len(lineage.Merges),
+		ConfirmToken:    mergeToken(p, sec),
+	}, nil
+}
+
+// MergeAccounts folds secondary into primary in one transaction: the
+// secondary's balance is posted to the primary, its merge lineage is
+// appended to the primary's with this merge last, and it is removed with
+// its indexes like DeleteAsset, leaving a tombstone that points to the
+// primary. confirm must be the token PlanMerge returned for the accounts as
+// they are now. Admin identities only.
+func (s *SmartContract) MergeAccounts(ctx contractapi.TransactionContextInterface, primary, secondary, confirm string) (*MergeLineage, error) {
+	p, sec, err := s.mergeable(ctx, primary, secondary)
+	if err != nil {
+		return nil, err
+	}
+	if confirm != mergeToken(p, sec) {
+		return nil, ErrMergeTokenMismatch
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.applyPostings(ctx, []posting{{msisdn: primary, transType: TransTransferIn, amount: sec.BALANCE, remarks: "merged from " + secondary}}); err != nil {
+		return nil, err
+	}
+	if err := s.removeAccount(ctx, sec, primary); err != nil {
+		return nil, err
+	}
+	lineage, err := readLineage(ctx, primary)
+	if err != nil {
+		return nil, err
+	}
+	inherited, err := readLineage(ctx, secondary)
+	if err != nil {
+		return nil, err
+	}
+	lineage.Merges = append(lineage.Merges, inherited.Merges...)
+	lineage.Merges = append(lineage.Merges, &MergeRecord{Primary: primary, Secondary: secondary, DEALERID: sec.DEALERID, Balance: sec.BALANCE, MergedAt: now, MergedBy: clientID(ctx), TxID: ctx.GetStub().GetTxID()})
+	b, err := canonical.Marshal(lineage)
+	if err != nil {
+		return nil, err
+	}
+	key, err := mergeKey(ctx, primary)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().PutState(key, b); err != nil {
+		return nil, err
+	}
+	if key, err = mergeKey(ctx, secondary); err != nil {
+		return nil, err
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return nil, err
+	}
+	raw, err := canonical.Marshal(&assetsMerged{MSISDNs: []string{primary, secondary}, Balance: sec.BALANCE})
+	if err != nil {
+		return nil, err
+	}
+	return lineage, ctx.GetStub().SetEvent(EventAssetsMerged, raw)
+}
+
+// GetMergeLineage returns the accounts merged into msisdn; the list is
+// empty if there are none. Dealer identities only get their own accounts'.
+func (s *SmartContract) GetMergeLineage(ctx contractapi.TransactionContextInterface, msisdn string) (*MergeLineage, error) {
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if own != "" {
+		acc, err := s.readAccount(ctx, msisdn)
+		if err != nil {
+			return nil, err
+		}
+		if acc == nil || acc.DEALERID != own {
+			return nil, errors.New("not found")
+		}
+	}
+	return readLineage(ctx, msisdn)
+}
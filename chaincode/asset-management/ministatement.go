@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const maxStatementLines = 10
+
+// statementTypes are the two-letter codes a statement line uses for TRANSTYPE.
+var statementTypes = map[string]string{
+	TransCredit:      "CR",
+	TransDebit:       "DR",
+	TransTransferIn:  "TI",
+	TransTransferOut: "TO",
+	TransAdjustment:  "AJ",
+}
+
+// StatementLine is one balance-affecting transaction. Line is the same data
+// rendered in 32 characters ("DDMMYY TT AMOUNT.... BALANCE..."), which fits a
+// USSD screen or SMS line as is.
+type StatementLine struct {
+	Date    string `json:"date"`
+	Type    string `json:"type"`
+	Amount  int64  `json:"amount"`
+	Balance int64  `json:"balanceAfter"`
+	Line    string `json:"line"`
+}
+
+type MiniStatement struct {
+	MSISDN  string           `json:"MSISDN"`
+	Balance int64            `json:"balance"`
+	Lines   []*StatementLine `json:"lines"`
+}
+
+// GetMiniStatement returns the current balance and the last count
+// transactions that moved it, newest first, taken from the recent
+// transactions ring. Dates are UTC.
+func (s *SmartContract) GetMiniStatement(ctx contractapi.TransactionContextInterface, msisdn string, count int) (*MiniStatement, error) {
+	if count < 1 || count > maxStatementLines {
+		return nil, fmt.Errorf("count must be between 1 and %d", maxStatementLines)
+	}
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if acc == nil {
+		return nil, errors.New("not found")
+	}
+	own, err := callerDealer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if own != "" && own != acc.DEALERID {
+		return nil, errors.New("not found")
+	}
+	ring, err := s.readRecent(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	st := &MiniStatement{MSISDN: msisdn, Balance: acc.BALANCE, Lines: []*StatementLine{}}
+	for _, t := range ring {
+		if len(st.Lines) == count {
+			break
+		}
+		if t.TRANSAMOUNT == 0 {
+			continue
+		}
+		l := &StatementLine{
+			Date:    time.Unix(t.Timestamp, 0).UTC().Format("020106"),
+			Type:    statementTypes[t.TRANSTYPE],
+			Amount:  t.TRANSAMOUNT,
+			Balance: t.BALANCE,
+		}
+		l.Line = fmt.Sprintf("%s %-2s %11d %10d", l.Date, l.Type, l.Amount, l.Balance)
+		st.Lines = append(st.Lines, l)
+	}
+	return st, nil
+}
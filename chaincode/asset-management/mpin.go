@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"asset-management/canonical"
+	"asset-management/fieldcrypt"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const pinStatePrefix = "pin"
+
+// PINPolicy governs ChangeMPIN and VerifyMPIN. History is how many previous
+// PINs may not be reused; MaxFailures wrong PINs in a row lock the account's
+// PIN for LockSeconds.
+type PINPolicy struct {
+	History     int   `json:"history"`
+	MaxFailures int   `json:"maxFailures"`
+	LockSeconds int64 `json:"lockSeconds"`
+}
+
+var defaultPINPolicy = PINPolicy{History: 3, MaxFailures: 3, LockSeconds: 900}
+
+// pinState is kept per account. History holds digests of previous PIN
+// hashes, newest first, never the hashes themselves.
+type pinState struct {
+	Failures    int      `json:"failures"`
+	LockedUntil int64    `json:"lockedUntil,omitempty"`
+	ChangedAt   int64    `json:"changedAt,omitempty"`
+	History     []string `json:"history,omitempty"`
+}
+
+// PINResult is the outcome of a PIN check. A wrong PIN is not an error, so
+// the failure it counts is committed.
+type PINResult struct {
+	MSISDN      string `json:"MSISDN"`
+	Verified    bool   `json:"verified"`
+	Changed     bool   `json:"changed,omitempty"`
+	Failures    int    `json:"failures"`
+	Locked      bool   `json:"locked"`
+	LockedUntil int64  `json:"lockedUntil,omitempty"`
+}
+
+func pinPolicyKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configPrefix, []string{"pinPolicy"})
+}
+
+func pinPolicy(ctx contractapi.TransactionContextInterface) (*PINPolicy, error) {
+	key, err := pinPolicyKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	p := defaultPINPolicy
+	if b == nil {
+		return &p, nil
+	}
+	return &p, json.Unmarshal(b, &p)
+}
+
+// SetPINPolicy replaces the PIN policy with the JSON-encoded policy.
+func (s *SmartContract) SetPINPolicy(ctx contractapi.TransactionContextInterface, policy string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(policy)))
+	dec.DisallowUnknownFields()
+	var p PINPolicy
+	if err := dec.Decode(&p); err != nil {
+		return fmt.Errorf("invalid PIN policy: %w", err)
+	}
+	if p.History < 0 || p.History > 24 {
+		return errors.New("history must be 0-24")
+	}
+	if p.MaxFailures < 1 || p.LockSeconds < 1 {
+		return errors.New("maxFailures and lockSeconds must be positive")
+	}
+	key, err := pinPolicyKey(ctx)
+	if err != nil {
+		return err
+	}
+	raw, err := canonical.Marshal(&p)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, raw)
+}
+
+func (s *SmartContract) GetPINPolicy(ctx contractapi.TransactionContextInterface) (*PINPolicy, error) {
+	return pinPolicy(ctx)
+}
+
+func pinStateKey(ctx contractapi.TransactionContextInterface, msisdn string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(pinStatePrefix, []string{msisdn})
+}
+
+func readPINState(ctx contractapi.TransactionContextInterface, msisdn string) (*pinState, error) {
+	key, err := pinStateKey(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	st := &pinState{}
+	if b == nil {
+		return st, nil
+	}
+	return st, json.Unmarshal(b, st)
+}
+
+func writePINState(ctx contractapi.TransactionContextInterface, msisdn string, st *pinState) error {
+	key, err := pinStateKey(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	raw, err := canonical.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, raw)
+}
+
+func deletePINState(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	key, err := pinStateKey(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// pinDigest binds a PIN hash to its account, so equal PINs on different
+// accounts do not show up as equal history entries.
+func pinDigest(msisdn, pinHash string) string {
+	sum := sha256.Sum256([]byte(msisdn + "\x00" + pinHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPIN compares pinHash with the account's MPIN under the policy and
+// counts the attempt. It returns the account, decrypted, for ChangeMPIN.
+// Dealer-scoped callers only reach their own dealer's accounts.
+func (s *SmartContract) checkPIN(ctx contractapi.TransactionContextInterface, msisdn, pinHash string) (*Account, *pinState, *PINResult, error) {
+	if err := s.checkDealerAccess(ctx, msisdn); err != nil {
+		return nil, nil, nil, err
+	}
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if acc == nil {
+		return nil, nil, nil, errors.New("not found")
+	}
+	if acc.STATUS == StatusClosed {
+		return nil, nil, nil, errors.New("account is CLOSED")
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if fieldcrypt.IsEncrypted(acc.MPIN) && key == nil {
+		return nil, nil, nil, errors.New("MPIN is encrypted: the encryption key is required")
+	}
+	if err := decryptAccount(key, acc); err != nil {
+		return nil, nil, nil, err
+	}
+	policy, err := pinPolicy(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	st, err := readPINState(ctx, msisdn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	res := &PINResult{MSISDN: msisdn}
+	if st.LockedUntil > now {
+		res.Failures, res.Locked, res.LockedUntil = st.Failures, true, st.LockedUntil
+		return acc, st, res, nil
+	}
+	if st.LockedUntil != 0 {
+		// The lock ran out: the account starts over with a clean count.
+		st.Failures, st.LockedUntil = 0, 0
+	}
+	if acc.MPIN != "" && subtle.ConstantTimeCompare([]byte(acc.MPIN), []byte(pinHash)) == 1 {
+		res.Verified = true
+		if st.Failures == 0 {
+			return acc, st, res, nil
+		}
+		st.Failures = 0
+		return acc, st, res, writePINState(ctx, msisdn, st)
+	}
+	st.Failures++
+	if st.Failures >= policy.MaxFailures {
+		st.LockedUntil = now + policy.LockSeconds
+	}
+	res.Failures, res.Locked, res.LockedUntil = st.Failures, st.LockedUntil != 0, st.LockedUntil
+	return acc, st, res, writePINState(ctx, msisdn, st)
+}
+
+// VerifyMPIN checks pinHash against the account's MPIN. Each wrong PIN is
+// counted on chain; after the policy's maxFailures in a row the PIN is locked
+// for lockSeconds, during which even the right PIN is refused.
+func (s *SmartContract) VerifyMPIN(ctx contractapi.TransactionContextInterface, msisdn, pinHash string) (*PINResult, error) {
+	_, _, res, err := s.checkPIN(ctx, msisdn, pinHash)
+	return res, err
+}
+
+// ChangeMPIN replaces the MPIN with newPinHash if oldPinHash is right. A
+// wrong old PIN counts as a failed verify. The new PIN may not be the current
+// one or one of the policy's last history PINs.
+func (s *SmartContract) ChangeMPIN(ctx contractapi.TransactionContextInterface, msisdn, oldPinHash, newPinHash string) (*PINResult, error) {
+	if newPinHash == "" {
+		return nil, errors.New("new PIN required")
+	}
+	if err := checkPlain("new PIN", newPinHash); err != nil {
+		return nil, err
+	}
+	acc, st, res, err := s.checkPIN(ctx, msisdn, oldPinHash)
+	if err != nil || !res.Verified {
+		return res, err
+	}
+	if frozen(acc.STATUS) {
+		return nil, fmt.Errorf("account is %s", acc.STATUS)
+	}
+	policy, err := pinPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	digest := pinDigest(msisdn, newPinHash)
+	if newPinHash == acc.MPIN {
+		return nil, errors.New("new PIN must differ from the current one")
+	}
+	for i, d := range st.History {
+		if i < policy.History && d == digest {
+			return nil, fmt.Errorf("new PIN was used in the last %d changes", policy.History)
+		}
+	}
+	st.History = append([]string{pinDigest(msisdn, acc.MPIN)}, st.History...)
+	if len(st.History) > policy.History {
+		st.History = st.History[:policy.History]
+	}
+	if st.ChangedAt, err = txSeconds(ctx); err != nil {
+		return nil, err
+	}
+	if err := writePINState(ctx, msisdn, st); err != nil {
+		return nil, err
+	}
+	acc.MPIN = newPinHash
+	if err := encryptAccount(ctx, acc); err != nil {
+		return nil, err
+	}
+	if err := s.writeAccount(ctx, acc); err != nil {
+		return nil, err
+	}
+	res.Changed = true
+	return res, emitEvent(ctx, EventAssetUpdated, acc)
+}
+
+// UnlockMPIN clears the failure count and any lock on msisdn's PIN.
+func (s *SmartContract) UnlockMPIN(ctx contractapi.TransactionContextInterface, msisdn string) (*PINResult, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	st, err := readPINState(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	st.Failures, st.LockedUntil = 0, 0
+	return &PINResult{MSISDN: msisdn}, writePINState(ctx, msisdn, st)
+}
+
+// GetPINStatus returns msisdn's failure count and lock, without verifying.
+func (s *SmartContract) GetPINStatus(ctx contractapi.TransactionContextInterface, msisdn string) (*PINResult, error) {
+	if err := s.checkDealerAccess(ctx, msisdn); err != nil {
+		return nil, err
+	}
+	st, err := readPINState(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &PINResult{MSISDN: msisdn, Failures: st.Failures}
+	if st.LockedUntil > now {
+		res.Locked, res.LockedUntil = true, st.LockedUntil
+	}
+	return res, nil
+}
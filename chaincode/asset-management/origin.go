@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	originPrefix = "origin"
+	// requestContextTransient is the transient field the API puts the
+	// request's context in.
+	requestContextTransient = "requestContext"
+)
+
+// Origin says where the request behind a transaction came from. RequestID,
+// AppID and Channel are copied from the API's request context; Fingerprint
+// is the SHA-256 of the whole context as sent, which also covers fields
+// kept off the ledger, such as the client address, so the API's own log of
+// the request can be matched against it.
+type Origin struct {
+	RequestID   string `json:"requestId"`
+	AppID       string `json:"appId,omitempty"`
+	Channel     string `json:"channel,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+func originKey(ctx contractapi.TransactionContextInterface, txID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(originPrefix, []string{txID})
+}
+
+// recordOrigin stores the transaction's request context, if it has one, and
+// returns it. Every account write calls it; the key is the transaction ID,
+// so writing it again in the same transaction changes nothing.
+func recordOrigin(ctx contractapi.TransactionContextInterface) (*Origin, error) {
+	t, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	raw := t[requestContextTransient]
+	if raw == nil {
+		return nil, nil
+	}
+	var o Origin
+	if err := json.Unmarshal(raw, &o); err != nil {
+		return nil, fmt.Errorf("requestContext: %w", err)
+	}
+	if o.RequestID == "" || len(o.RequestID) > 128 || len(o.AppID) > 64 || len(o.Channel) > 32 {
+		return nil, errors.New("requestContext: requestId of 1-128 bytes required, appId at most 64, channel at most 32")
+	}
+	sum := sha256.Sum256(raw)
+	o.Fingerprint = hex.EncodeToString(sum[:])
+	b, err := canonical.Marshal(&o)
+	if err != nil {
+		return nil, err
+	}
+	key, err := originKey(ctx, ctx.GetStub().GetTxID())
+	if err != nil {
+		return nil, err
+	}
+	return &o, ctx.GetStub().PutState(key, b)
+}
+
+func readOrigin(ctx contractapi.TransactionContextInterface, txID string) (*Origin, error) {
+	key, err := originKey(ctx, txID)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	var o Origin
+	return &o, json.Unmarshal(b, &o)
+}
+
+// GetOrigin returns the request context recorded for txID.
+func (s *SmartContract) GetOrigin(ctx contractapi.TransactionContextInterface, txID string) (*Origin, error) {
+	o, err := readOrigin(ctx, txID)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, errors.New("not found")
+	}
+	return o, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// accountPayloadSchema is the JSON Schema CreateAssetJSON and UpdateAssetJSON
+// check their payload against. Only the keywords payloadSchema understands
+// may be used. Rules that depend on more than one field, such as
+// TRANSAMOUNT's sign, are still Account.validate's.
+const accountPayloadSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Account payload",
+  "type": "object",
+  "additionalProperties": false,
+  "required": ["DEALERID", "MSISDN", "MPIN", "BALANCE", "STATUS"],
+  "properties": {
+    "DEALERID": {"type": "string", "minLength": 1, "maxLength": 64},
+    "MSISDN": {"type": "string", "minLength": 1, "maxLength": 64},
+    "MPIN": {"type": "string"},
+    "BALANCE": {"type": "integer"},
+    "STATUS": {"type": "string", "minLength": 1, "maxLength": 32},
+    "TRANSAMOUNT": {"type": "integer"},
+    "TRANSTYPE": {"type": "string", "enum": ["", "CREDIT", "DEBIT", "TRANSFER_IN", "TRANSFER_OUT", "ADJUSTMENT"]},
+    "REMARKS": {"type": "string", "maxLength": 256}
+  }
+}`
+
+// payloadSchema is the subset of JSON Schema the chaincode validates:
+// type, required, properties, additionalProperties, minLength, maxLength
+// and enum.
+type payloadSchema struct {
+	Type                 string                    `json:"type"`
+	Required             []string                  `json:"required"`
+	Properties           map[string]*payloadSchema `json:"properties"`
+	AdditionalProperties *bool                     `json:"additionalProperties"`
+	MinLength            *int                      `json:"minLength"`
+	MaxLength            *int                      `json:"maxLength"`
+	Enum                 []string                  `json:"enum"`
+}
+
+var accountSchema = mustPayloadSchema(accountPayloadSchema)
+
+func mustPayloadSchema(doc string) *payloadSchema {
+	var s payloadSchema
+	if err := json.Unmarshal([]byte(doc), &s); err != nil {
+		panic(err)
+	}
+	return &s
+}
+
+// check reports the first way v, decoded with UseNumber, breaks the schema.
+// path names the offending field as $.FIELD.
+func (s *payloadSchema) check(path string, v any) error {
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s: required", path, name)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s: unknown field", path, name)
+				}
+				continue
+			}
+			if err := prop.check(path+"."+name, obj[name]); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", path)
+		}
+		n := utf8.RuneCountInString(str)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: exceeds %d characters", path, *s.MaxLength)
+		}
+		if s.Enum != nil {
+			found := false
+			for _, e := range s.Enum {
+				found = found || e == str
+			}
+			if !found {
+				return fmt.Errorf("%s: must be one of %s", path, strings.Join(s.Enum, ", "))
+			}
+		}
+	case "integer":
+		num, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: must be an integer", path)
+		}
+		if _, err := num.Int64(); err != nil {
+			return fmt.Errorf("%s: must be a 64-bit integer", path)
+		}
+	}
+	return nil
+}
+
+// parseAccountPayload decodes a CreateAssetJSON or UpdateAssetJSON payload
+// after checking it against accountPayloadSchema.
+func parseAccountPayload(payload string) (*Account, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(payload)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	if dec.More() {
+		return nil, errors.New("payload: trailing data after the object")
+	}
+	if err := accountSchema.check("$", v); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	var acc Account
+	if err := json.Unmarshal([]byte(payload), &acc); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	if err := checkPlain("MPIN", acc.MPIN); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	if err := checkPlain("REMARKS", acc.REMARKS); err != nil {
+		return nil, fmt.Errorf("payload: %w", err)
+	}
+	return &acc, nil
+}
+
+// CreateAssetJSON is CreateAsset with the account as one JSON object, e.g.
+// {"DEALERID": "D123", "MSISDN": "9000000001", "MPIN": "1234", "BALANCE": 0,
+// "STATUS": "ACTIVE"}, checked against GetAccountPayloadSchema.
+func (s *SmartContract) CreateAssetJSON(ctx contractapi.TransactionContextInterface, payload string) error {
+	acc, err := parseAccountPayload(payload)
+	if err != nil {
+		return err
+	}
+	return s.createAccount(ctx, acc)
+}
+
+// UpdateAssetJSON is UpdateAsset with the account as one JSON object, as for
+// CreateAssetJSON. MSISDN names the account to replace; MPIN is ignored, as
+// for UpdateAsset.
+func (s *SmartContract) UpdateAssetJSON(ctx contractapi.TransactionContextInterface, payload string) error {
+	acc, err := parseAccountPayload(payload)
+	if err != nil {
+		return err
+	}
+	return s.updateAccount(ctx, acc)
+}
+
+// GetAccountPayloadSchema returns the JSON Schema of CreateAssetJSON's and
+// UpdateAssetJSON's payload.
+func (s *SmartContract) GetAccountPayloadSchema(ctx contractapi.TransactionContextInterface) (string, error) {
+	return accountPayloadSchema, nil
+}
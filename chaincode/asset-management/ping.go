@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// version is the chaincode build, set with -ldflags "-X main.version=...".
+var version = "dev"
+
+// HeightMarker is the last channel height recorded with MarkHeight. A
+// chaincode cannot read the block height, so Ping reports the marker: a
+// lower bound on the height, as of MarkedAt.
+type HeightMarker struct {
+	Height   uint64 `json:"height"`
+	MarkedAt int64  `json:"markedAt"`
+}
+
+// PingResult answers a health check end to end: that the peer reached this
+// chaincode, which build answered, and its clock.
+type PingResult struct {
+	Version    string `json:"version"`
+	Height     uint64 `json:"height"`
+	MarkedAt   int64  `json:"markedAt,omitempty"`
+	ServerTime int64  `json:"serverTime"`
+}
+
+func heightMarkerKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configPrefix, []string{"height"})
+}
+
+func readHeightMarker(ctx contractapi.TransactionContextInterface) (*HeightMarker, error) {
+	key, err := heightMarkerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	var m HeightMarker
+	if b != nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+// Ping reads one key and nothing else, so it is cheap enough for readiness
+// probes. ServerTime is the chaincode's own clock in unix milliseconds; it
+// differs between peers, so Ping is only meant to be evaluated.
+func (s *SmartContract) Ping(ctx contractapi.TransactionContextInterface) (*PingResult, error) {
+	m, err := readHeightMarker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &PingResult{Version: version, Height: m.Height, MarkedAt: m.MarkedAt, ServerTime: time.Now().UnixMilli()}, nil
+}
+
+// MarkHeight records height as the channel height Ping reports. The marker
+// only moves forward; a lower height leaves it as it is. Admin identities
+// only.
+func (s *SmartContract) MarkHeight(ctx contractapi.TransactionContextInterface, height uint64) (*HeightMarker, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if height == 0 {
+		return nil, errors.New("height must be positive")
+	}
+	m, err := readHeightMarker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if height <= m.Height {
+		return m, nil
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m = &HeightMarker{Height: height, MarkedAt: now}
+	b, err := canonical.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	key, err := heightMarkerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m, ctx.GetStub().PutState(key, b)
+}
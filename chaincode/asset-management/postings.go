@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// PostEntry moves msisdn's balance by amount, signed as TRANSTYPE requires,
+// and records it like any other transaction. Unlike UpdateAsset it applies
+// a delta to the balance on the ledger, so a later ADJUSTMENT by -amount
+// undoes it exactly even if other postings happened in between. Blocked
+// accounts only take adjustments, closed ones nothing, and no posting may
+// leave a balance negative or spend funds on hold.
+func (s *SmartContract) PostEntry(ctx contractapi.TransactionContextInterface, msisdn, transType, amount, remarks string) error {
+	acc, err := s.readAccount(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if acc == nil {
+		return errors.New("not found")
+	}
+	delta, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %q", amount)
+	}
+	if delta == 0 {
+		return errors.New("amount must not be zero")
+	}
+	if err := checkPlain("remarks", remarks); err != nil {
+		return err
+	}
+	if acc.STATUS == StatusClosed || frozen(acc.STATUS) && transType != TransAdjustment {
+		return fmt.Errorf("account is %s", acc.STATUS)
+	}
+	if acc.BALANCE+delta < 0 {
+		return errors.New("insufficient balance")
+	}
+	if delta < 0 {
+		now, err := txSeconds(ctx)
+		if err != nil {
+			return err
+		}
+		held, err := heldAmount(ctx, msisdn, now, nil)
+		if err != nil {
+			return err
+		}
+		if acc.BALANCE+delta < held {
+			return errors.New("insufficient available balance")
+		}
+	}
+	acc.BALANCE += delta
+	acc.TRANSAMOUNT = delta
+	acc.TRANSTYPE = transType
+	acc.REMARKS = remarks
+	if err := acc.validate(); err != nil {
+		return err
+	}
+	return s.putAccount(ctx, acc, EventAssetUpdated)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+// BalanceProof is an account's balance as committed at the end of a block,
+// with the history entries it follows from.
+type BalanceProof struct {
+	MSISDN          string   `json:"msisdn"`
+	BlockNumber     uint64   `json:"blockNumber"`
+	Exists          bool     `json:"exists"`
+	Balance         int64    `json:"balance"`
+	TxID            string   `json:"txId,omitempty"`
+	TxBlock         uint64   `json:"txBlock,omitempty"`
+	SupportingTxIDs []string `json:"supportingTxIds"`
+}
+
+// txBlocks are the blocks of the history's transactions, as the caller
+// found them through qscc.
+type txBlocks map[string]uint64
+
+func (t txBlocks) block(txID string) (uint64, error) {
+	n, ok := t[txID]
+	if !ok {
+		return 0, fmt.Errorf("txBlocks: no block for transaction %s", txID)
+	}
+	return n, nil
+}
+
+// ProveBalanceAtBlock reconstructs the balance of msisdn as committed at the
+// end of blockNumber. Key history carries no block numbers, and peers refuse
+// chaincode-to-chaincode calls to qscc, so the caller passes txBlocksJSON, an
+// object of transaction IDs and their block numbers. It must hold the first
+// and last history entries and those a binary search for blockNumber visits,
+// which the API looks up the same way. It is evaluated, never submitted: the
+// answer depends on blocks the endorsers have, not on state.
+func (s *SmartContract) ProveBalanceAtBlock(ctx contractapi.TransactionContextInterface, msisdn string, blockNumber uint64, txBlocksJSON string) (*BalanceProof, error) {
+	var blocks txBlocks
+	if err := json.Unmarshal([]byte(txBlocksJSON), &blocks); err != nil {
+		return nil, fmt.Errorf("txBlocks: %w", err)
+	}
+	h, err := s.GetAssetHistory(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	proof := &BalanceProof{MSISDN: msisdn, BlockNumber: blockNumber, SupportingTxIDs: []string{}}
+	if len(h) == 0 {
+		return proof, nil
+	}
+	first, err := blocks.block(h[0].TxID)
+	if err != nil {
+		return nil, err
+	}
+	last, err := blocks.block(h[len(h)-1].TxID)
+	if err != nil {
+		return nil, err
+	}
+	if first > last {
+		for i, j := 0, len(h)-1; i < j; i, j = i+1, j-1 {
+			h[i], h[j] = h[j], h[i]
+		}
+	}
+
+	var searchErr error
+	i := sort.Search(len(h), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		n, err := blocks.block(h[i].TxID)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return n > blockNumber
+	}) - 1
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	if i < 0 {
+		return proof, nil
+	}
+
+	rec := h[i]
+	proof.TxID = rec.TxID
+	if proof.TxBlock, err = blocks.block(rec.TxID); err != nil {
+		return nil, err
+	}
+	if rec.IsDelete || rec.Value == nil {
+		proof.SupportingTxIDs = append(proof.SupportingTxIDs, rec.TxID)
+		return proof, nil
+	}
+	proof.Exists = true
+	proof.Balance = rec.Value.BALANCE
+	start := i
+	for start > 0 && !h[start-1].IsDelete {
+		start--
+	}
+	for _, r := range h[start : i+1] {
+		proof.SupportingTxIDs = append(proof.SupportingTxIDs, r.TxID)
+	}
+	return proof, nil
+}
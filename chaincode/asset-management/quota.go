@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	// Dealer account counts are counters like sequences, under their own
+	// prefixes so NextSequence cannot move them.
+	dealerCountPrefix      = "count"
+	dealerCountDeltaPrefix = "count~delta"
+
+	// defaultQuotaDealer is the dealer ID SetDealerQuota takes for the quota
+	// of dealers without their own.
+	defaultQuotaDealer = "*"
+)
+
+// DealerQuota is a dealer's account quota and how much of it is used. Quota
+// 0 means no limit. Default is set when the quota is the default one.
+type DealerQuota struct {
+	DEALERID string `json:"DEALERID"`
+	Quota    int64  `json:"quota"`
+	Default  bool   `json:"default"`
+	Used     int64  `json:"used"`
+}
+
+func quotaKey(ctx contractapi.TransactionContextInterface, dealerID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configPrefix, []string{"dealerQuota", dealerID})
+}
+
+func readQuota(ctx contractapi.TransactionContextInterface, dealerID string) (int64, bool, error) {
+	key, err := quotaKey(ctx, dealerID)
+	if err != nil {
+		return 0, false, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil || b == nil {
+		return 0, false, err
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	return n, true, err
+}
+
+// dealerQuota returns dealerID's quota and usage, falling back to the
+// default quota.
+func dealerQuota(ctx contractapi.TransactionContextInterface, dealerID string) (*DealerQuota, error) {
+	q := &DealerQuota{DEALERID: dealerID}
+	quota, ok, err := readQuota(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if quota, _, err = readQuota(ctx, defaultQuotaDealer); err != nil {
+			return nil, err
+		}
+		q.Default = true
+	}
+	q.Quota = quota
+	if q.Used, _, err = counterValue(ctx, dealerCountPrefix, dealerCountDeltaPrefix, dealerID); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// countDealerAccounts adds delta to dealerID's account count. Adding
+// accounts beyond the dealer's quota fails; removing them never does. A
+// transaction may adjust a dealer's count once, so batches pass their total.
+func countDealerAccounts(ctx contractapi.TransactionContextInterface, dealerID string, delta int64) error {
+	if delta > 0 {
+		q, err := dealerQuota(ctx, dealerID)
+		if err != nil {
+			return err
+		}
+		if q.Quota > 0 && q.Used+delta > q.Quota {
+			return fmt.Errorf("dealer %s is at its quota of %d accounts", dealerID, q.Quota)
+		}
+	}
+	_, err := addToCounter(ctx, dealerCountPrefix, dealerCountDeltaPrefix, dealerID, delta)
+	return err
+}
+
+// SetDealerQuota limits dealerID to quota accounts, sub-accounts included;
+// 0 removes the dealer's own quota, so the default applies again. Dealer "*"
+// sets the default, where 0 means no limit. Dealers already above a new
+// quota keep their accounts but cannot add more.
+func (s *SmartContract) SetDealerQuota(ctx contractapi.TransactionContextInterface, dealerID string, quota int64) (*DealerQuota, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if dealerID == "" {
+		return nil, errors.New("dealerID required")
+	}
+	if quota < 0 {
+		return nil, errors.New("quota must not be negative")
+	}
+	key, err := quotaKey(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	if quota == 0 {
+		err = ctx.GetStub().DelState(key)
+	} else {
+		err = ctx.GetStub().PutState(key, []byte(strconv.FormatInt(quota, 10)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dealerID == defaultQuotaDealer {
+		return &DealerQuota{DEALERID: dealerID, Quota: quota, Default: true}, nil
+	}
+	q, err := dealerQuota(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	// Reads do not see this transaction's write.
+	q.Quota, q.Default = quota, false
+	if quota == 0 {
+		if q.Quota, _, err = readQuota(ctx, defaultQuotaDealer); err != nil {
+			return nil, err
+		}
+		q.Default = true
+	}
+	return q, nil
+}
+
+// GetDealerQuota returns dealerID's quota and usage. Dealer identities may
+// only read their own.
+func (s *SmartContract) GetDealerQuota(ctx contractapi.TransactionContextInterface, dealerID string) (*DealerQuota, error) {
+	dealerID, err := dealerScope(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	if dealerID == "" {
+		return nil, errors.New("dealerID required")
+	}
+	return dealerQuota(ctx, dealerID)
+}
+
+// RecountDealer sets dealerID's account count from the dealer index, for
+// dealers with accounts from before counts were kept. Run IndexDealers
+// first so those accounts are in the index.
+func (s *SmartContract) RecountDealer(ctx contractapi.TransactionContextInterface, dealerID string) (*DealerQuota, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if dealerID == "" {
+		return nil, errors.New("dealerID required")
+	}
+	it, err := ctx.GetStub().GetStateByPartialCompositeKey(dealerIndex, []string{dealerID})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var n int64
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attrs, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		acc, err := s.readAccount(ctx, attrs[1])
+		if err != nil {
+			return nil, err
+		}
+		if acc != nil && acc.DEALERID == dealerID {
+			n++
+		}
+	}
+	_, deltas, err := counterValue(ctx, dealerCountPrefix, dealerCountDeltaPrefix, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := setCounter(ctx, dealerCountPrefix, dealerID, n, deltas); err != nil {
+		return nil, err
+	}
+	q, err := dealerQuota(ctx, dealerID)
+	if err != nil {
+		return nil, err
+	}
+	q.Used = n
+	return q, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	recentPrefix = "recent"
+	maxRecent    = 50
+)
+
+type TxSummary struct {
+	TxID        string `json:"txId"`
+	TRANSTYPE   string `json:"TRANSTYPE"`
+	TRANSAMOUNT int64  `json:"TRANSAMOUNT"`
+	BALANCE     int64  `json:"BALANCE"`
+	REMARKS     string `json:"REMARKS"`
+	Timestamp   int64  `json:"timestamp"`
+	Receipt     string `json:"receipt,omitempty"`
+}
+
+func recentKey(ctx contractapi.TransactionContextInterface, msisdn string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(recentPrefix, []string{msisdn})
+}
+
+func (s *SmartContract) readRecent(ctx contractapi.TransactionContextInterface, msisdn string) ([]*TxSummary, error) {
+	key, err := recentKey(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	ring := []*TxSummary{}
+	if b == nil {
+		return ring, nil
+	}
+	if err := json.Unmarshal(b, &ring); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// recordTransaction numbers acc's transaction and prepends a summary of it to
+// the account's ring, dropping the oldest entry once maxRecent is reached.
+func (s *SmartContract) recordTransaction(ctx contractapi.TransactionContextInterface, acc *Account) error {
+	if acc.TRANSTYPE == "" {
+		return nil
+	}
+	receipt, err := assignReceipt(ctx, []string{acc.MSISDN}, nil)
+	if err != nil {
+		return err
+	}
+	return s.recordTransactions(ctx, acc.MSISDN, []*Account{acc}, receipt)
+}
+
+// recordTransactions records several transactions of msisdn made by one
+// chaincode transaction, oldest first, in the ring and the day's summary.
+// Writes are not visible to reads within a transaction, so they must be
+// recorded together. They share the transaction's receipt.
+func (s *SmartContract) recordTransactions(ctx contractapi.TransactionContextInterface, msisdn string, txs []*Account, receipt string) error {
+	ring, err := s.readRecent(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return err
+	}
+	for _, acc := range txs {
+		entry := &TxSummary{TxID: ctx.GetStub().GetTxID(), TRANSTYPE: acc.TRANSTYPE, TRANSAMOUNT: acc.TRANSAMOUNT, BALANCE: acc.BALANCE, REMARKS: acc.REMARKS, Timestamp: now, Receipt: receipt}
+		ring = append([]*TxSummary{entry}, ring...)
+	}
+	if len(ring) > maxRecent {
+		ring = ring[:maxRecent]
+	}
+	raw, err := canonical.Marshal(ring)
+	if err != nil {
+		return err
+	}
+	key, err := recentKey(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, raw); err != nil {
+		return err
+	}
+	return addToDailySummary(ctx, msisdn, txs)
+}
+
+func (s *SmartContract) deleteRecent(ctx contractapi.TransactionContextInterface, msisdn string) error {
+	key, err := recentKey(ctx, msisdn)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+func (s *SmartContract) GetRecentTransactions(ctx contractapi.TransactionContextInterface, msisdn string, n int) ([]*TxSummary, error) {
+	ok, err := s.exists(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	if err := s.checkDealerAccess(ctx, msisdn); err != nil {
+		return nil, err
+	}
+	ring, err := s.readRecent(ctx, msisdn)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && n < len(ring) {
+		ring = ring[:n]
+	}
+	key, err := encryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		for _, t := range ring {
+			if err := decryptField(key, "REMARKS", &t.REMARKS); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ring, nil
+}
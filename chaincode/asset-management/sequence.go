@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	seqPrefix      = "seq"
+	seqDeltaPrefix = "seq~delta"
+	receiptPrefix  = "receipt"
+
+	// seqFoldAt is how many delta keys a sequence collects before the next
+	// increment folds them into its base.
+	seqFoldAt = 64
+
+	// receiptSequence prefixes the per-account sequences receipts are
+	// numbered from.
+	receiptSequence = "receipt"
+)
+
+// Receipt is the human-readable number given to a transaction that moved
+// money, with the accounts it touched.
+type Receipt struct {
+	Number    string   `json:"receipt"`
+	TxID      string   `json:"txId"`
+	MSISDNs   []string `json:"MSISDNs"`
+	Timestamp int64    `json:"timestamp"`
+	// FX is the conversion of a transfer between currencies.
+	FX *FXConversion `json:"fx,omitempty"`
+}
+
+func validSequenceName(name string) error {
+	if name == "" || len(name) > 64 || strings.ContainsRune(name, 0) {
+		return errors.New("sequence name must be 1-64 bytes without NUL")
+	}
+	return nil
+}
+
+// sequenceValue folds a sequence's base and delta keys. It returns the delta
+// keys so a caller can fold them.
+func sequenceValue(ctx contractapi.TransactionContextInterface, name string) (int64, []string, error) {
+	return counterValue(ctx, seqPrefix, seqDeltaPrefix, name)
+}
+
+// counterValue is sequenceValue for any counter kept as a base key under
+// basePrefix and delta keys under deltaPrefix.
+func counterValue(ctx contractapi.TransactionContextInterface, basePrefix, deltaPrefix, name string) (int64, []string, error) {
+	baseKey, err := ctx.GetStub().CreateCompositeKey(basePrefix, []string{name})
+	if err != nil {
+		return 0, nil, err
+	}
+	b, err := ctx.GetStub().GetState(baseKey)
+	if err != nil {
+		return 0, nil, err
+	}
+	var n int64
+	if b != nil {
+		if n, err = strconv.ParseInt(string(b), 10, 64); err != nil {
+			return 0, nil, fmt.Errorf("counter %s: %w", name, err)
+		}
+	}
+	it, err := ctx.GetStub().GetStateByPartialCompositeKey(deltaPrefix, []string{name})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer it.Close()
+	var deltas []string
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return 0, nil, err
+		}
+		d, err := deltaValue(kv.Value)
+		if err != nil {
+			return 0, nil, fmt.Errorf("counter %s: %w", name, err)
+		}
+		n += d
+		deltas = append(deltas, kv.Key)
+	}
+	return n, deltas, nil
+}
+
+// deltaValue reads a delta key: the single byte 1 that sequences write, or a
+// signed decimal amount.
+func deltaValue(b []byte) (int64, error) {
+	if len(b) == 1 && b[0] == 1 {
+		return 1, nil
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+// nextSequence returns name's next number. Every increment writes its own
+// delta key, named by the transaction ID, so two transactions never write
+// the same key; the value is the base plus the deltas. Concurrent
+// increments still read the same deltas, and Fabric's phantom read check
+// invalidates all but the first to commit, so a number is never handed out
+// twice: the losers fail with a conflict and have to be submitted again, so a
+// sequence shared by unrelated transactions serializes them. Once seqFoldAt
+// deltas exist the next increment folds them into the base.
+//
+// Reads in a transaction do not see its own writes, so a transaction may
+// take at most one number from a sequence.
+func nextSequence(ctx contractapi.TransactionContextInterface, name string) (int64, error) {
+	if err := validSequenceName(name); err != nil {
+		return 0, err
+	}
+	return addToCounter(ctx, seqPrefix, seqDeltaPrefix, name, 1)
+}
+
+// addToCounter adds delta to a counter the way nextSequence increments a
+// sequence and returns the new value. A transaction may adjust a counter at
+// most once, since a second delta would replace the first.
+func addToCounter(ctx contractapi.TransactionContextInterface, basePrefix, deltaPrefix, name string, delta int64) (int64, error) {
+	n, deltas, err := counterValue(ctx, basePrefix, deltaPrefix, name)
+	if err != nil {
+		return 0, err
+	}
+	n += delta
+	if len(deltas) < seqFoldAt {
+		key, err := ctx.GetStub().CreateCompositeKey(deltaPrefix, []string{name, ctx.GetStub().GetTxID()})
+		if err != nil {
+			return 0, err
+		}
+		v := []byte{1}
+		if delta != 1 {
+			v = []byte(strconv.FormatInt(delta, 10))
+		}
+		return n, ctx.GetStub().PutState(key, v)
+	}
+	return n, setCounter(ctx, basePrefix, name, n, deltas)
+}
+
+// setCounter stores n as the counter's base and drops the deltas folded
+// into it.
+func setCounter(ctx contractapi.TransactionContextInterface, basePrefix, name string, n int64, deltas []string) error {
+	baseKey, err := ctx.GetStub().CreateCompositeKey(basePrefix, []string{name})
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(baseKey, []byte(strconv.FormatInt(n, 10))); err != nil {
+		return err
+	}
+	for _, k := range deltas {
+		if err := ctx.GetStub().DelState(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NextSequence takes the next number from the named sequence.
+func (s *SmartContract) NextSequence(ctx contractapi.TransactionContextInterface, name string) (int64, error) {
+	return nextSequence(ctx, name)
+}
+
+// GetSequence returns the last number taken from the named sequence, 0 if
+// none was.
+func (s *SmartContract) GetSequence(ctx contractapi.TransactionContextInterface, name string) (int64, error) {
+	if err := validSequenceName(name); err != nil {
+		return 0, err
+	}
+	n, _, err := sequenceValue(ctx, name)
+	return n, err
+}
+
+// assignReceipt numbers the current transaction from the sequence of the
+// first account in msisdns, the one paying, and indexes the receipt. A
+// sequence per account keeps receipts of unrelated accounts from reading the
+// same delta keys, which would let only one money transaction per block
+// commit; transactions on the same account conflict over its key anyway.
+func assignReceipt(ctx contractapi.TransactionContextInterface, msisdns []string, fx *FXConversion) (string, error) {
+	n, err := nextSequence(ctx, receiptSequence+"/"+msisdns[0])
+	if err != nil {
+		return "", err
+	}
+	now, err := txSeconds(ctx)
+	if err != nil {
+		return "", err
+	}
+	r := &Receipt{Number: fmt.Sprintf("R%s-%06d", msisdns[0], n), TxID: ctx.GetStub().GetTxID(), MSISDNs: msisdns, Timestamp: now, FX: fx}
+	b, err := canonical.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(receiptPrefix, []string{r.Number})
+	if err != nil {
+		return "", err
+	}
+	return r.Number, ctx.GetStub().PutState(key, b)
+}
+
+// GetReceipt returns a receipt by number. Dealer-scoped callers only see
+// receipts naming one of their dealer's accounts; for the rest, as for
+// unknown numbers, it answers "not found".
+func (s *SmartContract) GetReceipt(ctx contractapi.TransactionContextInterface, number string) (*Receipt, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(receiptPrefix, []string{number})
+	if err != nil {
+		return nil, err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, errors.New("not found")
+	}
+	var r Receipt
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	own, err := callerDealer(ctx)
+	if err != nil || own == "" {
+		return &r, err
+	}
+	for _, m := range r.MSISDNs {
+		acc, err := s.readAccount(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if acc != nil && acc.DEALERID == own {
+			return &r, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+)
+
+const (
+	modeClassic = "classic"
+	modeCCaaS   = "ccaas"
+)
+
+// chaincodeMode picks how the chaincode is run: CHAINCODE_MODE wins, otherwise
+// the presence of CHAINCODE_SERVER_ADDRESS selects chaincode-as-a-service.
+func chaincodeMode() string {
+	if m := os.Getenv("CHAINCODE_MODE"); m != "" {
+		return m
+	}
+	if os.Getenv("CHAINCODE_SERVER_ADDRESS") != "" {
+		return modeCCaaS
+	}
+	return modeClassic
+}
+
+func start(cc *contractapi.ContractChaincode) error {
+	switch mode := chaincodeMode(); mode {
+	case modeClassic:
+		return shim.Start(statsChaincode{cc})
+	case modeCCaaS:
+		server, err := newChaincodeServer(cc)
+		if err != nil {
+			return err
+		}
+		return server.Start()
+	default:
+		return fmt.Errorf("unknown CHAINCODE_MODE %q", mode)
+	}
+}
+
+// newChaincodeServer configures the external chaincode server the peer dials
+// in the chaincode-as-a-service model.
+func newChaincodeServer(cc *contractapi.ContractChaincode) (*shim.ChaincodeServer, error) {
+	addr := os.Getenv("CHAINCODE_SERVER_ADDRESS")
+	ccid := os.Getenv("CORE_CHAINCODE_ID_NAME")
+	if addr == "" || ccid == "" {
+		return nil, errors.New("CHAINCODE_SERVER_ADDRESS and CORE_CHAINCODE_ID_NAME are required in ccaas mode")
+	}
+	tls, err := tlsProperties()
+	if err != nil {
+		return nil, err
+	}
+	return &shim.ChaincodeServer{CCID: ccid, Address: addr, CC: statsChaincode{cc}, TLSProps: tls}, nil
+}
+
+func tlsProperties() (shim.TLSProperties, error) {
+	disabled, _ := strconv.ParseBool(os.Getenv("CHAINCODE_TLS_DISABLED"))
+	if disabled || os.Getenv("CHAINCODE_TLS_KEY") == "" {
+		return shim.TLSProperties{Disabled: true}, nil
+	}
+	key, err := os.ReadFile(os.Getenv("CHAINCODE_TLS_KEY"))
+	if err != nil {
+		return shim.TLSProperties{}, err
+	}
+	cert, err := os.ReadFile(os.Getenv("CHAINCODE_TLS_CERT"))
+	if err != nil {
+		return shim.TLSProperties{}, err
+	}
+	props := shim.TLSProperties{Key: key, Cert: cert}
+	if p := os.Getenv("CHAINCODE_CLIENT_CA_CERT"); p != "" {
+		if props.ClientCACerts, err = os.ReadFile(p); err != nil {
+			return shim.TLSProperties{}, err
+		}
+	}
+	return props, nil
+}
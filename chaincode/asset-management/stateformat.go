@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"asset-management/canonical"
+
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+
+	// protoMarker prefixes protobuf accounts (schema in account.proto). JSON
+	// state always starts with '{', so the first byte tells them apart and
+	// both can be read while a ledger is being migrated.
+	protoMarker byte = 0x01
+)
+
+func stateFormatKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(configPrefix, []string{"stateFormat"})
+}
+
+// stateFormat is kept on chain rather than in the chaincode environment so
+// every endorsing peer writes identical bytes.
+func stateFormat(ctx contractapi.TransactionContextInterface) (string, error) {
+	key, err := stateFormatKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	b, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return FormatJSON, nil
+	}
+	return string(b), nil
+}
+
+// SetStateFormat chooses how accounts are written from now on. Existing
+// records keep their format until they are next written or migrated.
+func (s *SmartContract) SetStateFormat(ctx contractapi.TransactionContextInterface, format string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	key, err := stateFormatKey(ctx)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatJSON:
+		return ctx.GetStub().DelState(key)
+	case FormatProtobuf:
+		return ctx.GetStub().PutState(key, []byte(format))
+	}
+	return fmt.Errorf("unknown state format %q", format)
+}
+
+func (s *SmartContract) GetStateFormat(ctx contractapi.TransactionContextInterface) (string, error) {
+	return stateFormat(ctx)
+}
+
+type MigrationPage struct {
+	Scanned  int    `json:"scanned"`
+	Migrated int    `json:"migrated"`
+	Bookmark string `json:"bookmark"`
+}
+
+// MigrateStateFormat rewrites up to pageSize accounts from bookmark on in the
+// current format. Values are copied as stored, so encrypted fields stay
+// encrypted and no events or recent transactions are recorded.
+func (s *SmartContract) MigrateStateFormat(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*MigrationPage, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if pageSize < 1 {
+		return nil, errors.New("pageSize must be positive")
+	}
+	format, err := stateFormat(ctx)
+	if err != nil {
+		return nil, err
+	}
+	it, err := ctx.GetStub().GetStateByRange(bookmark, "")
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	page := &MigrationPage{}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if page.Scanned == pageSize {
+			page.Bookmark = kv.Key
+			break
+		}
+		page.Scanned++
+		if (len(kv.Value) > 0 && kv.Value[0] == protoMarker) == (format == FormatProtobuf) {
+			continue
+		}
+		var acc Account
+		if err := decodeAccount(kv.Value, &acc); err != nil {
+			return nil, fmt.Errorf("%s: %w", kv.Key, err)
+		}
+		raw, err := encodeAccount(format, &acc)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.GetStub().PutState(kv.Key, raw); err != nil {
+			return nil, err
+		}
+		page.Migrated++
+	}
+	return page, nil
+}
+
+func encodeAccount(format string, acc *Account) ([]byte, error) {
+	if format == FormatProtobuf {
+		return marshalAccountProto(acc), nil
+	}
+	return canonical.Marshal(acc)
+}
+
+func decodeAccount(b []byte, acc *Account) error {
+	if len(b) > 0 && b[0] == protoMarker {
+		return unmarshalAccountProto(b[1:], acc)
+	}
+	return json.Unmarshal(b, acc)
+}
+
+// marshalAccountProto writes fields in number order and skips zero values,
+// which keeps the encoding deterministic across peers.
+func marshalAccountProto(a *Account) []byte {
+	b := []byte{protoMarker}
+	str := func(n protowire.Number, v string) {
+		if v != "" {
+			b = protowire.AppendTag(b, n, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		}
+	}
+	num := func(n protowire.Number, v int64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, n, protowire.VarintType)
+			b = protowire.AppendVarint(b, protowire.EncodeZigZag(v))
+		}
+	}
+	str(1, a.DEALERID)
+	str(2, a.MSISDN)
+	str(3, a.MPIN)
+	num(4, a.BALANCE)
+	str(5, a.STATUS)
+	num(6, a.TRANSAMOUNT)
+	str(7, a.TRANSTYPE)
+	str(8, a.REMARKS)
+	str(9, a.PARENT)
+	num(10, a.CreatedAt)
+	num(11, a.LastModified)
+	str(12, a.CURRENCY)
+	return b
+}
+
+func unmarshalAccountProto(b []byte, a *Account) error {
+	*a = Account{}
+	strs := map[protowire.Number]*string{1: &a.DEALERID, 2: &a.MSISDN, 3: &a.MPIN, 5: &a.STATUS, 7: &a.TRANSTYPE, 8: &a.REMARKS, 9: &a.PARENT, 12: &a.CURRENCY}
+	nums := map[protowire.Number]*int64{4: &a.BALANCE, 6: &a.TRANSAMOUNT, 10: &a.CreatedAt, 11: &a.LastModified}
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return protowire.ParseError(l)
+		}
+		b = b[l:]
+		if p, ok := strs[n]; ok && typ == protowire.BytesType {
+			v, l := protowire.ConsumeString(b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			*p, b = v, b[l:]
+		} else if p, ok := nums[n]; ok && typ == protowire.VarintType {
+			v, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			*p, b = protowire.DecodeZigZag(v), b[l:]
+		} else {
+			// Unknown fields are skipped so older chaincode can read newer records.
+			l := protowire.ConsumeFieldValue(n, typ, b)
+			if l < 0 {
+				return protowire.ParseError(l)
+			}
+			b = b[l:]
+		}
+	}
+	return nil
+}
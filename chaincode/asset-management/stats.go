@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/v2/shim"
+	"github.com/hyperledger/fabric-contract-api-go/v2/contractapi"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// TransactionContext carries the invocation start time from the before hook
+// to the after hook.
+type TransactionContext struct {
+	contractapi.TransactionContext
+	start time.Time
+}
+
+type FuncStat struct {
+	Name        string  `json:"name"`
+	Invocations int64   `json:"invocations"`
+	Errors      int64   `json:"errors"`
+	InFlight    int64   `json:"inFlight"`
+	TotalMillis float64 `json:"totalMillis"`
+	AvgMillis   float64 `json:"avgMillis"`
+	MaxMillis   float64 `json:"maxMillis"`
+}
+
+type Stats struct {
+	Since     int64       `json:"since"`
+	Functions []*FuncStat `json:"functions"`
+}
+
+type funcStat struct {
+	invocations int64
+	succeeded   int64
+	failed      int64
+	total       time.Duration
+	max         time.Duration
+}
+
+// invocationStats is local to this chaincode process: it resets when the
+// chaincode container restarts and differs between peers.
+var invocationStats = struct {
+	sync.Mutex
+	since time.Time
+	funcs map[string]*funcStat
+}{since: time.Now(), funcs: map[string]*funcStat{}}
+
+func functionName(ctx contractapi.TransactionContextInterface) string {
+	return stubFunction(ctx.GetStub())
+}
+
+func stubFunction(stub shim.ChaincodeStubInterface) string {
+	fn, _ := stub.GetFunctionAndParameters()
+	if i := strings.LastIndex(fn, ":"); i >= 0 {
+		fn = fn[i+1:]
+	}
+	return fn
+}
+
+func countInvocation(ctx *TransactionContext) {
+	ctx.start = time.Now()
+	name := functionName(ctx)
+	invocationStats.Lock()
+	defer invocationStats.Unlock()
+	st, ok := invocationStats.funcs[name]
+	if !ok {
+		st = &funcStat{}
+		invocationStats.funcs[name] = st
+	}
+	st.invocations++
+}
+
+// afterTransaction only runs for transactions that returned without error;
+// statsChaincode counts the others.
+func afterTransaction(ctx *TransactionContext) {
+	d := time.Since(ctx.start)
+	invocationStats.Lock()
+	defer invocationStats.Unlock()
+	st, ok := invocationStats.funcs[functionName(ctx)]
+	if !ok {
+		return
+	}
+	st.succeeded++
+	st.total += d
+	if d > st.max {
+		st.max = d
+	}
+}
+
+// statsChaincode counts the invocations the contract answered with an error,
+// which never reach afterTransaction.
+type statsChaincode struct {
+	*contractapi.ContractChaincode
+}
+
+func (c statsChaincode) Invoke(stub shim.ChaincodeStubInterface) *peer.Response {
+	res := c.ContractChaincode.Invoke(stub)
+	if res.GetStatus() >= shim.ERRORTHRESHOLD {
+		invocationStats.Lock()
+		defer invocationStats.Unlock()
+		if st, ok := invocationStats.funcs[stubFunction(stub)]; ok {
+			st.failed++
+		}
+	}
+	return res
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func (s *SmartContract) GetStats(ctx contractapi.TransactionContextInterface) (*Stats, error) {
+	self := functionName(ctx)
+	invocationStats.Lock()
+	defer invocationStats.Unlock()
+	out := &Stats{Since: invocationStats.since.Unix(), Functions: []*FuncStat{}}
+	for name, st := range invocationStats.funcs {
+		fs := &FuncStat{Name: name, Invocations: st.invocations, Errors: st.failed, TotalMillis: millis(st.total), MaxMillis: millis(st.max)}
+		// This call is still in flight.
+		if name == self {
+			fs.Invocations--
+		}
+		fs.InFlight = fs.Invocations - st.succeeded - st.failed
+		if st.succeeded > 0 {
+			fs.AvgMillis = millis(st.total / time.Duration(st.succeeded))
+		}
+		out.Functions = append(out.Functions, fs)
+	}
+	sort.Slice(out.Functions, func(i, j int) bool { return out.Functions[i].Name < out.Functions[j].Name })
+	return out, nil
+}
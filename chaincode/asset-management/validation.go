@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	TransCredit      = "CREDIT"
+	TransDebit       = "DEBIT"
+	TransTransferIn  = "TRANSFER_IN"
+	TransTransferOut = "TRANSFER_OUT"
+	TransAdjustment  = "ADJUSTMENT"
+
+	maxRemarksLen = 256
+)
+
+// transTypeSign is the sign TRANSAMOUNT must carry for each TRANSTYPE:
+// inflows are non-negative, outflows non-positive, adjustments either way.
+var transTypeSign = map[string]int{
+	TransCredit:      1,
+	TransDebit:       -1,
+	TransTransferIn:  1,
+	TransTransferOut: -1,
+	TransAdjustment:  0,
+}
+
+func (a *Account) validate() error {
+	if a.TRANSTYPE == "" {
+		if a.TRANSAMOUNT != 0 {
+			return errors.New("TRANSTYPE required when TRANSAMOUNT is set")
+		}
+	} else {
+		sign, ok := transTypeSign[a.TRANSTYPE]
+		if !ok {
+			return fmt.Errorf("invalid TRANSTYPE %q", a.TRANSTYPE)
+		}
+		if sign > 0 && a.TRANSAMOUNT < 0 || sign < 0 && a.TRANSAMOUNT > 0 {
+			return fmt.Errorf("TRANSAMOUNT %d inconsistent with TRANSTYPE %s", a.TRANSAMOUNT, a.TRANSTYPE)
+		}
+	}
+	if utf8.RuneCountInString(a.REMARKS) > maxRemarksLen {
+		return fmt.Errorf("REMARKS exceeds %d characters", maxRemarksLen)
+	}
+	return nil
+}